@@ -0,0 +1,160 @@
+// Package cache holds the shared caches: an in-process LRU, a client for
+// Redis-compatible servers, and the bits the caching repositories need
+// around them.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache maps keys to opaque values with a time to live. Errors mean the
+// cache could not be reached; callers treat them like misses.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Group collapses concurrent calls for the same key into one, so a burst
+// of misses for a hot key reaches the database once.
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+// Do runs fn, or waits for the run already in flight for key and returns
+// its result. shared reports whether the result went to other callers too.
+func (g *Group) Do(key string, fn func() (any, error)) (value any, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call{}
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.value, c.err, true
+	}
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.value, c.err = fn()
+	return c.value, c.err, false
+}
+
+// Stats count what happens to an instrumented cache.
+type Stats struct {
+	Hits          atomic.Int64
+	Misses        atomic.Int64
+	Errors        atomic.Int64
+	Invalidations atomic.Int64
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*Stats{}
+)
+
+type instrumented struct {
+	Cache
+	stats *Stats
+}
+
+// Instrument counts hits, misses, errors and invalidated keys of c under
+// name, for WriteMetrics.
+func Instrument(name string, c Cache) Cache {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	stats, ok := registry[name]
+	if !ok {
+		stats = &Stats{}
+		registry[name] = stats
+	}
+	return &instrumented{Cache: c, stats: stats}
+}
+
+func (c *instrumented) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, ok, err := c.Cache.Get(ctx, key)
+	switch {
+	case err != nil:
+		c.stats.Errors.Add(1)
+	case ok:
+		c.stats.Hits.Add(1)
+	default:
+		c.stats.Misses.Add(1)
+	}
+	return value, ok, err
+}
+
+func (c *instrumented) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	err := c.Cache.Set(ctx, key, value, ttl)
+	if err != nil {
+		c.stats.Errors.Add(1)
+	}
+	return err
+}
+
+func (c *instrumented) Delete(ctx context.Context, keys ...string) error {
+	err := c.Cache.Delete(ctx, keys...)
+	if err != nil {
+		c.stats.Errors.Add(1)
+	} else {
+		c.stats.Invalidations.Add(int64(len(keys)))
+	}
+	return err
+}
+
+// WriteMetrics writes the counters of every instrumented cache in the
+// Prometheus text format.
+func WriteMetrics(w io.Writer) error {
+	registryMu.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stats := make([]*Stats, len(names))
+	for i, name := range names {
+		stats[i] = registry[name]
+	}
+	registryMu.Unlock()
+
+	metrics := []struct {
+		name, help string
+		value      func(*Stats) int64
+	}{
+		{"cache_hits_total", "Lookups answered from the cache.", func(s *Stats) int64 { return s.Hits.Load() }},
+		{"cache_misses_total", "Lookups that had to go to the source.", func(s *Stats) int64 { return s.Misses.Load() }},
+		{"cache_errors_total", "Cache operations that failed.", func(s *Stats) int64 { return s.Errors.Load() }},
+		{"cache_invalidations_total", "Keys removed because the source changed.", func(s *Stats) int64 { return s.Invalidations.Load() }},
+	}
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name); err != nil {
+			return err
+		}
+		for i, name := range names {
+			if _, err := fmt.Fprintf(w, "%s{cache=%q} %d\n", m.name, name, m.value(stats[i])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRU(t *testing.T) {
+	c := NewLRU(2)
+	ctx := context.Background()
+
+	require.NoError(t, c.Set(ctx, "a", []byte("1"), time.Minute))
+	require.NoError(t, c.Set(ctx, "b", []byte("2"), time.Minute))
+	_, ok, _ := c.Get(ctx, "a")
+	assert.True(t, ok)
+	require.NoError(t, c.Set(ctx, "c", []byte("3"), time.Minute))
+
+	_, ok, _ = c.Get(ctx, "b")
+	assert.False(t, ok, "the least recently used entry makes room")
+	value, ok, _ := c.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", string(value))
+
+	require.NoError(t, c.Delete(ctx, "a", "missing"))
+	_, ok, _ = c.Get(ctx, "a")
+	assert.False(t, ok)
+
+	require.NoError(t, c.Set(ctx, "d", []byte("4"), time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+	_, ok, _ = c.Get(ctx, "d")
+	assert.False(t, ok, "expired entries are misses")
+}
+
+func TestGroupCollapsesConcurrentCalls(t *testing.T) {
+	var g Group
+	var calls atomic.Int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	results := make([]any, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _, _ = g.Do("user:1", func() (any, error) {
+				calls.Add(1)
+				<-release
+				return "jane", nil
+			})
+		}(i)
+	}
+	// Let every caller reach Do before the first one finishes
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.EqualValues(t, 1, calls.Load())
+	for _, r := range results {
+		assert.Equal(t, "jane", r)
+	}
+
+	_, _, shared := g.Do("user:1", func() (any, error) { return nil, nil })
+	assert.False(t, shared, "finished calls are not reused")
+}
+
+func TestInstrumentedMetrics(t *testing.T) {
+	c := Instrument("test", NewLRU(10))
+	ctx := context.Background()
+	c.Get(ctx, "a")
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Get(ctx, "a")
+	c.Get(ctx, "a")
+	c.Delete(ctx, "a")
+
+	var out strings.Builder
+	require.NoError(t, WriteMetrics(&out))
+	assert.Contains(t, out.String(), "# TYPE cache_hits_total counter\n")
+	assert.Contains(t, out.String(), `cache_hits_total{cache="test"} 2`)
+	assert.Contains(t, out.String(), `cache_misses_total{cache="test"} 1`)
+	assert.Contains(t, out.String(), `cache_invalidations_total{cache="test"} 1`)
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// lru keeps at most size entries in process, evicting the least recently
+// used one to make room. Expired entries are dropped when they are read.
+type lru struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewLRU(size int) Cache {
+	return &lru{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *lru) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.remove(el)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true, nil
+}
+
+func (c *lru) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt := time.Now().Add(ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(el)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+func (c *lru) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+	}
+	return nil
+}
+
+func (c *lru) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).key)
+}
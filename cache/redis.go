@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redisCache speaks RESP to a Redis-compatible server (Redis, Valkey,
+// KeyDB, Dragonfly). It needs only GET, SET, DEL, AUTH and SELECT, so it
+// is written against the protocol rather than a client library.
+type redisCache struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+const maxIdleRedisConns = 8
+
+// NewRedis returns a cache on the server at addr (host:port), logging in
+// with password when it is set and using database db.
+func NewRedis(addr string, password string, db int, timeout time.Duration) Cache {
+	return &redisCache{addr: addr, password: password, db: db, timeout: timeout}
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := c.do(ctx, "GET", key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected reply to GET: %v", reply)
+	}
+	return value, true, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := c.do(ctx, "SET", key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (c *redisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := c.do(ctx, append([]string{"DEL"}, keys...)...)
+	return err
+}
+
+// do sends one command and reads its reply on a pooled connection.
+func (c *redisCache) do(ctx context.Context, args ...string) (any, error) {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.conn.SetDeadline(deadline)
+
+	reply, err := conn.roundTrip(args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The stream may be out of step; do not reuse it
+		conn.conn.Close()
+		return nil, err
+	}
+	c.put(conn)
+	return reply, err
+}
+
+func (c *redisCache) get(ctx context.Context) (*redisConn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: c.timeout}
+	nc, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	nc.SetDeadline(time.Now().Add(c.timeout))
+	conn := &redisConn{conn: nc, r: bufio.NewReader(nc)}
+	if c.password != "" {
+		if _, err := conn.roundTrip([]string{"AUTH", c.password}); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := conn.roundTrip([]string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *redisCache) put(conn *redisConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= maxIdleRedisConns {
+		conn.conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+func (conn *redisConn) roundTrip(args []string) (any, error) {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := conn.conn.Write(buf); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return readReply(conn.r)
+}
+
+// readReply reads one RESP2 reply: simple strings and bulk strings come
+// back as []byte, integers as int64, nil bulk strings and arrays as nil.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return []byte(rest), nil
+	case '-':
+		return nil, redisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		value := make([]byte, n+2)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		return value[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
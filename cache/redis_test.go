@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis answers the handful of commands the client sends, keeping
+// values in a map; it checks the password and records expiries.
+type fakeRedis struct {
+	mu       sync.Mutex
+	values   map[string]string
+	ttls     map[string]string
+	password string
+	commands []string
+}
+
+func (f *fakeRedis) serve(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.handle(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, a := range reply.([]any) {
+			args = append(args, string(a.([]byte)))
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, args[0])
+		var out string
+		switch {
+		case args[0] == "AUTH":
+			authed = args[1] == f.password
+			out = "+OK\r\n"
+			if !authed {
+				out = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			out = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SELECT":
+			out = "+OK\r\n"
+		case args[0] == "GET":
+			if v, ok := f.values[args[1]]; ok {
+				out = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			} else {
+				out = "$-1\r\n"
+			}
+		case args[0] == "SET":
+			f.values[args[1]] = args[2]
+			f.ttls[args[1]] = strings.Join(args[3:], " ")
+			out = "+OK\r\n"
+		case args[0] == "DEL":
+			n := 0
+			for _, k := range args[1:] {
+				if _, ok := f.values[k]; ok {
+					delete(f.values, k)
+					n++
+				}
+			}
+			out = fmt.Sprintf(":%d\r\n", n)
+		default:
+			out = "-ERR unknown command\r\n"
+		}
+		f.mu.Unlock()
+		conn.Write([]byte(out))
+	}
+}
+
+func TestRedis(t *testing.T) {
+	server := &fakeRedis{values: map[string]string{}, ttls: map[string]string{}, password: "s3cret"}
+	addr := server.serve(t)
+	c := NewRedis(addr, "s3cret", 2, time.Second)
+	ctx := context.Background()
+
+	_, ok, err := c.Get(ctx, "user:1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	value := "binary\r\n\x00value"
+	require.NoError(t, c.Set(ctx, "user:1", []byte(value), 30*time.Second))
+	got, ok, err := c.Get(ctx, "user:1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, value, string(got))
+	assert.Equal(t, "PX 30000", server.ttls["user:1"])
+
+	require.NoError(t, c.Delete(ctx, "user:1", "user:2"))
+	_, ok, err = c.Get(ctx, "user:1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Equal(t, []string{"AUTH", "SELECT", "GET", "SET", "GET", "DEL", "GET"}, server.commands, "one pooled connection")
+
+	_, _, err = NewRedis(addr, "wrong", 0, time.Second).Get(ctx, "user:1")
+	assert.EqualError(t, err, "redis: WRONGPASS invalid password")
+	_, _, err = NewRedis("127.0.0.1:1", "", 0, 100*time.Millisecond).Get(ctx, "user:1")
+	assert.Error(t, err, "an unreachable server is an error, not a miss")
+}
@@ -0,0 +1,136 @@
+// Command auditctl exports and verifies the audit trail.
+//
+// Both work on one organization, chosen with -tenant SLUG before the
+// subcommand (default $TENANT_DEFAULT or "default").
+//
+//	auditctl [-tenant SLUG] export [-actor ID] [-target ID] [-action NAME] [-since RFC3339] [-until RFC3339] [-o FILE]
+//	auditctl [-tenant SLUG] verify
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"server-go/config"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/services"
+	"server-go/tenancy"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	flag.Usage = usage
+	tenant := flag.String("tenant", "", "organization slug")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+
+	_ = godotenv.Load("../.env")
+	logger := config.NewLogger(os.Stderr)
+	DB, err := config.DatabaseConnection(logger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not connect to the database:", err)
+		os.Exit(1)
+	}
+	defer DB.Close()
+
+	auditService := services.NewAuditService(repositories.NewAuditRepository(DB, config.AuditHashChain(), logger), logger)
+	ctx, err := tenantContext(context.Background(), repositories.NewOrganizationRepository(DB), *tenant)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	switch flag.Arg(0) {
+	case "export":
+		err = export(ctx, auditService, flag.Args()[1:])
+	case "verify":
+		err = verify(ctx, auditService)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func export(ctx context.Context, auditService services.AuditService, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	actor := fs.Int("actor", 0, "only events performed by this user id")
+	target := fs.Int("target", 0, "only events affecting this user id")
+	action := fs.String("action", "", "only events with this action, e.g. user.login")
+	since := fs.String("since", "", "only events at or after this RFC 3339 time")
+	until := fs.String("until", "", "only events before this RFC 3339 time")
+	output := fs.String("o", "-", "output file, - for stdout")
+	fs.Parse(args)
+
+	filter := models.AuditFilter{Action: *action}
+	if *actor != 0 {
+		filter.ActorId = actor
+	}
+	if *target != 0 {
+		filter.TargetId = target
+	}
+	for _, p := range []struct {
+		value string
+		dest  **time.Time
+	}{{*since, &filter.Since}, {*until, &filter.Until}} {
+		if p.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, p.value)
+		if err != nil {
+			return err
+		}
+		*p.dest = &t
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return auditService.Export(ctx, filter, w)
+}
+
+func verify(ctx context.Context, auditService services.AuditService) error {
+	broken, err := auditService.Verify(ctx)
+	if err != nil {
+		return err
+	}
+	if broken != 0 {
+		return fmt.Errorf("hash chain broken at audit event %d", broken)
+	}
+	fmt.Println("hash chain intact")
+	return nil
+}
+
+func tenantContext(ctx context.Context, orgs repositories.OrganizationRepository, slug string) (context.Context, error) {
+	if slug == "" {
+		slug = config.TenantOptions().DefaultSlug
+	}
+	org, err := orgs.FindBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, fmt.Errorf("organization %q not found", slug)
+	}
+	return tenancy.WithTenant(ctx, org.Id), nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: auditctl [-tenant SLUG] export [flags] | auditctl [-tenant SLUG] verify")
+	os.Exit(2)
+}
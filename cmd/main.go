@@ -1,37 +1,153 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
 	"server-go/config"
 	"server-go/controllers"
+	"server-go/events"
+	"server-go/jobs"
+	"server-go/mailer"
+	"server-go/middlewares"
 	"server-go/repositories"
 	"server-go/routes"
 	"server-go/services"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	log.Println("Starting the server")
-
 	err := godotenv.Load("../.env")
+	logger := config.NewLogger(os.Stdout)
 	if err != nil {
-		log.Fatal("Error loading .env file", err)
+		logger.Error("error loading .env file", "error", err)
+		os.Exit(1)
 	}
+	logger.Info("starting the server")
 	gin.SetMode(gin.ReleaseMode)
 
-	r := gin.Default()
+	r := gin.New()
+	r.Use(middlewares.RequestID(), middlewares.AccessLog(logger), gin.Recovery())
 	r.Use(config.CORSmiddleware())
-	DB, err := config.DatabaseConnection()
+	DB, err := config.DatabaseConnection(logger)
+	if err != nil {
+		logger.Error("could not connect to the database", "error", err)
+		os.Exit(1)
+	}
+	orgRepo := repositories.NewOrganizationRepository(DB)
+	r.Use(middlewares.TenantMiddleware(orgRepo, config.TenantOptions(), logger))
+
+	auditRepo := repositories.NewAuditRepository(DB, config.AuditHashChain(), logger)
+	auditService := services.NewAuditService(auditRepo, logger)
+	auditController := controllers.NewAuditController(auditService)
+	userCache, userCacheTTL := config.UserCache(logger)
+	transactor := repositories.NewCachingTransactor(repositories.NewTransactor(DB, repositories.TxOptions{Isolation: config.TxIsolation(), MaxRetries: config.TxMaxRetries()}, logger), userCache, logger)
+	userRepo := repositories.NewCachedUserRepository(repositories.NewUserRepository(DB, logger), userCache, userCacheTTL, logger)
+	resetRepo := repositories.NewPasswordResetRepository(DB)
+	membershipRepo := repositories.NewMembershipRepository(DB)
+	invitationRepo := repositories.NewInvitationRepository(DB)
+	// Mail is sent by the job workers, not the request handlers
+	runner := jobs.NewRunner(config.JobStore(DB, logger), config.JobOptions(), logger)
+	mailer.SendJob.Handle(runner, config.NewMailer(logger).Send)
+	mail := mailer.NewQueuedMailer(runner)
+	passwordService := services.NewPasswordService(config.PasswordHasher(logger), config.PasswordPolicy(logger), repositories.NewPasswordHistoryRepository(DB), logger)
+	sessionService := services.NewSessionService(repositories.NewSessionRepository(DB), userRepo, membershipRepo, config.SessionLimit(), auditService, logger)
+	sessionController := controllers.NewSessionController(sessionService, logger)
+	userService := services.NewUserService(transactor, userRepo, resetRepo, membershipRepo, invitationRepo, sessionService, passwordService, auditService, logger)
+	userController := controllers.NewUserController(userService, logger)
+	bulkService := services.NewUserBulkService(transactor, userRepo, resetRepo, mail, auditService, runner, config.ExportDir(logger), config.AppURL(), logger)
+	services.ExportUsersJob.Handle(runner, bulkService.RunExport)
+	bulkController := controllers.NewUserBulkController(bulkService, logger)
+	orgService := services.NewOrganizationService(userRepo, orgRepo, membershipRepo, invitationRepo, sessionService, mail, auditService, config.AppURL(), logger)
+	orgController := controllers.NewOrganizationController(orgService, logger)
+
+	webauthnService := services.NewWebAuthnService(config.WebAuthn(), repositories.NewPasskeyRepository(DB), userRepo, sessionService, auditService, logger)
+	webauthnController := controllers.NewWebAuthnController(webauthnService, logger)
+
+	magicLinkService := services.NewMagicLinkService(repositories.NewMagicLinkRepository(DB), userRepo, sessionService, mail, auditService, config.AppURL(), config.MagicLinkTTL(), config.MagicLinkRateLimit(), logger)
+	magicLinkController := controllers.NewMagicLinkController(magicLinkService, logger)
+
+	accountService := services.NewAccountService(transactor, userRepo, repositories.NewEmailChangeRepository(DB), passwordService, sessionService, mail, auditService, config.AppURL(), logger)
+	accountController := controllers.NewAccountController(accountService, logger)
+
+	socialService := services.NewSocialLoginService(transactor, config.OAuthProviders(), userRepo, repositories.NewIdentityRepository(DB), sessionService, auditService, logger)
+	socialController := controllers.NewSocialLoginController(socialService, logger)
+
+	signingKey, err := config.OIDCSigningKey(logger)
 	if err != nil {
-		log.Fatalf("Could not connect to the database")
+		logger.Error("could not load the OIDC signing key", "error", err)
+		os.Exit(1)
+	}
+	clientRepo := repositories.NewOAuthClientRepository(DB)
+	oidcService := services.NewOIDCProviderService(config.OIDCIssuer(), signingKey, clientRepo,
+		repositories.NewConsentRepository(DB), repositories.NewAuthorizationCodeRepository(DB), userRepo, auditService, logger)
+	accessTokenService := services.NewAccessTokenService(transactor, repositories.NewAccessTokenRepository(DB), userRepo, membershipRepo, auditService, logger)
+	accessTokenController := controllers.NewAccessTokenController(accessTokenService, logger)
+	revocations := middlewares.WithStatus(middlewares.WithSessions(middlewares.WithAccessTokens(userRepo, accessTokenService), sessionService), userService)
+	fresh := revocations
+	if config.FreshUsers() {
+		fresh = middlewares.WithFreshUsers(revocations, userRepo)
 	}
-	userRepo := repositories.NewUserRepository(DB)
-	userService := services.NewUserService(userRepo)
-	userController := controllers.NewUserController(userService)
+	oidcController := controllers.NewOIDCProviderController(oidcService, userService, fresh, logger)
+	tokenService := services.NewTokenService(clientRepo, userRepo, middlewares.ActiveToken(fresh), auditService, logger)
+	tokenController := controllers.NewTokenController(tokenService, logger)
 
-	routes.SetUpRoutes(r, userController)
+	webhookService := services.NewWebhookService(repositories.NewWebhookRepository(DB), config.WebhookClient(), config.WebhookOptions(), auditService, logger)
+	webhookController := controllers.NewWebhookController(webhookService, logger)
 
-	r.Run(":4000")
+	retentionService := services.NewRetentionService(orgRepo, userRepo, auditService, logger)
+	services.PurgeUsersJob.Handle(runner, retentionService.PurgeUsers)
+	purgeSchedule, purge := config.UserPurge()
+	if err := services.PurgeUsersJob.Schedule(runner, purgeSchedule, purge); err != nil {
+		logger.Error("invalid USER_PURGE_SCHEDULE", "error", err)
+		os.Exit(1)
+	}
+
+	// Background work stops after the server, once requests have drained
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	// Deliver domain events written by the services
+	bus := events.NewBus()
+	bus.Subscribe("*", webhookService.Enqueue)
+	relay := events.NewRelay(repositories.NewOutboxRepository(DB), config.EventSink(bus, logger), config.RelayOptions(), logger)
+	go relay.Run(background)
+	go webhookService.Run(background)
+	runner.Start(background)
+
+	routes.SetUpRoutes(r, userController, auditController, bulkController, orgController, socialController, oidcController, tokenController, accessTokenController, sessionController, webauthnController, magicLinkController, accountController, webhookController, config.RecentAuthWindow(), revocations, fresh, config.UnversionedAPI(logger), logger)
+
+	server := &http.Server{Addr: ":4000", Handler: r}
+	failed := make(chan error, 1)
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	select {
+	case err := <-failed:
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	case <-signals.Done():
+	}
+
+	logger.Info("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout())
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("requests still in flight were cut off", "error", err)
+	}
+	if err := runner.Shutdown(ctx); err != nil {
+		logger.Warn("jobs still running were put back in the queue", "error", err)
+	}
+	stopBackground()
+	logger.Info("server stopped")
 }
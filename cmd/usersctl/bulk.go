@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"server-go/models"
+)
+
+// importCmd prints a summary on stderr and one output row per rejected input row.
+func importCmd(ctx context.Context, app *app, args []string) (table, error) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "csv or ndjson; guessed from the file extension when empty")
+	commit := fs.Bool("commit", false, "write the users; without it the file is only validated")
+	invite := fs.Bool("invite", false, "email a set-password link to rows without passwordHash")
+	batch := fs.Int("batch", 0, "rows per transaction")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return table{}, errors.New("import takes one file, or - for stdin")
+	}
+	var in io.Reader = os.Stdin
+	if path := fs.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return table{}, err
+		}
+		defer f.Close()
+		in = f
+		if *format == "" {
+			*format = formatFromPath(path)
+		}
+	}
+	if *format == "" {
+		return table{}, errors.New("-format is required when reading stdin")
+	}
+
+	report, err := app.bulk.Import(ctx, in, models.ImportOptions{Format: *format, Commit: *commit, Invite: *invite, BatchSize: *batch})
+	if report != nil {
+		fmt.Fprintf(os.Stderr, "rows=%d valid=%d created=%d invited=%d failed=%d committed=%t\n",
+			report.Rows, report.Valid, report.Created, report.Invited, report.Failed, report.Committed)
+	}
+	if err != nil {
+		return table{}, err
+	}
+
+	t := table{headers: []string{"row", "email", "errors"}}
+	for _, e := range report.Errors {
+		t.rows = append(t.rows, []string{strconv.Itoa(e.Row), e.Email, strings.Join(e.Errors, "; ")})
+	}
+	return t, nil
+}
+
+func exportCmd(ctx context.Context, app *app, args []string) (table, error) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "", "csv or ndjson; guessed from -file when empty, else csv")
+	includeHashes := fs.Bool("include-hashes", false, "include password hashes so the file can be imported elsewhere")
+	file := fs.String("file", "", "write to this file instead of stdout")
+	fs.Parse(args)
+
+	if *format == "" {
+		*format = formatFromPath(*file)
+	}
+	if *format == "" {
+		*format = models.FormatCSV
+	}
+
+	if *file == "" {
+		// The export itself is the output; nothing else goes to stdout.
+		return table{}, app.bulk.Export(ctx, os.Stdout, models.ExportOptions{Format: *format, IncludeHashes: *includeHashes})
+	}
+
+	f, err := os.Create(*file)
+	if err != nil {
+		return table{}, err
+	}
+	if err := app.bulk.Export(ctx, f, models.ExportOptions{Format: *format, IncludeHashes: *includeHashes}); err != nil {
+		f.Close()
+		return table{}, err
+	}
+	if err := f.Close(); err != nil {
+		return table{}, err
+	}
+	return resultTable("file", *file, "format", *format), nil
+}
+
+func formatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return models.FormatCSV
+	case ".ndjson", ".jsonl":
+		return models.FormatNDJSON
+	}
+	return ""
+}
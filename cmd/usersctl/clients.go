@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+
+	"server-go/models"
+	"server-go/services"
+)
+
+func clientCreateCmd(ctx context.Context, app *app, args []string) (table, error) {
+	fs := flag.NewFlagSet("client-create", flag.ExitOnError)
+	name := fs.String("name", "", "application name shown on the consent page")
+	redirects := fs.String("redirect-uri", "", "comma-separated redirect URIs")
+	scopes := fs.String("scopes", "openid,profile,email", "comma-separated allowed scopes")
+	public := fs.Bool("public", false, "no client secret; PKCE is required")
+	fs.Parse(args)
+
+	client, secret, err := services.NewOAuthClient(*name, splitList(*redirects), splitList(*scopes), *public)
+	if err != nil {
+		return table{}, err
+	}
+	if client, err = app.clients.Create(ctx, client); err != nil {
+		return table{}, err
+	}
+	return resultTable("clientId", client.ClientId, "clientSecret", secret, "name", client.Name), nil
+}
+
+func clientListCmd(ctx context.Context, app *app, args []string) (table, error) {
+	clients, err := app.clients.List(ctx)
+	if err != nil {
+		return table{}, err
+	}
+	return clientsTable(clients...), nil
+}
+
+func clientDeleteCmd(ctx context.Context, app *app, args []string) (table, error) {
+	if len(args) != 1 {
+		return table{}, errors.New("usage: client-delete <client-id>")
+	}
+	deleted, err := app.clients.Delete(ctx, args[0])
+	if err != nil {
+		return table{}, err
+	}
+	if !deleted {
+		return table{}, errors.New("client not found")
+	}
+	return resultTable("clientId", args[0], "status", "deleted"), nil
+}
+
+func clientsTable(clients ...models.OAuthClient) table {
+	t := table{headers: []string{"id", "clientId", "name", "public", "redirectUris", "scopes", "createdAt"}}
+	for _, c := range clients {
+		t.rows = append(t.rows, []string{strconv.Itoa(c.Id), c.ClientId, c.Name, strconv.FormatBool(c.Public()),
+			strings.Join(c.RedirectURIs, " "), strings.Join(c.Scopes, " "), c.CreatedAt.Format(time.RFC3339)})
+	}
+	return t
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
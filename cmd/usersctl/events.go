@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strconv"
+	"time"
+)
+
+func deadLettersCmd(ctx context.Context, app *app, args []string) (table, error) {
+	fs := flag.NewFlagSet("dead-letters", flag.ExitOnError)
+	limit := fs.Int("limit", 100, "maximum number of events")
+	fs.Parse(args)
+
+	letters, err := app.outbox.ListDeadLetters(ctx, *limit)
+	if err != nil {
+		return table{}, err
+	}
+	t := table{headers: []string{"id", "tenantId", "userId", "type", "attempts", "lastError", "createdAt", "failedAt"}}
+	for _, d := range letters {
+		t.rows = append(t.rows, []string{strconv.FormatInt(d.Id, 10), strconv.Itoa(d.TenantId), strconv.Itoa(d.UserId), d.Type,
+			strconv.Itoa(d.Attempts), d.LastError, d.CreatedAt.Format(time.RFC3339), d.FailedAt.Format(time.RFC3339)})
+	}
+	return t, nil
+}
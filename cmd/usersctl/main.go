@@ -0,0 +1,449 @@
+// Command usersctl manages user accounts from the command line. It uses the
+// same .env / environment configuration as the server.
+//
+//	usersctl [-o table|json|csv] <command> [flags] [args]
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"server-go/config"
+	"server-go/logging"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/services"
+	"server-go/tenancy"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `usage: usersctl [-o table|json|csv] [-tenant SLUG] <command> [flags] [args]
+
+organization commands:
+  org-create -slug S -name N
+  org-list
+
+OpenID client commands:
+  client-create -name N -redirect-uri U[,U...] [-scopes S[,S...]] [-public]
+  client-list
+  client-delete <client-id>
+
+event commands:
+  dead-letters [-limit N]
+
+user commands (scoped to -tenant, default $TENANT_DEFAULT or "default"):
+  create -name N -lastname L -email E [-password P] [-role R]
+  list [-limit N] [-offset N]
+  show <id|email>
+  update <id> [-name N] [-lastname L]
+  disable <id>
+  enable <id>
+  suspend <id> [-reason R] [-until RFC3339]
+  reactivate <id> [-reason R]
+  status-history <id>
+  delete <id>
+  reset-password <id> [-temporary]
+  set-role <id> <role>
+  revoke-sessions <id>
+  import [-format csv|ndjson] [-commit] [-invite] [-batch N] <file|->
+  export [-format csv|ndjson] [-include-hashes] [-file F]
+  create-service-account -name N -email E
+  token-create -name N [-scopes S[,S...]] [-days D] <id|email>
+  token-list <id|email>
+  token-revoke <id|email> <token-id>
+`
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+type app struct {
+	users   services.UserService
+	bulk    services.UserBulkService
+	orgs    repositories.OrganizationRepository
+	clients repositories.OAuthClientRepository
+	tokens  services.AccessTokenService
+	outbox  repositories.OutboxRepository
+}
+
+type command func(ctx context.Context, app *app, args []string) (table, error)
+
+var commands = map[string]command{
+	"create":          createCmd,
+	"list":            listCmd,
+	"show":            showCmd,
+	"update":          updateCmd,
+	"disable":         disableCmd(true),
+	"enable":          disableCmd(false),
+	"suspend":         statusCmd("suspend", models.StatusSuspended),
+	"reactivate":      statusCmd("reactivate", models.StatusActive),
+	"status-history":  statusHistoryCmd,
+	"delete":          deleteCmd,
+	"reset-password":  resetPasswordCmd,
+	"set-role":        setRoleCmd,
+	"revoke-sessions": revokeSessionsCmd,
+	"import":          importCmd,
+	"export":          exportCmd,
+	"org-create":      orgCreateCmd,
+	"org-list":        orgListCmd,
+	"client-create":   clientCreateCmd,
+	"client-list":     clientListCmd,
+	"client-delete":   clientDeleteCmd,
+	"dead-letters":    deadLettersCmd,
+
+	"create-service-account": createServiceAccountCmd,
+	"token-create":           tokenCreateCmd,
+	"token-list":             tokenListCmd,
+	"token-revoke":           tokenRevokeCmd,
+}
+
+// globalCommands work across tenants and need no -tenant.
+var globalCommands = map[string]bool{
+	"org-create": true, "org-list": true,
+	"client-create": true, "client-list": true, "client-delete": true,
+	"dead-letters": true,
+}
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	format := flag.String("o", "table", "output format: table, json or csv")
+	tenant := flag.String("tenant", "", "organization slug")
+	flag.Parse()
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	_ = godotenv.Load("../.env")
+	logger := config.NewLogger(os.Stderr)
+	DB, err := config.DatabaseConnection(logger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not connect to the database:", err)
+		os.Exit(1)
+	}
+	defer DB.Close()
+
+	auditService := services.NewAuditService(repositories.NewAuditRepository(DB, config.AuditHashChain(), logger), logger)
+	// Changes made here evict the servers' cached users when they share Redis
+	userCache, userCacheTTL := config.UserCache(logger)
+	transactor := repositories.NewCachingTransactor(repositories.NewTransactor(DB, repositories.TxOptions{Isolation: config.TxIsolation(), MaxRetries: config.TxMaxRetries()}, logger), userCache, logger)
+	userRepo := repositories.NewCachedUserRepository(repositories.NewUserRepository(DB, logger), userCache, userCacheTTL, logger)
+	resetRepo := repositories.NewPasswordResetRepository(DB)
+	membershipRepo := repositories.NewMembershipRepository(DB)
+	passwordService := services.NewPasswordService(config.PasswordHasher(logger), config.PasswordPolicy(logger), repositories.NewPasswordHistoryRepository(DB), logger)
+	sessionService := services.NewSessionService(repositories.NewSessionRepository(DB), userRepo, membershipRepo, config.SessionLimit(), auditService, logger)
+	cli := &app{
+		users:   services.NewUserService(transactor, userRepo, resetRepo, membershipRepo, repositories.NewInvitationRepository(DB), sessionService, passwordService, auditService, logger),
+		bulk:    services.NewUserBulkService(transactor, userRepo, resetRepo, config.NewMailer(logger), auditService, nil, "", config.AppURL(), logger),
+		orgs:    repositories.NewOrganizationRepository(DB),
+		clients: repositories.NewOAuthClientRepository(DB),
+		tokens:  services.NewAccessTokenService(transactor, repositories.NewAccessTokenRepository(DB), userRepo, membershipRepo, auditService, logger),
+		outbox:  repositories.NewOutboxRepository(DB),
+	}
+
+	ctx := operatorContext()
+	if !globalCommands[flag.Arg(0)] {
+		ctx, err = tenantContext(ctx, cli.orgs, *tenant)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "usersctl:", err)
+			os.Exit(1)
+		}
+	}
+
+	result, err := cmd(ctx, cli, flag.Args()[1:])
+	if err == nil {
+		err = result.write(os.Stdout, *format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "usersctl:", err)
+		os.Exit(1)
+	}
+}
+
+// operatorContext tags audit events with the OS user running the command.
+func operatorContext() context.Context {
+	agent := "usersctl"
+	if u, err := user.Current(); err == nil {
+		agent += " (" + u.Username + ")"
+	}
+	return logging.WithClient(context.Background(), logging.ClientInfo{IP: "local", UserAgent: agent})
+}
+
+// tenantContext scopes ctx to the organization named by slug, falling back
+// to the server's default organization.
+func tenantContext(ctx context.Context, orgs repositories.OrganizationRepository, slug string) (context.Context, error) {
+	if slug == "" {
+		slug = config.TenantOptions().DefaultSlug
+	}
+	if slug == "" {
+		return nil, errors.New("-tenant is required")
+	}
+	org, err := orgs.FindBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, fmt.Errorf("organization %q not found", slug)
+	}
+	return tenancy.WithTenant(ctx, org.Id), nil
+}
+
+func orgCreateCmd(ctx context.Context, app *app, args []string) (table, error) {
+	fs := flag.NewFlagSet("org-create", flag.ExitOnError)
+	slug := fs.String("slug", "", "subdomain-safe identifier, e.g. acme")
+	name := fs.String("name", "", "display name")
+	fs.Parse(args)
+
+	if !slugPattern.MatchString(*slug) || *name == "" {
+		return table{}, errors.New("-slug (lowercase letters, digits and dashes) and -name are required")
+	}
+	org, err := app.orgs.Create(ctx, *slug, *name)
+	if err != nil {
+		return table{}, err
+	}
+	return orgsTable(*org), nil
+}
+
+func orgListCmd(ctx context.Context, app *app, args []string) (table, error) {
+	orgs, err := app.orgs.List(ctx)
+	if err != nil {
+		return table{}, err
+	}
+	return orgsTable(orgs...), nil
+}
+
+func createCmd(ctx context.Context, app *app, args []string) (table, error) {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	name := fs.String("name", "", "first name")
+	lastName := fs.String("lastname", "", "last name")
+	email := fs.String("email", "", "email address")
+	password := fs.String("password", "", "password; a temporary one is generated when empty")
+	role := fs.String("role", models.RoleUser, "role: user or admin")
+	fs.Parse(args)
+
+	if *name == "" || *lastName == "" || *email == "" {
+		return table{}, errors.New("-name, -lastname and -email are required")
+	}
+
+	generated := ""
+	if *password == "" {
+		b := make([]byte, 12)
+		if _, err := rand.Read(b); err != nil {
+			return table{}, err
+		}
+		generated = base64.RawURLEncoding.EncodeToString(b)
+		*password = generated
+	}
+
+	u, err := app.users.CreateUser(ctx, models.User{Name: *name, LastName: *lastName, Email: *email, Password: *password, Role: *role})
+	if err != nil {
+		return table{}, err
+	}
+	if generated != "" {
+		return resultTable("id", strconv.Itoa(u.Id), "email", u.Email, "temporaryPassword", generated), nil
+	}
+	return usersTable(*u), nil
+}
+
+func listCmd(ctx context.Context, app *app, args []string) (table, error) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	limit := fs.Int("limit", 100, "maximum number of users")
+	offset := fs.Int("offset", 0, "number of users to skip")
+	fs.Parse(args)
+
+	users, err := app.users.ListUsers(ctx, *limit, *offset)
+	if err != nil {
+		return table{}, err
+	}
+	return usersTable(users...), nil
+}
+
+func showCmd(ctx context.Context, app *app, args []string) (table, error) {
+	if len(args) != 1 {
+		return table{}, errors.New("show takes exactly one id or email")
+	}
+	u, err := lookup(ctx, app.users, args[0])
+	if err != nil {
+		return table{}, err
+	}
+	return usersTable(*u), nil
+}
+
+func updateCmd(ctx context.Context, app *app, args []string) (table, error) {
+	id, rest, err := idArg(args)
+	if err != nil {
+		return table{}, err
+	}
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	name := fs.String("name", "", "new first name")
+	lastName := fs.String("lastname", "", "new last name")
+	fs.Parse(rest)
+
+	u, err := app.users.GetUser(ctx, id)
+	if err != nil {
+		return table{}, err
+	}
+	// Passwords change with reset-password; UpdateUser keeps the hash
+	u.Password = ""
+	if *name != "" {
+		u.Name = *name
+	}
+	if *lastName != "" {
+		u.LastName = *lastName
+	}
+
+	u, err = app.users.UpdateUser(ctx, u)
+	if err != nil {
+		return table{}, err
+	}
+	return usersTable(*u), nil
+}
+
+func disableCmd(disabled bool) command {
+	return func(ctx context.Context, app *app, args []string) (table, error) {
+		id, _, err := idArg(args)
+		if err != nil {
+			return table{}, err
+		}
+		u, err := app.users.SetDisabled(ctx, id, disabled)
+		if err != nil {
+			return table{}, err
+		}
+		return usersTable(*u), nil
+	}
+}
+
+func statusCmd(name string, status string) command {
+	return func(ctx context.Context, app *app, args []string) (table, error) {
+		id, rest, err := idArg(args)
+		if err != nil {
+			return table{}, err
+		}
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		reason := fs.String("reason", "", "why the status changes, kept in the status history")
+		until := fs.String("until", "", "when the suspension ends (RFC 3339)")
+		fs.Parse(rest)
+
+		update := models.StatusUpdate{Reason: *reason}
+		if *until != "" {
+			t, err := time.Parse(time.RFC3339, *until)
+			if err != nil {
+				return table{}, fmt.Errorf("invalid -until: %v", err)
+			}
+			update.Until = &t
+		}
+		u, err := app.users.SetStatus(ctx, id, status, update)
+		if err != nil {
+			return table{}, err
+		}
+		return usersTable(*u), nil
+	}
+}
+
+func statusHistoryCmd(ctx context.Context, app *app, args []string) (table, error) {
+	id, _, err := idArg(args)
+	if err != nil {
+		return table{}, err
+	}
+	changes, err := app.users.StatusHistory(ctx, id)
+	if err != nil {
+		return table{}, err
+	}
+	return statusHistoryTable(changes), nil
+}
+
+func deleteCmd(ctx context.Context, app *app, args []string) (table, error) {
+	id, _, err := idArg(args)
+	if err != nil {
+		return table{}, err
+	}
+	if err := app.users.DeleteUser(ctx, id); err != nil {
+		return table{}, err
+	}
+	return resultTable("id", strconv.Itoa(id), "status", "deleted"), nil
+}
+
+func resetPasswordCmd(ctx context.Context, app *app, args []string) (table, error) {
+	id, rest, err := idArg(args)
+	if err != nil {
+		return table{}, err
+	}
+	fs := flag.NewFlagSet("reset-password", flag.ExitOnError)
+	temporary := fs.Bool("temporary", false, "set and print a temporary password instead of a reset link")
+	fs.Parse(rest)
+
+	if *temporary {
+		password, err := app.users.SetTemporaryPassword(ctx, id)
+		if err != nil {
+			return table{}, err
+		}
+		return resultTable("id", strconv.Itoa(id), "temporaryPassword", password), nil
+	}
+
+	token, err := app.users.CreatePasswordResetToken(ctx, id)
+	if err != nil {
+		return table{}, err
+	}
+	link := config.AppURL() + "/password/reset?token=" + url.QueryEscape(token)
+	return resultTable("id", strconv.Itoa(id), "resetLink", link), nil
+}
+
+func setRoleCmd(ctx context.Context, app *app, args []string) (table, error) {
+	id, rest, err := idArg(args)
+	if err != nil {
+		return table{}, err
+	}
+	if len(rest) != 1 {
+		return table{}, errors.New("set-role takes an id and a role")
+	}
+	u, err := app.users.SetRole(ctx, id, rest[0])
+	if err != nil {
+		return table{}, err
+	}
+	return usersTable(*u), nil
+}
+
+func revokeSessionsCmd(ctx context.Context, app *app, args []string) (table, error) {
+	id, _, err := idArg(args)
+	if err != nil {
+		return table{}, err
+	}
+	if err := app.users.RevokeSessions(ctx, id); err != nil {
+		return table{}, err
+	}
+	return resultTable("id", strconv.Itoa(id), "status", "sessions revoked"), nil
+}
+
+func idArg(args []string) (int, []string, error) {
+	if len(args) == 0 {
+		return 0, nil, errors.New("missing user id")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid user id %q", args[0])
+	}
+	return id, args[1:], nil
+}
+
+func lookup(ctx context.Context, svc services.UserService, ref string) (*models.User, error) {
+	if strings.Contains(ref, "@") {
+		return svc.GetUserByEmail(ctx, ref)
+	}
+	id, err := strconv.Atoi(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id %q", ref)
+	}
+	return svc.GetUser(ctx, id)
+}
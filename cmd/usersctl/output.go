@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"server-go/models"
+)
+
+// table is the format-independent shape of every command's output.
+type table struct {
+	headers []string
+	rows    [][]string
+}
+
+func (t table) write(w io.Writer, format string) error {
+	if t.headers == nil {
+		return nil
+	}
+	switch format {
+	case "json":
+		records := make([]map[string]string, len(t.rows))
+		for i, row := range t.rows {
+			records[i] = map[string]string{}
+			for j, h := range t.headers {
+				records[i][h] = row[j]
+			}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(t.headers); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(t.rows); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(t.headers, "\t")))
+		for _, row := range t.rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q (want table, json or csv)", format)
+	}
+}
+
+func orgsTable(orgs ...models.Organization) table {
+	t := table{headers: []string{"id", "slug", "name", "createdAt"}}
+	for _, o := range orgs {
+		t.rows = append(t.rows, []string{strconv.Itoa(o.Id), o.Slug, o.Name, o.CreatedAt.Format(time.RFC3339)})
+	}
+	return t
+}
+
+func usersTable(users ...models.User) table {
+	t := table{headers: []string{"id", "tenantId", "name", "lastName", "email", "role", "status", "disabledAt"}}
+	for _, u := range users {
+		disabledAt := ""
+		if u.DisabledAt != nil {
+			disabledAt = u.DisabledAt.Format(time.RFC3339)
+		}
+		t.rows = append(t.rows, []string{strconv.Itoa(u.Id), strconv.Itoa(u.TenantId), u.Name, u.LastName, u.Email, u.Role, u.CurrentStatus(time.Now()), disabledAt})
+	}
+	return t
+}
+
+func statusHistoryTable(changes []models.StatusChange) table {
+	t := table{headers: []string{"id", "from", "to", "reason", "until", "actorId", "createdAt"}}
+	for _, c := range changes {
+		until, actor := "", ""
+		if c.Until != nil {
+			until = c.Until.Format(time.RFC3339)
+		}
+		if c.ActorId != nil {
+			actor = strconv.Itoa(*c.ActorId)
+		}
+		t.rows = append(t.rows, []string{strconv.Itoa(c.Id), c.From, c.To, c.Reason, until, actor, c.CreatedAt.Format(time.RFC3339)})
+	}
+	return t
+}
+
+func resultTable(pairs ...string) table {
+	t := table{rows: [][]string{{}}}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		t.headers = append(t.headers, pairs[i])
+		t.rows[0] = append(t.rows[0], pairs[i+1])
+	}
+	return t
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+
+	"server-go/models"
+)
+
+func createServiceAccountCmd(ctx context.Context, app *app, args []string) (table, error) {
+	fs := flag.NewFlagSet("create-service-account", flag.ExitOnError)
+	name := fs.String("name", "", "display name, e.g. ci-deploy")
+	email := fs.String("email", "", "unique contact address")
+	fs.Parse(args)
+
+	user, err := app.tokens.CreateServiceAccount(ctx, models.CreateServiceAccount{Name: *name, Email: *email})
+	if err != nil {
+		return table{}, err
+	}
+	return usersTable(*user), nil
+}
+
+func tokenCreateCmd(ctx context.Context, app *app, args []string) (table, error) {
+	fs := flag.NewFlagSet("token-create", flag.ExitOnError)
+	name := fs.String("name", "", "what the token is for")
+	scopes := fs.String("scopes", models.TokenScopeRead, "comma-separated scopes: read, write, admin")
+	days := fs.Int("days", models.DefaultAccessTokenDays, "days until the token expires")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return table{}, errors.New("usage: token-create -name N [-scopes S[,S...]] [-days D] <id|email>")
+	}
+
+	user, err := lookup(ctx, app.users, fs.Arg(0))
+	if err != nil {
+		return table{}, err
+	}
+	token, err := app.tokens.Create(ctx, user, user.Id, models.CreateAccessToken{Name: *name, Scopes: splitList(*scopes), ExpiresInDays: *days})
+	if err != nil {
+		return table{}, err
+	}
+	return resultTable("id", strconv.Itoa(token.Id), "token", token.Token, "expiresAt", token.ExpiresAt.Format(time.RFC3339)), nil
+}
+
+func tokenListCmd(ctx context.Context, app *app, args []string) (table, error) {
+	if len(args) != 1 {
+		return table{}, errors.New("usage: token-list <id|email>")
+	}
+	user, err := lookup(ctx, app.users, args[0])
+	if err != nil {
+		return table{}, err
+	}
+	tokens, err := app.tokens.List(ctx, user.Id)
+	if err != nil {
+		return table{}, err
+	}
+	return tokensTable(tokens...), nil
+}
+
+func tokenRevokeCmd(ctx context.Context, app *app, args []string) (table, error) {
+	if len(args) != 2 {
+		return table{}, errors.New("usage: token-revoke <id|email> <token-id>")
+	}
+	user, err := lookup(ctx, app.users, args[0])
+	if err != nil {
+		return table{}, err
+	}
+	id, err := strconv.Atoi(args[1])
+	if err != nil {
+		return table{}, errors.New("invalid token id")
+	}
+	if err := app.tokens.Revoke(ctx, user.Id, id); err != nil {
+		return table{}, err
+	}
+	return resultTable("id", args[1], "status", "revoked"), nil
+}
+
+func tokensTable(tokens ...models.AccessToken) table {
+	t := table{headers: []string{"id", "name", "prefix", "scopes", "expiresAt", "lastUsedAt", "createdAt"}}
+	for _, tok := range tokens {
+		lastUsedAt := ""
+		if tok.LastUsedAt != nil {
+			lastUsedAt = tok.LastUsedAt.Format(time.RFC3339)
+		}
+		t.rows = append(t.rows, []string{strconv.Itoa(tok.Id), tok.Name, tok.Prefix, strings.Join(tok.Scopes, " "),
+			tok.ExpiresAt.Format(time.RFC3339), lastUsedAt, tok.CreatedAt.Format(time.RFC3339)})
+	}
+	return t
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"server-go/middlewares"
+)
+
+// UnversionedAPI reads when the unversioned API paths were deprecated in
+// favour of /v1 (API_UNVERSIONED_DEPRECATED, default 2026-11-01) and when
+// they may stop working (API_UNVERSIONED_SUNSET, default 2027-05-01). Both
+// are dates such as 2027-05-01 or RFC 3339 times.
+func UnversionedAPI(logger *slog.Logger) middlewares.Deprecation {
+	return middlewares.Deprecation{
+		Since:  apiDate(logger, "API_UNVERSIONED_DEPRECATED", time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)),
+		Sunset: apiDate(logger, "API_UNVERSIONED_SUNSET", time.Date(2027, time.May, 1, 0, 0, 0, 0, time.UTC)),
+	}
+}
+
+func apiDate(logger *slog.Logger, name string, fallback time.Time) time.Time {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t
+		}
+	}
+	logger.Warn("invalid date, using the default", "variable", name, "value", v)
+	return fallback
+}
@@ -0,0 +1,15 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// AppURL is the public base URL used in links sent to users (APP_URL).
+func AppURL() string {
+	url := os.Getenv("APP_URL")
+	if url == "" {
+		url = "http://localhost:4000"
+	}
+	return strings.TrimRight(url, "/")
+}
@@ -0,0 +1,13 @@
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+// AuditHashChain reports whether AUDIT_HASH_CHAIN enables tamper-evident
+// hashing of audit events.
+func AuditHashChain() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("AUDIT_HASH_CHAIN"))
+	return enabled
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"server-go/cache"
+)
+
+// UserCache is the cache for user lookups: the Redis-compatible server at
+// USER_CACHE_URL (redis://[:password@]host:port[/db]) when set, otherwise an
+// in-process LRU of USER_CACHE_SIZE entries (default 10000). Entries live
+// for USER_CACHE_TTL (default 30s); 0 turns caching off. Several servers
+// should share Redis, or a change on one is only seen by the others when
+// the TTL runs out.
+func UserCache(logger *slog.Logger) (cache.Cache, time.Duration) {
+	ttl := 30 * time.Second
+	if v := os.Getenv("USER_CACHE_TTL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < 0 {
+			logger.Warn("invalid USER_CACHE_TTL, using the default", "value", v)
+		} else {
+			ttl = parsed
+		}
+	}
+
+	if raw := os.Getenv("USER_CACHE_URL"); raw != "" {
+		u, err := url.Parse(raw)
+		if err == nil && u.Scheme == "redis" && u.Host != "" {
+			password, _ := u.User.Password()
+			db, _ := strconv.Atoi(strings.TrimPrefix(u.Path, "/"))
+			logger.Info("caching users in Redis", "addr", u.Host, "db", db, "ttl", ttl)
+			return cache.Instrument("users", cache.NewRedis(u.Host, password, db, time.Second)), ttl
+		}
+		logger.Warn("invalid USER_CACHE_URL, caching users in process")
+	}
+
+	size, err := strconv.Atoi(os.Getenv("USER_CACHE_SIZE"))
+	if err != nil || size <= 0 {
+		size = 10000
+	}
+	return cache.Instrument("users", cache.NewLRU(size)), ttl
+}
@@ -11,7 +11,8 @@ func CORSmiddleware() gin.HandlerFunc {
 	return cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Tenant", "X-Request-ID"},
+		ExposeHeaders:    []string{"X-Request-ID", "Deprecation", "Sunset", "Link"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	})
@@ -3,31 +3,57 @@ package config
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strconv"
 
 	_ "github.com/lib/pq"
 )
 
-func DatabaseConnection() (*sql.DB, error) {
+func DatabaseConnection(logger *slog.Logger) (*sql.DB, error) {
 	dbHost := os.Getenv("DB_HOST")
 	dbPort := os.Getenv("DB_PORT")
 	dbUser := os.Getenv("DB_USER")
 	dbPassword := os.Getenv("DB_PASSWORD")
 	dbName := os.Getenv("DB_NAME")
-	log.Printf("Database configuration: Host=%s, Port=%s, User=%s, DBName=%s", dbHost, dbPort, dbUser, dbName)
+	logger.Info("database configuration", "host", dbHost, "port", dbPort, "user", dbUser, "dbname", dbName)
 
-	log.Println("Attempting to connect to the database...")
+	logger.Debug("attempting to connect to the database")
 	db, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", dbHost, dbPort, dbUser, dbPassword, dbName))
 	if err != nil {
-		log.Fatal("Failed to connect to the database:", err)
+		logger.Error("failed to connect to the database", "error", err)
+		return nil, err
 	}
-	log.Println("Database connection established")
+	logger.Debug("database connection established")
 
-	log.Println("Pinging the database...")
+	logger.Debug("pinging the database")
 	if err := db.Ping(); err != nil {
-		log.Fatal("Failed to ping the database:", err)
+		logger.Error("failed to ping the database", "error", err)
+		return nil, err
 	}
-	log.Println("Database ping successful")
+	logger.Info("database ping successful")
 	return db, nil
 }
+
+// TxIsolation returns DB_ISOLATION_LEVEL, the isolation of units of work:
+// read-committed (the default), repeatable-read or serializable.
+func TxIsolation() sql.IsolationLevel {
+	switch os.Getenv("DB_ISOLATION_LEVEL") {
+	case "repeatable-read":
+		return sql.LevelRepeatableRead
+	case "serializable":
+		return sql.LevelSerializable
+	default:
+		return sql.LevelReadCommitted
+	}
+}
+
+// TxMaxRetries returns DB_TX_MAX_RETRIES, how often a unit of work is run
+// again after a serialization failure or deadlock (default 3).
+func TxMaxRetries() int {
+	retries, err := strconv.Atoi(os.Getenv("DB_TX_MAX_RETRIES"))
+	if err != nil || retries < 0 {
+		return 3
+	}
+	return retries
+}
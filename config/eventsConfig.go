@@ -0,0 +1,61 @@
+package config
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"server-go/events"
+)
+
+// EventSink publishes to bus and to each sink that is configured:
+// EVENTS_WEBHOOK_URL, EVENTS_NATS_ADDR (subjects under EVENTS_NATS_SUBJECT,
+// default "users") and EVENTS_KAFKA_REST_URL (topic EVENTS_KAFKA_TOPIC,
+// default "users").
+func EventSink(bus events.Bus, logger *slog.Logger) events.Sink {
+	client := &http.Client{Timeout: 10 * time.Second}
+	sinks := []events.Sink{bus}
+	if url := os.Getenv("EVENTS_WEBHOOK_URL"); url != "" {
+		sinks = append(sinks, events.NewWebhookSink(url, client))
+		logger.Info("publishing events to a webhook", "url", url)
+	}
+	if addr := os.Getenv("EVENTS_NATS_ADDR"); addr != "" {
+		sinks = append(sinks, events.NewNATSSink(addr, envOr("EVENTS_NATS_SUBJECT", "users"), 5*time.Second))
+		logger.Info("publishing events to NATS", "addr", addr)
+	}
+	if url := os.Getenv("EVENTS_KAFKA_REST_URL"); url != "" {
+		sinks = append(sinks, events.NewKafkaSink(url, envOr("EVENTS_KAFKA_TOPIC", "users"), client))
+		logger.Info("publishing events to Kafka", "proxy", url)
+	}
+	return events.Fanout(sinks...)
+}
+
+// RelayOptions reads EVENTS_RELAY_INTERVAL (default 1s) and
+// EVENTS_MAX_ATTEMPTS (default 10) for the outbox relay.
+func RelayOptions() events.RelayOptions {
+	interval, err := time.ParseDuration(os.Getenv("EVENTS_RELAY_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = time.Second
+	}
+	attempts, err := strconv.Atoi(os.Getenv("EVENTS_MAX_ATTEMPTS"))
+	if err != nil || attempts <= 0 {
+		attempts = 10
+	}
+	return events.RelayOptions{
+		Interval:    interval,
+		BatchSize:   100,
+		Lease:       time.Minute,
+		MaxAttempts: attempts,
+		Backoff:     time.Second,
+		MaxBackoff:  10 * time.Minute,
+	}
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"database/sql"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"server-go/jobs"
+	"server-go/repositories"
+	"server-go/services"
+)
+
+// JobStore keeps jobs in Postgres, or in memory when JOB_QUEUE=memory;
+// queued jobs are then lost on restart.
+func JobStore(db *sql.DB, logger *slog.Logger) repositories.JobRepository {
+	if os.Getenv("JOB_QUEUE") == "memory" {
+		logger.Warn("background jobs are kept in memory")
+		return jobs.NewMemoryStore()
+	}
+	return repositories.NewJobRepository(db)
+}
+
+// JobOptions reads JOB_WORKERS (default 4) and JOB_MAX_ATTEMPTS (default 5).
+func JobOptions() jobs.Options {
+	workers, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || workers <= 0 {
+		workers = 4
+	}
+	attempts, err := strconv.Atoi(os.Getenv("JOB_MAX_ATTEMPTS"))
+	if err != nil || attempts <= 0 {
+		attempts = 5
+	}
+	return jobs.Options{
+		Workers:      workers,
+		PollInterval: time.Second,
+		Lease:        5 * time.Minute,
+		MaxAttempts:  attempts,
+		Backoff:      10 * time.Second,
+		MaxBackoff:   time.Hour,
+	}
+}
+
+// UserPurge reads when deleted users are purged, USER_PURGE_SCHEDULE (a
+// cron expression, default 03:00 UTC daily), and after how long,
+// USER_RETENTION_DAYS (default 30).
+func UserPurge() (string, services.PurgeUsers) {
+	days, err := strconv.Atoi(os.Getenv("USER_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	return envOr("USER_PURGE_SCHEDULE", "0 3 * * *"), services.PurgeUsers{RetentionDays: days}
+}
+
+// ExportDir is where queued exports are written (EXPORT_DIR, default a
+// directory under the system temp dir). Every server must see the same
+// directory for download links to work.
+func ExportDir(logger *slog.Logger) string {
+	dir := envOr("EXPORT_DIR", filepath.Join(os.TempDir(), "server-go-exports"))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		logger.Error("could not create the export directory", "dir", dir, "error", err)
+	}
+	return dir
+}
+
+// ShutdownTimeout is how long a stopping server waits for requests and
+// jobs in flight (SHUTDOWN_TIMEOUT, default 30s).
+func ShutdownTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return timeout
+}
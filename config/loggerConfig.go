@@ -0,0 +1,19 @@
+package config
+
+import (
+	"io"
+	"log/slog"
+	"os"
+
+	"server-go/logging"
+)
+
+// NewLogger builds the application logger from LOG_LEVEL (debug, info,
+// warn, error) and LOG_FORMAT (json or text), writing to out.
+func NewLogger(out io.Writer) *slog.Logger {
+	return logging.New(logging.Options{
+		Level:  logging.ParseLevel(os.Getenv("LOG_LEVEL")),
+		Format: os.Getenv("LOG_FORMAT"),
+		Output: out,
+	})
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// MagicLinkTTL returns MAGIC_LINK_TTL, how long an emailed sign-in link
+// stays valid (default 15m).
+func MagicLinkTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("MAGIC_LINK_TTL"))
+	if err != nil || ttl <= 0 {
+		return 15 * time.Minute
+	}
+	return ttl
+}
+
+// MagicLinkRateLimit returns MAGIC_LINK_MAX_PER_HOUR, the number of sign-in
+// links one address may request per hour (default 5). Zero disables the
+// limit.
+func MagicLinkRateLimit() int {
+	value := os.Getenv("MAGIC_LINK_MAX_PER_HOUR")
+	if value == "" {
+		return 5
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 5
+	}
+	return limit
+}
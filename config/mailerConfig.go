@@ -0,0 +1,22 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+
+	"server-go/mailer"
+)
+
+// NewMailer uses SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and
+// MAIL_FROM, falling back to a mailer that only logs when SMTP_HOST is unset.
+func NewMailer(logger *slog.Logger) mailer.Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return mailer.NewLogMailer(logger)
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	return mailer.NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("MAIL_FROM"))
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"server-go/oidc"
+)
+
+// Well-known providers only need a client id and secret.
+var oauthPresets = map[string]oidc.Config{
+	"google": {
+		Issuer: "https://accounts.google.com",
+		Scopes: []string{"openid", "email", "profile"},
+	},
+	"github": {
+		AuthURL:     "https://github.com/login/oauth/authorize",
+		TokenURL:    "https://github.com/login/oauth/access_token",
+		UserInfoURL: "https://api.github.com/user",
+		Scopes:      []string{"read:user", "user:email"},
+	},
+}
+
+// OAuthProviders builds the social login registry from OAUTH_PROVIDERS, a
+// comma-separated list of names. Each provider NAME is configured with
+// OAUTH_NAME_CLIENT_ID, OAUTH_NAME_CLIENT_SECRET and, unless it is a
+// preset (google, github), OAUTH_NAME_ISSUER for discovery or explicit
+// OAUTH_NAME_AUTH_URL, _TOKEN_URL, _USERINFO_URL and _JWKS_URL.
+// OAUTH_NAME_SCOPES overrides the scopes. Callbacks go to
+// APP_URL/auth/NAME/callback.
+func OAuthProviders() *oidc.Registry {
+	var configs []oidc.Config
+	for _, name := range strings.Split(os.Getenv("OAUTH_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		cfg := oauthPresets[name]
+		if cfg.Scopes == nil {
+			cfg.Scopes = []string{"openid", "email", "profile"}
+		}
+		cfg.Name = name
+		cfg.RedirectURL = AppURL() + "/auth/" + name + "/callback"
+
+		env := func(key string) string {
+			return os.Getenv("OAUTH_" + strings.ToUpper(name) + "_" + key)
+		}
+		cfg.ClientID = env("CLIENT_ID")
+		cfg.ClientSecret = env("CLIENT_SECRET")
+		override(&cfg.Issuer, env("ISSUER"))
+		override(&cfg.AuthURL, env("AUTH_URL"))
+		override(&cfg.TokenURL, env("TOKEN_URL"))
+		override(&cfg.UserInfoURL, env("USERINFO_URL"))
+		override(&cfg.JWKSURL, env("JWKS_URL"))
+		if scopes := env("SCOPES"); scopes != "" {
+			cfg.Scopes = strings.FieldsFunc(scopes, func(r rune) bool { return r == ',' || r == ' ' })
+		}
+		configs = append(configs, cfg)
+	}
+	return oidc.NewRegistry(&http.Client{Timeout: 10 * time.Second}, configs...)
+}
+
+func override(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
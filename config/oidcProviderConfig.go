@@ -0,0 +1,53 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// OIDCIssuer is the issuer this service uses as an OpenID provider
+// (OIDC_ISSUER, default APP_URL).
+func OIDCIssuer() string {
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		return strings.TrimRight(issuer, "/")
+	}
+	return AppURL()
+}
+
+// OIDCSigningKey loads the RSA key that signs ID and access tokens from the
+// PEM file named by OIDC_SIGNING_KEY_FILE. Without one an ephemeral key is
+// generated, so tokens stop verifying when the server restarts.
+func OIDCSigningKey(logger *slog.Logger) (*rsa.PrivateKey, error) {
+	path := os.Getenv("OIDC_SIGNING_KEY_FILE")
+	if path == "" {
+		logger.Warn("OIDC_SIGNING_KEY_FILE not set, using an ephemeral signing key")
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("OIDC_SIGNING_KEY_FILE: no PEM block")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("OIDC_SIGNING_KEY_FILE: not an RSA key")
+	}
+	return key, nil
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"server-go/passwords"
+)
+
+// PasswordHasher hashes new passwords with PASSWORD_HASH_ALGORITHM,
+// "argon2id" (the default) or "bcrypt". BCRYPT_COST sets the bcrypt cost;
+// ARGON2_MEMORY (KiB), ARGON2_ITERATIONS and ARGON2_PARALLELISM the Argon2id
+// parameters. PASSWORD_PEPPER, when set, is mixed into every password.
+// Invalid settings fall back to the defaults with a warning.
+func PasswordHasher(logger *slog.Logger) passwords.Hasher {
+	cfg := passwords.Config{
+		Algorithm:  os.Getenv("PASSWORD_HASH_ALGORITHM"),
+		BcryptCost: envInt("BCRYPT_COST", 0),
+		Argon2: passwords.Argon2Params{
+			Memory:      uint32(envInt("ARGON2_MEMORY", int(passwords.DefaultArgon2Params.Memory))),
+			Iterations:  uint32(envInt("ARGON2_ITERATIONS", int(passwords.DefaultArgon2Params.Iterations))),
+			Parallelism: uint8(envInt("ARGON2_PARALLELISM", int(passwords.DefaultArgon2Params.Parallelism))),
+			SaltLength:  passwords.DefaultArgon2Params.SaltLength,
+			KeyLength:   passwords.DefaultArgon2Params.KeyLength,
+		},
+	}
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = passwords.Argon2id
+	}
+	if pepper := os.Getenv("PASSWORD_PEPPER"); pepper != "" {
+		cfg.Pepper = []byte(pepper)
+	}
+
+	hasher, err := passwords.New(cfg)
+	if err != nil {
+		logger.Warn("invalid password hashing settings, using defaults", "error", err)
+		hasher, _ = passwords.New(passwords.Config{Algorithm: passwords.Argon2id, Pepper: cfg.Pepper})
+	}
+	return hasher
+}
+
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// PasswordPolicy reads the rules new passwords must follow:
+// PASSWORD_MIN_LENGTH (default 8), PASSWORD_MAX_LENGTH (default 128),
+// PASSWORD_MIN_CLASSES of lowercase, uppercase, digits and symbols (default
+// 0), PASSWORD_REJECT_PERSONAL_INFO ("false" allows the user's name and
+// email, default true), PASSWORD_HISTORY, the number of previous passwords
+// that cannot be reused (default 0), PASSWORD_MAX_AGE, a duration such as
+// "2160h" (default none), and PASSWORD_BREACHED_CORPUS, the path of an
+// offline Have I Been Pwned corpus: a directory of range files or a sorted
+// hash file.
+func PasswordPolicy(logger *slog.Logger) passwords.Policy {
+	policy := passwords.Policy{
+		MinLength:          envInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:          envInt("PASSWORD_MAX_LENGTH", 128),
+		MinClasses:         envInt("PASSWORD_MIN_CLASSES", 0),
+		RejectPersonalInfo: os.Getenv("PASSWORD_REJECT_PERSONAL_INFO") != "false",
+		History:            envInt("PASSWORD_HISTORY", 0),
+	}
+	if maxAge, err := time.ParseDuration(os.Getenv("PASSWORD_MAX_AGE")); err == nil && maxAge > 0 {
+		policy.MaxAge = maxAge
+	}
+	if path := os.Getenv("PASSWORD_BREACHED_CORPUS"); path != "" {
+		corpus, err := passwords.OpenCorpus(path)
+		if err != nil {
+			logger.Error("breached password corpus unavailable, check disabled", "path", path, "error", err)
+		} else {
+			policy.Breached = corpus
+		}
+	}
+	return policy
+}
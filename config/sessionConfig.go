@@ -0,0 +1,39 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// SessionLimit returns SESSION_MAX_PER_USER, the number of concurrent
+// sessions a user may have before the oldest are signed out. Zero, the
+// default, means no limit.
+func SessionLimit() int {
+	limit, err := strconv.Atoi(os.Getenv("SESSION_MAX_PER_USER"))
+	if err != nil || limit < 0 {
+		return 0
+	}
+	return limit
+}
+
+// RecentAuthWindow returns REAUTH_WINDOW, how long after signing in a user
+// may change their password or email without signing in again (default
+// 10m).
+func RecentAuthWindow() time.Duration {
+	window, err := time.ParseDuration(os.Getenv("REAUTH_WINDOW"))
+	if err != nil || window <= 0 {
+		return 10 * time.Minute
+	}
+	return window
+}
+
+// FreshUsers reports whether AUTH_FRESH_USERS makes the routes that act on
+// the caller's account, role or credentials, and token introspection, load
+// the user on every request, so that password changes and suspensions end
+// existing tokens at once. Other routes trust the token's claims either
+// way.
+func FreshUsers() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("AUTH_FRESH_USERS"))
+	return enabled
+}
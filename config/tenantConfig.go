@@ -0,0 +1,25 @@
+package config
+
+import (
+	"os"
+
+	"server-go/tenancy"
+)
+
+// TenantOptions reads TENANT_BASE_DOMAIN, TENANT_HEADER (default X-Tenant)
+// and TENANT_DEFAULT (default "default"; set it empty to require every
+// request to name its organization).
+func TenantOptions() tenancy.Options {
+	opts := tenancy.Options{
+		BaseDomain:  os.Getenv("TENANT_BASE_DOMAIN"),
+		Header:      os.Getenv("TENANT_HEADER"),
+		DefaultSlug: "default",
+	}
+	if opts.Header == "" {
+		opts.Header = "X-Tenant"
+	}
+	if slug, ok := os.LookupEnv("TENANT_DEFAULT"); ok {
+		opts.DefaultSlug = slug
+	}
+	return opts
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"strings"
+
+	"server-go/webauthn"
+)
+
+// WebAuthn describes this service as a WebAuthn relying party.
+// WEBAUTHN_RP_ID defaults to the host of APP_URL and WEBAUTHN_ORIGINS, a
+// comma-separated list, to its origin. WEBAUTHN_USER_VERIFICATION is
+// "required", "preferred" (the default) or "discouraged".
+func WebAuthn() webauthn.Config {
+	app, _ := url.Parse(AppURL())
+	cfg := webauthn.Config{
+		RPID:             os.Getenv("WEBAUTHN_RP_ID"),
+		RPName:           os.Getenv("WEBAUTHN_RP_NAME"),
+		UserVerification: os.Getenv("WEBAUTHN_USER_VERIFICATION"),
+	}
+	if cfg.RPID == "" && app != nil {
+		cfg.RPID = app.Hostname()
+	}
+	if cfg.RPName == "" {
+		cfg.RPName = "server-go"
+	}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			cfg.Origins = append(cfg.Origins, origin)
+		}
+	}
+	if len(cfg.Origins) == 0 && app != nil {
+		cfg.Origins = []string{app.Scheme + "://" + app.Host}
+	}
+	return cfg
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"server-go/events"
+	"server-go/services"
+)
+
+// webhookTimeout bounds one delivery request.
+const webhookTimeout = 10 * time.Second
+
+// WebhookClient returns the client organization webhooks are delivered
+// with. It refuses private and loopback addresses unless
+// WEBHOOK_ALLOW_PRIVATE_NETWORKS is true, which is meant for development
+// against local receivers.
+func WebhookClient() *http.Client {
+	allowPrivate, _ := strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS"))
+	return events.NewWebhookClient(webhookTimeout, allowPrivate)
+}
+
+// WebhookOptions reads WEBHOOK_MAX_ATTEMPTS (default 8: retries a minute,
+// then 2, 4, ... 64 minutes apart, about two hours in all) and
+// WEBHOOK_DISABLE_AFTER (default 25 failed requests in a row).
+func WebhookOptions() services.WebhookOptions {
+	attempts, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS"))
+	if err != nil || attempts <= 0 {
+		attempts = 8
+	}
+	disableAfter, err := strconv.Atoi(os.Getenv("WEBHOOK_DISABLE_AFTER"))
+	if err != nil || disableAfter <= 0 {
+		disableAfter = 25
+	}
+	// A batch is sent one request after another, so it must finish within
+	// the lease even when every receiver times out; otherwise another
+	// dispatcher claims the rest again while they are still in flight
+	lease := time.Minute
+	return services.WebhookOptions{
+		Interval:     time.Second,
+		BatchSize:    int(lease/webhookTimeout) - 1,
+		Lease:        lease,
+		MaxAttempts:  attempts,
+		Backoff:      time.Minute,
+		MaxBackoff:   6 * time.Hour,
+		DisableAfter: disableAfter,
+	}
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"server-go/models"
+	"server-go/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccessTokenController struct {
+	tokenService services.AccessTokenService
+	logger       *slog.Logger
+}
+
+func NewAccessTokenController(tokenService services.AccessTokenService, logger *slog.Logger) *AccessTokenController {
+	return &AccessTokenController{tokenService: tokenService, logger: logger}
+}
+
+// List shows the caller's active access tokens.
+func (ctrl *AccessTokenController) List(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	ctrl.list(c, user.Id)
+}
+
+// Create issues an access token for the caller. The token is in the
+// response and cannot be retrieved again.
+func (ctrl *AccessTokenController) Create(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	ctrl.create(c, user, user.Id)
+}
+
+func (ctrl *AccessTokenController) Revoke(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	ctrl.revoke(c, user.Id, c.Param("id"))
+}
+
+// ListForUser, CreateForUser and RevokeForUser manage another user's
+// tokens, typically a service account's.
+func (ctrl *AccessTokenController) ListForUser(c *gin.Context) {
+	if userId, ok := userIdParam(c); ok {
+		ctrl.list(c, userId)
+	}
+}
+
+func (ctrl *AccessTokenController) CreateForUser(c *gin.Context) {
+	actor, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if userId, ok := userIdParam(c); ok {
+		ctrl.create(c, actor, userId)
+	}
+}
+
+func (ctrl *AccessTokenController) RevokeForUser(c *gin.Context) {
+	if userId, ok := userIdParam(c); ok {
+		ctrl.revoke(c, userId, c.Param("tokenId"))
+	}
+}
+
+func (ctrl *AccessTokenController) CreateServiceAccount(c *gin.Context) {
+	var input models.CreateServiceAccount
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+		return
+	}
+
+	user, err := ctrl.tokenService.CreateServiceAccount(c.Request.Context(), input)
+	if err != nil {
+		switch err.Error() {
+		case "name is required":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		case "invalid email":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email"})
+		case "user already exists":
+			c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "failed to create service account", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+func userIdParam(c *gin.Context) (int, bool) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, false
+	}
+	return userId, true
+}
+
+func (ctrl *AccessTokenController) list(c *gin.Context, userId int) {
+	tokens, err := ctrl.tokenService.List(c.Request.Context(), userId)
+	if err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to list access tokens", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load access tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+func (ctrl *AccessTokenController) create(c *gin.Context, actor *models.User, userId int) {
+	var input models.CreateAccessToken
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+		return
+	}
+
+	token, err := ctrl.tokenService.Create(c.Request.Context(), actor, userId, input)
+	if err != nil {
+		switch err.Error() {
+		case "name is required":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		case "invalid scope":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Scopes must be read, write or admin"})
+		case "invalid expiry":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expiresInDays must be between 1 and " + strconv.Itoa(models.MaxAccessTokenDays)})
+		case "scope not granted":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access tokens can only create tokens with their own scopes"})
+		case "user not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "failed to create access token", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, token)
+}
+
+func (ctrl *AccessTokenController) revoke(c *gin.Context, userId int, param string) {
+	id, err := strconv.Atoi(param)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	if err := ctrl.tokenService.Revoke(c.Request.Context(), userId, id); err != nil {
+		if err.Error() == "token not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+			return
+		}
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to revoke access token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
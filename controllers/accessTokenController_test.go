@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/middlewares"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryAccessTokens struct {
+	repositories.AccessTokenRepository
+	byHash map[string]*models.AccessToken
+	nextId int
+}
+
+func (r *memoryAccessTokens) Create(ctx context.Context, token *models.AccessToken, tokenHash string) (*models.AccessToken, error) {
+	r.nextId++
+	created := *token
+	created.Id = r.nextId
+	created.TenantId = 2
+	created.CreatedAt = time.Now()
+	r.byHash[tokenHash] = &created
+	return &created, nil
+}
+
+func (r *memoryAccessTokens) FindByHash(ctx context.Context, tokenHash string) (*models.AccessToken, error) {
+	return r.byHash[tokenHash], nil
+}
+
+func (r *memoryAccessTokens) Revoke(ctx context.Context, userId int, id int) (bool, error) {
+	for _, token := range r.byHash {
+		if token.Id == id && token.UserId == userId && token.RevokedAt == nil {
+			now := time.Now()
+			token.RevokedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryAccessTokens) Touch(ctx context.Context, id int) error {
+	for _, token := range r.byHash {
+		if token.Id == id {
+			now := time.Now()
+			token.LastUsedAt = &now
+		}
+	}
+	return nil
+}
+
+type noMemberships struct {
+	repositories.MembershipRepository
+}
+
+func (noMemberships) Find(ctx context.Context, userId int, orgId int) (*models.Membership, error) {
+	return nil, nil
+}
+
+func TestAccessTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	middlewares.JwtSecret = []byte("test-secret")
+
+	tokens := &memoryAccessTokens{byHash: map[string]*models.AccessToken{}}
+	users := &oneUser{user: &models.User{Id: 7, Name: "Jane", LastName: "Doe", Email: "jane@example.com", Role: models.RoleAdmin, TenantId: 2}}
+	svc := services.NewAccessTokenService(nil, tokens, users, noMemberships{}, discardAudit{}, logging.Discard())
+	revoked := middlewares.WithAccessTokens(&revocations{jtis: map[string]bool{}}, svc)
+	ctrl := NewAccessTokenController(svc, logging.Discard())
+
+	auth := middlewares.AuthMiddleware(logging.Discard(), revoked)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router := gin.New()
+	router.GET("/me", auth, ok)
+	router.PUT("/me", auth, ok)
+	router.GET("/audit", auth, middlewares.RequireRole(models.RoleAdmin), ok)
+	router.POST("/me/tokens", auth, ctrl.Create)
+	router.DELETE("/me/tokens/:id", auth, ctrl.Revoke)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	session := sessionToken(t, nil)
+	create := func(scopes string) models.NewAccessToken {
+		w := do(http.MethodPost, "/me/tokens", session, `{"name":"ci","scopes":`+scopes+`}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var created models.NewAccessToken
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		return created
+	}
+
+	read := create(`["read"]`)
+	assert.True(t, strings.HasPrefix(read.Token, models.AccessTokenPrefix))
+	assert.True(t, strings.HasPrefix(read.Token, read.Prefix))
+	assert.WithinDuration(t, time.Now().Add(models.DefaultAccessTokenDays*24*time.Hour), read.ExpiresAt, time.Minute)
+	_, kept := tokens.byHash[read.Token]
+	assert.False(t, kept, "only the hash is stored")
+
+	t.Run("scopes", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/me", read.Token, "").Code)
+		assert.Equal(t, http.StatusForbidden, do(http.MethodPut, "/me", read.Token, "").Code)
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/audit", read.Token, "").Code)
+
+		admin := create(`["write","admin"]`)
+		assert.Equal(t, http.StatusOK, do(http.MethodPut, "/me", admin.Token, "").Code)
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/audit", admin.Token, "").Code)
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/audit", session, "").Code, "session tokens are unscoped")
+	})
+
+	t.Run("tokens cannot widen themselves", func(t *testing.T) {
+		write := create(`["write"]`)
+		for _, stored := range tokens.byHash {
+			if stored.Id == write.Id {
+				stored.ExpiresAt = time.Now().Add(48 * time.Hour)
+			}
+		}
+
+		w := do(http.MethodPost, "/me/tokens", write.Token, `{"name":"x","scopes":["admin"]}`)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+
+		w = do(http.MethodPost, "/me/tokens", write.Token, `{"name":"x","scopes":["read"],"expiresInDays":90}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var narrower models.NewAccessToken
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &narrower))
+		assert.WithinDuration(t, time.Now().Add(48*time.Hour), narrower.ExpiresAt, time.Minute, "capped at the caller's expiry")
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/me/tokens", session, `{"name":"x","scopes":["root"]}`).Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/me/tokens", session, `{"name":"x","scopes":["read"],"expiresInDays":1000}`).Code)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/me", models.AccessTokenPrefix+"unknown", "").Code)
+	})
+
+	t.Run("last use is recorded", func(t *testing.T) {
+		for _, stored := range tokens.byHash {
+			if stored.Id == read.Id {
+				assert.NotNil(t, stored.LastUsedAt)
+			}
+		}
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/me/tokens/"+strconv.Itoa(read.Id), session, "").Code)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/me", read.Token, "").Code)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/me/tokens/"+strconv.Itoa(read.Id), session, "").Code)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expiring := create(`["read"]`)
+		for _, stored := range tokens.byHash {
+			if stored.Id == expiring.Id {
+				stored.ExpiresAt = time.Now().Add(-time.Second)
+			}
+		}
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/me", expiring.Token, "").Code)
+	})
+}
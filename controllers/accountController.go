@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"server-go/models"
+	"server-go/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccountController struct {
+	accountService services.AccountService
+	logger         *slog.Logger
+}
+
+func NewAccountController(accountService services.AccountService, logger *slog.Logger) *AccountController {
+	return &AccountController{accountService: accountService, logger: logger}
+}
+
+// ChangePassword sets the caller's password and signs their other devices
+// out.
+func (ctrl *AccountController) ChangePassword(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.ChangePassword
+	if err := c.ShouldBindJSON(&input); err != nil || input.CurrentPassword == "" || input.NewPassword == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "currentPassword and newPassword are required"})
+		return
+	}
+
+	token, err := ctrl.accountService.ChangePassword(c.Request.Context(), user, input, c.Writer)
+	if err != nil {
+		if validationFailed(c, err) {
+			return
+		}
+		switch err.Error() {
+		case "invalid current password":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		case "service accounts have no password":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Service accounts have no password"})
+		case "user not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "failed to change password", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed", "token": token})
+}
+
+// ChangeEmail starts a change of the caller's address; it takes effect once
+// the link sent to the new address is followed.
+func (ctrl *AccountController) ChangeEmail(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.ChangeEmail
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+		return
+	}
+
+	if err := ctrl.accountService.RequestEmailChange(c.Request.Context(), user, input); err != nil {
+		switch err.Error() {
+		case "invalid email":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A valid email is required"})
+		case "email unchanged":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This is already your email"})
+		case "email already in use":
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		case "user not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "failed to request email change", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		}
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Confirmation link sent to the new address"})
+}
+
+// emailChangePage asks the user to confirm the new address. As with sign-in
+// links, a scanner fetching the emailed URL changes nothing.
+var emailChangePage = template.Must(template.New("email-change").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Confirm email</title></head>
+<body>
+{{if .Error}}<p role="alert">{{.Error}}</p>
+{{else}}<form method="post" action="/v1/email/confirm">
+<input type="hidden" name="token" value="{{.Token}}">
+<p>Use {{.Email}} for your account?</p>
+<button type="submit">Confirm</button>
+</form>
+{{end}}</body>
+</html>
+`))
+
+// ConfirmPage renders the page the emailed link opens.
+func (ctrl *AccountController) ConfirmPage(c *gin.Context) {
+	token := c.Query("token")
+	view := magicLinkView{Token: token}
+	status := http.StatusOK
+
+	email, err := ctrl.accountService.CheckEmailChange(c.Request.Context(), token)
+	if err != nil {
+		view.Error = "This confirmation link is invalid or has expired."
+		status = http.StatusBadRequest
+	}
+	view.Email = email
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Status(status)
+	if err := emailChangePage.Execute(c.Writer, view); err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to render email change page", "error", err)
+	}
+}
+
+// ConfirmEmail redeems the link, from the confirmation form or as JSON.
+func (ctrl *AccountController) ConfirmEmail(c *gin.Context) {
+	var input models.EmailChangeConfirm
+	if err := c.ShouldBind(&input); err != nil || input.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+		return
+	}
+
+	user, err := ctrl.accountService.ConfirmEmailChange(c.Request.Context(), input.Token)
+	if err != nil {
+		switch err.Error() {
+		case "invalid or expired link":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+		case "email already in use":
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "failed to confirm email change", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email changed", "email": user.Email})
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"server-go/models"
+	"server-go/services"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+type AuditController struct {
+	auditService services.AuditService
+}
+
+func NewAuditController(auditService services.AuditService) *AuditController {
+	return &AuditController{auditService: auditService}
+}
+
+// List returns audit events newest first. Supported query parameters:
+// actor_id, target_id, action, since, until (RFC 3339), limit and offset.
+func (ctrl *AuditController) List(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter", "details": err.Error()})
+		return
+	}
+
+	events, total, err := ctrl.auditService.List(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load audit events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"total":  total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	})
+}
+
+func parseAuditFilter(c *gin.Context) (models.AuditFilter, error) {
+	filter := models.AuditFilter{
+		Action: c.Query("action"),
+		Limit:  defaultAuditPageSize,
+	}
+
+	ints := []struct {
+		name string
+		dest **int
+	}{{"actor_id", &filter.ActorId}, {"target_id", &filter.TargetId}}
+	for _, p := range ints {
+		if v := c.Query(p.name); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				return filter, err
+			}
+			*p.dest = &id
+		}
+	}
+
+	times := []struct {
+		name string
+		dest **time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}}
+	for _, p := range times {
+		if v := c.Query(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, err
+			}
+			*p.dest = &t
+		}
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return filter, errors.New("limit must be a positive integer")
+		}
+		if limit > maxAuditPageSize {
+			limit = maxAuditPageSize
+		}
+		filter.Limit = limit
+	}
+	if v := c.Query("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return filter, errors.New("offset must be a non-negative integer")
+		}
+		filter.Offset = offset
+	}
+	return filter, nil
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"server-go/models"
+	"server-go/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pagedAudit serves a fixed trail through List, applying the filter's
+// action and paging the way the repository does.
+type pagedAudit struct {
+	services.AuditService
+	events []models.AuditEvent
+	filter models.AuditFilter
+}
+
+func (a *pagedAudit) List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, int, error) {
+	a.filter = filter
+	matching := []models.AuditEvent{}
+	for _, e := range a.events {
+		if filter.Action == "" || e.Action == filter.Action {
+			matching = append(matching, e)
+		}
+	}
+	page := matching[min(filter.Offset, len(matching)):]
+	return page[:min(filter.Limit, len(page))], len(matching), nil
+}
+
+func TestAuditList(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	audit := &pagedAudit{}
+	for i := 10; i > 0; i-- {
+		action := models.AuditLogin
+		if i%2 == 0 {
+			action = models.AuditLoginFailed
+		}
+		audit.events = append(audit.events, models.AuditEvent{Id: int64(i), Action: action})
+	}
+	router := gin.New()
+	router.GET("/audit", NewAuditController(audit).List)
+
+	type auditPage struct {
+		Events []models.AuditEvent `json:"events"`
+		Total  int                 `json:"total"`
+		Limit  int                 `json:"limit"`
+		Offset int                 `json:"offset"`
+	}
+	list := func(query string) (int, auditPage) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/audit"+query, nil))
+		var page auditPage
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		}
+		return w.Code, page
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		code, page := list("")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 10, page.Total)
+		assert.Equal(t, defaultAuditPageSize, page.Limit)
+		assert.Len(t, page.Events, 10)
+		assert.Equal(t, models.AuditFilter{Limit: defaultAuditPageSize}, audit.filter)
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		code, page := list("?action=user.login_failed&actor_id=7&target_id=8&since=2024-05-01T00:00:00Z&until=2024-06-01T00:00:00Z")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 5, page.Total)
+		for _, e := range page.Events {
+			assert.Equal(t, models.AuditLoginFailed, e.Action)
+		}
+		require.NotNil(t, audit.filter.ActorId)
+		require.NotNil(t, audit.filter.TargetId)
+		assert.Equal(t, 7, *audit.filter.ActorId)
+		assert.Equal(t, 8, *audit.filter.TargetId)
+		assert.True(t, audit.filter.Since.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)))
+		assert.True(t, audit.filter.Until.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		code, page := list("?limit=3&offset=3")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 10, page.Total, "the total ignores paging")
+		assert.Equal(t, 3, page.Limit)
+		assert.Equal(t, 3, page.Offset)
+		require.Len(t, page.Events, 3)
+		assert.EqualValues(t, 7, page.Events[0].Id, "newest first, after the first page")
+
+		_, page = list("?limit=3&offset=9")
+		assert.Len(t, page.Events, 1)
+
+		_, page = list("?limit=100000")
+		assert.Equal(t, maxAuditPageSize, page.Limit, "the page size is capped")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, query := range []string{"?actor_id=jane", "?since=yesterday", "?limit=0", "?offset=-1"} {
+			code, _ := list(query)
+			assert.Equal(t, http.StatusBadRequest, code, query)
+		}
+	})
+}
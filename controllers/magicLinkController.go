@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"server-go/models"
+	"server-go/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MagicLinkController struct {
+	magicLinkService services.MagicLinkService
+	logger           *slog.Logger
+}
+
+func NewMagicLinkController(magicLinkService services.MagicLinkService, logger *slog.Logger) *MagicLinkController {
+	return &MagicLinkController{magicLinkService: magicLinkService, logger: logger}
+}
+
+// magicLinkPage asks the user to confirm the sign-in. Nothing is redeemed
+// until the form is submitted, so a scanner fetching the link is harmless.
+var magicLinkPage = template.Must(template.New("magic-link").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+{{if .Error}}<p role="alert">{{.Error}}</p>
+{{else}}<form method="post" action="/v1/login/magic/verify">
+<input type="hidden" name="token" value="{{.Token}}">
+<p>Continue as {{.Email}}?</p>
+<button type="submit">Sign in</button>
+</form>
+{{end}}</body>
+</html>
+`))
+
+type magicLinkView struct {
+	Token string
+	Email string
+	Error string
+}
+
+func (ctrl *MagicLinkController) Request(c *gin.Context) {
+	var input models.MagicLinkRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+		return
+	}
+
+	if err := ctrl.magicLinkService.Request(c.Request.Context(), input); err != nil {
+		switch err.Error() {
+		case "invalid email":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A valid email is required"})
+		case "too many requests":
+			c.Header("Retry-After", "3600")
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many sign-in links requested, try again later"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "failed to send magic link", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		}
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "If an account exists for this email, a sign-in link has been sent"})
+}
+
+// Confirm renders the page the emailed link opens.
+func (ctrl *MagicLinkController) Confirm(c *gin.Context) {
+	token := c.Query("token")
+	view := magicLinkView{Token: token}
+	status := http.StatusOK
+
+	email, err := ctrl.magicLinkService.Check(c.Request.Context(), token)
+	if err != nil {
+		view.Error = "This sign-in link is invalid or has expired."
+		status = http.StatusBadRequest
+	}
+	view.Email = email
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Cache-Control", "no-store")
+	// The token is in the URL; keep it out of Referer headers
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Status(status)
+	if err := magicLinkPage.Execute(c.Writer, view); err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to render magic link page", "error", err)
+	}
+}
+
+// Verify redeems the link, from the confirmation form or as JSON.
+func (ctrl *MagicLinkController) Verify(c *gin.Context) {
+	var input models.MagicLinkConfirm
+	if err := c.ShouldBind(&input); err != nil || input.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+		return
+	}
+
+	token, err := ctrl.magicLinkService.Redeem(c.Request.Context(), input, c.Writer)
+	if err != nil {
+		switch err.Error() {
+		case "invalid or expired link":
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		case "account disabled":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "magic link login failed", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "message": "Login successful"})
+}
@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"server-go/middlewares"
+	"server-go/models"
+	"server-go/services"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCProviderController struct {
+	provider    services.OIDCProviderService
+	userService services.UserService
+	revocations middlewares.RevocationChecker
+	logger      *slog.Logger
+}
+
+func NewOIDCProviderController(provider services.OIDCProviderService, userService services.UserService, revocations middlewares.RevocationChecker, logger *slog.Logger) *OIDCProviderController {
+	return &OIDCProviderController{provider: provider, userService: userService, revocations: revocations, logger: logger}
+}
+
+// authorizePage asks for consent to the requested scopes, and for
+// credentials too when there is no session.
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in to {{.Client.Name}}</title></head>
+<body>
+<h1>{{.Client.Name}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="/authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}{{if .Login}}<label>Email <input type="email" name="email" required></label>
+<label>Password <input type="password" name="password" required></label>
+{{else}}<p>Signed in as {{.User.Email}}</p>
+{{end}}<p>{{.Client.Name}} would like to access:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+<button type="submit" name="consent" value="allow">Allow</button>
+<button type="submit" name="consent" value="deny" formnovalidate>Deny</button>
+</form>
+</body>
+</html>
+`))
+
+type authorizeView struct {
+	Client *models.OAuthClient
+	User   *models.User
+	Params map[string]string
+	Scopes []string
+	Login  bool
+	Error  string
+}
+
+func (ctrl *OIDCProviderController) Discovery(c *gin.Context) {
+	c.JSON(http.StatusOK, ctrl.provider.Discovery())
+}
+
+func (ctrl *OIDCProviderController) JWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, ctrl.provider.JWKS())
+}
+
+func (ctrl *OIDCProviderController) Authorize(c *gin.Context) {
+	var req models.AuthorizationRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	client, ok := ctrl.checkAuthorization(c, req)
+	if !ok {
+		return
+	}
+
+	user := ctrl.sessionUser(c)
+	if c.Request.Method == http.MethodPost {
+		if c.PostForm("consent") == "deny" {
+			c.Redirect(http.StatusFound, ctrl.provider.Deny(c.Request.Context(), user, req))
+			return
+		}
+		if email := c.PostForm("email"); email != "" {
+			var err error
+			if user, err = ctrl.login(c, email, c.PostForm("password")); err != nil {
+				ctrl.render(c, http.StatusUnauthorized, client, nil, req, err.Error())
+				return
+			}
+		}
+	}
+	if user == nil {
+		ctrl.render(c, http.StatusOK, client, nil, req, "")
+		return
+	}
+
+	grant := c.Request.Method == http.MethodPost && c.PostForm("consent") == "allow"
+	if !grant {
+		needed, err := ctrl.provider.NeedsConsent(c.Request.Context(), user, req)
+		if err != nil {
+			ctrl.logger.ErrorContext(c.Request.Context(), "failed to check consent", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+		if needed {
+			ctrl.render(c, http.StatusOK, client, user, req, "")
+			return
+		}
+	}
+
+	redirect, err := ctrl.provider.Authorize(c.Request.Context(), user, req, grant)
+	if err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to authorize client", "error", err)
+		c.Redirect(http.StatusFound, services.AuthorizationErrorRedirect(req, &models.OAuthError{Code: "server_error"}))
+		return
+	}
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// checkAuthorization answers invalid requests itself: back to the client
+// when its redirect URI is known, to the browser otherwise.
+func (ctrl *OIDCProviderController) checkAuthorization(c *gin.Context, req models.AuthorizationRequest) (*models.OAuthClient, bool) {
+	client, err := ctrl.provider.CheckAuthorization(c.Request.Context(), req)
+	if err == nil {
+		return client, true
+	}
+
+	var oauthErr *models.OAuthError
+	if !errors.As(err, &oauthErr) {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to load client", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return nil, false
+	}
+	if client == nil {
+		c.JSON(http.StatusBadRequest, oauthErr)
+		return nil, false
+	}
+	c.Redirect(http.StatusFound, services.AuthorizationErrorRedirect(req, oauthErr))
+	return nil, false
+}
+
+// sessionUser is the user of a valid session cookie, if any.
+func (ctrl *OIDCProviderController) sessionUser(c *gin.Context) *models.User {
+	cookie, err := c.Cookie("session_token")
+	if err != nil || cookie == "" {
+		return nil
+	}
+	user, _, err := middlewares.ValidateToken(c.Request.Context(), cookie, ctrl.revocations)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// login checks credentials with Login, which also starts a session here.
+func (ctrl *OIDCProviderController) login(c *gin.Context, email string, password string) (*models.User, error) {
+	token, err := ctrl.userService.Login(c.Request.Context(), models.LoginUser{Email: email, Password: password}, c.Writer)
+	if err != nil {
+		switch err.Error() {
+		case "user not found", "invalid credentials":
+			return nil, errors.New("Invalid email or password")
+		case "account disabled":
+			return nil, errors.New("This account is disabled")
+		case "password expired":
+			return nil, errors.New("Your password has expired, sign in to the app to choose a new one")
+		}
+		ctrl.logger.ErrorContext(c.Request.Context(), "login during authorization failed", "error", err)
+		return nil, errors.New("Sign in failed, please try again")
+	}
+	user, _, err := middlewares.ValidateToken(c.Request.Context(), token, nil)
+	return user, err
+}
+
+func (ctrl *OIDCProviderController) render(c *gin.Context, status int, client *models.OAuthClient, user *models.User, req models.AuthorizationRequest, message string) {
+	params := map[string]string{
+		"client_id":             req.ClientId,
+		"redirect_uri":          req.RedirectURI,
+		"response_type":         req.ResponseType,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+	}
+	view := authorizeView{
+		Client: client,
+		User:   user,
+		Params: params,
+		Scopes: strings.Fields(req.Scope),
+		Login:  user == nil,
+		Error:  message,
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	if err := authorizePage.Execute(c.Writer, view); err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to render authorization page", "error", err)
+	}
+}
+
+func (ctrl *OIDCProviderController) Token(c *gin.Context) {
+	var req models.TokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.OAuthError{Code: "invalid_request"})
+		return
+	}
+	// client_secret_basic takes precedence over client_secret_post
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		req.ClientId, req.ClientSecret = id, secret
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	response, err := ctrl.provider.Token(c.Request.Context(), req)
+	if err != nil {
+		var oauthErr *models.OAuthError
+		if !errors.As(err, &oauthErr) {
+			ctrl.logger.ErrorContext(c.Request.Context(), "token request failed", "error", err)
+			c.JSON(http.StatusInternalServerError, models.OAuthError{Code: "server_error"})
+			return
+		}
+		status := http.StatusBadRequest
+		if oauthErr.Code == "invalid_client" {
+			status = http.StatusUnauthorized
+			c.Header("WWW-Authenticate", `Basic realm="token"`)
+		}
+		c.JSON(status, oauthErr)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (ctrl *OIDCProviderController) UserInfo(c *gin.Context) {
+	accessToken := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if accessToken == "" || accessToken == c.GetHeader("Authorization") {
+		c.Header("WWW-Authenticate", `Bearer`)
+		c.JSON(http.StatusUnauthorized, models.OAuthError{Code: "invalid_token"})
+		return
+	}
+
+	claims, err := ctrl.provider.UserInfo(c.Request.Context(), accessToken)
+	if err != nil {
+		var oauthErr *models.OAuthError
+		if errors.As(err, &oauthErr) {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, oauthErr)
+			return
+		}
+		ctrl.logger.ErrorContext(c.Request.Context(), "userinfo failed", "error", err)
+		c.JSON(http.StatusInternalServerError, models.OAuthError{Code: "server_error"})
+		return
+	}
+	c.JSON(http.StatusOK, claims)
+}
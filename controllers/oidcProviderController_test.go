@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/oidc"
+	"server-go/repositories"
+	"server-go/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryClients struct {
+	repositories.OAuthClientRepository
+	clients map[string]*models.OAuthClient
+}
+
+func (r *memoryClients) FindByClientID(ctx context.Context, id string) (*models.OAuthClient, error) {
+	return r.clients[id], nil
+}
+
+type memoryConsents struct {
+	repositories.ConsentRepository
+	scopes map[int][]string
+}
+
+func (r *memoryConsents) Find(ctx context.Context, userId int, clientId string) (*models.Consent, error) {
+	if scopes, ok := r.scopes[userId]; ok {
+		return &models.Consent{UserId: userId, ClientId: clientId, Scopes: scopes}, nil
+	}
+	return nil, nil
+}
+
+func (r *memoryConsents) Grant(ctx context.Context, userId int, clientId string, scopes []string) error {
+	r.scopes[userId] = scopes
+	return nil
+}
+
+type memoryCodes struct {
+	codes map[string]*models.AuthorizationCode
+}
+
+func (r *memoryCodes) Create(ctx context.Context, code *models.AuthorizationCode) error {
+	r.codes[code.CodeHash] = code
+	return nil
+}
+
+func (r *memoryCodes) Consume(ctx context.Context, hash string) (*models.AuthorizationCode, error) {
+	code := r.codes[hash]
+	delete(r.codes, hash)
+	return code, nil
+}
+
+type oneUser struct {
+	repositories.UserRepository
+	user *models.User
+}
+
+func (r *oneUser) FindByID(ctx context.Context, id int) (*models.User, error) {
+	if id == r.user.Id {
+		user := *r.user
+		return &user, nil
+	}
+	return nil, nil
+}
+
+type discardAudit struct{ services.AuditService }
+
+func (discardAudit) Record(ctx context.Context, event models.AuditEvent) {}
+
+func TestOIDCProviderWithRelyingParty(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	client, secret, err := services.NewOAuthClient("Wiki", []string{"https://wiki.example/cb"}, nil, false)
+	require.NoError(t, err)
+	public, _, err := services.NewOAuthClient("CLI", []string{"http://127.0.0.1/cb"}, nil, true)
+	require.NoError(t, err)
+	clients := &memoryClients{clients: map[string]*models.OAuthClient{client.ClientId: client, public.ClientId: public}}
+	signedIn := time.Now().Add(-time.Hour).Truncate(time.Second)
+	user := &models.User{Id: 7, Name: "Jane", LastName: "Doe", Email: "jane@example.com", TenantId: 2, AuthTime: signedIn}
+
+	router := gin.New()
+	server := httptest.NewServer(router)
+	defer server.Close()
+	svc := services.NewOIDCProviderService(server.URL, key, clients, &memoryConsents{scopes: map[int][]string{}},
+		&memoryCodes{codes: map[string]*models.AuthorizationCode{}}, &oneUser{user: user}, discardAudit{}, logging.Discard())
+	ctrl := NewOIDCProviderController(svc, nil, nil, logging.Discard())
+	router.GET("/.well-known/openid-configuration", ctrl.Discovery)
+	router.GET("/.well-known/jwks.json", ctrl.JWKS)
+	router.POST("/token", ctrl.Token)
+	router.GET("/userinfo", ctrl.UserInfo)
+
+	ctx := context.Background()
+	rp := oidc.NewProvider(oidc.Config{
+		Name: "sso", Issuer: server.URL, ClientID: client.ClientId, ClientSecret: secret,
+		RedirectURL: "https://wiki.example/cb", Scopes: []string{"openid", "profile", "email"},
+	}, nil)
+
+	authorize := func(t *testing.T, nonce string) (models.AuthorizationRequest, string, string) {
+		verifier, challenge, _ := oidc.NewPKCE()
+		authURL, err := rp.AuthCodeURL(ctx, "st", nonce, challenge)
+		require.NoError(t, err)
+		u, _ := url.Parse(authURL)
+		q := u.Query()
+		req := models.AuthorizationRequest{
+			ClientId: q.Get("client_id"), RedirectURI: q.Get("redirect_uri"), ResponseType: q.Get("response_type"),
+			Scope: q.Get("scope"), State: q.Get("state"), Nonce: q.Get("nonce"),
+			CodeChallenge: q.Get("code_challenge"), CodeChallengeMethod: q.Get("code_challenge_method"),
+		}
+		_, err = svc.CheckAuthorization(ctx, req)
+		require.NoError(t, err)
+
+		redirect, err := svc.Authorize(ctx, user, req, true)
+		require.NoError(t, err)
+		back, _ := url.Parse(redirect)
+		assert.Equal(t, "st", back.Query().Get("state"))
+		return req, back.Query().Get("code"), verifier
+	}
+
+	t.Run("code flow", func(t *testing.T) {
+		_, code, verifier := authorize(t, "n-1")
+		token, err := rp.Exchange(ctx, code, verifier)
+		require.NoError(t, err)
+
+		claims, err := rp.Identity(ctx, token, "n-1")
+		require.NoError(t, err)
+		assert.Equal(t, "7", claims.Subject)
+		assert.Equal(t, "jane@example.com", claims.Email)
+		assert.Equal(t, "Jane Doe", claims.Name)
+		idClaims := jwt.MapClaims{}
+		_, _, err = jwt.NewParser().ParseUnverified(token.IDToken, idClaims)
+		require.NoError(t, err)
+		assert.EqualValues(t, signedIn.Unix(), idClaims["auth_time"], "auth_time is when the user signed in")
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/userinfo", nil)
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var info map[string]any
+		json.NewDecoder(res.Body).Decode(&info)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "Doe", info["family_name"])
+
+		_, err = rp.Exchange(ctx, code, verifier)
+		assert.ErrorContains(t, err, "invalid_grant", "codes are single use")
+	})
+
+	t.Run("consent is remembered", func(t *testing.T) {
+		req, _, _ := authorize(t, "")
+		needed, err := svc.NeedsConsent(ctx, user, req)
+		require.NoError(t, err)
+		assert.False(t, needed)
+	})
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		_, code, _ := authorize(t, "")
+		_, err := rp.Exchange(ctx, code, "guess")
+		assert.ErrorContains(t, err, "invalid_grant")
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		bad := oidc.NewProvider(oidc.Config{Issuer: server.URL, ClientID: client.ClientId, ClientSecret: "nope", RedirectURL: "https://wiki.example/cb"}, nil)
+		_, code, verifier := authorize(t, "")
+		_, err := bad.Exchange(ctx, code, verifier)
+		assert.ErrorContains(t, err, "invalid_client")
+	})
+
+	t.Run("userinfo rejects malformed tokens", func(t *testing.T) {
+		_, err := svc.UserInfo(ctx, "not-a-token")
+		assert.EqualError(t, err, "invalid_token")
+	})
+
+	t.Run("authorization request checks", func(t *testing.T) {
+		base := models.AuthorizationRequest{ClientId: client.ClientId, RedirectURI: "https://wiki.example/cb", ResponseType: "code", Scope: "openid"}
+
+		bad := base
+		bad.RedirectURI = "https://evil.example/cb"
+		c, err := svc.CheckAuthorization(ctx, bad)
+		assert.Nil(t, c, "errors must not be sent to an unregistered redirect URI")
+		assert.Error(t, err)
+
+		bad = base
+		bad.Scope = "profile"
+		c, err = svc.CheckAuthorization(ctx, bad)
+		assert.NotNil(t, c)
+		assert.EqualError(t, err, "invalid_scope: openid scope is required")
+
+		noPKCE := models.AuthorizationRequest{ClientId: public.ClientId, RedirectURI: "http://127.0.0.1/cb", ResponseType: "code", Scope: "openid"}
+		_, err = svc.CheckAuthorization(ctx, noPKCE)
+		assert.EqualError(t, err, "invalid_request: public clients must use PKCE")
+	})
+}
@@ -0,0 +1,271 @@
+package controllers
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"server-go/models"
+	"server-go/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OrganizationController struct {
+	orgService services.OrganizationService
+	logger     *slog.Logger
+}
+
+func NewOrganizationController(orgService services.OrganizationService, logger *slog.Logger) *OrganizationController {
+	return &OrganizationController{orgService: orgService, logger: logger}
+}
+
+// currentUser returns the user set by AuthMiddleware, answering 401 itself
+// when there is none.
+func currentUser(c *gin.Context) (*models.User, bool) {
+	value, _ := c.Get("user")
+	user, ok := value.(*models.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	}
+	return user, ok
+}
+
+// Organizations lists the organizations the caller belongs to.
+func (ctrl *OrganizationController) Organizations(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	memberships, err := ctrl.orgService.ListMemberships(c.Request.Context(), user.Id)
+	if err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to list memberships", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load organizations"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"organizations": memberships, "active": user.OrgId})
+}
+
+func (ctrl *OrganizationController) Switch(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.SwitchOrganization
+	if err := c.ShouldBindJSON(&input); err != nil || input.OrganizationId <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "organizationId is required"})
+		return
+	}
+
+	token, err := ctrl.orgService.Switch(c.Request.Context(), user, input.OrganizationId, c.Writer)
+	if err != nil {
+		switch err.Error() {
+		case "not a member":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this organization"})
+		case "account disabled":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		case "user not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "failed to switch organization", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "organizationId": input.OrganizationId})
+}
+
+// Members lists the active organization's members.
+func (ctrl *OrganizationController) Members(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	members, err := ctrl.orgService.ListMembers(c.Request.Context(), user.OrgId)
+	if err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to list members", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load members"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+func (ctrl *OrganizationController) SetMemberRole(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	userId, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var input models.MemberRole
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+		return
+	}
+
+	membership, err := ctrl.orgService.SetMemberRole(c.Request.Context(), user, userId, input.Role)
+	if err != nil {
+		switch err.Error() {
+		case "invalid role":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be owner, admin or member"})
+		case "member not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		case "forbidden":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can change ownership"})
+		case "last owner":
+			c.JSON(http.StatusConflict, gin.H{"error": "An organization needs at least one owner"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "failed to change member role", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change role"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"membership": membership})
+}
+
+func (ctrl *OrganizationController) Invite(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.InviteRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+		return
+	}
+
+	invitation, err := ctrl.orgService.Invite(c.Request.Context(), user, input)
+	if err != nil {
+		switch err.Error() {
+		case "invalid email":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A valid email is required"})
+		case "invalid role":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be owner, admin or member"})
+		case "forbidden":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can invite owners"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "failed to invite member", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send invitation"})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"invitation": invitation})
+}
+
+func (ctrl *OrganizationController) Invitations(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	invitations, err := ctrl.orgService.ListInvitations(c.Request.Context(), user.OrgId)
+	if err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to list invitations", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load invitations"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+// AcceptInvitation joins an organization from an existing account. New
+// accounts pass the token as inviteToken to /register instead.
+func (ctrl *OrganizationController) AcceptInvitation(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.AcceptInvitation
+	if err := c.ShouldBindJSON(&input); err != nil || input.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+		return
+	}
+
+	membership, err := ctrl.orgService.AcceptInvitation(c.Request.Context(), user, input.Token)
+	if err != nil {
+		if status, message, ok := invitationError(err); ok {
+			c.JSON(status, gin.H{"error": message})
+			return
+		}
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to accept invitation", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"membership": membership})
+}
+
+// invitationPage shows an invitation and lets a new invitee create their
+// account with it. Existing accounts accept from the app, signed in.
+var invitationPage = template.Must(template.New("invitation").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Invitation</title></head>
+<body>
+{{if .Error}}<p role="alert">{{.Error}}</p>
+{{else}}<p>You are invited to join {{.Organization}} as {{.Role}}.</p>
+<form method="post" action="/v1/register">
+<input type="hidden" name="inviteToken" value="{{.Token}}">
+<input type="hidden" name="email" value="{{.Email}}">
+<label>First name <input name="name" autocomplete="given-name" required></label>
+<label>Last name <input name="lastName" autocomplete="family-name" required></label>
+<label>Password <input type="password" name="password" autocomplete="new-password" required></label>
+<button type="submit">Create account</button>
+</form>
+<p>Already have an account as {{.Email}}? Sign in and accept the invitation from the app.</p>
+{{end}}</body>
+</html>
+`))
+
+type invitationView struct {
+	Token        string
+	Email        string
+	Organization string
+	Role         string
+	Error        string
+}
+
+// InvitationPage renders the page the emailed invitation link opens.
+func (ctrl *OrganizationController) InvitationPage(c *gin.Context) {
+	token := c.Query("token")
+	view := invitationView{Token: token}
+	status := http.StatusOK
+
+	invitation, org, err := ctrl.orgService.CheckInvitation(c.Request.Context(), token)
+	switch {
+	case err == nil:
+		view.Email, view.Organization, view.Role = invitation.Email, org.Name, invitation.Role
+	case err.Error() == "invalid or expired invitation":
+		view.Error = "This invitation is invalid, has expired or was already accepted."
+		status = http.StatusBadRequest
+	default:
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to check invitation", "error", err)
+		view.Error = "The invitation could not be loaded. Try again later."
+		status = http.StatusInternalServerError
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Status(status)
+	if err := invitationPage.Execute(c.Writer, view); err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to render invitation page", "error", err)
+	}
+}
+
+func invitationError(err error) (int, string, bool) {
+	switch err.Error() {
+	case "invalid or expired invitation":
+		return http.StatusBadRequest, "Invalid or expired invitation", true
+	case "invitation is for another email":
+		return http.StatusForbidden, "Invitation is for another email", true
+	}
+	return 0, "", false
+}
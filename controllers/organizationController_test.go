@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"server-go/logging"
+	"server-go/models"
+	"server-go/services"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type invitations struct {
+	services.OrganizationService
+	invitation *models.Invitation
+}
+
+func (s *invitations) CheckInvitation(ctx context.Context, token string) (*models.Invitation, *models.Organization, error) {
+	if token != "good" {
+		return nil, nil, errors.New("invalid or expired invitation")
+	}
+	return s.invitation, &models.Organization{Id: s.invitation.OrganizationId, Name: "Acme <Labs>"}, nil
+}
+
+func TestInvitationPage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	invitation := &models.Invitation{Id: 4, OrganizationId: 5, Email: "jane@example.com", Role: models.OrgRoleAdmin}
+	controller := NewOrganizationController(&invitations{invitation: invitation}, logging.Discard())
+	router := gin.New()
+	router.GET("/invitations/accept", controller.InvitationPage)
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/invitations/accept?token=good", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "DENY", resp.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "no-store", resp.Header().Get("Cache-Control"))
+	body := resp.Body.String()
+	assert.Contains(t, body, `action="/v1/register"`)
+	assert.Contains(t, body, `name="inviteToken" value="good"`)
+	assert.Contains(t, body, `name="email" value="jane@example.com"`)
+	assert.Contains(t, body, "Acme &lt;Labs&gt; as admin")
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/invitations/accept?token=bad", nil))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.NotContains(t, resp.Body.String(), "<form")
+}
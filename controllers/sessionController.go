@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"server-go/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionController struct {
+	sessionService services.SessionService
+	logger         *slog.Logger
+}
+
+func NewSessionController(sessionService services.SessionService, logger *slog.Logger) *SessionController {
+	return &SessionController{sessionService: sessionService, logger: logger}
+}
+
+// List shows the devices the caller is signed in on.
+func (ctrl *SessionController) List(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := ctrl.sessionService.List(c.Request.Context(), user)
+	if err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to list sessions", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// Revoke signs one of the caller's devices out.
+func (ctrl *SessionController) Revoke(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := ctrl.sessionService.Revoke(c.Request.Context(), user, id); err != nil {
+		if err.Error() == "session not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to revoke session", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
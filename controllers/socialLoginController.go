@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"server-go/oidc"
+	"server-go/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SocialLoginController struct {
+	socialService services.SocialLoginService
+	logger        *slog.Logger
+}
+
+func NewSocialLoginController(socialService services.SocialLoginService, logger *slog.Logger) *SocialLoginController {
+	return &SocialLoginController{socialService: socialService, logger: logger}
+}
+
+func (ctrl *SocialLoginController) Providers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": ctrl.socialService.Providers()})
+}
+
+// Start redirects the browser to the provider's sign-in page.
+func (ctrl *SocialLoginController) Start(c *gin.Context) {
+	authURL, err := ctrl.socialService.Start(c.Request.Context(), c.Param("provider"), c.Writer)
+	if err != nil {
+		if errors.Is(err, oidc.ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown provider"})
+			return
+		}
+		if err.Error() == "provider unavailable" {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Provider unavailable"})
+			return
+		}
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to start social login", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback is where the provider sends the browser back with a code.
+func (ctrl *SocialLoginController) Callback(c *gin.Context) {
+	if providerError := c.Query("error"); providerError != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in was not completed", "details": providerError})
+		return
+	}
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code and state are required"})
+		return
+	}
+	flow, _ := c.Cookie(services.OAuthFlowCookie)
+
+	token, err := ctrl.socialService.Callback(c.Request.Context(), c.Param("provider"), code, state, flow, c.Writer)
+	if err != nil {
+		if errors.Is(err, oidc.ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown provider"})
+			return
+		}
+		switch err.Error() {
+		case "invalid oauth state":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired sign-in attempt"})
+		case "provider rejected the sign-in":
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in could not be verified"})
+		case "email not provided":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The provider did not share an email address"})
+		case "email not verified":
+			c.JSON(http.StatusConflict, gin.H{"error": "An account with this email exists; sign in with your password"})
+		case "account disabled":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		case "service account":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Service accounts sign in with access tokens"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "social login failed", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "message": "Login successful"})
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"server-go/models"
+	"server-go/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TokenController struct {
+	tokenService services.TokenService
+	logger       *slog.Logger
+}
+
+func NewTokenController(tokenService services.TokenService, logger *slog.Logger) *TokenController {
+	return &TokenController{tokenService: tokenService, logger: logger}
+}
+
+// clientCredentials reads client_secret_basic, falling back to
+// client_secret_post.
+func clientCredentials(c *gin.Context) (string, string) {
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		return id, secret
+	}
+	return c.PostForm("client_id"), c.PostForm("client_secret")
+}
+
+func (ctrl *TokenController) oauthError(c *gin.Context, err error) {
+	var oauthErr *models.OAuthError
+	if errors.As(err, &oauthErr) {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		c.JSON(http.StatusUnauthorized, oauthErr)
+		return
+	}
+	ctrl.logger.ErrorContext(c.Request.Context(), "token endpoint failed", "error", err)
+	c.JSON(http.StatusInternalServerError, models.OAuthError{Code: "server_error"})
+}
+
+// Introspect implements POST /oauth/introspect (RFC 7662).
+func (ctrl *TokenController) Introspect(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, models.OAuthError{Code: "invalid_request", Description: "token is required"})
+		return
+	}
+
+	clientId, secret := clientCredentials(c)
+	response, err := ctrl.tokenService.Introspect(c.Request.Context(), clientId, secret, token)
+	if err != nil {
+		ctrl.oauthError(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, response)
+}
+
+// Revoke implements POST /oauth/revoke (RFC 7009); unknown and already
+// invalid tokens also get 200.
+func (ctrl *TokenController) Revoke(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, models.OAuthError{Code: "invalid_request", Description: "token is required"})
+		return
+	}
+
+	clientId, secret := clientCredentials(c)
+	if err := ctrl.tokenService.Revoke(c.Request.Context(), clientId, secret, token); err != nil {
+		ctrl.oauthError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/middlewares"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// revocations keeps revoked jtis and per-user cut-offs in memory.
+type revocations struct {
+	repositories.UserRepository
+	jtis      map[string]bool
+	revokedAt time.Time
+}
+
+func (r *revocations) TokensRevokedAt(ctx context.Context, id int) (time.Time, error) {
+	return r.revokedAt, nil
+}
+
+func (r *revocations) TokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return r.jtis[jti], nil
+}
+
+func (r *revocations) RevokeToken(ctx context.Context, id int, jti string, expiresAt time.Time) error {
+	r.jtis[jti] = true
+	return nil
+}
+
+func (r *revocations) RevokeTokens(ctx context.Context, id int) error {
+	r.revokedAt = time.Now().Add(time.Second)
+	return nil
+}
+
+func sessionToken(t *testing.T, claims jwt.MapClaims) string {
+	base := jwt.MapClaims{
+		"iat":           time.Now().Unix(),
+		"exp":           time.Now().Add(time.Hour).Unix(),
+		"jti":           "jti-1",
+		"user_id":       7,
+		"user_Name":     "Jane",
+		"user_LastName": "Doe",
+		"user_Email":    "jane@example.com",
+		"user_Role":     "admin",
+		"tenant_id":     2,
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(base, k)
+		} else {
+			base[k] = v
+		}
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, base).SignedString(middlewares.JwtSecret)
+	require.NoError(t, err)
+	return token
+}
+
+func TestIntrospectionAgreesWithAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	middlewares.JwtSecret = []byte("test-secret")
+
+	gateway, secret, err := services.NewOAuthClient("Gateway", []string{"https://gw.example/cb"}, nil, false)
+	require.NoError(t, err)
+	spa, _, _ := services.NewOAuthClient("SPA", []string{"https://spa.example/cb"}, nil, true)
+	clients := &memoryClients{clients: map[string]*models.OAuthClient{gateway.ClientId: gateway, spa.ClientId: spa}}
+	revoked := &revocations{jtis: map[string]bool{}}
+
+	svc := services.NewTokenService(clients, revoked, middlewares.ActiveToken(revoked), discardAudit{}, logging.Discard())
+	ctrl := NewTokenController(svc, logging.Discard())
+	router := gin.New()
+	router.GET("/me", middlewares.AuthMiddleware(logging.Discard(), revoked), func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/oauth/introspect", ctrl.Introspect)
+	router.POST("/oauth/revoke", ctrl.Revoke)
+
+	post := func(path, clientId, clientSecret, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(url.Values{"token": {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(clientId, clientSecret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	introspect := func(token string) map[string]any {
+		w := post("/oauth/introspect", gateway.ClientId, secret, token)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body
+	}
+	middlewareAccepts := func(token string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code == http.StatusOK
+	}
+
+	valid := sessionToken(t, nil)
+	tokens := map[string]string{
+		"valid":        valid,
+		"expired":      sessionToken(t, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}),
+		"tampered":     valid[:len(valid)-4] + "AAAA",
+		"missing name": sessionToken(t, jwt.MapClaims{"user_Name": nil}),
+		"other secret": func() string {
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{"user_id": 7}).SignedString([]byte("other"))
+			return s
+		}(),
+	}
+	for name, token := range tokens {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, middlewareAccepts(token), introspect(token)["active"])
+		})
+	}
+
+	body := introspect(valid)
+	assert.Equal(t, "7", body["sub"])
+	assert.Equal(t, "jane@example.com", body["username"])
+	assert.EqualValues(t, 2, body["tenant_id"])
+	assert.NotNil(t, body["exp"])
+
+	t.Run("client authentication", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, post("/oauth/introspect", gateway.ClientId, "wrong", valid).Code)
+		assert.Equal(t, http.StatusUnauthorized, post("/oauth/introspect", spa.ClientId, "", valid).Code)
+		assert.Equal(t, http.StatusUnauthorized, post("/oauth/revoke", "unknown", "", valid).Code)
+	})
+
+	t.Run("revocation", func(t *testing.T) {
+		other := sessionToken(t, jwt.MapClaims{"jti": "jti-2"})
+		assert.Equal(t, http.StatusOK, post("/oauth/revoke", gateway.ClientId, secret, valid).Code)
+		assert.False(t, middlewareAccepts(valid))
+		assert.Equal(t, false, introspect(valid)["active"])
+		assert.True(t, middlewareAccepts(other), "only the revoked token stops working")
+
+		assert.Equal(t, http.StatusOK, post("/oauth/revoke", gateway.ClientId, secret, "garbage").Code)
+	})
+
+	t.Run("revoking a token without jti revokes the user's tokens", func(t *testing.T) {
+		legacy := sessionToken(t, jwt.MapClaims{"jti": nil})
+		assert.Equal(t, http.StatusOK, post("/oauth/revoke", gateway.ClientId, secret, legacy).Code)
+		assert.False(t, middlewareAccepts(legacy))
+		assert.Equal(t, false, introspect(legacy)["active"])
+	})
+}
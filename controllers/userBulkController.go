@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"server-go/models"
+	"server-go/services"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UserBulkController struct {
+	bulkService services.UserBulkService
+	logger      *slog.Logger
+}
+
+func NewUserBulkController(bulkService services.UserBulkService, logger *slog.Logger) *UserBulkController {
+	return &UserBulkController{bulkService: bulkService, logger: logger}
+}
+
+// Import streams a CSV or NDJSON body into the users table.
+// Query parameters: format (csv or ndjson, otherwise taken from
+// Content-Type), mode (dry-run, the default, or commit), invite and
+// batch_size.
+func (ctrl *UserBulkController) Import(c *gin.Context) {
+	opts := models.ImportOptions{
+		Format: bulkFormat(c, c.ContentType()),
+		Commit: c.Query("mode") == "commit",
+		Invite: c.Query("invite") == "true",
+	}
+	if opts.Format == "" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Body must be text/csv or application/x-ndjson"})
+		return
+	}
+	if mode := c.Query("mode"); mode != "" && mode != "commit" && mode != "dry-run" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be dry-run or commit"})
+		return
+	}
+	if v := c.Query("batch_size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "batch_size must be a positive integer"})
+			return
+		}
+		opts.BatchSize = size
+	}
+
+	report, err := ctrl.bulkService.Import(c.Request.Context(), c.Request.Body, opts)
+	if err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "user import failed", "error", err)
+		if report == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to import users", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Import stopped part way", "details": err.Error(), "report": report})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// Export streams every user as CSV or NDJSON (?format=, default csv).
+// Password hashes are only exported by usersctl.
+func (ctrl *UserBulkController) Export(c *gin.Context) {
+	opts := models.ExportOptions{Format: bulkFormat(c, c.GetHeader("Accept"))}
+	if opts.Format == "" {
+		opts.Format = models.FormatCSV
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if opts.Format == models.FormatNDJSON {
+		contentType = "application/x-ndjson"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="users.`+opts.Format+`"`)
+	c.Status(http.StatusOK)
+
+	if err := ctrl.bulkService.Export(c.Request.Context(), c.Writer, opts); err != nil {
+		// Headers are already sent; all we can do is log and cut the stream.
+		ctrl.logger.ErrorContext(c.Request.Context(), "user export failed", "error", err)
+		c.Abort()
+	}
+}
+
+// ExportLater queues an export (same parameters as Export) and mails the
+// caller a download link once it is ready.
+func (ctrl *UserBulkController) ExportLater(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	opts := models.ExportOptions{Format: bulkFormat(c, c.GetHeader("Accept"))}
+	if opts.Format == "" {
+		opts.Format = models.FormatCSV
+	}
+
+	if err := ctrl.bulkService.ExportLater(c.Request.Context(), user, opts); err != nil {
+		if err.Error() == "export already in progress" {
+			c.JSON(http.StatusConflict, gin.H{"error": "An export is already in progress"})
+			return
+		}
+		ctrl.logger.ErrorContext(c.Request.Context(), "could not queue user export", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue export"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Export queued; a download link will be sent by email"})
+}
+
+// DownloadExport serves a finished export from ExportLater.
+func (ctrl *UserBulkController) DownloadExport(c *gin.Context) {
+	path, err := ctrl.bulkService.ExportFile(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return
+	}
+	c.FileAttachment(path, "users"+filepath.Ext(path))
+}
+
+func bulkFormat(c *gin.Context, mediaType string) string {
+	switch strings.ToLower(c.Query("format")) {
+	case models.FormatCSV:
+		return models.FormatCSV
+	case models.FormatNDJSON, "jsonl":
+		return models.FormatNDJSON
+	}
+	mediaType, _, _ = mime.ParseMediaType(mediaType)
+	switch mediaType {
+	case "text/csv":
+		return models.FormatCSV
+	case "application/x-ndjson", "application/jsonl", "application/ndjson":
+		return models.FormatNDJSON
+	}
+	return ""
+}
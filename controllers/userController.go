@@ -1,10 +1,13 @@
 package controllers
 
 import (
-	"log"
+	"errors"
+	"html/template"
+	"log/slog"
 	"net/http"
 	"server-go/models"
 	"server-go/services"
+	"server-go/tenancy"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -12,10 +15,11 @@ import (
 
 type UserController struct {
 	userService services.UserService
+	logger      *slog.Logger
 }
 
-func NewUserController(userService services.UserService) *UserController {
-	return &UserController{userService: userService}
+func NewUserController(userService services.UserService, logger *slog.Logger) *UserController {
+	return &UserController{userService: userService, logger: logger}
 }
 
 func (crtl *UserController) Login(c *gin.Context) {
@@ -32,13 +36,25 @@ func (crtl *UserController) Login(c *gin.Context) {
 		return
 	}
 
-	token, err := crtl.userService.Login(loginData, c.Writer)
+	token, err := crtl.userService.Login(c.Request.Context(), loginData, c.Writer)
 	if err != nil {
+		var expired *services.PasswordExpiredError
+		if errors.As(err, &expired) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Password expired", "resetToken": expired.ResetToken})
+			return
+		}
+		var inactive *services.AccountInactiveError
+		if errors.As(err, &inactive) {
+			c.JSON(http.StatusForbidden, inactiveAccount(inactive))
+			return
+		}
 		switch err.Error() {
 		case "user not found":
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		case "invalid credentials":
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		case "account disabled":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
 		}
@@ -51,10 +67,16 @@ func (crtl *UserController) Login(c *gin.Context) {
 func (crtl *UserController) Register(c *gin.Context) {
 	var registerData models.User
 
-	if err := c.ShouldBindJSON(&registerData); err != nil {
+	// JSON from the API, or the form on the invitation page
+	if err := c.ShouldBind(&registerData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "details": err.Error()})
 		return
 	}
+	// An invitation names the organization; otherwise the request must
+	if _, ok := tenancy.TenantID(c.Request.Context()); !ok && registerData.InviteToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization required"})
+		return
+	}
 
 	// Basic input validation
 	if registerData.Name == "" || registerData.LastName == "" || registerData.Email == "" || registerData.Password == "" {
@@ -62,9 +84,16 @@ func (crtl *UserController) Register(c *gin.Context) {
 		return
 	}
 
-	token, err := crtl.userService.Register(registerData)
+	token, err := crtl.userService.Register(c.Request.Context(), registerData)
 	if err != nil {
-		log.Printf("Error in Register: %v", err)
+		if validationFailed(c, err) {
+			return
+		}
+		crtl.logger.ErrorContext(c.Request.Context(), "error in register", "error", err)
+		if status, message, ok := invitationError(err); ok {
+			c.JSON(status, gin.H{"error": message})
+			return
+		}
 		switch err.Error() {
 		case "user already exists":
 			c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
@@ -119,15 +148,123 @@ func (ctrl *UserController) UpdateUser(c *gin.Context) {
 	// Call the UpdateUser method in the UserService
 	updatedUser, err := ctrl.userService.UpdateUser(c.Request.Context(), &user)
 	if err != nil {
+		if validationFailed(c, err) {
+			return
+		}
+		switch err.Error() {
+		case "user not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		case "email already in use":
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+			return
+		case "password cannot be changed here":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Change the password with POST /me/password or a reset link"})
+			return
+		case "email cannot be changed here":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Change the email with POST /me/email"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user", "details": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Successfully updated the user", "user": updatedUser})
+	// Admins update other users; never send back the stored hash
+	response := *updatedUser
+	response.Password = ""
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully updated the user", "user": response})
+}
+
+// inactiveAccount tells a user why they cannot sign in. The admin's reason
+// is not shown.
+func inactiveAccount(err *services.AccountInactiveError) gin.H {
+	messages := map[string]string{
+		models.StatusPending:     "Account not activated yet",
+		models.StatusSuspended:   "Account suspended",
+		models.StatusLocked:      "Account locked",
+		models.StatusDeactivated: "Account disabled",
+	}
+	body := gin.H{"error": messages[err.Status], "status": err.Status}
+	if err.Until != nil {
+		body["until"] = err.Until
+	}
+	return body
+}
+
+// resetPasswordPage lets the user choose a password from an emailed reset
+// link or one printed by usersctl. The one-time token is only redeemed
+// when the form is sent.
+var resetPasswordPage = template.Must(template.New("reset-password").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Choose a password</title></head>
+<body>
+{{if .Error}}<p role="alert">{{.Error}}</p>
+{{else}}<form method="post" action="/v1/password/reset">
+<input type="hidden" name="token" value="{{.Token}}">
+<label>New password <input type="password" name="password" autocomplete="new-password" required></label>
+<button type="submit">Set password</button>
+</form>
+{{end}}</body>
+</html>
+`))
+
+type resetPasswordView struct {
+	Token string
+	Error string
+}
+
+// ResetPasswordPage renders the page a reset link opens.
+func (crtl *UserController) ResetPasswordPage(c *gin.Context) {
+	view := resetPasswordView{Token: c.Query("token")}
+	status := http.StatusOK
+	if view.Token == "" {
+		view.Error = "This password reset link is invalid."
+		status = http.StatusBadRequest
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Cache-Control", "no-store")
+	// The token is in the URL; keep it out of Referer headers
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Status(status)
+	if err := resetPasswordPage.Execute(c.Writer, view); err != nil {
+		crtl.logger.ErrorContext(c.Request.Context(), "failed to render password reset page", "error", err)
+	}
+}
+
+// ResetPassword redeems a reset token, from the reset page's form or as
+// JSON.
+func (crtl *UserController) ResetPassword(c *gin.Context) {
+	var resetData models.PasswordReset
+
+	if err := c.ShouldBind(&resetData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+		return
+	}
+
+	if resetData.Token == "" || resetData.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token and password are required"})
+		return
+	}
+
+	if err := crtl.userService.ResetPassword(c.Request.Context(), resetData.Token, resetData.Password); err != nil {
+		if validationFailed(c, err) {
+			return
+		}
+		if err.Error() == "invalid or expired token" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
 }
 
 func (crtl *UserController) Logout(c *gin.Context) {
-	err := crtl.userService.Logout(c.Writer)
+	err := crtl.userService.Logout(c.Request.Context(), c.Writer)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
 		return
@@ -135,3 +272,78 @@ func (crtl *UserController) Logout(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
 }
+
+// validationFailed answers 400 with the field errors when err carries them.
+func validationFailed(c *gin.Context, err error) bool {
+	var invalid *models.ValidationError
+	if !errors.As(err, &invalid) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data", "fields": invalid.Errors})
+	return true
+}
+
+// Suspend blocks an account, optionally until a given time, and signs it
+// out everywhere.
+func (crtl *UserController) Suspend(c *gin.Context) {
+	crtl.setStatus(c, models.StatusSuspended)
+}
+
+// Reactivate makes a suspended, locked, deactivated or pending account
+// active again.
+func (crtl *UserController) Reactivate(c *gin.Context) {
+	crtl.setStatus(c, models.StatusActive)
+}
+
+func (crtl *UserController) setStatus(c *gin.Context, status string) {
+	id, ok := userIdParam(c)
+	if !ok {
+		return
+	}
+	var update models.StatusUpdate
+	// The body is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&update); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+			return
+		}
+	}
+
+	user, err := crtl.userService.SetStatus(c.Request.Context(), id, status, update)
+	if err != nil {
+		switch err.Error() {
+		case "user not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		case "invalid status transition":
+			c.JSON(http.StatusConflict, gin.H{"error": "The account cannot change to " + status + " from its current status"})
+		case "invalid status expiry":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "until must be in the future"})
+		case "cannot change own status":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot change the status of your own account"})
+		default:
+			crtl.logger.ErrorContext(c.Request.Context(), "failed to change account status", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": user.Id, "status": user.Status, "statusReason": user.StatusReason, "statusUntil": user.StatusUntil})
+}
+
+// StatusHistory lists an account's status changes, newest first.
+func (crtl *UserController) StatusHistory(c *gin.Context) {
+	id, ok := userIdParam(c)
+	if !ok {
+		return
+	}
+	changes, err := crtl.userService.StatusHistory(c.Request.Context(), id)
+	if err != nil {
+		if err.Error() == "user not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		crtl.logger.ErrorContext(c.Request.Context(), "failed to load status history", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"history": changes})
+}
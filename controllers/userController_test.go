@@ -3,14 +3,21 @@ package controllers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"server-go/logging"
 	"server-go/models"
+	"server-go/tenancy"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // Mocking the UserService
@@ -18,13 +25,13 @@ type MockUserService struct {
 	mock.Mock
 }
 
-func (m *MockUserService) Login(loginData models.LoginUser, writer http.ResponseWriter) (string, error) {
-	args := m.Called(loginData, writer)
+func (m *MockUserService) Login(ctx context.Context, loginData models.LoginUser, writer http.ResponseWriter) (string, error) {
+	args := m.Called(ctx, loginData, writer)
 	return args.String(0), args.Error(1)
 }
 
-func (m *MockUserService) Register(user models.User) (string, error) {
-	args := m.Called(user)
+func (m *MockUserService) Register(ctx context.Context, user models.User) (string, error) {
+	args := m.Called(ctx, user)
 	return args.String(0), args.Error(1)
 }
 
@@ -33,14 +40,84 @@ func (m *MockUserService) UpdateUser(ctx context.Context, user *models.User) (*m
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
-func (m *MockUserService) Logout(writer http.ResponseWriter) error {
-	args := m.Called(writer)
+func (m *MockUserService) SetRole(ctx context.Context, id int, role string) (*models.User, error) {
+	args := m.Called(ctx, id, role)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) ResetPassword(ctx context.Context, token string, password string) error {
+	args := m.Called(ctx, token, password)
+	return args.Error(0)
+}
+
+func (m *MockUserService) GetUser(ctx context.Context, id int) (*models.User, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	args := m.Called(ctx, email)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) ListUsers(ctx context.Context, limit int, offset int) ([]models.User, error) {
+	args := m.Called(ctx, limit, offset)
+	return args.Get(0).([]models.User), args.Error(1)
+}
+
+func (m *MockUserService) CreateUser(ctx context.Context, user models.User) (*models.User, error) {
+	args := m.Called(ctx, user)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) SetDisabled(ctx context.Context, id int, disabled bool) (*models.User, error) {
+	args := m.Called(ctx, id, disabled)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) SetStatus(ctx context.Context, id int, status string, update models.StatusUpdate) (*models.User, error) {
+	args := m.Called(ctx, id, status, update)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) StatusHistory(ctx context.Context, id int) ([]models.StatusChange, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).([]models.StatusChange), args.Error(1)
+}
+
+func (m *MockUserService) AccountStatus(ctx context.Context, id int) (string, error) {
+	args := m.Called(ctx, id)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockUserService) DeleteUser(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockUserService) CreatePasswordResetToken(ctx context.Context, id int) (string, error) {
+	args := m.Called(ctx, id)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockUserService) SetTemporaryPassword(ctx context.Context, id int) (string, error) {
+	args := m.Called(ctx, id)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockUserService) RevokeSessions(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockUserService) Logout(ctx context.Context, writer http.ResponseWriter) error {
+	args := m.Called(ctx, writer)
 	return args.Error(0)
 }
 
 func TestLogin(t *testing.T) {
 	mockUserService := new(MockUserService)
-	controller := NewUserController(mockUserService)
+	controller := NewUserController(mockUserService, logging.Discard())
 
 	gin.SetMode(gin.TestMode)
 	router := gin.Default()
@@ -48,7 +125,7 @@ func TestLogin(t *testing.T) {
 
 	t.Run("successful login", func(t *testing.T) {
 		loginData := models.LoginUser{Email: "john@example.com", Password: "password"}
-		mockUserService.On("Login", loginData).Return("token123", nil)
+		mockUserService.On("Login", mock.Anything, loginData, mock.Anything).Return("token123", nil)
 
 		body := bytes.NewBufferString(`{"email":"john@example.com","password":"password"}`)
 		req, _ := http.NewRequest(http.MethodPost, "/login", body)
@@ -76,15 +153,19 @@ func TestLogin(t *testing.T) {
 
 func TestRegister(t *testing.T) {
 	mockUserService := new(MockUserService)
-	controller := NewUserController(mockUserService)
+	controller := NewUserController(mockUserService, logging.Discard())
 
 	gin.SetMode(gin.TestMode)
 	router := gin.Default()
-	router.POST("/register", controller.Register)
+	inOrganization := func(c *gin.Context) {
+		c.Request = c.Request.WithContext(tenancy.WithTenant(c.Request.Context(), 1))
+	}
+	router.POST("/register", inOrganization, controller.Register)
+	router.POST("/invited/register", controller.Register)
 
 	t.Run("successful registration", func(t *testing.T) {
 		registerData := models.User{Name: "John", LastName: "Doe", Email: "john@example.com", Password: "password"}
-		mockUserService.On("Register", registerData).Return("token123", nil)
+		mockUserService.On("Register", mock.Anything, registerData).Return("token123", nil)
 
 		body := bytes.NewBufferString(`{"name":"John","lastName":"Doe","email":"john@example.com","password":"password"}`)
 		req, _ := http.NewRequest(http.MethodPost, "/register", body)
@@ -108,10 +189,53 @@ func TestRegister(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, resp.Code)
 		assert.Contains(t, resp.Body.String(), "All fields are required")
 	})
+
+	t.Run("password policy violation", func(t *testing.T) {
+		registerData := models.User{Name: "Jane", LastName: "Doe", Email: "jane@example.com", Password: "x"}
+		mockUserService.On("Register", mock.Anything, registerData).Return("", &models.ValidationError{Errors: []models.FieldError{
+			{Field: "password", Code: "too_short", Message: "must be at least 8 characters"},
+		}})
+
+		body := bytes.NewBufferString(`{"name":"Jane","lastName":"Doe","email":"jane@example.com","password":"x"}`)
+		req, _ := http.NewRequest(http.MethodPost, "/register", body)
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error":"Invalid input data","fields":[{"field":"password","code":"too_short","message":"must be at least 8 characters"}]}`, resp.Body.String())
+	})
+
+	t.Run("invitation page form", func(t *testing.T) {
+		registerData := models.User{Name: "Ann", LastName: "Lee", Email: "ann@example.com", Password: "password", InviteToken: "invite"}
+		mockUserService.On("Register", mock.Anything, registerData).Return("token456", nil)
+
+		form := url.Values{"name": {"Ann"}, "lastName": {"Lee"}, "email": {"ann@example.com"}, "password": {"password"}, "inviteToken": {"invite"}}
+		req, _ := http.NewRequest(http.MethodPost, "/invited/register", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusCreated, resp.Code, "the invitation names the organization")
+	})
+
+	t.Run("no organization", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"name":"John","lastName":"Doe","email":"john@example.com","password":"password"}`)
+		req, _ := http.NewRequest(http.MethodPost, "/invited/register", body)
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "Organization required")
+	})
 }
 
 func TestMe(t *testing.T) {
-	controller := NewUserController(nil)
+	controller := NewUserController(nil, logging.Discard())
 
 	gin.SetMode(gin.TestMode)
 	router := gin.Default()
@@ -128,15 +252,16 @@ func TestMe(t *testing.T) {
 	})
 
 	t.Run("successful retrieval", func(t *testing.T) {
-		router.Use(func(c *gin.Context) {
+		authed := gin.New()
+		authed.GET("/me", func(c *gin.Context) {
 			c.Set("user", &models.User{Id: 1, Name: "John", LastName: "Doe", Email: "john@example.com", Password: "password"})
 			c.Next()
-		})
+		}, controller.Me)
 
 		req, _ := http.NewRequest(http.MethodGet, "/me", nil)
 		resp := httptest.NewRecorder()
 
-		router.ServeHTTP(resp, req)
+		authed.ServeHTTP(resp, req)
 
 		assert.Equal(t, http.StatusOK, resp.Code)
 		assert.Contains(t, resp.Body.String(), "John")
@@ -145,18 +270,18 @@ func TestMe(t *testing.T) {
 
 func TestUpdateUser(t *testing.T) {
 	mockUserService := new(MockUserService)
-	controller := NewUserController(mockUserService)
+	controller := NewUserController(mockUserService, logging.Discard())
 
 	gin.SetMode(gin.TestMode)
 	router := gin.Default()
 	router.PUT("/users/:id", controller.UpdateUser)
 
 	t.Run("successful update", func(t *testing.T) {
-		user := models.User{Id: 1, Name: "John", LastName: "Doe", Email: "john@example.com", Password: "password"}
-		updatedUser := models.User{Id: 1, Name: "John", LastName: "Doe", Email: "john@example.com", Password: "newpassword"}
+		user := models.User{Id: 1, Name: "John", LastName: "Doe", Email: "john@example.com"}
+		updatedUser := models.User{Id: 1, Name: "John", LastName: "Doe", Email: "john@example.com", Password: "$argon2id$stored-hash"}
 		mockUserService.On("UpdateUser", mock.Anything, &user).Return(&updatedUser, nil)
 
-		body := bytes.NewBufferString(`{"name":"John","lastName":"Doe","email":"john@example.com","password":"password"}`)
+		body := bytes.NewBufferString(`{"name":"John","lastName":"Doe","email":"john@example.com"}`)
 		req, _ := http.NewRequest(http.MethodPut, "/users/1", body)
 		req.Header.Set("Content-Type", "application/json")
 		resp := httptest.NewRecorder()
@@ -165,6 +290,27 @@ func TestUpdateUser(t *testing.T) {
 
 		assert.Equal(t, http.StatusOK, resp.Code)
 		assert.Contains(t, resp.Body.String(), "Successfully updated the user")
+		var sent struct {
+			User map[string]any `json:"user"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &sent))
+		assert.Equal(t, "John", sent.User["name"])
+		assert.NotContains(t, sent.User, "password", "the stored hash is not sent back")
+		assert.Equal(t, "$argon2id$stored-hash", updatedUser.Password, "the service's user is left alone")
+	})
+
+	t.Run("password change", func(t *testing.T) {
+		user := models.User{Id: 2, Name: "Ann", Password: "secret"}
+		mockUserService.On("UpdateUser", mock.Anything, &user).Return((*models.User)(nil), errors.New("password cannot be changed here"))
+
+		req, _ := http.NewRequest(http.MethodPut, "/users/2", bytes.NewBufferString(`{"name":"Ann","password":"secret"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "/me/password")
 	})
 
 	t.Run("invalid user ID", func(t *testing.T) {
@@ -182,14 +328,14 @@ func TestUpdateUser(t *testing.T) {
 
 func TestLogout(t *testing.T) {
 	mockUserService := new(MockUserService)
-	controller := NewUserController(mockUserService)
+	controller := NewUserController(mockUserService, logging.Discard())
 
 	gin.SetMode(gin.TestMode)
 	router := gin.Default()
 	router.POST("/logout", controller.Logout)
 
 	t.Run("successful logout", func(t *testing.T) {
-		mockUserService.On("Logout", mock.Anything).Return(nil)
+		mockUserService.On("Logout", mock.Anything, mock.Anything).Return(nil)
 
 		req, _ := http.NewRequest(http.MethodPost, "/logout", nil)
 		resp := httptest.NewRecorder()
@@ -201,7 +347,8 @@ func TestLogout(t *testing.T) {
 	})
 
 	t.Run("failed logout", func(t *testing.T) {
-		mockUserService.On("Logout", mock.Anything).Return(assert.AnError)
+		mockUserService.ExpectedCalls = nil
+		mockUserService.On("Logout", mock.Anything, mock.Anything).Return(assert.AnError)
 
 		req, _ := http.NewRequest(http.MethodPost, "/logout", nil)
 		resp := httptest.NewRecorder()
@@ -212,3 +359,41 @@ func TestLogout(t *testing.T) {
 		assert.Contains(t, resp.Body.String(), "Failed to logout")
 	})
 }
+
+func TestResetPasswordPage(t *testing.T) {
+	mockUserService := new(MockUserService)
+	controller := NewUserController(mockUserService, logging.Discard())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/password/reset", controller.ResetPasswordPage)
+	router.POST("/password/reset", controller.ResetPassword)
+
+	t.Run("page", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/password/reset?token=3.abc", nil))
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `action="/v1/password/reset"`)
+		assert.Contains(t, resp.Body.String(), `value="3.abc"`)
+		assert.Equal(t, "no-referrer", resp.Header().Get("Referrer-Policy"))
+	})
+
+	t.Run("form", func(t *testing.T) {
+		mockUserService.On("ResetPassword", mock.Anything, "3.abc", "correct horse").Return(nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/password/reset", strings.NewReader("token=3.abc&password=correct+horse"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockUserService.AssertExpectations(t)
+	})
+
+	t.Run("no token", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/password/reset", nil))
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+}
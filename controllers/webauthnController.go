@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"server-go/models"
+	"server-go/services"
+	"server-go/webauthn"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebAuthnController struct {
+	webauthnService services.WebAuthnService
+	logger          *slog.Logger
+}
+
+func NewWebAuthnController(webauthnService services.WebAuthnService, logger *slog.Logger) *WebAuthnController {
+	return &WebAuthnController{webauthnService: webauthnService, logger: logger}
+}
+
+// BeginRegistration returns the options for navigator.credentials.create.
+func (ctrl *WebAuthnController) BeginRegistration(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	options, err := ctrl.webauthnService.BeginRegistration(c.Request.Context(), user, c.Writer)
+	if err != nil {
+		switch err.Error() {
+		case "user not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		case "service account":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Service accounts cannot register passkeys"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "failed to begin passkey registration", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+func (ctrl *WebAuthnController) FinishRegistration(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.FinishPasskeyRegistration
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+		return
+	}
+	flow, _ := c.Cookie(services.WebAuthnFlowCookie)
+
+	passkey, err := ctrl.webauthnService.FinishRegistration(c.Request.Context(), user, input, flow, c.Writer)
+	if err != nil {
+		switch err.Error() {
+		case "invalid webauthn flow":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Registration expired, start again"})
+		case "invalid passkey":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey could not be verified"})
+		case "passkey already registered":
+			c.JSON(http.StatusConflict, gin.H{"error": "Passkey already registered"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "failed to register passkey", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, passkey)
+}
+
+// BeginLogin returns the options for navigator.credentials.get.
+func (ctrl *WebAuthnController) BeginLogin(c *gin.Context) {
+	var input models.BeginPasskeyLogin
+	// The body is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+			return
+		}
+	}
+
+	options, err := ctrl.webauthnService.BeginLogin(c.Request.Context(), input, c.Writer)
+	if err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to begin passkey login", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+func (ctrl *WebAuthnController) FinishLogin(c *gin.Context) {
+	var response webauthn.AssertionResponse
+	if err := c.ShouldBindJSON(&response); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+		return
+	}
+	flow, _ := c.Cookie(services.WebAuthnFlowCookie)
+
+	token, err := ctrl.webauthnService.FinishLogin(c.Request.Context(), response, flow, c.Writer)
+	if err != nil {
+		switch err.Error() {
+		case "invalid webauthn flow":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Sign-in expired, start again"})
+		case "invalid passkey":
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey could not be verified"})
+		case "account disabled":
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "passkey login failed", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "message": "Login successful"})
+}
+
+// List shows the caller's passkeys.
+func (ctrl *WebAuthnController) List(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	passkeys, err := ctrl.webauthnService.List(c.Request.Context(), user)
+	if err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to list passkeys", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load passkeys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"passkeys": passkeys})
+}
+
+func (ctrl *WebAuthnController) Delete(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey ID"})
+		return
+	}
+	if err := ctrl.webauthnService.Delete(c.Request.Context(), user, id); err != nil {
+		if err.Error() == "passkey not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+			return
+		}
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to delete passkey", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"server-go/models"
+	"server-go/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookController manages the webhook endpoints of the caller's
+// organization. Routes using it require an organization admin.
+type WebhookController struct {
+	webhookService services.WebhookService
+	logger         *slog.Logger
+}
+
+func NewWebhookController(webhookService services.WebhookService, logger *slog.Logger) *WebhookController {
+	return &WebhookController{webhookService: webhookService, logger: logger}
+}
+
+// Create registers an endpoint. The response is the only place its secret
+// is shown.
+func (ctrl *WebhookController) Create(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.WebhookEndpointInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+		return
+	}
+
+	endpoint, err := ctrl.webhookService.CreateEndpoint(c.Request.Context(), user, input)
+	if err != nil {
+		switch err.Error() {
+		case "invalid url":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "URL must be an absolute http or https URL"})
+		case "invalid event type":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event type"})
+		case "secret too short":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Secret must be at least 32 characters"})
+		default:
+			ctrl.logger.ErrorContext(c.Request.Context(), "failed to create webhook", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"webhook": endpoint})
+}
+
+func (ctrl *WebhookController) List(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	endpoints, err := ctrl.webhookService.ListEndpoints(c.Request.Context(), user)
+	if err != nil {
+		ctrl.logger.ErrorContext(c.Request.Context(), "failed to list webhooks", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load webhooks"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": endpoints})
+}
+
+func (ctrl *WebhookController) Delete(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	id, ok := webhookParam(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := ctrl.webhookService.DeleteEndpoint(c.Request.Context(), user, id); err != nil {
+		ctrl.fail(c, "failed to delete webhook", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Enable turns an endpoint back on after it was disabled for failing.
+func (ctrl *WebhookController) Enable(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	id, ok := webhookParam(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := ctrl.webhookService.EnableEndpoint(c.Request.Context(), user, id); err != nil {
+		ctrl.fail(c, "failed to enable webhook", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Deliveries lists the latest deliveries of an endpoint.
+func (ctrl *WebhookController) Deliveries(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	id, ok := webhookParam(c, "id")
+	if !ok {
+		return
+	}
+
+	deliveries, err := ctrl.webhookService.ListDeliveries(c.Request.Context(), user, id)
+	if err != nil {
+		ctrl.fail(c, "failed to list webhook deliveries", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// Delivery shows one delivery with the log of its attempts.
+func (ctrl *WebhookController) Delivery(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	id, ok := webhookParam(c, "id")
+	if !ok {
+		return
+	}
+	deliveryId, ok := webhookParam(c, "deliveryId")
+	if !ok {
+		return
+	}
+
+	delivery, err := ctrl.webhookService.GetDelivery(c.Request.Context(), user, id, deliveryId)
+	if err != nil {
+		ctrl.fail(c, "failed to load webhook delivery", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"delivery": delivery})
+}
+
+// Redeliver sends a delivery again, whatever its outcome so far.
+func (ctrl *WebhookController) Redeliver(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	id, ok := webhookParam(c, "id")
+	if !ok {
+		return
+	}
+	deliveryId, ok := webhookParam(c, "deliveryId")
+	if !ok {
+		return
+	}
+
+	if err := ctrl.webhookService.Redeliver(c.Request.Context(), user, id, deliveryId); err != nil {
+		ctrl.fail(c, "failed to redeliver webhook", err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Delivery queued"})
+}
+
+func webhookParam(c *gin.Context, name string) (int, bool) {
+	id, err := strconv.Atoi(c.Param(name))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+func (ctrl *WebhookController) fail(c *gin.Context, msg string, err error) {
+	switch err.Error() {
+	case "webhook not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+	case "delivery not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+	default:
+		ctrl.logger.ErrorContext(c.Request.Context(), msg, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+	}
+}
@@ -0,0 +1,76 @@
+// Package events delivers domain events from the outbox to the systems
+// that react to them.
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"server-go/models"
+)
+
+// Sink receives events from the relay. Delivery is at least once, so a
+// sink must tolerate the same event twice; models.Event.Id identifies it.
+type Sink interface {
+	Publish(ctx context.Context, event models.Event) error
+}
+
+type fanout []Sink
+
+// Fanout publishes to every sink. An event counts as delivered only when
+// all of them accepted it; a retry goes to all sinks again.
+func Fanout(sinks ...Sink) Sink {
+	return fanout(sinks)
+}
+
+func (f fanout) Publish(ctx context.Context, event models.Event) error {
+	var errs []error
+	for _, s := range f {
+		if err := s.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Handler reacts to an event published on a Bus.
+type Handler func(ctx context.Context, event models.Event) error
+
+// Bus is an in-process sink: handlers subscribe to an event type, or to
+// every type with "*".
+type Bus interface {
+	Sink
+	Subscribe(eventType string, handler Handler)
+}
+
+type bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewBus() Bus {
+	return &bus{handlers: map[string][]Handler{}}
+}
+
+func (b *bus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish runs the matching handlers in order and fails if any of them
+// did, which makes the relay retry the event for all of them.
+func (b *bus) Publish(ctx context.Context, event models.Event) error {
+	b.mu.RLock()
+	handlers := append(append([]Handler(nil), b.handlers[event.Type]...), b.handlers["*"]...)
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, h := range handlers {
+		if err := h(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,198 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"server-go/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var registered = models.Event{Id: 42, TenantId: 3, UserId: 7, Type: models.EventUserRegistered, Payload: json.RawMessage(`{"id":7}`)}
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	var seen []string
+	b.Subscribe(models.EventUserRegistered, func(ctx context.Context, e models.Event) error {
+		seen = append(seen, "registered")
+		return nil
+	})
+	b.Subscribe("*", func(ctx context.Context, e models.Event) error {
+		seen = append(seen, "any:"+e.Type)
+		return errors.New("not now")
+	})
+
+	assert.EqualError(t, b.Publish(context.Background(), registered), "not now")
+	assert.NoError(t, NewBus().Publish(context.Background(), registered), "no subscribers is fine")
+	assert.Equal(t, []string{"registered", "any:user.registered"}, seen)
+}
+
+func TestWebhookSink(t *testing.T) {
+	status := http.StatusNoContent
+	var got models.Event
+	var key string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("Idempotency-Key")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	sink := NewWebhookSink(srv.URL, srv.Client())
+
+	require.NoError(t, sink.Publish(context.Background(), registered))
+	assert.Equal(t, "42", key)
+	assert.Equal(t, registered.Type, got.Type)
+	assert.JSONEq(t, `{"id":7}`, string(got.Payload))
+
+	status = http.StatusBadGateway
+	assert.Error(t, sink.Publish(context.Background(), registered))
+}
+
+func TestWebhookClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	_, err := NewWebhookClient(time.Second, false).Get(srv.URL)
+	assert.ErrorIs(t, err, ErrPrivateAddress, "loopback is refused")
+	for _, addr := range []string{"10.0.0.1:80", "169.254.169.254:80", "0.0.0.0:80", "[::1]:80", "[fe80::1]:80"} {
+		assert.ErrorIs(t, refusePrivate("tcp", addr, nil), ErrPrivateAddress, addr)
+	}
+	assert.NoError(t, refusePrivate("tcp", "93.184.216.34:443", nil))
+
+	client := NewWebhookClient(time.Second, true)
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode, "private networks can be allowed for development")
+
+	resp, err = client.Get(srv.URL + "/moved")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode, "redirects are not followed")
+}
+
+func TestKafkaSink(t *testing.T) {
+	answer := `{"offsets":[{"partition":0,"offset":12,"error_code":null,"error":null}]}`
+	var body struct {
+		Records []struct {
+			Key   string       `json:"key"`
+			Value models.Event `json:"value"`
+		} `json:"records"`
+	}
+	var path, contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&body)
+		io.WriteString(w, answer)
+	}))
+	defer srv.Close()
+	sink := NewKafkaSink(srv.URL, "users", srv.Client())
+
+	require.NoError(t, sink.Publish(context.Background(), registered))
+	assert.Equal(t, "/topics/users", path)
+	assert.Equal(t, "application/vnd.kafka.json.v2+json", contentType)
+	require.Len(t, body.Records, 1)
+	assert.Equal(t, "3:7", body.Records[0].Key, "keyed by user for ordering")
+	assert.Equal(t, int64(42), body.Records[0].Value.Id)
+
+	answer = `{"offsets":[{"partition":null,"offset":null,"error_code":40301,"error":"not authorized"}]}`
+	assert.ErrorContains(t, sink.Publish(context.Background(), registered), "not authorized")
+}
+
+// natsServer accepts one client at a time and answers like a NATS server,
+// failing publishes to subjects that contain "deny".
+func natsServer(t *testing.T, published chan<- string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				io.WriteString(conn, `INFO {"server_id":"test","headers":true}`+"\r\n")
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					switch fields[0] {
+					case "HPUB":
+						total, _ := strconv.Atoi(fields[3])
+						msg := make([]byte, total+2)
+						if _, err := io.ReadFull(r, msg); err != nil {
+							return
+						}
+						if strings.Contains(fields[1], "deny") {
+							io.WriteString(conn, "-ERR 'Permissions Violation for Publish'\r\n")
+							continue
+						}
+						published <- fields[1] + " " + string(msg[:total])
+					case "PING":
+						io.WriteString(conn, "PONG\r\n")
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestNATSSink(t *testing.T) {
+	published := make(chan string, 4)
+	sink := NewNATSSink(natsServer(t, published), "users", time.Second)
+
+	require.NoError(t, sink.Publish(context.Background(), registered))
+	msg := <-published
+	assert.True(t, strings.HasPrefix(msg, "users.user.registered NATS/1.0\r\nNats-Msg-Id: 42\r\n\r\n"), msg)
+	assert.Contains(t, msg, `"type":"user.registered"`)
+
+	denied := registered
+	denied.Type = "deny"
+	assert.ErrorContains(t, sink.Publish(context.Background(), denied), "Permissions Violation")
+
+	// A failure drops the connection; the next publish reconnects
+	require.NoError(t, sink.Publish(context.Background(), registered))
+	<-published
+
+	assert.Error(t, NewNATSSink("127.0.0.1:1", "users", time.Second).Publish(context.Background(), registered))
+}
+
+func TestFanout(t *testing.T) {
+	var calls int
+	ok := sinkFunc(func(context.Context, models.Event) error { calls++; return nil })
+	failing := sinkFunc(func(context.Context, models.Event) error { calls++; return fmt.Errorf("down") })
+
+	assert.NoError(t, Fanout(ok, ok).Publish(context.Background(), registered))
+	assert.EqualError(t, Fanout(failing, ok).Publish(context.Background(), registered), "down")
+	assert.Equal(t, 4, calls, "a failing sink does not stop the others")
+}
+
+type sinkFunc func(ctx context.Context, event models.Event) error
+
+func (f sinkFunc) Publish(ctx context.Context, event models.Event) error { return f(ctx, event) }
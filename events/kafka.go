@@ -0,0 +1,78 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"server-go/models"
+)
+
+// kafkaSink produces through a Kafka REST proxy (the v2 API of Confluent's
+// REST Proxy and compatible gateways), which keeps a Kafka client out of
+// this service.
+type kafkaSink struct {
+	proxyURL string
+	topic    string
+	client   *http.Client
+}
+
+// NewKafkaSink produces each event to topic. Records are keyed by tenant
+// and user, so a user's events land on one partition and keep their order.
+func NewKafkaSink(proxyURL string, topic string, client *http.Client) Sink {
+	return &kafkaSink{proxyURL: proxyURL, topic: topic, client: client}
+}
+
+type kafkaRecord struct {
+	Key   string       `json:"key"`
+	Value models.Event `json:"value"`
+}
+
+type kafkaOffsets struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+func (s *kafkaSink) Publish(ctx context.Context, event models.Event) error {
+	body, err := json.Marshal(map[string][]kafkaRecord{"records": {{
+		Key:   strconv.Itoa(event.TenantId) + ":" + strconv.Itoa(event.UserId),
+		Value: event,
+	}}})
+	if err != nil {
+		return err
+	}
+	endpoint := s.proxyURL + "/topics/" + url.PathEscape(s.topic)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kafka: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kafka: proxy answered %d", resp.StatusCode)
+	}
+
+	// The proxy answers 200 even when a record was rejected
+	var result kafkaOffsets
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("kafka: %w", err)
+	}
+	for _, o := range result.Offsets {
+		if o.ErrorCode != nil {
+			return fmt.Errorf("kafka: record rejected (%d): %s", *o.ErrorCode, o.Error)
+		}
+	}
+	return nil
+}
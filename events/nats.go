@@ -0,0 +1,111 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"server-go/models"
+)
+
+// natsSink speaks the NATS client protocol directly: it is small, and a
+// publish followed by PING/PONG tells us the server has the message.
+type natsSink struct {
+	addr    string
+	prefix  string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewNATSSink publishes each event to <prefix>.<event type> on the NATS
+// server at addr (host:port). The event id goes in the Nats-Msg-Id header,
+// which JetStream uses to drop duplicates.
+func NewNATSSink(addr string, prefix string, timeout time.Duration) Sink {
+	return &natsSink{addr: addr, prefix: prefix, timeout: timeout}
+}
+
+func (s *natsSink) Publish(ctx context.Context, event models.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err := s.connect(ctx); err != nil {
+			return err
+		}
+	}
+	if err := s.publish(ctx, s.prefix+"."+event.Type, strconv.FormatInt(event.Id, 10), body); err != nil {
+		// Start over with a fresh connection next time
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *natsSink) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("nats: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(s.timeout))
+	r := bufio.NewReader(conn)
+
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("nats: no INFO from %s", s.addr)
+	}
+	if _, err := conn.Write([]byte(`CONNECT {"verbose":false,"pedantic":false,"headers":true,"name":"server-go"}` + "\r\n")); err != nil {
+		conn.Close()
+		return fmt.Errorf("nats: %w", err)
+	}
+	s.conn, s.r = conn, r
+	return nil
+}
+
+func (s *natsSink) publish(ctx context.Context, subject string, msgId string, body []byte) error {
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	s.conn.SetDeadline(deadline)
+
+	headers := "NATS/1.0\r\nNats-Msg-Id: " + msgId + "\r\n\r\n"
+	frame := fmt.Sprintf("HPUB %s %d %d\r\n%s%s\r\nPING\r\n", subject, len(headers), len(headers)+len(body), headers, body)
+	if _, err := s.conn.Write([]byte(frame)); err != nil {
+		return fmt.Errorf("nats: %w", err)
+	}
+
+	// The PONG comes after the server processed the HPUB
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("nats: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return fmt.Errorf("nats: %w", err)
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"server-go/repositories"
+)
+
+// RelayOptions tune the relay. Backoff doubles with each failed attempt;
+// after MaxAttempts the event goes to the dead-letter table.
+type RelayOptions struct {
+	Interval    time.Duration
+	BatchSize   int
+	Lease       time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Relay moves events from the outbox to a sink.
+type Relay interface {
+	// Run polls the outbox until ctx is cancelled.
+	Run(ctx context.Context)
+	// Poll delivers one batch and returns how many events it claimed.
+	Poll(ctx context.Context) (int, error)
+}
+
+type relay struct {
+	outbox  repositories.OutboxRepository
+	sink    Sink
+	options RelayOptions
+	logger  *slog.Logger
+}
+
+func NewRelay(outbox repositories.OutboxRepository, sink Sink, options RelayOptions, logger *slog.Logger) Relay {
+	return &relay{outbox: outbox, sink: sink, options: options, logger: logger}
+}
+
+func (r *relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.options.Interval)
+	defer ticker.Stop()
+	for {
+		// Keep going while there is a backlog
+		n, err := r.Poll(ctx)
+		if err != nil {
+			r.logger.ErrorContext(ctx, "outbox relay failed", "error", err)
+		}
+		if err == nil && n == r.options.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *relay) Poll(ctx context.Context) (int, error) {
+	events, err := r.outbox.Claim(ctx, r.options.BatchSize, r.options.Lease)
+	if err != nil {
+		return 0, err
+	}
+	for _, event := range events {
+		// Claim hands out one event per user, so these are independent
+		publishErr := r.sink.Publish(ctx, event)
+		if publishErr == nil {
+			if err := r.outbox.Delivered(ctx, event.Id); err != nil {
+				// The lease runs out and the event goes out again
+				return len(events), err
+			}
+			continue
+		}
+
+		attempts := event.Attempts + 1
+		if attempts >= r.options.MaxAttempts {
+			r.logger.ErrorContext(ctx, "event dead-lettered", "event", event.Id, "type", event.Type, "attempts", attempts, "error", publishErr)
+			err = r.outbox.DeadLetter(ctx, event.Id, publishErr.Error())
+		} else {
+			next := time.Now().Add(r.backoff(attempts))
+			r.logger.WarnContext(ctx, "event delivery failed", "event", event.Id, "type", event.Type, "attempts", attempts, "retryAt", next, "error", publishErr)
+			err = r.outbox.Retry(ctx, event.Id, publishErr.Error(), next)
+		}
+		if err != nil {
+			return len(events), err
+		}
+	}
+	return len(events), nil
+}
+
+func (r *relay) backoff(attempts int) time.Duration {
+	delay := r.options.Backoff
+	for i := 1; i < attempts && delay < r.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.options.MaxBackoff {
+		delay = r.options.MaxBackoff
+	}
+	return delay
+}
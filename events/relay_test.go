@@ -0,0 +1,174 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/repositories"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryOutbox mirrors the claim rules of the SQL implementation: only the
+// oldest pending event of each user is due.
+type memoryOutbox struct {
+	repositories.OutboxRepository
+	mu      sync.Mutex
+	pending []*outboxRow
+	dead    []models.DeadLetter
+}
+
+type outboxRow struct {
+	event     models.Event
+	available time.Time
+}
+
+func (o *memoryOutbox) add(userId int, eventType string) {
+	o.pending = append(o.pending, &outboxRow{event: models.Event{Id: int64(len(o.pending) + len(o.dead) + 1), TenantId: 3, UserId: userId, Type: eventType}})
+}
+
+func (o *memoryOutbox) Claim(ctx context.Context, limit int, lease time.Duration) ([]models.Event, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	seen := map[int]bool{}
+	var events []models.Event
+	for _, row := range o.pending {
+		head := !seen[row.event.UserId]
+		seen[row.event.UserId] = true
+		if head && !row.available.After(time.Now()) && len(events) < limit {
+			row.available = time.Now().Add(lease)
+			events = append(events, row.event)
+		}
+	}
+	return events, nil
+}
+
+func (o *memoryOutbox) remove(id int64) *outboxRow {
+	for i, row := range o.pending {
+		if row.event.Id == id {
+			o.pending = append(o.pending[:i], o.pending[i+1:]...)
+			return row
+		}
+	}
+	return nil
+}
+
+func (o *memoryOutbox) Delivered(ctx context.Context, id int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.remove(id)
+	return nil
+}
+
+func (o *memoryOutbox) Retry(ctx context.Context, id int64, lastErr string, next time.Time) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, row := range o.pending {
+		if row.event.Id == id {
+			row.event.Attempts++
+			row.available = next
+		}
+	}
+	return nil
+}
+
+func (o *memoryOutbox) DeadLetter(ctx context.Context, id int64, lastErr string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	row := o.remove(id)
+	o.dead = append(o.dead, models.DeadLetter{Event: row.event, LastError: lastErr})
+	return nil
+}
+
+var testRelayOptions = RelayOptions{Interval: time.Millisecond, BatchSize: 10, Lease: time.Minute, MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+func TestRelayKeepsOrderPerUser(t *testing.T) {
+	outbox := &memoryOutbox{}
+	outbox.add(1, models.EventUserRegistered)
+	outbox.add(2, models.EventUserRegistered)
+	outbox.add(1, models.EventUserUpdated)
+	outbox.add(1, models.EventUserDeleted)
+
+	var got []models.Event
+	sink := sinkFunc(func(ctx context.Context, e models.Event) error {
+		got = append(got, e)
+		return nil
+	})
+	relay := NewRelay(outbox, sink, testRelayOptions, logging.Discard())
+
+	for i := 0; i < 3; i++ {
+		_, err := relay.Poll(context.Background())
+		require.NoError(t, err)
+	}
+	assert.Empty(t, outbox.pending)
+
+	var forUser1 []string
+	for _, e := range got {
+		if e.UserId == 1 {
+			forUser1 = append(forUser1, e.Type)
+		}
+	}
+	assert.Equal(t, []string{models.EventUserRegistered, models.EventUserUpdated, models.EventUserDeleted}, forUser1)
+}
+
+func TestRelayRetriesAndDeadLetters(t *testing.T) {
+	outbox := &memoryOutbox{}
+	outbox.add(1, models.EventUserRegistered)
+	outbox.add(1, models.EventUserUpdated)
+	outbox.add(2, models.EventUserRegistered)
+
+	attempts := map[int64]int{}
+	var order []int64
+	sink := sinkFunc(func(ctx context.Context, e models.Event) error {
+		attempts[e.Id]++
+		order = append(order, e.Id)
+		switch {
+		case e.Id == 1:
+			return errors.New("receiver rejects it")
+		case e.Id == 3 && attempts[e.Id] == 1:
+			return errors.New("flaky")
+		}
+		return nil
+	})
+	relay := NewRelay(outbox, sink, testRelayOptions, logging.Discard())
+
+	for i := 0; i < 10 && len(outbox.pending) > 0; i++ {
+		_, err := relay.Poll(context.Background())
+		require.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	assert.Empty(t, outbox.pending)
+	require.Len(t, outbox.dead, 1)
+	assert.Equal(t, int64(1), outbox.dead[0].Id)
+	assert.Equal(t, "receiver rejects it", outbox.dead[0].LastError)
+	assert.Equal(t, 3, attempts[1], "given up after MaxAttempts")
+	assert.Equal(t, int64(2), order[len(order)-1], "the user's next event waited for the dead letter")
+	assert.Equal(t, 2, attempts[3], "delivered on the retry")
+}
+
+func TestRelayRunStopsWithContext(t *testing.T) {
+	outbox := &memoryOutbox{}
+	outbox.add(1, models.EventUserRegistered)
+	delivered := make(chan models.Event, 1)
+	relay := NewRelay(outbox, sinkFunc(func(ctx context.Context, e models.Event) error {
+		delivered <- e
+		return nil
+	}), testRelayOptions, logging.Discard())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+	assert.Equal(t, int64(1), (<-delivered).Id)
+	cancel()
+	<-done
+}
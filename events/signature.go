@@ -0,0 +1,59 @@
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the signature of a webhook delivery.
+const SignatureHeader = "X-Webhook-Signature"
+
+// Sign returns the SignatureHeader value for body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">".
+// Signing the timestamp lets receivers reject replayed deliveries.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + signature(secret, ts, body)
+}
+
+func signature(secret string, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a SignatureHeader value against body, as a receiver
+// would. Signatures older or newer than tolerance are rejected.
+func Verify(secret string, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return errors.New("malformed signature")
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return errors.New("signature timestamp outside tolerance")
+	}
+	expected := signature(secret, ts, body)
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return errors.New("signature mismatch")
+}
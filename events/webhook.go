@@ -0,0 +1,91 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"server-go/models"
+)
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink POSTs each event as JSON to url. The event id is sent as
+// Idempotency-Key so the receiver can drop redeliveries. Any status other
+// than 2xx is a failure.
+func NewWebhookSink(url string, client *http.Client) Sink {
+	return &webhookSink{url: url, client: client}
+}
+
+func (s *webhookSink) Publish(ctx context.Context, event models.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", strconv.FormatInt(event.Id, 10))
+	req.Header.Set("X-Event-Type", event.Type)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: %s answered %d", s.url, resp.StatusCode)
+	}
+	return nil
+}
+
+// ErrPrivateAddress is returned when a webhook client is asked to connect
+// to a loopback, private, link-local or unspecified address.
+var ErrPrivateAddress = errors.New("webhook: refusing to connect to a private address")
+
+// NewWebhookClient returns a client for callbacks whose URLs come from
+// users. It does not follow redirects and, unless allowPrivate is set for
+// development, refuses to connect to addresses inside the network. The
+// check runs on the address actually dialed, so DNS cannot point a public
+// name at an internal host.
+func NewWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = refusePrivate
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func refusePrivate(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return ErrPrivateAddress
+	}
+	return nil
+}
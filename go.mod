@@ -1,6 +1,6 @@
 module server-go
 
-go 1.20
+go 1.21
 
 require github.com/gin-gonic/gin v1.9.1
 
@@ -0,0 +1,145 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression: minute, hour, day of month, month
+// and day of week, each a *, a number, a range a-b or a list of those, with
+// an optional /step. Day of week runs from 0 (Sunday) to 6; 7 is Sunday as
+// well. As in cron, a day matches either day field when both are
+// restricted.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSchedule parses a five-field cron expression or one of @hourly,
+// @daily, @weekly, @monthly and @yearly.
+func ParseSchedule(spec string) (*Schedule, error) {
+	if expanded, ok := cronDescriptors[strings.TrimSpace(spec)]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields in %q", spec)
+	}
+
+	s := &Schedule{
+		domAny: strings.HasPrefix(fields[2], "*"),
+		dowAny: strings.HasPrefix(fields[4], "*"),
+	}
+	bounds := []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		bits, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron: field %d of %q: %w", i+1, spec, err)
+		}
+		*b.bits = bits
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		span, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			span, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		if span != "*" {
+			var err error
+			if from, to, ok := strings.Cut(span, "-"); ok {
+				lo, err = strconv.Atoi(from)
+				if err == nil {
+					hi, err = strconv.Atoi(to)
+				}
+			} else {
+				lo, err = strconv.Atoi(span)
+				// A bare number with a step, like 5/15, runs to the end
+				if step == 1 {
+					hi = lo
+				}
+			}
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t that matches the schedule, in t's
+// location, or the zero time when there is none within five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,43 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2024, time.May, 15, 10, 17, 30, 0, time.UTC)
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.May, 15, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.May, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, time.May, 16, 3, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.May, 16, 0, 0, 0, 0, time.UTC)},
+		{"30 9-17/4 * * *", time.Date(2024, time.May, 15, 13, 30, 0, 0, time.UTC)},
+		{"0 0 * * 1,5", time.Date(2024, time.May, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.May, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, time.May, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted
+		{"0 0 1 * 4", time.Date(2024, time.May, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, c := range cases {
+		s, err := ParseSchedule(c.spec)
+		require.NoError(t, err, c.spec)
+		assert.Equal(t, c.want, s.Next(from), c.spec)
+	}
+}
+
+func TestParseScheduleRejects(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often"} {
+		_, err := ParseSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,401 @@
+// Package jobs runs background work out of a queue, so that request
+// handlers can hand off mail, maintenance and exports instead of doing
+// them inline.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"server-go/models"
+	"server-go/repositories"
+)
+
+// Options tune the runner. Backoff doubles with each failed attempt up to
+// MaxBackoff; a job that fails MaxAttempts times stays in the queue as
+// failed.
+type Options struct {
+	Workers      int
+	PollInterval time.Duration
+	Lease        time.Duration
+	MaxAttempts  int
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+}
+
+// EnqueueOptions are per job; the zero value runs the job as soon as a
+// worker is free, with the runner's MaxAttempts.
+type EnqueueOptions struct {
+	RunAt       time.Time
+	UniqueKey   string
+	MaxAttempts int
+}
+
+// Queue is what code handing off work depends on.
+type Queue interface {
+	// Enqueue adds a job of kind with args encoded as JSON. It returns
+	// false when a job with the same unique key is already queued.
+	Enqueue(ctx context.Context, kind string, args any, opts EnqueueOptions) (bool, error)
+}
+
+// Handler runs one job. Returning an error retries the job later, unless
+// the error is Permanent.
+type Handler func(ctx context.Context, args json.RawMessage) error
+
+// Runner is a pool of workers for the kinds registered with it.
+type Runner interface {
+	Queue
+	// Register installs the handler for kind. Call it before Start.
+	Register(kind string, handler Handler)
+	// Schedule enqueues a job of kind at every time matching the cron spec,
+	// in UTC. Several servers may share a schedule; each run is enqueued
+	// once, and not at all while the previous run is still queued.
+	Schedule(spec string, kind string, args any) error
+	// Start launches the workers and the scheduler. Jobs run with a context
+	// derived from ctx.
+	Start(ctx context.Context)
+	// Shutdown stops claiming jobs and waits for the running ones. When ctx
+	// ends first they are cancelled and go back to the queue.
+	Shutdown(ctx context.Context) error
+	// Work runs due jobs on the calling goroutine until there are none left
+	// and returns how many ran. Tests use it instead of Start.
+	Work(ctx context.Context) (int, error)
+}
+
+// Kind ties a job name to the type of its arguments, so enqueuing and
+// handling agree on them.
+type Kind[T any] struct {
+	Name string
+}
+
+func (k Kind[T]) Enqueue(ctx context.Context, q Queue, args T, opts EnqueueOptions) (bool, error) {
+	return q.Enqueue(ctx, k.Name, args, opts)
+}
+
+func (k Kind[T]) Handle(r Runner, fn func(ctx context.Context, args T) error) {
+	r.Register(k.Name, func(ctx context.Context, raw json.RawMessage) error {
+		var args T
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return Permanent(fmt.Errorf("decoding %s arguments: %w", k.Name, err))
+		}
+		return fn(ctx, args)
+	})
+}
+
+func (k Kind[T]) Schedule(r Runner, spec string, args T) error {
+	return r.Schedule(spec, k.Name, args)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handler error that retrying will not fix.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+type schedule struct {
+	kind string
+	cron *Schedule
+	args json.RawMessage
+	next time.Time
+}
+
+type runner struct {
+	store     repositories.JobRepository
+	options   Options
+	logger    *slog.Logger
+	handlers  map[string]Handler
+	kinds     []string
+	schedules []*schedule
+
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	cancel   context.CancelFunc
+	running  sync.WaitGroup
+}
+
+// NewRunner returns a runner on store: NewJobRepository for Postgres, or
+// NewMemoryStore.
+func NewRunner(store repositories.JobRepository, options Options, logger *slog.Logger) Runner {
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = time.Second
+	}
+	if options.Lease <= 0 {
+		options.Lease = 5 * time.Minute
+	}
+	return &runner{
+		store:    store,
+		options:  options,
+		logger:   logger,
+		handlers: map[string]Handler{},
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (r *runner) Register(kind string, handler Handler) {
+	if _, ok := r.handlers[kind]; !ok {
+		r.kinds = append(r.kinds, kind)
+	}
+	r.handlers[kind] = handler
+}
+
+func (r *runner) Schedule(spec string, kind string, args any) error {
+	cron, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	r.schedules = append(r.schedules, &schedule{kind: kind, cron: cron, args: raw})
+	return nil
+}
+
+func (r *runner) Enqueue(ctx context.Context, kind string, args any, opts EnqueueOptions) (bool, error) {
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return false, err
+	}
+	job := &models.Job{Kind: kind, Args: raw, UniqueKey: opts.UniqueKey, MaxAttempts: opts.MaxAttempts, RunAt: opts.RunAt}
+	if job.MaxAttempts == 0 {
+		job.MaxAttempts = r.options.MaxAttempts
+	}
+	added, err := r.store.Enqueue(ctx, job)
+	if err != nil || !added {
+		return false, err
+	}
+	if !job.RunAt.After(time.Now()) {
+		// Let an idle worker of this process pick it up right away
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+	return true, nil
+}
+
+func (r *runner) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	for i := 0; i < r.options.Workers; i++ {
+		r.running.Add(1)
+		go r.work(ctx)
+	}
+	if len(r.schedules) > 0 {
+		r.running.Add(1)
+		go r.schedule(ctx)
+	}
+}
+
+func (r *runner) Shutdown(ctx context.Context) error {
+	r.stopOnce.Do(func() { close(r.stop) })
+	if r.cancel == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		r.cancel()
+		<-done
+		return ctx.Err()
+	}
+	r.cancel()
+	return nil
+}
+
+func (r *runner) stopping() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *runner) work(ctx context.Context) {
+	defer r.running.Done()
+	ticker := time.NewTicker(r.options.PollInterval)
+	defer ticker.Stop()
+	for !r.stopping() {
+		// Keep going while there is a backlog
+		n, err := r.runDue(ctx, 1)
+		if err != nil {
+			r.logger.ErrorContext(ctx, "job worker failed", "error", err)
+		}
+		if err == nil && n > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.stop:
+		case <-r.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *runner) Work(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := r.runDue(ctx, r.options.Workers)
+		total += n
+		if err != nil || n == 0 {
+			return total, err
+		}
+	}
+}
+
+// runDue claims up to limit jobs and runs them one after the other.
+func (r *runner) runDue(ctx context.Context, limit int) (int, error) {
+	if len(r.kinds) == 0 {
+		return 0, nil
+	}
+	jobs, err := r.store.Claim(ctx, r.kinds, limit, r.options.Lease)
+	if err != nil {
+		return 0, err
+	}
+	for _, job := range jobs {
+		if err := r.run(ctx, job); err != nil {
+			// The lease runs out and the job runs again
+			return len(jobs), err
+		}
+	}
+	return len(jobs), nil
+}
+
+func (r *runner) run(ctx context.Context, job models.Job) error {
+	started := time.Now()
+	runErr := r.call(ctx, job)
+	// Record the outcome even when the job was cancelled by shutdown
+	store := context.WithoutCancel(ctx)
+
+	var held bool
+	var err error
+	var permanent *permanentError
+	switch {
+	case runErr == nil:
+		r.logger.InfoContext(ctx, "job done", "job", job.Id, "kind", job.Kind, "duration", time.Since(started))
+		held, err = r.store.Complete(store, job.Id, job.Attempts)
+	case ctx.Err() != nil:
+		r.logger.WarnContext(ctx, "job interrupted", "job", job.Id, "kind", job.Kind)
+		held, err = r.store.Retry(store, job.Id, job.Attempts, "interrupted: "+runErr.Error(), time.Now())
+	case errors.As(runErr, &permanent) || job.Attempts >= job.MaxAttempts:
+		r.logger.ErrorContext(ctx, "job failed", "job", job.Id, "kind", job.Kind, "attempts", job.Attempts, "error", runErr)
+		held, err = r.store.Fail(store, job.Id, job.Attempts, runErr.Error())
+	default:
+		next := time.Now().Add(r.backoff(job.Attempts))
+		r.logger.WarnContext(ctx, "job attempt failed", "job", job.Id, "kind", job.Kind, "attempts", job.Attempts, "retryAt", next, "error", runErr)
+		held, err = r.store.Retry(store, job.Id, job.Attempts, runErr.Error(), next)
+	}
+	if err == nil && !held {
+		// The lease ran out and another worker claimed the job; its outcome counts
+		r.logger.WarnContext(ctx, "job lease lost before its outcome was recorded", "job", job.Id, "kind", job.Kind, "attempts", job.Attempts)
+	}
+	return err
+}
+
+// call runs the handler, renewing the lease while it works and turning a
+// panic into an error.
+func (r *runner) call(ctx context.Context, job models.Job) (err error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(r.options.Lease / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := r.store.Extend(ctx, job.Id, r.options.Lease); err != nil {
+					r.logger.WarnContext(ctx, "could not extend job lease", "job", job.Id, "error", err)
+				}
+			}
+		}
+	}()
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return r.handlers[job.Kind](ctx, job.Args)
+}
+
+func (r *runner) backoff(attempts int) time.Duration {
+	delay := r.options.Backoff
+	for i := 1; i < attempts && delay < r.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.options.MaxBackoff {
+		delay = r.options.MaxBackoff
+	}
+	return delay
+}
+
+func (r *runner) schedule(ctx context.Context) {
+	defer r.running.Done()
+	now := time.Now().UTC()
+	for _, s := range r.schedules {
+		s.next = s.cron.Next(now)
+	}
+	for {
+		wait := r.enqueueScheduled(ctx, time.Now().UTC())
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-r.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// enqueueScheduled enqueues the runs due at now and returns how long to
+// wait for the next one.
+func (r *runner) enqueueScheduled(ctx context.Context, now time.Time) time.Duration {
+	wait := time.Hour
+	for _, s := range r.schedules {
+		if s.next.IsZero() {
+			continue
+		}
+		if !now.Before(s.next) {
+			job := &models.Job{Kind: s.kind, Args: s.args, UniqueKey: "schedule:" + s.kind, MaxAttempts: r.options.MaxAttempts}
+			added, err := r.store.EnqueueScheduled(ctx, s.kind, s.next, job)
+			if err != nil {
+				r.logger.ErrorContext(ctx, "could not enqueue scheduled job", "kind", s.kind, "runAt", s.next, "error", err)
+			} else if added {
+				r.logger.InfoContext(ctx, "scheduled job enqueued", "job", job.Id, "kind", s.kind, "runAt", s.next)
+			}
+			s.next = s.cron.Next(now)
+		}
+		if d := s.next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	return wait
+}
@@ -0,0 +1,223 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type greeting struct {
+	Name string `json:"name"`
+}
+
+var greet = Kind[greeting]{Name: "test.greet"}
+
+func testOptions() Options {
+	return Options{Workers: 2, PollInterval: 10 * time.Millisecond, Lease: time.Minute, MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond}
+}
+
+// jobsIn lists the jobs left in a memory store.
+func jobsIn(store *memoryStore) []models.Job {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var jobs []models.Job
+	for _, j := range store.jobs {
+		jobs = append(jobs, j.job)
+	}
+	return jobs
+}
+
+func TestRunnerRunsTypedJobs(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	runner := NewRunner(store, testOptions(), logging.Discard())
+	var got []string
+	greet.Handle(runner, func(ctx context.Context, args greeting) error {
+		got = append(got, args.Name)
+		return nil
+	})
+	ctx := context.Background()
+
+	for _, name := range []string{"Ada", "Grace", "Edsger"} {
+		added, err := greet.Enqueue(ctx, runner, greeting{Name: name}, EnqueueOptions{})
+		require.NoError(t, err)
+		assert.True(t, added)
+	}
+	_, err := greet.Enqueue(ctx, runner, greeting{Name: "Later"}, EnqueueOptions{RunAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	_, err = runner.Enqueue(ctx, "test.unknown", nil, EnqueueOptions{})
+	require.NoError(t, err)
+
+	n, err := runner.Work(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []string{"Ada", "Grace", "Edsger"}, got)
+	assert.Len(t, jobsIn(store), 2, "future and unhandled jobs stay queued")
+}
+
+func TestRunnerRetriesWithBackoff(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	runner := NewRunner(store, testOptions(), logging.Discard())
+	var calls atomic.Int32
+	runner.Register("test.flaky", func(ctx context.Context, args json.RawMessage) error {
+		if calls.Add(1) < 3 {
+			return errors.New("try again")
+		}
+		return nil
+	})
+	runner.Register("test.broken", func(ctx context.Context, args json.RawMessage) error {
+		panic("boom")
+	})
+	runner.Register("test.invalid", func(ctx context.Context, args json.RawMessage) error {
+		return Permanent(errors.New("bad input"))
+	})
+	ctx := context.Background()
+	for _, kind := range []string{"test.flaky", "test.broken", "test.invalid"} {
+		_, err := runner.Enqueue(ctx, kind, nil, EnqueueOptions{})
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err := runner.Work(ctx)
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.EqualValues(t, 3, calls.Load())
+	left := map[string]models.Job{}
+	for _, job := range jobsIn(store) {
+		left[job.Kind] = job
+	}
+	require.Len(t, left, 2, "the flaky job succeeded on its third attempt")
+	assert.Equal(t, models.JobFailed, left["test.broken"].State)
+	assert.Equal(t, 3, left["test.broken"].Attempts)
+	assert.Equal(t, "panic: boom", left["test.broken"].LastError)
+	assert.Equal(t, models.JobFailed, left["test.invalid"].State)
+	assert.Equal(t, 1, left["test.invalid"].Attempts, "permanent errors are not retried")
+}
+
+func TestRunnerUniqueJobs(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	runner := NewRunner(store, testOptions(), logging.Discard())
+	greet.Handle(runner, func(ctx context.Context, args greeting) error { return nil })
+	ctx := context.Background()
+
+	opts := EnqueueOptions{UniqueKey: "greet:ada"}
+	added, err := greet.Enqueue(ctx, runner, greeting{Name: "Ada"}, opts)
+	require.NoError(t, err)
+	assert.True(t, added)
+	added, err = greet.Enqueue(ctx, runner, greeting{Name: "Ada"}, opts)
+	require.NoError(t, err)
+	assert.False(t, added)
+
+	_, err = runner.Work(ctx)
+	require.NoError(t, err)
+	added, err = greet.Enqueue(ctx, runner, greeting{Name: "Ada"}, opts)
+	require.NoError(t, err)
+	assert.True(t, added, "the key is free once the job is done")
+}
+
+func TestSchedulesEnqueueEachRunOnce(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	// Two servers sharing a queue
+	var runners []*runner
+	for i := 0; i < 2; i++ {
+		r := NewRunner(store, testOptions(), logging.Discard()).(*runner)
+		require.NoError(t, greet.Schedule(r, "0 3 * * *", greeting{Name: "nightly"}))
+		r.schedules[0].next = time.Date(2024, time.May, 15, 3, 0, 0, 0, time.UTC)
+		runners = append(runners, r)
+	}
+	ctx := context.Background()
+
+	now := time.Date(2024, time.May, 15, 3, 0, 2, 0, time.UTC)
+	for _, r := range runners {
+		wait := r.enqueueScheduled(ctx, now)
+		assert.Equal(t, time.Hour, wait, "the scheduler wakes up at least hourly")
+	}
+	jobs := jobsIn(store)
+	require.Len(t, jobs, 1)
+	assert.JSONEq(t, `{"name":"nightly"}`, string(jobs[0].Args))
+
+	// The next night's run is skipped while the last one is still queued
+	now = now.Add(24 * time.Hour)
+	runners[0].enqueueScheduled(ctx, now)
+	assert.Len(t, jobsIn(store), 1)
+}
+
+func TestShutdownWaitsForRunningJobs(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	runner := NewRunner(store, testOptions(), logging.Discard())
+	started := make(chan string, 2)
+	greet.Handle(runner, func(ctx context.Context, args greeting) error {
+		started <- args.Name
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	ctx := context.Background()
+	runner.Start(ctx)
+
+	_, err := greet.Enqueue(ctx, runner, greeting{Name: "quick"}, EnqueueOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "quick", <-started)
+	require.NoError(t, runner.Shutdown(ctx), "a clean shutdown lets the job finish")
+	assert.Empty(t, jobsIn(store))
+
+	runner = NewRunner(store, testOptions(), logging.Discard())
+	greet.Handle(runner, func(ctx context.Context, args greeting) error {
+		started <- args.Name
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	runner.Start(ctx)
+	_, err = greet.Enqueue(ctx, runner, greeting{Name: "slow"}, EnqueueOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "slow", <-started)
+
+	deadline, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, runner.Shutdown(deadline), context.DeadlineExceeded)
+	jobs := jobsIn(store)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, models.JobPending, jobs[0].State, "interrupted jobs go back to the queue")
+	assert.NotPanics(t, func() { runner.Shutdown(ctx) }, "shutting down twice is harmless")
+}
+
+func TestExpiredLeasesCannotSettle(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	ctx := context.Background()
+	_, err := store.Enqueue(ctx, &models.Job{Kind: greet.Name, MaxAttempts: 3})
+	require.NoError(t, err)
+
+	stale, err := store.Claim(ctx, []string{greet.Name}, 1, 0)
+	require.NoError(t, err)
+	require.Len(t, stale, 1)
+	current, err := store.Claim(ctx, []string{greet.Name}, 1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, current, 1, "the lease ran out, so the job is claimed again")
+
+	for _, settle := range []func() (bool, error){
+		func() (bool, error) { return store.Complete(ctx, stale[0].Id, stale[0].Attempts) },
+		func() (bool, error) { return store.Retry(ctx, stale[0].Id, stale[0].Attempts, "late", time.Now()) },
+		func() (bool, error) { return store.Fail(ctx, stale[0].Id, stale[0].Attempts, "late") },
+	} {
+		held, err := settle()
+		require.NoError(t, err)
+		assert.False(t, held)
+	}
+	jobs := jobsIn(store)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, models.JobRunning, jobs[0].State, "the earlier worker changes nothing")
+
+	held, err := store.Complete(ctx, current[0].Id, current[0].Attempts)
+	require.NoError(t, err)
+	assert.True(t, held)
+	assert.Empty(t, jobsIn(store))
+}
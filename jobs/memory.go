@@ -0,0 +1,160 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"server-go/models"
+	"server-go/repositories"
+)
+
+// memoryStore follows the rules of the Postgres queue within one process.
+type memoryStore struct {
+	mu        sync.Mutex
+	nextId    int64
+	jobs      []*memoryJob
+	schedules map[string]time.Time
+}
+
+type memoryJob struct {
+	job         models.Job
+	lockedUntil time.Time
+}
+
+// NewMemoryStore returns a queue that lives in memory, for tests and for
+// running without a database.
+func NewMemoryStore() repositories.JobRepository {
+	return &memoryStore{schedules: map[string]time.Time{}}
+}
+
+func (s *memoryStore) Enqueue(ctx context.Context, job *models.Job) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(job), nil
+}
+
+func (s *memoryStore) add(job *models.Job) bool {
+	if job.UniqueKey != "" {
+		for _, j := range s.jobs {
+			if j.job.UniqueKey == job.UniqueKey && j.job.State != models.JobFailed {
+				return false
+			}
+		}
+	}
+	s.nextId++
+	job.Id = s.nextId
+	job.State = models.JobPending
+	job.CreatedAt = time.Now()
+	if job.RunAt.IsZero() {
+		job.RunAt = job.CreatedAt
+	}
+	s.jobs = append(s.jobs, &memoryJob{job: *job})
+	return true
+}
+
+func (s *memoryStore) EnqueueScheduled(ctx context.Context, name string, runAt time.Time, job *models.Job) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if last, ok := s.schedules[name]; ok && !last.Before(runAt) {
+		return false, nil
+	}
+	s.schedules[name] = runAt
+	job.RunAt = runAt
+	return s.add(job), nil
+}
+
+func (s *memoryStore) Claim(ctx context.Context, kinds []string, limit int, lease time.Duration) ([]models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var claimed []models.Job
+	for _, j := range s.jobs {
+		if len(claimed) == limit {
+			break
+		}
+		due := (j.job.State == models.JobPending && !j.job.RunAt.After(now)) ||
+			(j.job.State == models.JobRunning && !j.lockedUntil.After(now))
+		if !due || !contains(kinds, j.job.Kind) {
+			continue
+		}
+		j.job.State = models.JobRunning
+		j.job.Attempts++
+		j.lockedUntil = now.Add(lease)
+		claimed = append(claimed, j.job)
+	}
+	return claimed, nil
+}
+
+func contains(kinds []string, kind string) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) find(id int64) *memoryJob {
+	for _, j := range s.jobs {
+		if j.job.Id == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Extend(ctx context.Context, id int64, lease time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j := s.find(id); j != nil && j.job.State == models.JobRunning {
+		j.lockedUntil = time.Now().Add(lease)
+	}
+	return nil
+}
+
+// held finds job id if it is still running under attempt.
+func (s *memoryStore) held(id int64, attempt int) (int, *memoryJob) {
+	for i, j := range s.jobs {
+		if j.job.Id == id && j.job.State == models.JobRunning && j.job.Attempts == attempt {
+			return i, j
+		}
+	}
+	return -1, nil
+}
+
+func (s *memoryStore) Complete(ctx context.Context, id int64, attempt int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, j := s.held(id, attempt)
+	if j == nil {
+		return false, nil
+	}
+	s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+	return true, nil
+}
+
+func (s *memoryStore) Retry(ctx context.Context, id int64, attempt int, lastErr string, runAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, j := s.held(id, attempt)
+	if j == nil {
+		return false, nil
+	}
+	j.job.State = models.JobPending
+	j.job.LastError = lastErr
+	j.job.RunAt = runAt
+	return true, nil
+}
+
+func (s *memoryStore) Fail(ctx context.Context, id int64, attempt int, lastErr string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, j := s.held(id, attempt)
+	if j == nil {
+		return false, nil
+	}
+	j.job.State = models.JobFailed
+	j.job.LastError = lastErr
+	return true, nil
+}
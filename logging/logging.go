@@ -0,0 +1,122 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"server-go/tenancy"
+	"strings"
+)
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	userIDKey
+	clientKey
+)
+
+// ClientInfo describes where a request came from.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// Options controls how New builds the logger.
+type Options struct {
+	Level  slog.Level
+	Format string // "json" or "text"
+	Output io.Writer
+}
+
+// New builds a slog.Logger that redacts sensitive values and tags every
+// record with the request, user and tenant IDs found in the context.
+func New(opts Options) *slog.Logger {
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level}
+
+	var h slog.Handler
+	if strings.EqualFold(opts.Format, "text") {
+		h = slog.NewTextHandler(out, handlerOpts)
+	} else {
+		h = slog.NewJSONHandler(out, handlerOpts)
+	}
+	return slog.New(&contextHandler{next: NewRedactHandler(h)})
+}
+
+// ParseLevel maps debug/info/warn/error to a slog.Level, defaulting to info.
+func ParseLevel(s string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// Discard returns a logger that drops everything, handy for tests.
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func WithUserID(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, userIDKey, id)
+}
+
+func UserID(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(userIDKey).(int)
+	return id, ok
+}
+
+func WithClient(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientKey, info)
+}
+
+func Client(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientKey).(ClientInfo)
+	return info
+}
+
+// contextHandler adds request_id and user_id attributes from the context.
+type contextHandler struct {
+	next slog.Handler
+}
+
+func (h *contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if id := RequestID(ctx); id != "" {
+			r.AddAttrs(slog.String("request_id", id))
+		}
+		if id, ok := UserID(ctx); ok {
+			r.AddAttrs(slog.Int("user_id", id))
+		}
+		if id, ok := tenancy.TenantID(ctx); ok {
+			r.AddAttrs(slog.Int("tenant_id", id))
+		}
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{next: h.next.WithGroup(name)}
+}
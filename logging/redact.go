@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// JWTs, bcrypt/PHC hashes and bearer credentials.
+	secretPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*|\$2[aby]\$\d{2}\$[./A-Za-z0-9]{53}|\$argon2id?\$[^\s"]+|(?i)bearer\s+[^\s"]+`)
+
+	sensitiveKeys = []string{"password", "hash", "token", "secret", "authorization", "cookie", "pepper"}
+)
+
+// RedactHandler masks emails, passwords, hashes and tokens before they
+// reach the wrapped handler.
+type RedactHandler struct {
+	next slog.Handler
+}
+
+func NewRedactHandler(next slog.Handler) *RedactHandler {
+	return &RedactHandler{next: next}
+}
+
+func (h *RedactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *RedactHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, RedactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		clean[i] = redactAttr(a)
+	}
+	return &RedactHandler{next: h.next.WithAttrs(clean)}
+}
+
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	key := strings.ToLower(a.Key)
+
+	if a.Value.Kind() == slog.KindGroup {
+		group := a.Value.Group()
+		clean := make([]any, len(group))
+		for i, g := range group {
+			clean[i] = redactAttr(g)
+		}
+		return slog.Group(a.Key, clean...)
+	}
+
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if strings.Contains(key, "email") {
+			return slog.String(a.Key, MaskEmail(a.Value.String()))
+		}
+		return slog.String(a.Key, RedactString(a.Value.String()))
+	case slog.KindAny:
+		// Structs and errors are flattened only when they carry something to hide.
+		value := a.Value.String()
+		if clean := RedactString(value); clean != value {
+			return slog.String(a.Key, clean)
+		}
+	}
+	return a
+}
+
+// RedactString masks every email address and credential-looking value in s.
+func RedactString(s string) string {
+	s = secretPattern.ReplaceAllString(s, redacted)
+	return emailPattern.ReplaceAllStringFunc(s, MaskEmail)
+}
+
+// MaskEmail keeps the first character of the local part and the domain,
+// e.g. john@example.com becomes j***@example.com.
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return emailPattern.ReplaceAllStringFunc(email, MaskEmail)
+	}
+	return email[:1] + "***" + email[at:]
+}
@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(Options{Level: slog.LevelDebug, Format: "json", Output: &buf})
+
+	ctx := WithUserID(WithRequestID(context.Background(), "req-1"), 42)
+	logger.InfoContext(ctx, "login attempt for john@example.com",
+		"email", "john@example.com",
+		"password", "hunter2",
+		"token", "eyJhbGciOiJIUzUxMiJ9.eyJzdWIiOjF9.sig",
+		"error", errors.New("duplicate key for jane@example.com"),
+		slog.Group("user", "hashed", "$2a$10$abcdefghijklmnopqrstuuABCDEFGHIJKLMNOPQRSTUVWXYZ01234"),
+	)
+
+	out := buf.String()
+	assert.Contains(t, out, `"request_id":"req-1"`)
+	assert.Contains(t, out, `"user_id":42`)
+	assert.Contains(t, out, "j***@example.com")
+	assert.NotContains(t, out, "john@example.com")
+	assert.NotContains(t, out, "jane@example.com")
+	assert.NotContains(t, out, "hunter2")
+	assert.NotContains(t, out, "eyJhbGciOiJIUzUxMiJ9")
+	assert.NotContains(t, out, "$2a$10$")
+}
+
+func TestStructValuesAreRedacted(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(Options{Format: "text", Output: &buf})
+
+	logger.Info("registered", "user", struct {
+		Email    string
+		Password string
+	}{"john@example.com", "$2a$10$abcdefghijklmnopqrstuuABCDEFGHIJKLMNOPQRSTUVWXYZ01234"})
+
+	assert.NotContains(t, buf.String(), "john@example.com")
+	assert.NotContains(t, buf.String(), "$2a$10$")
+}
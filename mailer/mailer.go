@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/smtp"
+	"strings"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer sends plain-text mail through host:port, authenticating
+// with PLAIN auth when username is set.
+func NewSMTPMailer(host string, port string, username string, password string, from string) Mailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &smtpMailer{addr: host + ":" + port, auth: auth, from: from}
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("mailer: header contains a line break")
+	}
+	body := "From: " + m.from + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + msg.Body
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, []byte(body))
+}
+
+type logMailer struct {
+	logger *slog.Logger
+}
+
+// NewLogMailer only logs outgoing mail; it is used when SMTP is not
+// configured, e.g. in development.
+func NewLogMailer(logger *slog.Logger) Mailer {
+	return &logMailer{logger: logger}
+}
+
+func (m *logMailer) Send(ctx context.Context, msg Message) error {
+	m.logger.InfoContext(ctx, "mail not sent: SMTP is not configured", "to", msg.To, "subject", msg.Subject)
+	m.logger.DebugContext(ctx, "mail body", "body", msg.Body)
+	return nil
+}
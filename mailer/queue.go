@@ -0,0 +1,26 @@
+package mailer
+
+import (
+	"context"
+
+	"server-go/jobs"
+)
+
+// SendJob delivers a message handed to a queued mailer. Register it with
+// the mailer that actually sends.
+var SendJob = jobs.Kind[Message]{Name: "mail.send"}
+
+type queuedMailer struct {
+	queue jobs.Queue
+}
+
+// NewQueuedMailer puts messages on the job queue instead of sending them,
+// so callers do not wait on the mail server; failed sends are retried.
+func NewQueuedMailer(queue jobs.Queue) Mailer {
+	return &queuedMailer{queue: queue}
+}
+
+func (m *queuedMailer) Send(ctx context.Context, msg Message) error {
+	_, err := SendJob.Enqueue(ctx, m.queue, msg, jobs.EnqueueOptions{})
+	return err
+}
@@ -0,0 +1,93 @@
+package middlewares
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIVersionKey is where handlers find the version of the JSON API a
+// request asked for. Versions are served under /v<n>, or at the
+// unversioned paths when the Accept header names one, as in
+// "application/json; version=1".
+const APIVersionKey = "apiVersion"
+
+// Deprecation describes the unversioned paths: when they were deprecated
+// and when they may stop working.
+type Deprecation struct {
+	Since  time.Time
+	Sunset time.Time
+}
+
+// APIVersion serves a /v<version> route group. The request may still name
+// a version in Accept, but it must be this one.
+func APIVersion(version int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requested, ok := acceptVersion(c.GetHeader("Accept"))
+		if ok && requested != version {
+			c.JSON(http.StatusNotAcceptable, gin.H{"error": "Requested API version does not match the path", "supported": []int{version}})
+			c.Abort()
+			return
+		}
+		c.Set(APIVersionKey, version)
+		c.Next()
+	}
+}
+
+// NegotiateVersion serves the unversioned paths. They answer in the version
+// named by Accept, one of supported, or else in the oldest, which is what
+// they served before versioning; those requests get Deprecation (RFC 9745),
+// Sunset (RFC 8594) and a Link to the versioned path.
+func NegotiateVersion(supported []int, deprecation Deprecation) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept")
+		version, named := acceptVersion(c.GetHeader("Accept"))
+		if !named {
+			version = supported[0]
+			c.Header("Deprecation", "@"+strconv.FormatInt(deprecation.Since.Unix(), 10))
+			if !deprecation.Sunset.IsZero() {
+				c.Header("Sunset", deprecation.Sunset.UTC().Format(http.TimeFormat))
+			}
+			c.Header("Link", "</v"+strconv.Itoa(version)+c.Request.URL.Path+`>; rel="successor-version"`)
+		}
+		if !containsVersion(supported, version) {
+			c.JSON(http.StatusNotAcceptable, gin.H{"error": "Unsupported API version", "supported": supported})
+			c.Abort()
+			return
+		}
+		c.Set(APIVersionKey, version)
+		c.Next()
+	}
+}
+
+// acceptVersion finds the first version parameter in an Accept header. A
+// version that is not a number is reported as 0, which no route serves.
+func acceptVersion(accept string) (int, bool) {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		if v, ok := params["version"]; ok {
+			version, err := strconv.Atoi(strings.TrimPrefix(v, "v"))
+			if err != nil {
+				return 0, true
+			}
+			return version, true
+		}
+	}
+	return 0, false
+}
+
+func containsVersion(versions []int, version int) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
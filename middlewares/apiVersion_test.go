@@ -0,0 +1,58 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	deprecation := Deprecation{
+		Since:  time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+		Sunset: time.Date(2027, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	r := gin.New()
+	served := func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"version": c.GetInt(APIVersionKey)}) }
+	r.GET("/me", NegotiateVersion([]int{1}, deprecation), served)
+	r.GET("/v1/me", APIVersion(1), served)
+
+	for _, tc := range []struct {
+		path, accept string
+		code         int
+		deprecated   bool
+	}{
+		{"/me", "", http.StatusOK, true},
+		{"/me", "application/json", http.StatusOK, true},
+		{"/me", "application/json; version=1", http.StatusOK, false},
+		{"/me", "text/html, application/json;version=v1", http.StatusOK, false},
+		{"/me", "application/json; version=2", http.StatusNotAcceptable, false},
+		{"/me", "application/json; version=latest", http.StatusNotAcceptable, false},
+		{"/v1/me", "", http.StatusOK, false},
+		{"/v1/me", "application/json; version=1", http.StatusOK, false},
+		{"/v1/me", "application/json; version=2", http.StatusNotAcceptable, false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, tc.code, resp.Code, "%s %q", tc.path, tc.accept)
+		if tc.code == http.StatusOK {
+			assert.JSONEq(t, `{"version":1}`, resp.Body.String(), "%s %q", tc.path, tc.accept)
+		}
+		if tc.deprecated {
+			assert.Equal(t, "@1793491200", resp.Header().Get("Deprecation"))
+			assert.Equal(t, "Sat, 01 May 2027 00:00:00 GMT", resp.Header().Get("Sunset"))
+			assert.Equal(t, `</v1/me>; rel="successor-version"`, resp.Header().Get("Link"))
+		} else {
+			assert.Empty(t, resp.Header().Get("Deprecation"), "%s %q", tc.path, tc.accept)
+		}
+	}
+}
@@ -1,13 +1,19 @@
 package middlewares
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"server-go/logging"
 	"server-go/models"
+	"server-go/tenancy"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
@@ -15,11 +21,120 @@ import (
 
 var JwtSecret = []byte(os.Getenv("JWT_SECRET"))
 
-func AuthMiddleware() gin.HandlerFunc {
+// RevocationChecker reports the instant before which a user's tokens are no
+// longer valid (the zero time means nothing was revoked) and whether a
+// single token was revoked by its jti.
+type RevocationChecker interface {
+	TokensRevokedAt(ctx context.Context, id int) (time.Time, error)
+	TokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// AccessTokenAuthenticator resolves personal access tokens. It returns a
+// nil user for tokens that are unknown or no longer active.
+type AccessTokenAuthenticator interface {
+	AuthenticateAccessToken(ctx context.Context, token string) (*models.User, *models.AccessToken, error)
+}
+
+type accessTokenChecker struct {
+	RevocationChecker
+	AccessTokenAuthenticator
+}
+
+func (c accessTokenChecker) Unwrap() RevocationChecker { return c.RevocationChecker }
+
+// WithAccessTokens makes ValidateToken accept personal access tokens
+// alongside session tokens.
+func WithAccessTokens(revocations RevocationChecker, tokens AccessTokenAuthenticator) RevocationChecker {
+	return accessTokenChecker{RevocationChecker: revocations, AccessTokenAuthenticator: tokens}
+}
+
+// SessionTracker records the last time a session token was used.
+type SessionTracker interface {
+	TouchSession(ctx context.Context, jti string) error
+}
+
+type sessionChecker struct {
+	RevocationChecker
+	SessionTracker
+}
+
+func (c sessionChecker) Unwrap() RevocationChecker { return c.RevocationChecker }
+
+// WithSessions makes AuthMiddleware report every request made with a
+// session token to sessions.
+func WithSessions(revocations RevocationChecker, sessions SessionTracker) RevocationChecker {
+	return sessionChecker{RevocationChecker: revocations, SessionTracker: sessions}
+}
+
+// StatusChecker reports a user's account status, or "" when the user no
+// longer exists.
+type StatusChecker interface {
+	AccountStatus(ctx context.Context, id int) (string, error)
+}
+
+type statusChecker struct {
+	RevocationChecker
+	StatusChecker
+}
+
+func (c statusChecker) Unwrap() RevocationChecker { return c.RevocationChecker }
+
+// WithStatus makes ValidateToken reject session tokens of users whose
+// account is not active, even before the tokens expire.
+func WithStatus(revocations RevocationChecker, statuses StatusChecker) RevocationChecker {
+	return statusChecker{RevocationChecker: revocations, StatusChecker: statuses}
+}
+
+// UserLoader finds a user of the context's organization, or nil when there
+// is no such user.
+type UserLoader interface {
+	FindByID(ctx context.Context, id int) (*models.User, error)
+}
+
+type freshUserChecker struct {
+	RevocationChecker
+	users UserLoader
+}
+
+func (c freshUserChecker) Unwrap() RevocationChecker { return c.RevocationChecker }
+
+// WithFreshUsers makes ValidateToken load the user of every session token
+// through users instead of trusting the claims, so that the name, email and
+// role are current and tokens issued before the user's token version went
+// up stop working at once. users should be cached; without this wrapper
+// requests cost no user lookup.
+func WithFreshUsers(revocations RevocationChecker, users UserLoader) RevocationChecker {
+	return freshUserChecker{RevocationChecker: revocations, users: users}
+}
+
+// extension finds the T that revocations was wrapped with, if any.
+func extension[T any](revocations RevocationChecker) (T, bool) {
+	for revocations != nil {
+		if ext, ok := revocations.(T); ok {
+			return ext, true
+		}
+		wrapper, ok := revocations.(interface{ Unwrap() RevocationChecker })
+		if !ok {
+			break
+		}
+		revocations = wrapper.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+var (
+	ErrInvalidToken     = errors.New("invalid token")
+	ErrInvalidTokenData = errors.New("invalid token data")
+	ErrTokenRevoked     = errors.New("token revoked")
+	ErrAccountInactive  = errors.New("account not active")
+)
+
+func AuthMiddleware(logger *slog.Logger, revocations RevocationChecker) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			log.Println("Authorization header missing")
+			logger.DebugContext(c.Request.Context(), "authorization header missing")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing"})
 			c.Abort()
 			return
@@ -27,47 +142,276 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == authHeader {
-			log.Println("Bearer token missing")
+			logger.DebugContext(c.Request.Context(), "bearer token missing")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Bearer token missing"})
 			c.Abort()
 			return
 		}
 
-		claims := &jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || token.Method != jwt.SigningMethodHS512 {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		user, _, err := ValidateToken(c.Request.Context(), tokenString, revocations)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvalidToken):
+				logger.InfoContext(c.Request.Context(), "invalid token", "error", err)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Token"})
+			case errors.Is(err, ErrInvalidTokenData):
+				logger.InfoContext(c.Request.Context(), "invalid token data", "error", err)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token data"})
+			case errors.Is(err, ErrTokenRevoked):
+				logger.InfoContext(c.Request.Context(), "revoked token", "error", err)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Token revoked"})
+			case errors.Is(err, ErrAccountInactive):
+				logger.InfoContext(c.Request.Context(), "token of inactive account", "error", err)
+				c.JSON(http.StatusForbidden, gin.H{"error": "Account not active"})
+			default:
+				logger.ErrorContext(c.Request.Context(), "failed to check token revocation", "error", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
 			}
-			return JwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
-			log.Printf("Invalid Token: %v", err)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Token"})
 			c.Abort()
 			return
 		}
 
-		userId, okID := (*claims)["user_id"].(float64)
-		name, okName := (*claims)["user_Name"].(string)
-		lastName, okLastName := (*claims)["user_LastName"].(string)
-		email, okEmail := (*claims)["user_Email"].(string)
+		if requested, ok := tenancy.TenantID(c.Request.Context()); ok && requested != user.OrgId && c.GetBool("tenantExplicit") {
+			logger.InfoContext(c.Request.Context(), "token used for another organization", "token_tenant", user.OrgId, "requested_tenant", requested)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token not valid for this organization"})
+			c.Abort()
+			return
+		}
+		c.Request = c.Request.WithContext(tenancy.WithTenant(c.Request.Context(), user.OrgId))
 
-		if !okID || !okName || !okLastName || !okEmail {
-			log.Printf("Invalid token data: userId: %v, name: %v, lastName: %v, email: %v", userId, name, lastName, email)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token data"})
+		if !scopeAllowsMethod(user, c.Request.Method) {
+			logger.InfoContext(c.Request.Context(), "access token scope insufficient", "method", c.Request.Method)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token scope does not allow this request"})
 			c.Abort()
 			return
 		}
 
-		c.Set("user", &models.User{
-			Id:       int(userId),
-			Name:     name,
-			LastName: lastName,
-			Email:    email,
-		})
+		c.Set("user", user)
 
-		log.Printf("Token validated successfully: userId: %v, name: %v, lastName: %v, email: %v", userId, name, lastName, email)
+		c.Request = c.Request.WithContext(logging.WithUserID(c.Request.Context(), user.Id))
+		if sessions, ok := extension[SessionTracker](revocations); ok && user.TokenId != "" {
+			// Sessions live in the home organization
+			if err := sessions.TouchSession(tenancy.WithTenant(c.Request.Context(), user.TenantId), user.TokenId); err != nil {
+				logger.WarnContext(c.Request.Context(), "failed to record session activity", "error", err)
+			}
+		}
+		logger.DebugContext(c.Request.Context(), "token validated")
 		c.Next()
 	}
 }
+
+// ValidateToken checks a session token's signature, claims and revocation
+// and returns its user, with OrgId set to the organization the token is
+// active in, and the raw claims. Personal access tokens are accepted when
+// revocations was wrapped by WithAccessTokens, and the account must be active
+// when it was wrapped by WithStatus or WithFreshUsers. Everything that accepts session tokens,
+// including introspection, goes through here.
+func ValidateToken(ctx context.Context, tokenString string, revocations RevocationChecker) (*models.User, jwt.MapClaims, error) {
+	if strings.HasPrefix(tokenString, models.AccessTokenPrefix) {
+		return validateAccessToken(ctx, tokenString, revocations)
+	}
+
+	claims := &jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || token.Method != jwt.SigningMethodHS512 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return JwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	userId, okID := (*claims)["user_id"].(float64)
+	name, okName := (*claims)["user_Name"].(string)
+	lastName, okLastName := (*claims)["user_LastName"].(string)
+	email, okEmail := (*claims)["user_Email"].(string)
+
+	if !okID || !okName || !okLastName || !okEmail {
+		return nil, nil, fmt.Errorf("%w: user_id %t, name %t, last name %t, email %t", ErrInvalidTokenData, okID, okName, okLastName, okEmail)
+	}
+
+	// Tokens issued before multi-tenancy belong to the default organization,
+	// and tokens issued before memberships are active in their home one
+	tenantId := models.DefaultOrganizationId
+	if claim, ok := (*claims)["tenant_id"].(float64); ok {
+		tenantId = int(claim)
+	}
+	orgId := tenantId
+	if claim, ok := (*claims)["org_id"].(float64); ok {
+		orgId = int(claim)
+	}
+	orgRole, _ := (*claims)["org_role"].(string)
+	jti, _ := (*claims)["jti"].(string)
+	// Tokens issued before auth_time was added were issued at login
+	authTime, ok := (*claims)["auth_time"].(float64)
+	if !ok {
+		authTime, _ = (*claims)["iat"].(float64)
+	}
+
+	if revocations != nil {
+		issuedAt, _ := (*claims)["iat"].(float64)
+		revokedAt, err := revocations.TokensRevokedAt(tenancy.WithTenant(ctx, tenantId), int(userId))
+		if err != nil {
+			return nil, nil, err
+		}
+		// Session tokens carry iat to the microsecond; tokens issued
+		// before that count from the start of their second
+		if !revokedAt.IsZero() && issuedAt < float64(revokedAt.UnixMicro())/1e6 {
+			return nil, nil, fmt.Errorf("%w: user %d", ErrTokenRevoked, int(userId))
+		}
+
+		// Tokens issued before jti was added can only be revoked all at once
+		if jti != "" {
+			revoked, err := revocations.TokenRevoked(tenancy.WithTenant(ctx, tenantId), jti)
+			if err != nil {
+				return nil, nil, err
+			}
+			if revoked {
+				return nil, nil, fmt.Errorf("%w: jti %s", ErrTokenRevoked, jti)
+			}
+		}
+	}
+	// Tokens issued before token_version was added carry version 0
+	version, _ := (*claims)["token_version"].(float64)
+	var stored *models.User
+	if fresh, ok := extension[freshUserChecker](revocations); ok {
+		stored, err = fresh.users.FindByID(tenancy.WithTenant(ctx, tenantId), int(userId))
+		if err != nil {
+			return nil, nil, err
+		}
+		if stored == nil {
+			return nil, nil, fmt.Errorf("%w: user %d no longer exists", ErrTokenRevoked, int(userId))
+		}
+		if int(version) != stored.TokenVersion {
+			return nil, nil, fmt.Errorf("%w: user %d token version %d, now %d", ErrTokenRevoked, int(userId), int(version), stored.TokenVersion)
+		}
+		if !stored.Active() {
+			return nil, nil, fmt.Errorf("%w: user %d is %q", ErrAccountInactive, int(userId), stored.CurrentStatus(time.Now()))
+		}
+	} else if statuses, ok := extension[StatusChecker](revocations); ok {
+		status, err := statuses.AccountStatus(tenancy.WithTenant(ctx, tenantId), int(userId))
+		if err != nil {
+			return nil, nil, err
+		}
+		if status != models.StatusActive {
+			return nil, nil, fmt.Errorf("%w: user %d is %q", ErrAccountInactive, int(userId), status)
+		}
+	}
+
+	// Tokens issued before roles existed carry no role claim
+	role, _ := (*claims)["user_Role"].(string)
+	if role == "" {
+		role = models.RoleUser
+	}
+	if stored != nil {
+		name, lastName, email, role = stored.Name, stored.LastName, stored.Email, stored.Role
+	}
+	// Outside the home organization the system role comes from the
+	// membership, so an admin at home is not an admin everywhere
+	if orgId != tenantId {
+		role = models.RoleUser
+		if orgRole == models.OrgRoleOwner || orgRole == models.OrgRoleAdmin {
+			role = models.RoleAdmin
+		}
+	}
+
+	return &models.User{
+		Id:       int(userId),
+		Name:     name,
+		LastName: lastName,
+		Email:    email,
+		Role:     role,
+		TenantId: tenantId,
+		OrgId:    orgId,
+		OrgRole:  orgRole,
+		TokenId:  jti,
+		AuthTime: time.Unix(int64(authTime), 0),
+		// Tokens reissued from this user keep the version
+		TokenVersion: int(version),
+	}, *claims, nil
+}
+
+// validateAccessToken resolves a personal access token and describes it
+// with the claims a session token for the same user would carry.
+func validateAccessToken(ctx context.Context, tokenString string, revocations RevocationChecker) (*models.User, jwt.MapClaims, error) {
+	tokens, ok := extension[AccessTokenAuthenticator](revocations)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: access tokens are not accepted", ErrInvalidToken)
+	}
+	user, token, err := tokens.AuthenticateAccessToken(ctx, tokenString)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, fmt.Errorf("%w: inactive access token", ErrInvalidToken)
+	}
+
+	// Revocation through /oauth/revoke records the jti like for sessions
+	jti := fmt.Sprintf("pat-%d", token.Id)
+	revoked, err := revocations.TokenRevoked(tenancy.WithTenant(ctx, user.TenantId), jti)
+	if err != nil {
+		return nil, nil, err
+	}
+	if revoked {
+		return nil, nil, fmt.Errorf("%w: jti %s", ErrTokenRevoked, jti)
+	}
+
+	// Numbers are float64, as if decoded from a JWT
+	claims := jwt.MapClaims{
+		"sub":           strconv.Itoa(user.Id),
+		"jti":           jti,
+		"iat":           float64(token.CreatedAt.Unix()),
+		"exp":           float64(token.ExpiresAt.Unix()),
+		"scope":         strings.Join(token.Scopes, " "),
+		"user_id":       float64(user.Id),
+		"user_Name":     user.Name,
+		"user_LastName": user.LastName,
+		"user_Email":    user.Email,
+		"user_Role":     user.Role,
+		"tenant_id":     float64(user.TenantId),
+		"org_id":        float64(user.OrgId),
+		"org_role":      user.OrgRole,
+	}
+	return user, claims, nil
+}
+
+// scopeAllowsMethod reports whether the user's access token scopes cover
+// the request method. Session tokens carry no scopes and may do anything.
+func scopeAllowsMethod(user *models.User, method string) bool {
+	if user.Scopes == nil {
+		return true
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return hasScope(user, models.TokenScopeRead) || hasScope(user, models.TokenScopeWrite)
+	default:
+		return hasScope(user, models.TokenScopeWrite)
+	}
+}
+
+// hasScope is true for session users and for access tokens granted scope.
+func hasScope(user *models.User, scope string) bool {
+	if user.Scopes == nil {
+		return true
+	}
+	for _, s := range user.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveToken adapts ValidateToken for token introspection: tokens
+// AuthMiddleware would reject yield a nil user and no error, so only
+// infrastructure failures are reported as errors.
+func ActiveToken(revocations RevocationChecker) func(ctx context.Context, token string) (*models.User, jwt.MapClaims, error) {
+	return func(ctx context.Context, token string) (*models.User, jwt.MapClaims, error) {
+		user, claims, err := ValidateToken(ctx, token, revocations)
+		if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrInvalidTokenData) || errors.Is(err, ErrTokenRevoked) || errors.Is(err, ErrAccountInactive) {
+			return nil, nil, nil
+		}
+		return user, claims, err
+	}
+}
@@ -0,0 +1,147 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nothingRevoked struct{}
+
+func (nothingRevoked) TokensRevokedAt(ctx context.Context, id int) (time.Time, error) {
+	return time.Time{}, nil
+}
+func (nothingRevoked) TokenRevoked(ctx context.Context, jti string) (bool, error) { return false, nil }
+
+type fixedStatus string
+
+func (s fixedStatus) AccountStatus(ctx context.Context, id int) (string, error) {
+	return string(s), nil
+}
+
+func TestAuthMiddlewareRejectsInactiveAccounts(t *testing.T) {
+	JwtSecret = []byte("test-secret")
+	gin.SetMode(gin.TestMode)
+	token := tenantToken(t, 1)
+
+	for status, code := range map[string]int{
+		models.StatusActive:      http.StatusOK,
+		models.StatusSuspended:   http.StatusForbidden,
+		models.StatusDeactivated: http.StatusForbidden,
+		"":                       http.StatusForbidden,
+	} {
+		router := gin.New()
+		router.GET("/me", AuthMiddleware(logging.Discard(), WithStatus(nothingRevoked{}, fixedStatus(status))), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, code, resp.Code, "status %q", status)
+	}
+
+	user, _, err := ActiveToken(WithStatus(nothingRevoked{}, fixedStatus(models.StatusLocked)))(context.Background(), token)
+	assert.NoError(t, err)
+	assert.Nil(t, user, "introspection reports the token inactive")
+}
+
+type storedUsers map[int]*models.User
+
+func (u storedUsers) FindByID(ctx context.Context, id int) (*models.User, error) {
+	return u[id], nil
+}
+
+func TestAuthMiddlewareFreshUsers(t *testing.T) {
+	JwtSecret = []byte("test-secret")
+	gin.SetMode(gin.TestMode)
+	token := tenantToken(t, 1)
+
+	serve := func(revocations RevocationChecker) (*httptest.ResponseRecorder, *models.User) {
+		var seen *models.User
+		router := gin.New()
+		router.GET("/me", AuthMiddleware(logging.Discard(), revocations), func(c *gin.Context) {
+			seen = c.MustGet("user").(*models.User)
+			c.Status(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp, seen
+	}
+
+	stored := &models.User{Id: 1, Name: "Johnny", LastName: "Doe", Email: "johnny@example.com", Role: models.RoleAdmin, TenantId: 1, Status: models.StatusActive}
+	users := storedUsers{1: stored}
+
+	resp, user := serve(nothingRevoked{})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "john@example.com", user.Email, "claims-only by default")
+
+	resp, user = serve(WithFreshUsers(nothingRevoked{}, users))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "Johnny", user.Name)
+	assert.Equal(t, "johnny@example.com", user.Email)
+	assert.Equal(t, models.RoleAdmin, user.Role)
+
+	stored.TokenVersion = 1
+	resp, _ = serve(WithFreshUsers(nothingRevoked{}, users))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code, "tokens of an older version are revoked")
+	introspected, _, err := ActiveToken(WithFreshUsers(nothingRevoked{}, users))(context.Background(), token)
+	assert.NoError(t, err)
+	assert.Nil(t, introspected, "introspection agrees")
+	resp, _ = serve(nothingRevoked{})
+	assert.Equal(t, http.StatusOK, resp.Code, "claims-only routes do not look")
+
+	stored.TokenVersion = 0
+	stored.Status = models.StatusSuspended
+	resp, _ = serve(WithFreshUsers(nothingRevoked{}, users))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp, _ = serve(WithFreshUsers(nothingRevoked{}, storedUsers{}))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code, "deleted users are signed out")
+}
+
+type revokedAt time.Time
+
+func (r revokedAt) TokensRevokedAt(ctx context.Context, id int) (time.Time, error) {
+	return time.Time(r), nil
+}
+func (revokedAt) TokenRevoked(ctx context.Context, jti string) (bool, error) { return false, nil }
+
+func TestRevocationWithinTheSecond(t *testing.T) {
+	JwtSecret = []byte("test-secret")
+	second := time.Now().Truncate(time.Second)
+	token := func(issuedAt float64) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+			"iat":           issuedAt,
+			"exp":           time.Now().Add(time.Hour).Unix(),
+			"user_id":       1,
+			"user_Name":     "John",
+			"user_LastName": "Doe",
+			"user_Email":    "john@example.com",
+			"tenant_id":     1,
+		}).SignedString(JwtSecret)
+		require.NoError(t, err)
+		return token
+	}
+	revocations := revokedAt(second.Add(500 * time.Millisecond))
+	at := float64(second.Unix())
+
+	_, _, err := ValidateToken(context.Background(), token(at+0.2), revocations)
+	assert.ErrorIs(t, err, ErrTokenRevoked, "issued earlier in the same second")
+	_, _, err = ValidateToken(context.Background(), token(at+0.8), revocations)
+	assert.NoError(t, err, "issued after the revocation")
+	_, _, err = ValidateToken(context.Background(), token(at), revocations)
+	assert.ErrorIs(t, err, ErrTokenRevoked, "whole-second iat counts from the start of its second")
+}
@@ -0,0 +1,39 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"server-go/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRecentAuth must run after AuthMiddleware and rejects sessions whose
+// user last authenticated more than window ago, so sensitive changes need a
+// fresh login. Access tokens never count as recent.
+func RequireRecentAuth(window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, exists := c.Get("user")
+		user, ok := value.(*models.User)
+		if !exists || !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		if user.Scopes == nil && !user.AuthTime.IsZero() && time.Since(user.AuthTime) <= window {
+			c.Next()
+			return
+		}
+
+		maxAge := int(window.Seconds())
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_user_authentication", error_description="A more recent authentication is required", max_age=%d`, maxAge))
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":  "Recent authentication required",
+			"maxAge": maxAge,
+		})
+		c.Abort()
+	}
+}
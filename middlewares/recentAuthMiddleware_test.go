@@ -0,0 +1,36 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"server-go/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireRecentAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	serve := func(user *models.User) *httptest.ResponseRecorder {
+		r := gin.New()
+		r.POST("/me/password", func(c *gin.Context) { c.Set("user", user) }, RequireRecentAuth(10*time.Minute), func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/me/password", nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusNoContent, serve(&models.User{Id: 1, AuthTime: time.Now().Add(-time.Minute)}).Code)
+
+	stale := serve(&models.User{Id: 1, AuthTime: time.Now().Add(-time.Hour)})
+	assert.Equal(t, http.StatusUnauthorized, stale.Code)
+	assert.Contains(t, stale.Header().Get("WWW-Authenticate"), `error="insufficient_user_authentication"`)
+	assert.Contains(t, stale.Header().Get("WWW-Authenticate"), "max_age=600")
+
+	token := serve(&models.User{Id: 1, AuthTime: time.Now(), Scopes: []string{models.TokenScopeAdmin}})
+	assert.Equal(t, http.StatusUnauthorized, token.Code, "access tokens are never a recent login")
+}
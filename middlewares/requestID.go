@@ -0,0 +1,38 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"server-go/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID reuses the caller's X-Request-ID or generates one, echoes it
+// back and stores it, with the client's IP and user agent, in the request
+// context for the logger and the audit trail.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		c.Set("requestID", id)
+		c.Header(RequestIDHeader, id)
+		ctx := logging.WithRequestID(c.Request.Context(), id)
+		ctx = logging.WithClient(ctx, logging.ClientInfo{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
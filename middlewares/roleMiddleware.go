@@ -0,0 +1,84 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+
+	"server-go/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole must run after AuthMiddleware and rejects users whose role is
+// not one of roles, and access tokens without the admin scope.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, exists := c.Get("user")
+		user, ok := value.(*models.User)
+		if !exists || !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		if !hasScope(user, models.TokenScopeAdmin) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token scope does not allow this request"})
+			c.Abort()
+			return
+		}
+
+		for _, role := range roles {
+			if user.Role == role {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		c.Abort()
+	}
+}
+
+// RequireOrgRole must run after AuthMiddleware and rejects users whose role
+// in the active organization is not one of roles, and access tokens without
+// the admin scope.
+func RequireOrgRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, exists := c.Get("user")
+		user, ok := value.(*models.User)
+		if !exists || !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		if !hasScope(user, models.TokenScopeAdmin) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token scope does not allow this request"})
+			c.Abort()
+			return
+		}
+
+		for _, role := range roles {
+			if user.OrgRole == role {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		c.Abort()
+	}
+}
+
+// RequireSelfOrRole must run after AuthMiddleware and lets users through
+// when the :param route parameter is their own id, and otherwise behaves
+// like RequireRole.
+func RequireSelfOrRole(param string, roles ...string) gin.HandlerFunc {
+	others := RequireRole(roles...)
+	return func(c *gin.Context) {
+		value, _ := c.Get("user")
+		if user, ok := value.(*models.User); ok && c.Param(param) == strconv.Itoa(user.Id) {
+			c.Next()
+			return
+		}
+		others(c)
+	}
+}
@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"server-go/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireSelfOrRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	serve := func(user *models.User, path string) int {
+		r := gin.New()
+		r.PUT("/user/:id", func(c *gin.Context) { c.Set("user", user) }, RequireSelfOrRole("id", models.RoleAdmin), func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, path, nil))
+		return w.Code
+	}
+
+	jane := &models.User{Id: 1, Role: models.RoleUser}
+	assert.Equal(t, http.StatusNoContent, serve(jane, "/user/1"))
+	assert.Equal(t, http.StatusForbidden, serve(jane, "/user/2"))
+
+	admin := &models.User{Id: 3, Role: models.RoleAdmin}
+	assert.Equal(t, http.StatusNoContent, serve(admin, "/user/2"))
+	adminToken := &models.User{Id: 3, Role: models.RoleAdmin, Scopes: []string{models.TokenScopeWrite}}
+	assert.Equal(t, http.StatusForbidden, serve(adminToken, "/user/2"), "other users need the admin scope")
+}
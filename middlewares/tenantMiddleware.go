@@ -0,0 +1,75 @@
+package middlewares
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"server-go/models"
+	"server-go/tenancy"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OrganizationLookup interface {
+	FindBySlug(ctx context.Context, slug string) (*models.Organization, error)
+}
+
+// TenantMiddleware resolves the organization from the tenant header, then
+// the subdomain, then the configured default. When none applies the
+// request continues without a tenant and AuthMiddleware may take it from
+// the token's tenant_id claim.
+func TenantMiddleware(orgs OrganizationLookup, opts tenancy.Options, logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		slug := ""
+		if opts.Header != "" {
+			slug = c.GetHeader(opts.Header)
+		}
+		if slug == "" {
+			slug = tenancy.SlugFromHost(c.Request.Host, opts.BaseDomain)
+		}
+		explicit := slug != ""
+		if !explicit {
+			slug = opts.DefaultSlug
+		}
+		if slug == "" {
+			c.Next()
+			return
+		}
+
+		org, err := orgs.FindBySlug(c.Request.Context(), slug)
+		if err != nil {
+			logger.ErrorContext(c.Request.Context(), "failed to resolve organization", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred"})
+			c.Abort()
+			return
+		}
+		if org == nil {
+			if !explicit {
+				// A missing default organization is a deployment problem, not the caller's.
+				logger.ErrorContext(c.Request.Context(), "default organization not found", "slug", slug)
+			}
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			c.Abort()
+			return
+		}
+
+		c.Set("tenant", org)
+		c.Set("tenantExplicit", explicit)
+		c.Request = c.Request.WithContext(tenancy.WithTenant(c.Request.Context(), org.Id))
+		c.Next()
+	}
+}
+
+// RequireTenant rejects requests for which no organization was resolved.
+// Routes behind AuthMiddleware always have one.
+func RequireTenant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := tenancy.TenantID(c.Request.Context()); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Organization required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,110 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/tenancy"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeOrgs map[string]int
+
+func (f fakeOrgs) FindBySlug(ctx context.Context, slug string) (*models.Organization, error) {
+	id, ok := f[slug]
+	if !ok {
+		return nil, nil
+	}
+	return &models.Organization{Id: id, Slug: slug}, nil
+}
+
+func tenantToken(t *testing.T, tenantId int) string {
+	claims := jwt.MapClaims{
+		"iat":           time.Now().Unix(),
+		"exp":           time.Now().Add(time.Hour).Unix(),
+		"user_id":       1,
+		"user_Name":     "John",
+		"user_LastName": "Doe",
+		"user_Email":    "john@example.com",
+		"tenant_id":     tenantId,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString(JwtSecret)
+	assert.NoError(t, err)
+	return token
+}
+
+func TestTenantResolution(t *testing.T) {
+	JwtSecret = []byte("test-secret")
+	gin.SetMode(gin.TestMode)
+
+	orgs := fakeOrgs{"default": 1, "acme": 2, "globex": 3}
+	router := gin.New()
+	router.Use(TenantMiddleware(orgs, tenancy.Options{BaseDomain: "example.com", Header: "X-Tenant", DefaultSlug: "default"}, logging.Discard()))
+	tenantOf := func(c *gin.Context) {
+		id, _ := tenancy.TenantID(c.Request.Context())
+		c.String(http.StatusOK, strconv.Itoa(id))
+	}
+	router.GET("/public", RequireTenant(), tenantOf)
+	router.GET("/private", AuthMiddleware(logging.Discard(), nil), tenantOf)
+
+	cases := []struct {
+		name   string
+		path   string
+		host   string
+		header string
+		token  int
+		code   int
+		tenant string
+	}{
+		{name: "default organization", path: "/public", code: 200, tenant: "1"},
+		{name: "header", path: "/public", header: "acme", code: 200, tenant: "2"},
+		{name: "subdomain", path: "/public", host: "globex.example.com", code: 200, tenant: "3"},
+		{name: "header wins over subdomain", path: "/public", host: "globex.example.com", header: "acme", code: 200, tenant: "2"},
+		{name: "unknown organization", path: "/public", header: "initech", code: 404},
+		{name: "claim overrides default", path: "/private", token: 2, code: 200, tenant: "2"},
+		{name: "claim matches header", path: "/private", header: "acme", token: 2, code: 200, tenant: "2"},
+		{name: "token from another tenant via header", path: "/private", header: "acme", token: 3, code: 401},
+		{name: "token from another tenant via subdomain", path: "/private", host: "acme.example.com", token: 1, code: 401},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.host != "" {
+				req.Host = tc.host
+			}
+			if tc.header != "" {
+				req.Header.Set("X-Tenant", tc.header)
+			}
+			if tc.token != 0 {
+				req.Header.Set("Authorization", "Bearer "+tenantToken(t, tc.token))
+			}
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.code, resp.Code, resp.Body.String())
+			if tc.tenant != "" {
+				assert.Equal(t, tc.tenant, resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequireTenantWithoutDefault(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(TenantMiddleware(fakeOrgs{}, tenancy.Options{Header: "X-Tenant"}, logging.Discard()))
+	router.GET("/public", RequireTenant(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/public", nil))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
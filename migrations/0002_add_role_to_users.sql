@@ -0,0 +1,2 @@
+-- Add role column to users
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user';
@@ -0,0 +1,35 @@
+-- Create audit_events table (append-only)
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor_id INTEGER,
+    target_id INTEGER,
+    action VARCHAR(64) NOT NULL,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    changes JSONB,
+    details JSONB,
+    prev_hash VARCHAR(64),
+    hash VARCHAR(64)
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor_id, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events(target_id, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events(action, occurred_at);
+
+-- Reject any attempt to rewrite history
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_audit_events_append_only ON audit_events;
+CREATE TRIGGER trg_audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+DROP TRIGGER IF EXISTS trg_audit_events_no_truncate ON audit_events;
+CREATE TRIGGER trg_audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
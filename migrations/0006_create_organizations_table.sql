@@ -0,0 +1,11 @@
+-- Create organizations table (tenants)
+CREATE TABLE IF NOT EXISTS organizations (
+    id SERIAL PRIMARY KEY,
+    slug VARCHAR(63) UNIQUE NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Existing single-tenant data moves into the default organization
+INSERT INTO organizations (id, slug, name) VALUES (1, 'default', 'Default') ON CONFLICT DO NOTHING;
+SELECT setval(pg_get_serial_sequence('organizations', 'id'), (SELECT MAX(id) FROM organizations));
@@ -0,0 +1,36 @@
+-- Scope users, their reset tokens and audit events to an organization
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations(id);
+ALTER TABLE users ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE password_resets ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations(id);
+ALTER TABLE password_resets ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations(id);
+ALTER TABLE audit_events ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- Names and emails are only unique within a tenant
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_name_key;
+DROP INDEX IF EXISTS idx_users_email;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_email ON users(tenant_id, email) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_audit_events_tenant ON audit_events(tenant_id, id);
+
+-- Row-level security: the application sets app.tenant_id per transaction.
+-- An unset tenant matches nothing.
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE users FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON users;
+CREATE POLICY tenant_isolation ON users
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER);
+
+ALTER TABLE password_resets ENABLE ROW LEVEL SECURITY;
+ALTER TABLE password_resets FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON password_resets;
+CREATE POLICY tenant_isolation ON password_resets
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER);
+
+ALTER TABLE audit_events ENABLE ROW LEVEL SECURITY;
+ALTER TABLE audit_events FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON audit_events;
+CREATE POLICY tenant_isolation ON audit_events
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER);
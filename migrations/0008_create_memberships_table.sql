@@ -0,0 +1,58 @@
+-- Organization memberships and invitations.
+-- Run as a superuser: the backfill reads users, which has forced RLS.
+CREATE TABLE IF NOT EXISTS memberships (
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    organization_id INTEGER NOT NULL REFERENCES organizations(id),
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, organization_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_memberships_organization ON memberships(organization_id);
+
+-- Everyone belongs to their home organization; system admins own it
+INSERT INTO memberships (user_id, organization_id, role)
+SELECT id, tenant_id, CASE WHEN role = 'admin' THEN 'owner' ELSE 'member' END
+FROM users
+WHERE deleted_at IS NULL
+ON CONFLICT DO NOTHING;
+
+CREATE OR REPLACE FUNCTION users_home_membership() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO memberships (user_id, organization_id, role)
+    VALUES (NEW.id, NEW.tenant_id, CASE WHEN NEW.role = 'admin' THEN 'owner' ELSE 'member' END)
+    ON CONFLICT DO NOTHING;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_users_home_membership ON users;
+CREATE TRIGGER trg_users_home_membership
+    AFTER INSERT ON users
+    FOR EACH ROW EXECUTE FUNCTION users_home_membership();
+
+CREATE TABLE IF NOT EXISTS organization_invitations (
+    id SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations(id),
+    email VARCHAR(100) NOT NULL,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+    invited_by INTEGER NOT NULL REFERENCES users(id),
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    accepted_by INTEGER REFERENCES users(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_organization_invitations_org ON organization_invitations(organization_id);
+
+-- Members who joined from another organization can be read, not written,
+-- from it
+DROP POLICY IF EXISTS member_visibility ON users;
+CREATE POLICY member_visibility ON users
+    FOR SELECT
+    USING (
+        id IN (
+            SELECT user_id FROM memberships
+            WHERE organization_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER
+        )
+    );
@@ -0,0 +1,21 @@
+-- External OAuth2/OIDC accounts linked to users
+CREATE TABLE IF NOT EXISTS identities (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations(id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_login_at TIMESTAMPTZ,
+    UNIQUE (tenant_id, provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_identities_user ON identities(user_id);
+
+ALTER TABLE identities ENABLE ROW LEVEL SECURITY;
+ALTER TABLE identities FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON identities;
+CREATE POLICY tenant_isolation ON identities
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER);
@@ -0,0 +1,45 @@
+-- Applications that use this service as their OpenID provider. Clients are
+-- shared by all organizations; consents and codes belong to a user's tenant.
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id SERIAL PRIMARY KEY,
+    client_id VARCHAR(64) UNIQUE NOT NULL,
+    secret_hash VARCHAR(64) NOT NULL DEFAULT '',
+    name VARCHAR(255) NOT NULL,
+    redirect_uris TEXT[] NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT ARRAY['openid', 'profile', 'email'],
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS oauth_consents (
+    tenant_id INTEGER NOT NULL REFERENCES organizations(id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL,
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, client_id)
+);
+
+ALTER TABLE oauth_consents ENABLE ROW LEVEL SECURITY;
+ALTER TABLE oauth_consents FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON oauth_consents;
+CREATE POLICY tenant_isolation ON oauth_consents
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER);
+
+-- The token endpoint is called by the client without a tenant, so codes
+-- are looked up globally and carry the tenant they were issued in.
+CREATE TABLE IF NOT EXISTS oauth_codes (
+    code_hash VARCHAR(64) PRIMARY KEY,
+    client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    tenant_id INTEGER NOT NULL REFERENCES organizations(id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    nonce TEXT NOT NULL DEFAULT '',
+    code_challenge VARCHAR(128) NOT NULL DEFAULT '',
+    auth_time TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_oauth_codes_expires ON oauth_codes(expires_at);
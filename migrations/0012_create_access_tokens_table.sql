@@ -0,0 +1,22 @@
+-- Users that can only authenticate with access tokens
+ALTER TABLE users ADD COLUMN IF NOT EXISTS service_account BOOLEAN NOT NULL DEFAULT false;
+
+-- Personal access tokens. Only a SHA-256 hash of the token is stored; the
+-- prefix column keeps its first characters so users can tell tokens apart.
+-- Bearer tokens carry no tenant, so lookups by hash are global and every
+-- other statement filters on tenant_id explicitly.
+CREATE TABLE IF NOT EXISTS access_tokens (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations(id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_tokens_user ON access_tokens(tenant_id, user_id);
@@ -0,0 +1,25 @@
+-- One row per issued session token, so users can see and end their sessions.
+-- Revoking a session also records its jti in revoked_tokens, which is what
+-- AuthMiddleware checks.
+CREATE TABLE IF NOT EXISTS sessions (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations(id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    jti VARCHAR(64) UNIQUE NOT NULL,
+    device_name VARCHAR(255) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(tenant_id, user_id, created_at);
+
+ALTER TABLE sessions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sessions FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON sessions;
+CREATE POLICY tenant_isolation ON sessions
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER);
@@ -0,0 +1,21 @@
+-- Sign-in links sent by email. Every request is recorded, including those
+-- for unknown addresses (user_id NULL), so the per-address rate limit
+-- behaves the same whether or not an account exists.
+CREATE TABLE IF NOT EXISTS magic_links (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations(id),
+    user_id INTEGER REFERENCES users(id),
+    email VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_magic_links_email ON magic_links(tenant_id, lower(email), created_at);
+
+ALTER TABLE magic_links ENABLE ROW LEVEL SECURITY;
+ALTER TABLE magic_links FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON magic_links;
+CREATE POLICY tenant_isolation ON magic_links
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER);
@@ -0,0 +1,33 @@
+-- Account status lifecycle: pending, active, suspended, locked, deactivated
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_reason TEXT NOT NULL DEFAULT '';
+-- A suspension or lock ends on its own at status_until
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_until TIMESTAMPTZ;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_status_check;
+ALTER TABLE users ADD CONSTRAINT users_status_check
+    CHECK (status IN ('pending', 'active', 'suspended', 'locked', 'deactivated'));
+
+-- Accounts disabled before statuses existed
+UPDATE users SET status = 'deactivated' WHERE disabled_at IS NOT NULL AND status = 'active';
+
+-- Every status change, for support and compliance
+CREATE TABLE IF NOT EXISTS user_status_history (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations(id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    until TIMESTAMPTZ,
+    actor_id INTEGER REFERENCES users(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_status_history_user ON user_status_history(tenant_id, user_id, id);
+
+ALTER TABLE user_status_history ENABLE ROW LEVEL SECURITY;
+ALTER TABLE user_status_history FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON user_status_history;
+CREATE POLICY tenant_isolation ON user_status_history
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER);
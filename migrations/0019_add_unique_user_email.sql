@@ -0,0 +1,7 @@
+-- Emails are unique per tenant regardless of case, so JANE@example.com
+-- cannot register next to jane@example.com. Violations surface as a
+-- conflict error rather than a second account.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_email_lower ON users(tenant_id, lower(email)) WHERE deleted_at IS NULL;
+
+-- The case-sensitive index it replaces only let case variants through
+DROP INDEX IF EXISTS idx_users_tenant_email;
@@ -0,0 +1,49 @@
+-- Outgoing webhooks registered by organizations. The dispatcher reads
+-- every tenant, so there is no row-level security here; tenant-scoped
+-- statements filter on tenant_id explicitly.
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations(id),
+    url TEXT NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    events TEXT[] NOT NULL,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    disabled_at TIMESTAMPTZ,
+    disabled_reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_tenant ON webhook_endpoints(tenant_id) WHERE deleted_at IS NULL;
+
+-- One row per event and endpoint; the unique key absorbs redelivered events
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations(id),
+    endpoint_id INTEGER NOT NULL REFERENCES webhook_endpoints(id),
+    event_id BIGINT NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ DEFAULT now(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    delivered_at TIMESTAMPTZ,
+    UNIQUE (endpoint_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint ON webhook_deliveries(tenant_id, endpoint_id, id);
+
+-- The delivery log: every request made, with its outcome
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations(id),
+    delivery_id INTEGER NOT NULL REFERENCES webhook_deliveries(id),
+    status_code INTEGER,
+    error TEXT NOT NULL DEFAULT '',
+    duration_ms INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery ON webhook_attempts(tenant_id, delivery_id, id);
@@ -0,0 +1,30 @@
+-- Background jobs. Workers claim rows with FOR UPDATE SKIP LOCKED; a
+-- running job is leased until locked_until, after which another worker
+-- may pick it up again. Jobs are not tenant-scoped: handlers that need a
+-- tenant carry it in their arguments.
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(64) NOT NULL,
+    args JSONB NOT NULL,
+    unique_key VARCHAR(255),
+    state VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until TIMESTAMPTZ,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs(run_at) WHERE state = 'pending';
+CREATE INDEX IF NOT EXISTS idx_jobs_leased ON jobs(locked_until) WHERE state = 'running';
+
+-- At most one queued or running job per key
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_unique_key ON jobs(unique_key) WHERE state IN ('pending', 'running');
+
+-- The last run of each recurring job that some instance has enqueued, so
+-- that only one of several servers enqueues a given run
+CREATE TABLE IF NOT EXISTS job_schedules (
+    name VARCHAR(255) PRIMARY KEY,
+    last_run_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,5 @@
+-- Soft-deleted users are scrubbed of personal data once the retention
+-- period has passed; the row stays behind for the audit trail
+ALTER TABLE users ADD COLUMN IF NOT EXISTS purged_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_users_purge ON users(tenant_id, deleted_at) WHERE deleted_at IS NOT NULL AND purged_at IS NULL;
@@ -0,0 +1,4 @@
+-- Bumped whenever the user's existing tokens must stop working: password
+-- changes, status changes and "sign out everywhere". Tokens carry the value
+-- they were issued with as the token_version claim.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// AccessTokenPrefix marks personal access tokens so they are recognizable
+// in logs, secret scanners and the Authorization header.
+const AccessTokenPrefix = "sgp_"
+
+// Access token scopes. Read allows safe methods (GET, HEAD, OPTIONS),
+// write allows everything else and admin is needed on admin routes.
+const (
+	TokenScopeRead  = "read"
+	TokenScopeWrite = "write"
+	TokenScopeAdmin = "admin"
+)
+
+const (
+	DefaultAccessTokenDays = 30
+	MaxAccessTokenDays     = 365
+)
+
+type AccessToken struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"userId"`
+	TenantId   int        `json:"tenantId"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type CreateAccessToken struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+// NewAccessToken is returned once on creation; Token is never shown again.
+type NewAccessToken struct {
+	AccessToken
+	Token string `json:"token"`
+}
+
+type CreateServiceAccount struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func ValidTokenScope(scope string) bool {
+	return scope == TokenScopeRead || scope == TokenScopeWrite || scope == TokenScopeAdmin
+}
+
+// GrantsScope reports whether a token with scopes may do what scope allows;
+// write covers read, as it does for requests.
+func GrantsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || (s == TokenScopeWrite && scope == TokenScopeRead) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+package models
+
+type ChangePassword struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type ChangeEmail struct {
+	Email string `json:"email"`
+}
+
+// EmailChangeConfirm carries the token from an emailed confirmation link,
+// as a form field or JSON.
+type EmailChangeConfirm struct {
+	Token string `json:"token" form:"token"`
+}
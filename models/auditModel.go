@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+const (
+	AuditLogin       = "user.login"
+	AuditLoginFailed = "user.login_failed"
+	AuditRegister    = "user.registered"
+	AuditUpdate      = "user.updated"
+	AuditLogout      = "user.logout"
+	AuditRoleChange  = "user.role_changed"
+	AuditCreate      = "user.created"
+	AuditDisable     = "user.disabled"
+	AuditEnable      = "user.enabled"
+	AuditDelete      = "user.deleted"
+	AuditImport      = "user.imported"
+	AuditExport      = "users.exported"
+	AuditPurge       = "users.purged"
+
+	AuditPasswordReset     = "user.password_reset"
+	AuditPasswordResetLink = "user.password_reset_requested"
+	AuditSessionsRevoked   = "user.sessions_revoked"
+	AuditIdentityLinked    = "user.identity_linked"
+	AuditConsentGranted    = "oauth.consent_granted"
+	AuditConsentDenied     = "oauth.consent_denied"
+	AuditTokenRevoked      = "user.token_revoked"
+	AuditStatusChange      = "user.status_changed"
+
+	AuditAccessTokenCreated    = "user.access_token_created"
+	AuditAccessTokenRevoked    = "user.access_token_revoked"
+	AuditServiceAccountCreated = "user.service_account_created"
+	AuditSessionRevoked        = "user.session_revoked"
+	AuditPasskeyAdded          = "user.passkey_added"
+	AuditPasskeyRemoved        = "user.passkey_removed"
+	AuditMagicLinkSent         = "user.magic_link_sent"
+	AuditPasswordChanged       = "user.password_changed"
+	AuditEmailChangeRequested  = "user.email_change_requested"
+	AuditEmailChanged          = "user.email_changed"
+
+	AuditMemberInvited      = "org.member_invited"
+	AuditInviteAccepted     = "org.invitation_accepted"
+	AuditMemberRoleChange   = "org.member_role_changed"
+	AuditOrganizationSwitch = "org.switched"
+	AuditWebhookCreated     = "org.webhook_created"
+	AuditWebhookDeleted     = "org.webhook_deleted"
+	AuditWebhookEnabled     = "org.webhook_enabled"
+	AuditWebhookDisabled    = "org.webhook_disabled"
+)
+
+// FieldChange is one entry of an audit before/after diff.
+type FieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+type AuditEvent struct {
+	Id         int64                  `json:"id"`
+	OccurredAt time.Time              `json:"occurredAt"`
+	ActorId    *int                   `json:"actorId"`
+	TargetId   *int                   `json:"targetId"`
+	Action     string                 `json:"action"`
+	IP         string                 `json:"ip"`
+	UserAgent  string                 `json:"userAgent"`
+	Changes    map[string]FieldChange `json:"changes,omitempty"`
+	Details    map[string]string      `json:"details,omitempty"`
+	PrevHash   *string                `json:"prevHash,omitempty"`
+	Hash       *string                `json:"hash,omitempty"`
+}
+
+type AuditFilter struct {
+	ActorId  *int
+	TargetId *int
+	Action   string
+	Since    *time.Time
+	Until    *time.Time
+	Limit    int
+	Offset   int
+}
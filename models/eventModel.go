@@ -0,0 +1,56 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Domain events published through the outbox.
+const (
+	EventUserRegistered = "user.registered"
+	EventUserUpdated    = "user.updated"
+	EventUserDeleted    = "user.deleted"
+)
+
+// Event is a domain event as written to the outbox and handed to sinks.
+// Events of one user are delivered in the order they were written; a sink
+// may see the same Id more than once.
+type Event struct {
+	Id        int64           `json:"id"`
+	TenantId  int             `json:"tenantId"`
+	UserId    int             `json:"userId"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Attempts  int             `json:"-"`
+}
+
+// UserSnapshot is the payload of user events. It leaves out credentials.
+type UserSnapshot struct {
+	Id       int    `json:"id"`
+	TenantId int    `json:"tenantId"`
+	Name     string `json:"name"`
+	LastName string `json:"lastName"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	Status   string `json:"status"`
+}
+
+func NewUserSnapshot(user *User) UserSnapshot {
+	return UserSnapshot{
+		Id:       user.Id,
+		TenantId: user.TenantId,
+		Name:     user.Name,
+		LastName: user.LastName,
+		Email:    user.Email,
+		Role:     user.Role,
+		Status:   user.CurrentStatus(time.Now()),
+	}
+}
+
+// DeadLetter is an event the relay gave up on.
+type DeadLetter struct {
+	Event
+	LastError string    `json:"lastError"`
+	FailedAt  time.Time `json:"failedAt"`
+}
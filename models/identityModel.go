@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Identity links an account at an external OAuth2/OIDC provider to a user.
+type Identity struct {
+	Id          int        `json:"id"`
+	UserId      int        `json:"userId"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt,omitempty"`
+}
@@ -0,0 +1,49 @@
+package models
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// ImportUser is one row of a bulk import file.
+type ImportUser struct {
+	Name         string `json:"name"`
+	LastName     string `json:"lastName"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"passwordHash"`
+	Role         string `json:"role"`
+}
+
+type ImportOptions struct {
+	Format string
+	// Commit writes the users; otherwise the file is only validated.
+	Commit bool
+	// Invite emails a set-password link to rows without a passwordHash.
+	Invite    bool
+	BatchSize int
+}
+
+type ImportRowError struct {
+	Row    int      `json:"row"`
+	Email  string   `json:"email,omitempty"`
+	Errors []string `json:"errors"`
+}
+
+type ImportReport struct {
+	Committed bool             `json:"committed"`
+	Rows      int              `json:"rows"`
+	Valid     int              `json:"valid"`
+	Created   int              `json:"created"`
+	Invited   int              `json:"invited"`
+	Failed    int              `json:"failed"`
+	Errors    []ImportRowError `json:"errors"`
+}
+
+type ExportOptions struct {
+	Format        string
+	IncludeHashes bool
+}
+
+func (r *ImportReport) Fail(row int, email string, problems ...string) {
+	r.Errors = append(r.Errors, ImportRowError{Row: row, Email: email, Errors: problems})
+}
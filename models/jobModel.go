@@ -0,0 +1,31 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job states. Finished jobs are removed from the queue, so only failures
+// stay behind for inspection.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobFailed  = "failed"
+)
+
+// Job is a unit of background work. Kind selects the handler and Args is
+// its JSON-encoded argument.
+type Job struct {
+	Id   int64           `json:"id"`
+	Kind string          `json:"kind"`
+	Args json.RawMessage `json:"args"`
+	// UniqueKey, when set, keeps a second job with the same key out of the
+	// queue while the first is pending or running.
+	UniqueKey   string    `json:"uniqueKey,omitempty"`
+	State       string    `json:"state"`
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"maxAttempts"`
+	RunAt       time.Time `json:"runAt"`
+	LastError   string    `json:"lastError,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
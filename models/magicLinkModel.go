@@ -0,0 +1,12 @@
+package models
+
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// MagicLinkConfirm carries the token from an emailed sign-in link, as a
+// form field or JSON.
+type MagicLinkConfirm struct {
+	Token      string `json:"token" form:"token"`
+	DeviceName string `json:"deviceName" form:"deviceName"`
+}
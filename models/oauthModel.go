@@ -0,0 +1,92 @@
+package models
+
+import "time"
+
+// Scopes this service understands as an OpenID provider.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// OAuthClient is an application that delegates login to this service.
+// Public clients have no secret and must use PKCE.
+type OAuthClient struct {
+	Id           int       `json:"id"`
+	ClientId     string    `json:"clientId"`
+	SecretHash   string    `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirectUris"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+func (c *OAuthClient) Public() bool {
+	return c.SecretHash == ""
+}
+
+// Consent records the scopes a user allowed a client to receive.
+type Consent struct {
+	UserId    int       `json:"userId"`
+	ClientId  string    `json:"clientId"`
+	Scopes    []string  `json:"scopes"`
+	GrantedAt time.Time `json:"grantedAt"`
+}
+
+// AuthorizationCode is a pending /authorize result, redeemed once at /token.
+type AuthorizationCode struct {
+	CodeHash      string
+	ClientId      string
+	UserId        int
+	TenantId      int
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+}
+
+// AuthorizationRequest holds the /authorize parameters (RFC 6749 4.1.1,
+// OpenID Connect Core 3.1.2.1, RFC 7636).
+type AuthorizationRequest struct {
+	ClientId            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	ResponseType        string `form:"response_type"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	Nonce               string `form:"nonce"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+}
+
+// TokenRequest holds the /token parameters for the authorization_code grant.
+type TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	ClientId     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	CodeVerifier string `form:"code_verifier"`
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// OAuthError is an error response defined by RFC 6749 section 5.2.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// DefaultOrganizationId is the organization created by the tenancy
+// migration; data from before multi-tenancy belongs to it.
+const DefaultOrganizationId = 1
+
+// Organization is a tenant. Users, their audit trail and reset tokens all
+// belong to exactly one organization.
+type Organization struct {
+	Id        int       `json:"id"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// Membership gives a user a role in an organization. Every user is a member
+// of their home organization (users.tenant_id) and may join others.
+type Membership struct {
+	UserId         int       `json:"userId"`
+	OrganizationId int       `json:"organizationId"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"createdAt"`
+
+	// Filled in by listings
+	Organization *Organization `json:"organization,omitempty"`
+	User         *User         `json:"user,omitempty"`
+}
+
+type Invitation struct {
+	Id             int        `json:"id"`
+	OrganizationId int        `json:"organizationId"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	InvitedBy      int        `json:"invitedBy"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	AcceptedAt     *time.Time `json:"acceptedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+type InviteRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type AcceptInvitation struct {
+	Token string `json:"token"`
+}
+
+type SwitchOrganization struct {
+	OrganizationId int `json:"organizationId"`
+}
+
+type MemberRole struct {
+	Role string `json:"role"`
+}
+
+func ValidOrgRole(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleAdmin || role == OrgRoleMember
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"server-go/webauthn"
+)
+
+// Passkey is a WebAuthn credential registered by a user.
+type Passkey struct {
+	Id     int    `json:"id"`
+	UserId int    `json:"userId"`
+	Name   string `json:"name"`
+	// CredentialId is the authenticator's credential ID, base64url encoded.
+	CredentialId string     `json:"credentialId"`
+	PublicKey    []byte     `json:"-"`
+	SignCount    uint32     `json:"-"`
+	AAGUID       []byte     `json:"-"`
+	Attestation  string     `json:"attestation"`
+	Transports   []string   `json:"transports"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastUsedAt   *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+type FinishPasskeyRegistration struct {
+	Name       string                        `json:"name"`
+	Credential webauthn.RegistrationResponse `json:"credential"`
+}
+
+// BeginPasskeyLogin may name the account to sign in to; without an email
+// the authenticator offers any passkey it holds for this site.
+type BeginPasskeyLogin struct {
+	Email string `json:"email"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Session is a signed-in device: one session token and where it is used.
+type Session struct {
+	Id         int       `json:"id"`
+	UserId     int       `json:"userId"`
+	DeviceName string    `json:"deviceName"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	// Current marks the session the request was made with.
+	Current bool   `json:"current"`
+	JTI     string `json:"-"`
+}
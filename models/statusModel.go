@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// Account statuses. Only active accounts may sign in or use their tokens.
+const (
+	// StatusPending accounts exist but have not been activated yet.
+	StatusPending = "pending"
+	StatusActive  = "active"
+	// StatusSuspended is set by an admin, e.g. for abuse, optionally until a
+	// given time.
+	StatusSuspended = "suspended"
+	// StatusLocked blocks an account for security reasons, optionally until
+	// a given time.
+	StatusLocked = "locked"
+	// StatusDeactivated accounts are switched off until reactivated; usersctl
+	// calls this disabling.
+	StatusDeactivated = "deactivated"
+)
+
+// statusTransitions lists the statuses each status may change to.
+var statusTransitions = map[string][]string{
+	StatusPending:     {StatusActive, StatusDeactivated},
+	StatusActive:      {StatusSuspended, StatusLocked, StatusDeactivated},
+	StatusSuspended:   {StatusActive, StatusLocked, StatusDeactivated},
+	StatusLocked:      {StatusActive, StatusSuspended, StatusDeactivated},
+	StatusDeactivated: {StatusActive},
+}
+
+func ValidStatus(status string) bool {
+	_, ok := statusTransitions[status]
+	return ok
+}
+
+// CanTransition reports whether an account may go from one status to
+// another.
+func CanTransition(from, to string) bool {
+	for _, next := range statusTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusUpdate is the body of the admin suspend and reactivate requests.
+type StatusUpdate struct {
+	Reason string `json:"reason"`
+	// Until ends a suspension or lock automatically.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// StatusChange is one entry of an account's status history.
+type StatusChange struct {
+	Id        int        `json:"id"`
+	UserId    int        `json:"userId"`
+	From      string     `json:"from"`
+	To        string     `json:"to"`
+	Reason    string     `json:"reason,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+	ActorId   *int       `json:"actorId,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
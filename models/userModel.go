@@ -1,15 +1,95 @@
 package models
 
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	Id       int     `json:"id"`
-	Name     string  `json:"name"`
-	LastName string  `json:"lastName"`
-	Email    string  `json:"email"`
+	Name     string  `json:"name" form:"name"`
+	LastName string  `json:"lastName" form:"lastName"`
+	Email    string  `json:"email" form:"email"`
 	Avatar   *string `json:"avatar"`
-	Password string  `json:"password"`
+	Password string  `json:"password,omitempty" form:"password"`
+	Role     string  `json:"role"`
+	TenantId int     `json:"tenantId"`
+
+	// Status is one of the Status constants; use CurrentStatus to account
+	// for suspensions that have run out.
+	Status       string     `json:"status,omitempty"`
+	StatusReason string     `json:"statusReason,omitempty"`
+	StatusUntil  *time.Time `json:"statusUntil,omitempty"`
+	// DisabledAt is when the account was deactivated.
+	DisabledAt *time.Time `json:"disabledAt,omitempty"`
+	// PasswordChangedAt drives the password policy's maximum age.
+	PasswordChangedAt time.Time `json:"-"`
+	// Service accounts have no password and authenticate with access tokens only.
+	ServiceAccount bool `json:"serviceAccount,omitempty"`
+	// TokenVersion goes up when the user's tokens must stop working; tokens
+	// carry the version they were issued with.
+	TokenVersion int `json:"-"`
+
+	// Active organization and the user's role in it, from the token.
+	OrgId   int    `json:"orgId,omitempty"`
+	OrgRole string `json:"orgRole,omitempty"`
+	// InviteToken, when sent to /register, joins the invited organization.
+	InviteToken string `json:"inviteToken,omitempty" form:"inviteToken"`
+	// Scopes limit what the request may do when it authenticated with a
+	// personal access token; nil means a full session.
+	Scopes []string `json:"-"`
+	// TokenExpiresAt is when that access token runs out.
+	TokenExpiresAt time.Time `json:"-"`
+	// TokenId is the jti of the session token the request was made with.
+	TokenId string `json:"-"`
+	// AuthTime is when the user last proved who they are, e.g. by entering
+	// their password; sessions carry it as the auth_time claim.
+	AuthTime time.Time `json:"-"`
+}
+
+// PasswordReset carries a reset token and the new password, as form fields
+// or JSON.
+type PasswordReset struct {
+	Token    string `json:"token" form:"token"`
+	Password string `json:"password" form:"password"`
 }
 
 type LoginUser struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// DeviceName labels the session; it defaults to one derived from the
+	// user agent.
+	DeviceName string `json:"deviceName"`
+}
+
+// CurrentStatus is the account's status at now: a suspension or lock with
+// an end time is over once it has passed.
+func (u *User) CurrentStatus(now time.Time) string {
+	switch {
+	case u.Status == "" && u.DisabledAt != nil:
+		return StatusDeactivated
+	case u.Status == "":
+		return StatusActive
+	case (u.Status == StatusSuspended || u.Status == StatusLocked) && u.StatusUntil != nil && !now.Before(*u.StatusUntil):
+		return StatusActive
+	}
+	return u.Status
+}
+
+// Active reports whether the account may sign in and use its tokens.
+func (u *User) Active() bool {
+	return u.CurrentStatus(time.Now()) == StatusActive
+}
+
+// LogValue keeps the password hash and email out of structured logs.
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("id", u.Id),
+		slog.String("name", u.Name),
+	)
 }
@@ -0,0 +1,33 @@
+package models
+
+import "strings"
+
+// FieldError is one problem with one input field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError carries every field problem found in a request.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var problems []string
+	for _, f := range e.Errors {
+		problems = append(problems, f.Field+" "+f.Message)
+	}
+	return "invalid input: " + strings.Join(problems, "; ")
+}
+
+// ConflictError is returned by repositories when a write would break a
+// unique constraint, such as a second account with the same email.
+type ConflictError struct {
+	Constraint string
+}
+
+func (e *ConflictError) Error() string {
+	return "conflicts with an existing record (" + e.Constraint + ")"
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebhookEndpoint receives the events of its organization that match
+// Events ("*" for all). The secret signs every delivery and is only shown
+// when the endpoint is created.
+type WebhookEndpoint struct {
+	Id                  int        `json:"id"`
+	TenantId            int        `json:"tenantId"`
+	URL                 string     `json:"url"`
+	Secret              string     `json:"secret,omitempty"`
+	Events              []string   `json:"events"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	DisabledAt          *time.Time `json:"disabledAt,omitempty"`
+	DisabledReason      string     `json:"disabledReason,omitempty"`
+	CreatedAt           time.Time  `json:"createdAt"`
+}
+
+type WebhookEndpointInput struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// Matches reports whether the endpoint subscribed to eventType.
+func (e *WebhookEndpoint) Matches(eventType string) bool {
+	for _, t := range e.Events {
+		if t == "*" || t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event on its way to one endpoint.
+type WebhookDelivery struct {
+	Id            int             `json:"id"`
+	TenantId      int             `json:"tenantId"`
+	EndpointId    int             `json:"endpointId"`
+	EventId       int64           `json:"eventId"`
+	EventType     string          `json:"eventType"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt *time.Time      `json:"nextAttemptAt,omitempty"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	DeliveredAt   *time.Time      `json:"deliveredAt,omitempty"`
+
+	// Filled in when claimed for sending
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+
+	// Filled in when a single delivery is shown
+	Log []WebhookAttempt `json:"log,omitempty"`
+}
+
+// WebhookAttempt is one entry of the delivery log.
+type WebhookAttempt struct {
+	Id         int       `json:"id"`
+	DeliveryId int       `json:"deliveryId"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int       `json:"durationMs"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
@@ -0,0 +1,181 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// refetchInterval limits how often an unknown key id triggers a JWKS
+// download, so forged tokens cannot make us hammer the provider.
+const refetchInterval = 30 * time.Second
+
+type keySet struct {
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// JWK is one entry of a JSON Web Key Set (RFC 7517). Only the public
+// members of RSA and P-256 keys are used.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKey decodes the key material.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// RSAJWK describes an RSA public key for publishing in a key set.
+func RSAJWK(kid string, key *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// key finds kid in the cached set, downloading the set again when the id
+// is unknown (the provider rotated its keys).
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	cfg, err := p.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil {
+		if key, ok := p.keys.keys[kid]; ok {
+			return key, nil
+		}
+		if time.Since(p.keys.fetched) < refetchInterval {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+	}
+	if cfg.JWKSURL == "" {
+		return nil, errors.New("provider has no JWKS endpoint")
+	}
+
+	var set JWKS
+	if err := p.getJSON(ctx, cfg.JWKSURL, "", &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys := &keySet{keys: map[string]crypto.PublicKey{}, fetched: time.Now()}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.PublicKey(); err == nil {
+			keys.keys[k.Kid] = key
+		}
+	}
+	p.keys = keys
+
+	key, ok := keys.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// VerifyIDToken checks the signature against the provider's JWKS and the
+// iss, aud, azp, exp and nonce claims.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw string, nonce string) (*Claims, error) {
+	cfg, err := p.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256"}))
+	_, err = parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("id token: %w", err)
+	}
+
+	if cfg.Issuer != "" && !claims.VerifyIssuer(cfg.Issuer, true) {
+		return nil, errors.New("id token: wrong issuer")
+	}
+	if !claims.VerifyAudience(cfg.ClientID, true) {
+		return nil, errors.New("id token: wrong audience")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != cfg.ClientID {
+		return nil, errors.New("id token: wrong authorized party")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("id token: missing exp")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("id token: nonce mismatch")
+	}
+
+	result := claimsFrom(claims)
+	if result.Subject == "" {
+		return nil, errors.New("id token: missing subject")
+	}
+	return result, nil
+}
+
+// Thumbprint is the RFC 7638 SHA-256 thumbprint of an RSA key, a stable
+// key id.
+func Thumbprint(key *rsa.PublicKey) string {
+	jwk := RSAJWK("", key)
+	canonical := `{"e":"` + jwk.E + `","kty":"RSA","n":"` + jwk.N + `"}`
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,321 @@
+// Package oidc is a small OAuth 2.0 / OpenID Connect relying party: the
+// authorization code flow with PKCE, discovery, and ID-token verification
+// against the provider's JWKS. Providers without ID tokens (GitHub) are
+// supported through their user info endpoint.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrUnknownProvider = errors.New("unknown provider")
+
+// Config describes one provider. With Issuer set the endpoints are
+// discovered; explicit endpoints take precedence over discovered ones.
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+	JWKSURL     string
+}
+
+// Claims is the identity a provider vouches for.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	GivenName     string
+	FamilyName    string
+}
+
+// Token is a token endpoint response.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+}
+
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu         sync.Mutex
+	discovered bool
+	keys       *keySet
+}
+
+func NewProvider(cfg Config, client *http.Client) *Provider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Provider{cfg: cfg, client: client}
+}
+
+func (p *Provider) Name() string { return p.cfg.Name }
+
+// endpoints fills in the configuration from the discovery document once.
+func (p *Provider) endpoints(ctx context.Context) (Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovered || p.cfg.Issuer == "" {
+		return p.cfg, nil
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	wellKnown := strings.TrimRight(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, "", &doc); err != nil {
+		return Config{}, fmt.Errorf("discovery: %w", err)
+	}
+	if doc.Issuer != p.cfg.Issuer {
+		return Config{}, fmt.Errorf("discovery: issuer %q does not match %q", doc.Issuer, p.cfg.Issuer)
+	}
+
+	setDefault(&p.cfg.AuthURL, doc.AuthorizationEndpoint)
+	setDefault(&p.cfg.TokenURL, doc.TokenEndpoint)
+	setDefault(&p.cfg.UserInfoURL, doc.UserInfoEndpoint)
+	setDefault(&p.cfg.JWKSURL, doc.JWKSURI)
+	p.discovered = true
+	return p.cfg, nil
+}
+
+func setDefault(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// AuthCodeURL is where the browser is sent to sign in. challenge is the
+// S256 PKCE challenge for the verifier kept by the caller.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	cfg, err := p.endpoints(ctx)
+	if err != nil {
+		return "", err
+	}
+	if cfg.AuthURL == "" {
+		return "", errors.New("provider has no authorization endpoint")
+	}
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {cfg.ClientID},
+		"redirect_uri":          {cfg.RedirectURL},
+		"scope":                 {strings.Join(cfg.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	if nonce != "" {
+		q.Set("nonce", nonce)
+	}
+
+	sep := "?"
+	if strings.Contains(cfg.AuthURL, "?") {
+		sep = "&"
+	}
+	return cfg.AuthURL + sep + q.Encode(), nil
+}
+
+// Exchange redeems an authorization code.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+	cfg, err := p.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {cfg.RedirectURL},
+		"client_id":     {cfg.ClientID},
+		"client_secret": {cfg.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		Token
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("token endpoint: %s: %w", res.Status, err)
+	}
+	if body.Error != "" {
+		return nil, fmt.Errorf("token endpoint: %s: %s", body.Error, body.Description)
+	}
+	if res.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return nil, fmt.Errorf("token endpoint: %s", res.Status)
+	}
+	return &body.Token, nil
+}
+
+// Identity verifies the ID token when there is one (nonce must match) and
+// otherwise asks the user info endpoint.
+func (p *Provider) Identity(ctx context.Context, token *Token, nonce string) (*Claims, error) {
+	if token.IDToken != "" {
+		return p.VerifyIDToken(ctx, token.IDToken, nonce)
+	}
+
+	cfg, err := p.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.UserInfoURL == "" {
+		return nil, errors.New("provider returned no ID token and has no user info endpoint")
+	}
+
+	var info map[string]any
+	if err := p.getJSON(ctx, cfg.UserInfoURL, token.AccessToken, &info); err != nil {
+		return nil, fmt.Errorf("user info: %w", err)
+	}
+	claims := claimsFrom(info)
+	// GitHub names the subject "id"; plain OAuth2 says nothing about
+	// whether the email was verified.
+	if claims.Subject == "" {
+		claims.Subject = stringClaim(info, "id")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("user info: missing subject")
+	}
+	return claims, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url, bearer string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+func claimsFrom(m map[string]any) *Claims {
+	claims := &Claims{
+		Subject:    stringClaim(m, "sub"),
+		Email:      stringClaim(m, "email"),
+		Name:       stringClaim(m, "name"),
+		GivenName:  stringClaim(m, "given_name"),
+		FamilyName: stringClaim(m, "family_name"),
+	}
+	// Some providers send email_verified as a string
+	switch v := m["email_verified"].(type) {
+	case bool:
+		claims.EmailVerified = v
+	case string:
+		claims.EmailVerified = v == "true"
+	}
+	return claims
+}
+
+func stringClaim(m map[string]any, key string) string {
+	switch v := m[key].(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	}
+	return ""
+}
+
+// Registry holds the configured providers by name.
+type Registry struct {
+	providers map[string]*Provider
+}
+
+func NewRegistry(client *http.Client, configs ...Config) *Registry {
+	r := &Registry{providers: map[string]*Provider{}}
+	for _, cfg := range configs {
+		r.providers[cfg.Name] = NewProvider(cfg, client)
+	}
+	return r
+}
+
+func (r *Registry) Get(name string) (*Provider, error) {
+	p, ok := r.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RandomString returns n random bytes, base64url encoded.
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewPKCE returns a code verifier and its S256 challenge (RFC 7636).
+func NewPKCE() (verifier, challenge string, err error) {
+	verifier, err = RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	return verifier, S256(verifier), nil
+}
+
+func S256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,122 @@
+package oidc_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"server-go/oidc"
+	"server-go/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	idp := oidctest.NewServer("client", "secret")
+	defer idp.Close()
+	provider := oidc.NewProvider(idp.Config("fake", "http://app/auth/fake/callback"), nil)
+	ctx := context.Background()
+
+	verifier, challenge, err := oidc.NewPKCE()
+	require.NoError(t, err)
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", challenge)
+	require.NoError(t, err)
+
+	u, _ := url.Parse(authURL)
+	assert.Equal(t, idp.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, "openid email profile", u.Query().Get("scope"))
+
+	user := oidctest.User{Subject: "abc", Email: "jane@example.com", EmailVerified: true, Name: "Jane Doe"}
+	code, state, err := idp.Authorize(authURL, user)
+	require.NoError(t, err)
+	assert.Equal(t, "state-1", state)
+
+	_, err = provider.Exchange(ctx, code, "wrong-verifier")
+	assert.ErrorContains(t, err, "PKCE")
+
+	code, _, _ = idp.Authorize(authURL, user)
+	token, err := provider.Exchange(ctx, code, verifier)
+	require.NoError(t, err)
+
+	claims, err := provider.Identity(ctx, token, "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", claims.Subject)
+	assert.Equal(t, "jane@example.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+
+	_, err = provider.Identity(ctx, token, "nonce-2")
+	assert.ErrorContains(t, err, "nonce")
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := oidctest.NewServer("client", "secret")
+	defer idp.Close()
+	provider := oidc.NewProvider(idp.Config("fake", "http://app/cb"), nil)
+	ctx := context.Background()
+
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"iss": idp.Issuer(), "aud": "client", "sub": "abc", "nonce": "n", "exp": time.Now().Add(time.Minute).Unix()}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	_, err := provider.VerifyIDToken(ctx, idp.IDToken(claims(nil)), "n")
+	assert.NoError(t, err)
+
+	cases := map[string]jwt.MapClaims{
+		"wrong issuer":   {"iss": "https://evil.example"},
+		"wrong audience": {"aud": "other-client"},
+		"wrong azp":      {"aud": []string{"client", "other"}, "azp": "other"},
+		"expired":        {"exp": time.Now().Add(-time.Minute).Unix()},
+	}
+	for name, override := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := provider.VerifyIDToken(ctx, idp.IDToken(claims(override)), "n")
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("tampered", func(t *testing.T) {
+		raw := idp.IDToken(claims(nil))
+		_, err := provider.VerifyIDToken(ctx, raw[:len(raw)-4]+"AAAA", "n")
+		assert.Error(t, err)
+	})
+
+	t.Run("hmac with public key material", func(t *testing.T) {
+		raw, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(nil)).SignedString([]byte("secret"))
+		_, err := provider.VerifyIDToken(ctx, raw, "n")
+		assert.Error(t, err)
+	})
+}
+
+func TestJWKSCachedAndRefetchThrottled(t *testing.T) {
+	idp := oidctest.NewServer("client", "secret")
+	defer idp.Close()
+	provider := oidc.NewProvider(idp.Config("fake", "http://app/cb"), nil)
+	ctx := context.Background()
+	claims := jwt.MapClaims{"iss": idp.Issuer(), "aud": "client", "sub": "abc", "exp": time.Now().Add(time.Minute).Unix()}
+
+	_, err := provider.VerifyIDToken(ctx, idp.IDToken(claims), "")
+	require.NoError(t, err)
+	_, err = provider.VerifyIDToken(ctx, idp.IDToken(claims), "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, idp.JWKSRequests, "keys are cached")
+
+	// A new key id within the refetch interval is not looked up again
+	idp.RotateKey()
+	_, err = provider.VerifyIDToken(ctx, idp.IDToken(claims), "")
+	assert.ErrorContains(t, err, "unknown key id")
+	assert.Equal(t, 1, idp.JWKSRequests)
+}
+
+func TestRegistry(t *testing.T) {
+	registry := oidc.NewRegistry(nil, oidc.Config{Name: "google"}, oidc.Config{Name: "acme"})
+	assert.Equal(t, []string{"acme", "google"}, registry.Names())
+	_, err := registry.Get("github")
+	assert.ErrorIs(t, err, oidc.ErrUnknownProvider)
+}
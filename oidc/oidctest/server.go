@@ -0,0 +1,206 @@
+// Package oidctest runs a fake OpenID Connect provider for tests, in the
+// spirit of net/http/httptest.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"server-go/oidc"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// User is who signs in at the fake provider.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type grant struct {
+	user        User
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+
+type Server struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	mu    sync.Mutex
+	key   *rsa.PrivateKey
+	kid   int
+	codes map[string]grant
+	// JWKSRequests counts key set downloads.
+	JWKSRequests int
+}
+
+// NewServer starts a provider that accepts one client. Its issuer is the
+// server URL.
+func NewServer(clientID, clientSecret string) *Server {
+	s := &Server{ClientID: clientID, ClientSecret: clientSecret, codes: map[string]grant{}}
+	s.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/token", s.token)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) Issuer() string { return s.URL }
+
+// Config returns a relying party configuration for this provider.
+func (s *Server) Config(name, redirectURL string) oidc.Config {
+	return oidc.Config{
+		Name:         name,
+		Issuer:       s.Issuer(),
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// RotateKey replaces the signing key; tokens issued afterwards carry a new
+// key id.
+func (s *Server) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key = key
+	s.kid++
+}
+
+// Authorize plays the browser: it validates the authorization request the
+// way the provider would, signs user in and returns the code and state the
+// provider redirects back with.
+func (s *Server) Authorize(authURL string, user User) (code string, state string, err error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	q := u.Query()
+	switch {
+	case q.Get("response_type") != "code":
+		return "", "", errors.New("unsupported response_type")
+	case q.Get("client_id") != s.ClientID:
+		return "", "", errors.New("unknown client")
+	case q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "":
+		return "", "", errors.New("PKCE S256 required")
+	}
+
+	code, err = oidc.RandomString(16)
+	if err != nil {
+		return "", "", err
+	}
+	s.mu.Lock()
+	s.codes[code] = grant{user: user, redirectURI: q.Get("redirect_uri"), challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	s.mu.Unlock()
+	return code, q.Get("state"), nil
+}
+
+// IDToken signs an ID token with the current key.
+func (s *Server) IDToken(claims jwt.MapClaims) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = fmt.Sprint(s.kid)
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.Issuer(),
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.JWKSRequests++
+	set := oidc.JWKS{Keys: []oidc.JWK{oidc.RSAJWK(fmt.Sprint(s.kid), &s.key.PublicKey)}}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, set)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if r.PostForm.Get("client_id") != s.ClientID || r.PostForm.Get("client_secret") != s.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	g, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	switch {
+	case !ok || r.PostForm.Get("grant_type") != "authorization_code":
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	case r.PostForm.Get("redirect_uri") != g.redirectURI:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "redirect_uri mismatch"})
+		return
+	case oidc.S256(r.PostForm.Get("code_verifier")) != g.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            s.Issuer(),
+		"aud":            s.ClientID,
+		"sub":            g.user.Subject,
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+		"name":           g.user.Name,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "access-" + g.user.Subject,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     s.IDToken(claims),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
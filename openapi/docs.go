@@ -0,0 +1,31 @@
+package openapi
+
+import (
+	"html/template"
+	"io"
+)
+
+// The page loads Swagger UI from a CDN, so the server ships no assets.
+var docsPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+<script>
+window.ui = SwaggerUIBundle({url: {{.SpecURL}}, dom_id: "#swagger-ui", deepLinking: true});
+</script>
+</body>
+</html>
+`))
+
+// WriteDocsPage writes an interactive page for the document served at
+// specURL.
+func WriteDocsPage(w io.Writer, title string, specURL string) error {
+	return docsPage.Execute(w, struct{ Title, SpecURL string }{title, specURL})
+}
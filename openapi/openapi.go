@@ -0,0 +1,271 @@
+// Package openapi describes the HTTP API as an OpenAPI 3.1 document. Routes
+// are added with the gin path they are registered under, and request and
+// response schemas are derived from the Go values handlers bind and return.
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Document is an OpenAPI 3.1 document; encode it as JSON to publish it.
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Servers    []Server                         `json:"servers,omitempty"`
+	Tags       []Tag                            `json:"tags,omitempty"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+
+	// ErrorBody is the body of the statuses listed in Op.Errors, published
+	// as the Error component.
+	ErrorBody any `json:"-"`
+
+	schemas *schemas
+	routes  map[string]bool
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+type Operation struct {
+	OperationId string                `json:"operationId,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// Op declares one route for Document.Route.
+type Op struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Path documents the route under another path than the gin one, in
+	// OpenAPI syntax; a route serving several custom methods is documented
+	// once per method.
+	Path string
+	// Security names the schemes from Components.SecuritySchemes that the
+	// route accepts.
+	Security []string
+	// Query parameters, in addition to those from QueryStruct.
+	Query []Parameter
+	// QueryStruct is a struct whose form-tagged fields are query
+	// parameters.
+	QueryStruct any
+	// Body is a JSON request body and Form an urlencoded one; both are Go
+	// values, or Content for other media types.
+	Body any
+	Form any
+	// BodyOptional marks Body or Form as optional.
+	BodyOptional bool
+	// Responses maps statuses to bodies: nil for none, Content for other
+	// media types than JSON, otherwise a Go value or Object.
+	Responses map[int]any
+	// Errors are statuses answered with Document.ErrorBody.
+	Errors     []int
+	Deprecated bool
+}
+
+// Object is a JSON object whose properties have the schemas of the Go
+// values given, like the gin.H maps handlers respond with. Every property
+// is required.
+type Object map[string]any
+
+// Content maps media types to schemas, for bodies that are not JSON.
+type Content map[string]*Schema
+
+// New returns an empty document.
+func New(info Info) *Document {
+	s := newSchemas()
+	return &Document{
+		OpenAPI:    "3.1.0",
+		Info:       info,
+		Paths:      map[string]map[string]*Operation{},
+		Components: Components{Schemas: s.components, SecuritySchemes: map[string]SecurityScheme{}},
+		schemas:    s,
+		routes:     map[string]bool{},
+	}
+}
+
+var (
+	ginParam  = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+	pathParam = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+)
+
+// Route documents the route registered with gin as method and ginPath.
+func (d *Document) Route(method string, ginPath string, op Op) {
+	d.routes[method+" "+ginPath] = true
+
+	path := op.Path
+	if path == "" {
+		path = ginParam.ReplaceAllString(ginPath, "{$1}")
+	}
+	var params []Parameter
+	for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+		params = append(params, Parameter{Name: match[1], In: "path", Required: true, Schema: pathParamSchema(match[1])})
+	}
+	if op.QueryStruct != nil {
+		params = append(params, d.schemas.queryParameters(op.QueryStruct)...)
+	}
+	params = append(params, op.Query...)
+
+	operation := &Operation{
+		OperationId: operationId(method, path),
+		Summary:     op.Summary,
+		Description: op.Description,
+		Tags:        op.Tags,
+		Parameters:  params,
+		Responses:   map[string]*Response{},
+		Deprecated:  op.Deprecated,
+	}
+	for _, scheme := range op.Security {
+		operation.Security = append(operation.Security, map[string][]string{scheme: {}})
+	}
+	switch {
+	case op.Body != nil:
+		operation.RequestBody = &RequestBody{Required: !op.BodyOptional, Content: d.content(op.Body, "application/json")}
+	case op.Form != nil:
+		operation.RequestBody = &RequestBody{Required: !op.BodyOptional, Content: d.content(op.Form, "application/x-www-form-urlencoded")}
+	}
+	for status, body := range op.Responses {
+		operation.Responses[strconv.Itoa(status)] = d.response(status, body)
+	}
+	if len(op.Errors) > 0 && d.Components.Schemas["Error"] == nil {
+		d.Components.Schemas["Error"] = d.Schema(d.ErrorBody)
+	}
+	for _, status := range op.Errors {
+		operation.Responses[strconv.Itoa(status)] = d.response(status, &Schema{Ref: "#/components/schemas/Error"})
+	}
+
+	if d.Paths[path] == nil {
+		d.Paths[path] = map[string]*Operation{}
+	}
+	d.Paths[path][strings.ToLower(method)] = operation
+}
+
+// Documents reports whether the gin route method ginPath was documented.
+func (d *Document) Documents(method string, ginPath string) bool {
+	return d.routes[method+" "+ginPath]
+}
+
+// Routes lists the documented gin routes as "METHOD path", sorted.
+func (d *Document) Routes() []string {
+	routes := make([]string, 0, len(d.routes))
+	for route := range d.routes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// Schema returns the schema of v, registering the named structs it uses as
+// components.
+func (d *Document) Schema(v any) *Schema {
+	return d.schemas.of(v)
+}
+
+func (d *Document) content(body any, mediaType string) map[string]MediaType {
+	if c, ok := body.(Content); ok {
+		content := map[string]MediaType{}
+		for mediaType, schema := range c {
+			content[mediaType] = MediaType{Schema: schema}
+		}
+		return content
+	}
+	if mediaType == "application/x-www-form-urlencoded" {
+		return map[string]MediaType{mediaType: {Schema: d.schemas.withTag(body, "form")}}
+	}
+	return map[string]MediaType{mediaType: {Schema: d.Schema(body)}}
+}
+
+func (d *Document) response(status int, body any) *Response {
+	response := &Response{Description: http.StatusText(status)}
+	if body != nil {
+		response.Content = d.content(body, "application/json")
+	}
+	if status >= 300 && status < 400 {
+		response.Headers = map[string]Header{"Location": {Schema: &Schema{Type: "string", Format: "uri"}}}
+	}
+	return response
+}
+
+// pathParamSchema types ids as integers and anything else as a string.
+func pathParamSchema(name string) *Schema {
+	if name == "id" || strings.HasSuffix(name, "Id") {
+		return &Schema{Type: "integer"}
+	}
+	return &Schema{Type: "string"}
+}
+
+var nonWord = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// operationId derives a stable id such as "get_admin_user_id_tokens".
+func operationId(method string, path string) string {
+	id := strings.Trim(nonWord.ReplaceAllString(path, "_"), "_")
+	if id == "" {
+		id = "root"
+	}
+	return fmt.Sprintf("%s_%s", strings.ToLower(method), id)
+}
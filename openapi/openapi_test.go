@@ -0,0 +1,83 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type node struct {
+	Name     string     `json:"name"`
+	Parent   *node      `json:"parent,omitempty"`
+	Children []node     `json:"children"`
+	Secret   string     `json:"-"`
+	Seen     *time.Time `json:"seen"`
+	Data     []byte     `json:"data,omitempty"`
+	audit
+}
+
+type audit struct {
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type search struct {
+	Query string `form:"q"`
+	Page  int    `form:"page"`
+}
+
+func TestSchemaFollowsEncodingJSON(t *testing.T) {
+	doc := New(Info{Title: "test", Version: "1"})
+	ref := doc.Schema([]node{})
+	assert.Equal(t, "array", ref.Type)
+	assert.Equal(t, "#/components/schemas/node", ref.Items.Ref)
+
+	schema := doc.Components.Schemas["node"]
+	require.NotNil(t, schema)
+	assert.ElementsMatch(t, []string{"name", "parent", "children", "seen", "data", "createdAt"}, keys(schema.Properties))
+	assert.Equal(t, []string{"children", "createdAt", "name", "seen"}, schema.Required)
+	assert.Equal(t, []string{"string", "null"}, schema.Properties["seen"].Type)
+	assert.Equal(t, "#/components/schemas/node", schema.Properties["parent"].OneOf[0].Ref, "recursive types refer to themselves")
+	assert.Equal(t, "base64", schema.Properties["data"].ContentEncoding)
+}
+
+func TestRoute(t *testing.T) {
+	doc := New(Info{Title: "test", Version: "1"})
+	doc.ErrorBody = Object{"error": ""}
+	doc.Route(http.MethodGet, "/users/:id/items/:name", Op{
+		QueryStruct: search{},
+		Responses:   map[int]any{200: Object{"items": []string{}}},
+		Errors:      []int{404},
+	})
+	doc.Route(http.MethodPost, "/users:verb", Op{Path: "/users:import", Responses: map[int]any{204: nil}})
+
+	assert.True(t, doc.Documents(http.MethodGet, "/users/:id/items/:name"))
+	assert.True(t, doc.Documents(http.MethodPost, "/users:verb"))
+	assert.False(t, doc.Documents(http.MethodGet, "/users"))
+	assert.Contains(t, doc.Paths, "/users:import")
+
+	op := doc.Paths["/users/{id}/items/{name}"]["get"]
+	require.NotNil(t, op)
+	assert.Equal(t, "get_users_id_items_name", op.OperationId)
+	require.Len(t, op.Parameters, 4)
+	assert.Equal(t, Parameter{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer"}}, op.Parameters[0])
+	assert.Equal(t, "string", op.Parameters[1].Schema.Type)
+	assert.Equal(t, "page", op.Parameters[2].Name)
+	assert.Equal(t, "q", op.Parameters[3].Name)
+	assert.Equal(t, "#/components/schemas/Error", op.Responses["404"].Content["application/json"].Schema.Ref)
+
+	encoded, err := json.Marshal(doc)
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"openapi":"3.1.0"`)
+}
+
+func keys(m map[string]*Schema) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
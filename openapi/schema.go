@@ -0,0 +1,222 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema 2020-12 subset, as used by OpenAPI 3.1.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	ContentEncoding      string             `json:"contentEncoding,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+}
+
+// String, Integer and Binary are shorthands for Op.Query and Content.
+func String() *Schema  { return &Schema{Type: "string"} }
+func Integer() *Schema { return &Schema{Type: "integer"} }
+func Binary() *Schema  { return &Schema{Type: "string", ContentEncoding: "binary"} }
+
+// Any accepts any JSON value.
+func Any() *Schema { return &Schema{} }
+
+// Enum is a string that takes one of values.
+func Enum(values ...string) *Schema {
+	s := &Schema{Type: "string"}
+	for _, v := range values {
+		s.Enum = append(s.Enum, v)
+	}
+	return s
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	durationType   = reflect.TypeOf(time.Duration(0))
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemas derives schemas from Go types the way encoding/json encodes them.
+// Named structs become components referenced by $ref.
+type schemas struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newSchemas() *schemas {
+	return &schemas{components: map[string]*Schema{}, names: map[reflect.Type]string{}}
+}
+
+func (s *schemas) of(v any) *Schema {
+	switch v := v.(type) {
+	case *Schema:
+		return v
+	case Object:
+		return s.object(v)
+	}
+	return s.typeOf(reflect.TypeOf(v), "json")
+}
+
+func (s *schemas) object(o Object) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for name, v := range o {
+		schema.Properties[name] = s.of(v)
+		schema.Required = append(schema.Required, name)
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// withTag is the schema of a struct whose fields are named by tag, such as
+// an urlencoded form bound by gin. It is not registered as a component.
+func (s *schemas) withTag(v any, tag string) *Schema {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return s.structOf(t, tag)
+}
+
+// queryParameters lists the form-tagged fields of a struct as optional
+// query parameters.
+func (s *schemas) queryParameters(v any) []Parameter {
+	schema := s.withTag(v, "form")
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	params := make([]Parameter, 0, len(names))
+	for _, name := range names {
+		params = append(params, Parameter{Name: name, In: "query", Schema: schema.Properties[name]})
+	}
+	return params
+}
+
+func (s *schemas) typeOf(t reflect.Type, tag string) *Schema {
+	if t == nil {
+		return Any()
+	}
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &Schema{Type: "integer", Description: "nanoseconds"}
+	case rawMessageType:
+		return Any()
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(s.typeOf(t.Elem(), tag))
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema := &Schema{Type: "integer"}
+		if t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64 {
+			schema.Format = "int64"
+		}
+		return schema
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json writes []byte as base64
+			return &Schema{Type: "string", ContentEncoding: "base64"}
+		}
+		return &Schema{Type: "array", Items: s.typeOf(t.Elem(), tag)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.typeOf(t.Elem(), tag)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structOf(t, tag)
+		}
+		return s.component(t, tag)
+	}
+	// Interfaces, and anything encoding/json cannot encode
+	return Any()
+}
+
+var nonName = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// component registers the named struct t and returns a reference to it.
+func (s *schemas) component(t reflect.Type, tag string) *Schema {
+	name, ok := s.names[t]
+	if !ok {
+		name = nonName.ReplaceAllString(t.Name(), "_")
+		// Two packages may use the same type name
+		if _, taken := s.components[name]; taken {
+			pkg := t.PkgPath()
+			name = nonName.ReplaceAllString(pkg[strings.LastIndex(pkg, "/")+1:], "_") + "." + name
+		}
+		s.names[t] = name
+		// Registered before the fields so that recursive types terminate
+		s.components[name] = &Schema{}
+		*s.components[name] = *s.structOf(t, tag)
+	}
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// structOf lists the fields encoding/json would write: exported, not
+// tagged "-", with embedded structs flattened. Fields without omitempty
+// are always written and so required.
+func (s *schemas) structOf(t reflect.Type, tag string) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				inner := s.structOf(embedded, tag)
+				for n, p := range inner.Properties {
+					schema.Properties[n] = p
+				}
+				schema.Required = append(schema.Required, inner.Required...)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = s.typeOf(field.Type, tag)
+		if tag == "json" && !strings.Contains(opts, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// nullable also accepts null, as encoding/json writes for nil pointers.
+func nullable(schema *Schema) *Schema {
+	if t, ok := schema.Type.(string); ok && schema.Ref == "" {
+		nullable := *schema
+		nullable.Type = []string{t, "null"}
+		return &nullable
+	}
+	return &Schema{OneOf: []*Schema{schema, {Type: "null"}}}
+}
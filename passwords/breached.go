@@ -0,0 +1,151 @@
+package passwords
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Corpus is an offline copy of the Have I Been Pwned password list. It is
+// either a directory of range files, as served by the range API and
+// written by the official downloader (ABCDE.txt holding "SUFFIX:COUNT"
+// lines for hashes starting with ABCDE), or one file of "HASH:COUNT" lines
+// sorted by hash, which is searched in place without loading it.
+type Corpus struct {
+	dir  string
+	file *os.File
+	size int64
+}
+
+// OpenCorpus opens the corpus at path.
+func OpenCorpus(path string) (*Corpus, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &Corpus{dir: path}, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Corpus{file: file, size: info.Size()}, nil
+}
+
+func (c *Corpus) Close() error {
+	if c.file != nil {
+		return c.file.Close()
+	}
+	return nil
+}
+
+// Count returns how many times password was seen in breaches.
+func (c *Corpus) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if c.file != nil {
+		return c.search(hash)
+	}
+	return c.lookupRange(hash[:5], hash[5:])
+}
+
+func (c *Corpus) lookupRange(prefix string, suffix string) (int, error) {
+	file, err := os.Open(filepath.Join(c.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if hash, count, ok := splitEntry(scanner.Text()); ok && strings.EqualFold(hash, suffix) {
+			return count, nil
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// search does a binary search over byte offsets, keeping the invariant
+// that the line for hash, if any, starts within [lo, hi).
+func (c *Corpus) search(hash string) (int, error) {
+	lo, hi := int64(0), c.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, err := c.lineFrom(mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+		entry, count, ok := splitEntry(line)
+		if !ok {
+			return 0, errors.New("passwords: malformed corpus line at offset " + strconv.FormatInt(start, 10))
+		}
+		switch strings.Compare(strings.ToUpper(entry), hash) {
+		case 0:
+			return count, nil
+		case -1:
+			lo = start + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, nil
+}
+
+// maxLine comfortably exceeds a 40 character hash, a colon and a count.
+const maxLine = 128
+
+// lineFrom returns the first line starting at or after off.
+func (c *Corpus) lineFrom(off int64) (int64, string, error) {
+	start := off
+	if off > 0 {
+		buf := make([]byte, maxLine)
+		n, err := c.file.ReadAt(buf, off-1)
+		if n == 0 && err != nil {
+			return c.size, "", nil
+		}
+		i := bytes.IndexByte(buf[:n], '\n')
+		if i < 0 {
+			if int64(n) < maxLine {
+				return c.size, "", nil
+			}
+			return 0, "", errors.New("passwords: corpus line too long")
+		}
+		start = off + int64(i)
+	}
+	if start >= c.size {
+		return c.size, "", nil
+	}
+	buf := make([]byte, maxLine)
+	n, _ := c.file.ReadAt(buf, start)
+	line := buf[:n]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return start, strings.TrimSpace(string(line)), nil
+}
+
+func splitEntry(line string) (string, int, bool) {
+	hash, count, ok := strings.Cut(strings.TrimSpace(line), ":")
+	if !ok {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return "", 0, false
+	}
+	return hash, n, true
+}
@@ -0,0 +1,208 @@
+// Package passwords hashes and verifies user passwords. New hashes use the
+// configured algorithm, bcrypt or Argon2id; hashes made with either remain
+// verifiable, so a deployment can switch algorithms and let Verify report
+// which stored hashes should be replaced.
+//
+// Argon2id hashes are PHC strings:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//
+// bcrypt hashes keep their standard "$2a$" modular crypt form, which
+// existing rows already use.
+package passwords
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+// Hasher is what services use to store and check passwords.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash and, when it does,
+	// whether hash should be replaced by a fresh one: it was made with
+	// another algorithm, other parameters, or without the current pepper.
+	Verify(hash string, password string) (match bool, rehash bool, err error)
+}
+
+// Argon2Params are the Argon2id cost parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for Argon2id.
+var DefaultArgon2Params = Argon2Params{Memory: 19 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+type Config struct {
+	// Algorithm is Bcrypt or Argon2id.
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+	// Pepper is a server-side secret mixed into every password before
+	// hashing. It is kept out of the database, so a leaked users table
+	// alone cannot be brute-forced.
+	Pepper []byte
+}
+
+type hasher struct {
+	cfg Config
+}
+
+// New returns a Hasher for cfg.
+func New(cfg Config) (Hasher, error) {
+	switch cfg.Algorithm {
+	case Bcrypt:
+		if cfg.BcryptCost == 0 {
+			cfg.BcryptCost = bcrypt.DefaultCost
+		}
+		if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("passwords: bcrypt cost %d out of range", cfg.BcryptCost)
+		}
+	case Argon2id:
+		if cfg.Argon2 == (Argon2Params{}) {
+			cfg.Argon2 = DefaultArgon2Params
+		}
+		p := cfg.Argon2
+		if p.Memory < 8*uint32(p.Parallelism) || p.Iterations < 1 || p.Parallelism < 1 || p.SaltLength < 8 || p.KeyLength < 16 {
+			return nil, fmt.Errorf("passwords: invalid argon2id parameters %+v", p)
+		}
+	default:
+		return nil, fmt.Errorf("passwords: unknown algorithm %q", cfg.Algorithm)
+	}
+	return &hasher{cfg: cfg}, nil
+}
+
+func (h *hasher) Hash(password string) (string, error) {
+	return h.hash(h.season(password))
+}
+
+func (h *hasher) hash(password []byte) (string, error) {
+	if h.cfg.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword(password, h.cfg.BcryptCost)
+		return string(hash), err
+	}
+
+	p := h.cfg.Argon2
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(password, salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *hasher) Verify(hash string, password string) (bool, bool, error) {
+	match, current, err := h.verify(hash, h.season(password))
+	if err != nil || match || h.cfg.Pepper == nil {
+		return match, match && !current, err
+	}
+	// Hashes stored before a pepper was configured still verify, and are
+	// replaced on the next login
+	match, _, err = h.verify(hash, []byte(password))
+	return match, match, err
+}
+
+// verify checks one hash; current reports whether it was made with the
+// configured algorithm and parameters.
+func (h *hasher) verify(hash string, password []byte) (match bool, current bool, err error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		p, salt, key, err := parseArgon2id(hash)
+		if err != nil {
+			return false, false, err
+		}
+		computed := argon2.IDKey(password, salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false, nil
+		}
+		want := h.cfg.Argon2
+		current := h.cfg.Algorithm == Argon2id && p.Memory == want.Memory && p.Iterations == want.Iterations &&
+			p.Parallelism == want.Parallelism && uint32(len(salt)) == want.SaltLength && uint32(len(key)) == want.KeyLength
+		return true, current, nil
+	case isBcrypt(hash):
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), password); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, false, nil
+			}
+			return false, false, err
+		}
+		cost, _ := bcrypt.Cost([]byte(hash))
+		return true, h.cfg.Algorithm == Bcrypt && cost == h.cfg.BcryptCost, nil
+	default:
+		// Accounts without a password, e.g. created by social login or an
+		// invitation, never match
+		return false, false, nil
+	}
+}
+
+// season applies the pepper. HMAC-SHA256 keeps the input within bcrypt's
+// 72-byte limit whatever the password length.
+func (h *hasher) season(password string) []byte {
+	if h.cfg.Pepper == nil {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, h.cfg.Pepper)
+	mac.Write([]byte(password))
+	return []byte(base64.RawStdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// Recognized reports whether hash is a bcrypt or Argon2id hash this
+// package can verify, e.g. when importing pre-hashed passwords.
+func Recognized(hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		_, _, _, err := parseArgon2id(hash)
+		return err == nil
+	}
+	return isBcrypt(hash)
+}
+
+func isBcrypt(hash string) bool {
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+func parseArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	invalid := errors.New("passwords: malformed argon2id hash")
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return p, nil, nil, invalid
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, invalid
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, invalid
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, invalid
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+		return p, nil, nil, invalid
+	}
+	p.SaltLength, p.KeyLength = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,118 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap keeps the tests fast; the values are not meant for production.
+var cheap = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func mustNew(t *testing.T, cfg Config) Hasher {
+	h, err := New(cfg)
+	require.NoError(t, err)
+	return h
+}
+
+func TestArgon2id(t *testing.T) {
+	h := mustNew(t, Config{Algorithm: Argon2id, Argon2: cheap})
+	hash, err := h.Hash("correct horse")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"), hash)
+	assert.True(t, Recognized(hash))
+
+	match, rehash, err := h.Verify(hash, "correct horse")
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.False(t, rehash)
+
+	match, _, err = h.Verify(hash, "wrong horse")
+	require.NoError(t, err)
+	assert.False(t, match)
+
+	other, _ := h.Hash("correct horse")
+	assert.NotEqual(t, hash, other, "salted")
+
+	t.Run("stronger parameters ask for a rehash", func(t *testing.T) {
+		stronger := cheap
+		stronger.Iterations = 2
+		match, rehash, err := mustNew(t, Config{Algorithm: Argon2id, Argon2: stronger}).Verify(hash, "correct horse")
+		require.NoError(t, err)
+		assert.True(t, match)
+		assert.True(t, rehash)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		_, _, err := h.Verify("$argon2id$v=19$m=64$x$y", "correct horse")
+		assert.Error(t, err)
+		assert.False(t, Recognized("$argon2id$v=19$m=64$x$y"))
+	})
+}
+
+func TestBcryptUpgrade(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	match, rehash, err := mustNew(t, Config{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}).Verify(string(legacy), "correct horse")
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.False(t, rehash)
+
+	match, rehash, err = mustNew(t, Config{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost + 1}).Verify(string(legacy), "correct horse")
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.True(t, rehash, "cost changed")
+
+	match, rehash, err = mustNew(t, Config{Algorithm: Argon2id, Argon2: cheap}).Verify(string(legacy), "correct horse")
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.True(t, rehash, "algorithm changed")
+}
+
+func TestPepper(t *testing.T) {
+	plain := mustNew(t, Config{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost})
+	peppered := mustNew(t, Config{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost, Pepper: []byte("s3cret")})
+
+	hash, err := peppered.Hash("correct horse")
+	require.NoError(t, err)
+	match, _, _ := plain.Verify(hash, "correct horse")
+	assert.False(t, match, "the pepper is required")
+	match, rehash, _ := peppered.Verify(hash, "correct horse")
+	assert.True(t, match)
+	assert.False(t, rehash)
+
+	t.Run("hashes from before the pepper are upgraded", func(t *testing.T) {
+		old, _ := plain.Hash("correct horse")
+		match, rehash, err := peppered.Verify(old, "correct horse")
+		require.NoError(t, err)
+		assert.True(t, match)
+		assert.True(t, rehash)
+	})
+
+	t.Run("long passwords stay distinct under bcrypt", func(t *testing.T) {
+		long := strings.Repeat("a", 80)
+		hash, _ := peppered.Hash(long + "1")
+		match, _, _ := peppered.Verify(hash, long+"2")
+		assert.False(t, match)
+	})
+}
+
+func TestNoPassword(t *testing.T) {
+	match, rehash, err := mustNew(t, Config{Algorithm: Argon2id, Argon2: cheap}).Verify("", "")
+	assert.NoError(t, err)
+	assert.False(t, match)
+	assert.False(t, rehash)
+}
+
+func TestNewRejectsBadSettings(t *testing.T) {
+	_, err := New(Config{Algorithm: "md5"})
+	assert.Error(t, err)
+	_, err = New(Config{Algorithm: Bcrypt, BcryptCost: 99})
+	assert.Error(t, err)
+	_, err = New(Config{Algorithm: Argon2id, Argon2: Argon2Params{Memory: 1, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,106 @@
+package passwords
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy describes which new passwords are acceptable. The zero value
+// accepts anything.
+type Policy struct {
+	MinLength int
+	// MaxLength bounds the work a single hash can cost; zero means no limit.
+	MaxLength int
+	// MinClasses is how many of lowercase, uppercase, digits and symbols a
+	// password must mix.
+	MinClasses int
+	// RejectPersonalInfo refuses passwords containing the user's name or
+	// the local part of their email.
+	RejectPersonalInfo bool
+	// History is how many previous passwords may not be reused.
+	History int
+	// MaxAge, when set, is how long a password stays valid.
+	MaxAge time.Duration
+	// Breached, when set, is searched for the password.
+	Breached *Corpus
+}
+
+// Violation is one rule a password broke.
+type Violation struct {
+	Code    string
+	Message string
+}
+
+// Check returns the rules password breaks. personal lists the user's name,
+// last name and email; they only matter with RejectPersonalInfo. History is
+// left to the caller, which holds the previous hashes.
+func (p Policy) Check(password string, personal ...string) ([]Violation, error) {
+	var violations []Violation
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{"too_short", fmt.Sprintf("must be at least %d characters", p.MinLength)})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{"too_long", fmt.Sprintf("must be at most %d characters", p.MaxLength)})
+	}
+	if p.MinClasses > 0 && characterClasses(password) < p.MinClasses {
+		violations = append(violations, Violation{"character_classes",
+			fmt.Sprintf("must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses)})
+	}
+	if p.RejectPersonalInfo && containsPersonalInfo(password, personal) {
+		violations = append(violations, Violation{"personal_info", "must not contain your name or email"})
+	}
+	if p.Breached != nil {
+		count, err := p.Breached.Count(password)
+		if err != nil {
+			return violations, err
+		}
+		if count > 0 {
+			violations = append(violations, Violation{"breached", "appears in a list of breached passwords"})
+		}
+	}
+	return violations, nil
+}
+
+// Expired reports whether a password set at changedAt is past MaxAge.
+func (p Policy) Expired(changedAt time.Time) bool {
+	return p.MaxAge > 0 && !changedAt.IsZero() && time.Since(changedAt) > p.MaxAge
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// containsPersonalInfo ignores case and fragments shorter than three
+// characters, which would reject too much.
+func containsPersonalInfo(password string, personal []string) bool {
+	password = strings.ToLower(password)
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if at := strings.IndexByte(value, '@'); at >= 0 {
+			value = value[:at]
+		}
+		for _, part := range strings.FieldsFunc(value, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+			if utf8.RuneCountInString(part) >= 3 && strings.Contains(password, part) {
+				return true
+			}
+		}
+	}
+	return false
+}
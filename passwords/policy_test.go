@@ -0,0 +1,109 @@
+package passwords
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func codes(violations []Violation) []string {
+	var c []string
+	for _, v := range violations {
+		c = append(c, v.Code)
+	}
+	return c
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := Policy{MinLength: 8, MaxLength: 64, MinClasses: 3, RejectPersonalInfo: true}
+	for password, want := range map[string][]string{
+		"a":                       {"too_short", "character_classes"},
+		"correct-Horse-9":         nil,
+		"all lowercase letters":   {"character_classes"},
+		"Jane-Doe-2024":           {"personal_info"},
+		"x-Jdoe-99":               {"personal_info"},
+		"Jo-9-Abcdefg":            nil, // fragments under three characters are ignored
+		strings.Repeat("aB3", 30): {"too_long"},
+	} {
+		violations, err := policy.Check(password, "Jo", "Doe", "jane.jdoe@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, want, codes(violations), password)
+	}
+}
+
+func TestPolicyExpired(t *testing.T) {
+	policy := Policy{MaxAge: 24 * time.Hour}
+	assert.False(t, policy.Expired(time.Now().Add(-time.Hour)))
+	assert.True(t, policy.Expired(time.Now().Add(-48*time.Hour)))
+	assert.False(t, Policy{}.Expired(time.Now().Add(-48*time.Hour)), "no maximum age")
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestCorpus(t *testing.T) {
+	breached := map[string]int{"password": 9659365, "123456": 37359195, "letmein": 1, "qwerty": 2}
+	var lines []string
+	for password, count := range breached {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(password), count))
+	}
+	// Padding so the search has to narrow down a larger file
+	for i := 0; i < 2000; i++ {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(fmt.Sprintf("filler-%d", i)), i+1))
+	}
+	sort.Strings(lines)
+
+	t.Run("sorted file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "pwned.txt")
+		require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600))
+		corpus, err := OpenCorpus(path)
+		require.NoError(t, err)
+		defer corpus.Close()
+
+		for password, want := range breached {
+			count, err := corpus.Count(password)
+			require.NoError(t, err)
+			assert.Equal(t, want, count, password)
+		}
+		for _, password := range []string{"filler-0", "filler-1999"} {
+			count, err := corpus.Count(password)
+			require.NoError(t, err)
+			assert.NotZero(t, count, password)
+		}
+		count, err := corpus.Count("correct-Horse-9")
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+
+	t.Run("range directory", func(t *testing.T) {
+		dir := t.TempDir()
+		ranges := map[string][]string{}
+		for _, line := range lines {
+			ranges[line[:5]] = append(ranges[line[:5]], line[5:])
+		}
+		for prefix, suffixes := range ranges {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(strings.Join(suffixes, "\r\n")), 0o600))
+		}
+		corpus, err := OpenCorpus(dir)
+		require.NoError(t, err)
+
+		policy := Policy{Breached: corpus}
+		violations, err := policy.Check("letmein")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"breached"}, codes(violations))
+		violations, err = policy.Check("correct-Horse-9")
+		require.NoError(t, err)
+		assert.Empty(t, violations)
+	})
+}
@@ -0,0 +1,122 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"server-go/models"
+
+	"github.com/lib/pq"
+)
+
+// AccessTokenRepository stores personal access tokens. FindByHash and
+// Touch serve authentication, which happens before a tenant is known; the
+// rest is scoped to the tenant in the context.
+type AccessTokenRepository interface {
+	Create(ctx context.Context, token *models.AccessToken, tokenHash string) (*models.AccessToken, error)
+	FindByHash(ctx context.Context, tokenHash string) (*models.AccessToken, error)
+	ListForUser(ctx context.Context, userId int) ([]models.AccessToken, error)
+	Revoke(ctx context.Context, userId int, id int) (bool, error)
+	// Touch records a use, at most once a minute per token.
+	Touch(ctx context.Context, id int) error
+}
+
+type accessTokenRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewAccessTokenRepository(DB *sql.DB) AccessTokenRepository {
+	return &accessTokenRepositoryImpl{DB: DB}
+}
+
+const accessTokenColumns = "id, user_id, tenant_id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at"
+
+func scanAccessToken(row rowScanner) (*models.AccessToken, error) {
+	token := &models.AccessToken{}
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&token.Id, &token.UserId, &token.TenantId, &token.Name, &token.Prefix, pq.Array(&token.Scopes),
+		&token.ExpiresAt, &lastUsedAt, &revokedAt, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return token, nil
+}
+
+func (r *accessTokenRepositoryImpl) Create(ctx context.Context, token *models.AccessToken, tokenHash string) (*models.AccessToken, error) {
+	query := `INSERT INTO access_tokens (tenant_id, user_id, name, prefix, token_hash, scopes, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING ` + accessTokenColumns
+
+	var created *models.AccessToken
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		var err error
+		created, err = scanAccessToken(tx.QueryRowContext(ctx, query, tenantId, token.UserId, token.Name, token.Prefix,
+			tokenHash, pq.Array(token.Scopes), token.ExpiresAt))
+		return err
+	})
+	return created, err
+}
+
+func (r *accessTokenRepositoryImpl) FindByHash(ctx context.Context, tokenHash string) (*models.AccessToken, error) {
+	token, err := scanAccessToken(r.DB.QueryRowContext(ctx, "SELECT "+accessTokenColumns+" FROM access_tokens WHERE token_hash = $1", tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return token, err
+}
+
+func (r *accessTokenRepositoryImpl) ListForUser(ctx context.Context, userId int) ([]models.AccessToken, error) {
+	query := "SELECT " + accessTokenColumns + ` FROM access_tokens
+        WHERE tenant_id = $1 AND user_id = $2 AND revoked_at IS NULL
+        ORDER BY created_at DESC`
+
+	tokens := []models.AccessToken{}
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		rows, err := tx.QueryContext(ctx, query, tenantId, userId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			token, err := scanAccessToken(rows)
+			if err != nil {
+				return err
+			}
+			tokens = append(tokens, *token)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (r *accessTokenRepositoryImpl) Revoke(ctx context.Context, userId int, id int) (bool, error) {
+	query := `UPDATE access_tokens SET revoked_at = now()
+        WHERE tenant_id = $1 AND user_id = $2 AND id = $3 AND revoked_at IS NULL`
+
+	var n int64
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		res, err := tx.ExecContext(ctx, query, tenantId, userId, id)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return n > 0, err
+}
+
+func (r *accessTokenRepositoryImpl) Touch(ctx context.Context, id int) error {
+	query := `UPDATE access_tokens SET last_used_at = now()
+        WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`
+	_, err := r.DB.ExecContext(ctx, query, id)
+	return err
+}
@@ -0,0 +1,295 @@
+package repositories
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"server-go/models"
+	"strings"
+	"time"
+)
+
+type AuditRepository interface {
+	Append(ctx context.Context, event *models.AuditEvent) error
+	List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, int, error)
+	Each(ctx context.Context, filter models.AuditFilter, fn func(models.AuditEvent) error) error
+	// Verify walks the tenant's hash chain and returns the id of the first event whose
+	// hash does not match, or 0 when the chain is intact.
+	Verify(ctx context.Context) (int64, error)
+}
+
+type auditRepositoryImpl struct {
+	DB        *sql.DB
+	hashChain bool
+	logger    *slog.Logger
+}
+
+// NewAuditRepository stores audit events; with hashChain every row carries
+// the SHA-256 of its predecessor so edits made behind the app's back show up.
+func NewAuditRepository(DB *sql.DB, hashChain bool, logger *slog.Logger) AuditRepository {
+	return &auditRepositoryImpl{DB: DB, hashChain: hashChain, logger: logger}
+}
+
+const auditColumns = "id, occurred_at, actor_id, target_id, action, ip, user_agent, changes, details, prev_hash, hash"
+
+func (r *auditRepositoryImpl) Append(ctx context.Context, event *models.AuditEvent) error {
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+	// Postgres keeps microseconds; hash what will be read back.
+	event.OccurredAt = event.OccurredAt.UTC().Truncate(time.Microsecond)
+	if len(event.Changes) == 0 {
+		event.Changes = nil
+	}
+	if len(event.Details) == 0 {
+		event.Details = nil
+	}
+
+	changes, err := nullableJSON(event.Changes)
+	if err != nil {
+		return err
+	}
+	details, err := nullableJSON(event.Details)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO audit_events (tenant_id, occurred_at, actor_id, target_id, action, ip, user_agent, changes, details, prev_hash, hash)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+        RETURNING id`
+
+	err = inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		if r.hashChain {
+			// Each tenant has its own chain; serialize its writers so every
+			// event links to exactly one predecessor.
+			if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('audit_events'), $1)", tenantId); err != nil {
+				return err
+			}
+			var prev sql.NullString
+			err := tx.QueryRowContext(ctx, "SELECT hash FROM audit_events WHERE tenant_id = $1 AND hash IS NOT NULL ORDER BY id DESC LIMIT 1", tenantId).Scan(&prev)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			prevHash := prev.String
+			event.PrevHash = &prevHash
+			hash, err := AuditHash(prevHash, event)
+			if err != nil {
+				return err
+			}
+			event.Hash = &hash
+		}
+
+		return tx.QueryRowContext(ctx, query,
+			tenantId, event.OccurredAt, event.ActorId, event.TargetId, event.Action, event.IP, event.UserAgent,
+			changes, details, event.PrevHash, event.Hash,
+		).Scan(&event.Id)
+	})
+	if err != nil {
+		r.logger.ErrorContext(ctx, "error appending audit event", "action", event.Action, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (r *auditRepositoryImpl) List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, int, error) {
+	var total int
+	events := []models.AuditEvent{}
+
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		where, args := auditWhere(tenantId, filter)
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_events"+where, args...).Scan(&total); err != nil {
+			return err
+		}
+
+		query := "SELECT " + auditColumns + " FROM audit_events" + where + " ORDER BY id DESC"
+		if filter.Limit > 0 {
+			args = append(args, filter.Limit)
+			query += fmt.Sprintf(" LIMIT $%d", len(args))
+		}
+		if filter.Offset > 0 {
+			args = append(args, filter.Offset)
+			query += fmt.Sprintf(" OFFSET $%d", len(args))
+		}
+
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			event, err := scanAuditEvent(rows)
+			if err != nil {
+				return err
+			}
+			events = append(events, *event)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+func (r *auditRepositoryImpl) Each(ctx context.Context, filter models.AuditFilter, fn func(models.AuditEvent) error) error {
+	return inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		where, args := auditWhere(tenantId, filter)
+		rows, err := tx.QueryContext(ctx, "SELECT "+auditColumns+" FROM audit_events"+where+" ORDER BY id ASC", args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			event, err := scanAuditEvent(rows)
+			if err != nil {
+				return err
+			}
+			if err := fn(*event); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
+func (r *auditRepositoryImpl) Verify(ctx context.Context) (int64, error) {
+	var broken int64
+	prev := ""
+	err := r.Each(ctx, models.AuditFilter{}, func(event models.AuditEvent) error {
+		if broken != 0 || event.Hash == nil {
+			return nil
+		}
+		expected, err := AuditHash(prev, &event)
+		if err != nil {
+			return err
+		}
+		if event.PrevHash == nil || *event.PrevHash != prev || *event.Hash != expected {
+			broken = event.Id
+			return nil
+		}
+		prev = *event.Hash
+		return nil
+	})
+	return broken, err
+}
+
+// AuditHash is the chain link for event: SHA-256 over the previous hash and
+// the event's content, excluding its id.
+func AuditHash(prevHash string, event *models.AuditEvent) (string, error) {
+	payload, err := json.Marshal(struct {
+		OccurredAt string                        `json:"occurredAt"`
+		ActorId    *int                          `json:"actorId"`
+		TargetId   *int                          `json:"targetId"`
+		Action     string                        `json:"action"`
+		IP         string                        `json:"ip"`
+		UserAgent  string                        `json:"userAgent"`
+		Changes    map[string]models.FieldChange `json:"changes"`
+		Details    map[string]string             `json:"details"`
+	}{
+		OccurredAt: event.OccurredAt.UTC().Format(time.RFC3339Nano),
+		ActorId:    event.ActorId,
+		TargetId:   event.TargetId,
+		Action:     event.Action,
+		IP:         event.IP,
+		UserAgent:  event.UserAgent,
+		Changes:    event.Changes,
+		Details:    event.Details,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(prevHash+"|"), payload...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func auditWhere(tenantId int, filter models.AuditFilter) (string, []any) {
+	conds := []string{"tenant_id = $1"}
+	args := []any{tenantId}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.ActorId != nil {
+		add("actor_id = $%d", *filter.ActorId)
+	}
+	if filter.TargetId != nil {
+		add("target_id = $%d", *filter.TargetId)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.Since != nil {
+		add("occurred_at >= $%d", *filter.Since)
+	}
+	if filter.Until != nil {
+		add("occurred_at < $%d", *filter.Until)
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAuditEvent(row rowScanner) (*models.AuditEvent, error) {
+	event := &models.AuditEvent{}
+	var actorId, targetId sql.NullInt64
+	var changes, details []byte
+	var prevHash, hash sql.NullString
+
+	err := row.Scan(
+		&event.Id,
+		&event.OccurredAt,
+		&actorId,
+		&targetId,
+		&event.Action,
+		&event.IP,
+		&event.UserAgent,
+		&changes,
+		&details,
+		&prevHash,
+		&hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	event.OccurredAt = event.OccurredAt.UTC()
+	if actorId.Valid {
+		id := int(actorId.Int64)
+		event.ActorId = &id
+	}
+	if targetId.Valid {
+		id := int(targetId.Int64)
+		event.TargetId = &id
+	}
+	if len(changes) > 0 {
+		if err := json.Unmarshal(changes, &event.Changes); err != nil {
+			return nil, err
+		}
+	}
+	if len(details) > 0 {
+		if err := json.Unmarshal(details, &event.Details); err != nil {
+			return nil, err
+		}
+	}
+	if prevHash.Valid {
+		event.PrevHash = &prevHash.String
+	}
+	if hash.Valid {
+		event.Hash = &hash.String
+	}
+	return event, nil
+}
+
+func nullableJSON[T any](m map[string]T) ([]byte, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
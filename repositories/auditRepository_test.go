@@ -0,0 +1,97 @@
+package repositories
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"server-go/logging"
+	"server-go/models"
+	"server-go/tenancy"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditHashSurvivesStorageRoundTrip(t *testing.T) {
+	actor := 7
+	event := &models.AuditEvent{
+		OccurredAt: time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC),
+		ActorId:    &actor,
+		TargetId:   &actor,
+		Action:     models.AuditUpdate,
+		IP:         "10.0.0.1",
+		UserAgent:  "curl/8.0",
+		Changes:    map[string]models.FieldChange{"name": {Old: "John", New: "Jon"}, "role": {Old: "user", New: "admin"}},
+	}
+	hash, err := AuditHash("", event)
+	assert.NoError(t, err)
+
+	// Changes come back from JSONB with keys reordered and values as any.
+	raw, _ := json.Marshal(event.Changes)
+	var stored models.AuditEvent = *event
+	stored.Changes = nil
+	assert.NoError(t, json.Unmarshal(raw, &stored.Changes))
+
+	again, err := AuditHash("", &stored)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, again)
+
+	tampered := stored
+	tampered.Changes = map[string]models.FieldChange{"name": {Old: "John", New: "Jane"}}
+	forged, _ := AuditHash("", &tampered)
+	assert.NotEqual(t, hash, forged)
+
+	chained, _ := AuditHash(hash, event)
+	assert.NotEqual(t, hash, chained)
+}
+
+func TestAuditVerifyDetectsBrokenChain(t *testing.T) {
+	actor := 7
+	var events []models.AuditEvent
+	prev := ""
+	for i, action := range []string{models.AuditRegister, models.AuditLogin, models.AuditUpdate} {
+		event := models.AuditEvent{
+			Id:         int64(i + 1),
+			OccurredAt: time.Date(2024, 5, 1, 12, i, 0, 0, time.UTC),
+			ActorId:    &actor,
+			TargetId:   &actor,
+			Action:     action,
+			IP:         "10.0.0.1",
+		}
+		hash, err := AuditHash(prev, &event)
+		require.NoError(t, err)
+		prevHash := prev
+		event.PrevHash, event.Hash = &prevHash, &hash
+		events = append(events, event)
+		prev = hash
+	}
+
+	db, rec := newRecordingDB(t)
+	repo := NewAuditRepository(db, true, logging.Discard())
+	verify := func(events []models.AuditEvent) int64 {
+		rec.rows = func(query string) [][]driver.Value {
+			var rows [][]driver.Value
+			for _, e := range events {
+				rows = append(rows, []driver.Value{
+					e.Id, e.OccurredAt, int64(*e.ActorId), int64(*e.TargetId), e.Action, e.IP, e.UserAgent,
+					nil, nil, *e.PrevHash, *e.Hash,
+				})
+			}
+			return rows
+		}
+		broken, err := repo.Verify(tenancy.WithTenant(context.Background(), 3))
+		require.NoError(t, err)
+		return broken
+	}
+
+	assert.Zero(t, verify(events), "the chain is intact")
+
+	edited := append([]models.AuditEvent(nil), events...)
+	edited[1].Action = models.AuditLogout
+	assert.EqualValues(t, 2, verify(edited), "an edited event no longer matches its hash")
+
+	removed := []models.AuditEvent{events[0], events[2]}
+	assert.EqualValues(t, 3, verify(removed), "the event after a deleted one links to a missing hash")
+}
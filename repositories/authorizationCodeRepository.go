@@ -0,0 +1,48 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"server-go/models"
+)
+
+// AuthorizationCodeRepository is not tenant-scoped because the token
+// endpoint has no tenant; each code records the one it was issued in.
+type AuthorizationCodeRepository interface {
+	Create(ctx context.Context, code *models.AuthorizationCode) error
+	// Consume marks an unused, unexpired code as used and returns it.
+	Consume(ctx context.Context, codeHash string) (*models.AuthorizationCode, error)
+}
+
+type authorizationCodeRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewAuthorizationCodeRepository(DB *sql.DB) AuthorizationCodeRepository {
+	return &authorizationCodeRepositoryImpl{DB: DB}
+}
+
+func (r *authorizationCodeRepositoryImpl) Create(ctx context.Context, code *models.AuthorizationCode) error {
+	query := `INSERT INTO oauth_codes (code_hash, client_id, tenant_id, user_id, redirect_uri, scope, nonce, code_challenge, auth_time, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err := r.DB.ExecContext(ctx, query, code.CodeHash, code.ClientId, code.TenantId, code.UserId, code.RedirectURI,
+		code.Scope, code.Nonce, code.CodeChallenge, code.AuthTime, code.ExpiresAt)
+	return err
+}
+
+func (r *authorizationCodeRepositoryImpl) Consume(ctx context.Context, codeHash string) (*models.AuthorizationCode, error) {
+	query := `UPDATE oauth_codes SET used_at = now()
+        WHERE code_hash = $1 AND used_at IS NULL AND expires_at > now()
+        RETURNING code_hash, client_id, tenant_id, user_id, redirect_uri, scope, nonce, code_challenge, auth_time, expires_at`
+
+	code := &models.AuthorizationCode{}
+	err := r.DB.QueryRowContext(ctx, query, codeHash).Scan(&code.CodeHash, &code.ClientId, &code.TenantId, &code.UserId,
+		&code.RedirectURI, &code.Scope, &code.Nonce, &code.CodeChallenge, &code.AuthTime, &code.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return code, nil
+}
@@ -0,0 +1,168 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"server-go/cache"
+	"server-go/models"
+	"server-go/tenancy"
+)
+
+// cachedUserRepository reads users through a cache. Writes evict the user
+// right away, or, inside a unit of work, once it commits. A lookup racing a
+// write can still put back the old record; ttl bounds how long it stays.
+type cachedUserRepository struct {
+	UserRepository
+	cache  cache.Cache
+	ttl    time.Duration
+	group  *cache.Group
+	logger *slog.Logger
+	// pending collects the keys to evict when the transaction commits; nil
+	// outside a unit of work.
+	pending *[]string
+}
+
+// NewCachedUserRepository caches FindByID of next for ttl. The records hold
+// password hashes, so a shared cache needs the same care as the database.
+func NewCachedUserRepository(next UserRepository, c cache.Cache, ttl time.Duration, logger *slog.Logger) UserRepository {
+	return &cachedUserRepository{UserRepository: next, cache: c, ttl: ttl, group: &cache.Group{}, logger: logger}
+}
+
+func userCacheKey(tenantId int, id int) string {
+	return fmt.Sprintf("users:%d:%d", tenantId, id)
+}
+
+func (r *cachedUserRepository) FindByID(ctx context.Context, id int) (*models.User, error) {
+	tenantId, ok := tenancy.TenantID(ctx)
+	// A transaction must see its own writes
+	if !ok || r.pending != nil || r.ttl <= 0 {
+		return r.UserRepository.FindByID(ctx, id)
+	}
+	key := userCacheKey(tenantId, id)
+
+	data, hit, err := r.cache.Get(ctx, key)
+	if err != nil {
+		r.logger.WarnContext(ctx, "user cache unavailable", "error", err)
+	}
+	if hit {
+		var user models.User
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&user); err == nil {
+			return &user, nil
+		}
+		r.logger.WarnContext(ctx, "dropping unreadable user cache entry", "key", key)
+	}
+
+	value, err, _ := r.group.Do(key, func() (any, error) {
+		user, err := r.UserRepository.FindByID(ctx, id)
+		if err != nil || user == nil {
+			return user, err
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(user); err != nil {
+			return nil, err
+		}
+		if err := r.cache.Set(ctx, key, buf.Bytes(), r.ttl); err != nil {
+			r.logger.WarnContext(ctx, "could not cache user", "error", err)
+		}
+		return user, nil
+	})
+	user, _ := value.(*models.User)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	// Callers sharing a lookup each get their own copy
+	found := *user
+	return &found, nil
+}
+
+// invalidate evicts user id of the context's tenant.
+func (r *cachedUserRepository) invalidate(ctx context.Context, id int) {
+	tenantId, ok := tenancy.TenantID(ctx)
+	if !ok {
+		return
+	}
+	key := userCacheKey(tenantId, id)
+	if r.pending != nil {
+		*r.pending = append(*r.pending, key)
+		return
+	}
+	evict(ctx, r.cache, r.logger, key)
+}
+
+func evict(ctx context.Context, c cache.Cache, logger *slog.Logger, keys ...string) {
+	if err := c.Delete(context.WithoutCancel(ctx), keys...); err != nil {
+		logger.ErrorContext(ctx, "could not evict users from the cache", "keys", keys, "error", err)
+	}
+}
+
+func (r *cachedUserRepository) UpdateUser(ctx context.Context, user *models.User) (*models.User, error) {
+	defer r.invalidate(ctx, user.Id)
+	return r.UserRepository.UpdateUser(ctx, user)
+}
+
+func (r *cachedUserRepository) SetRole(ctx context.Context, id int, role string) (*models.User, error) {
+	defer r.invalidate(ctx, id)
+	return r.UserRepository.SetRole(ctx, id, role)
+}
+
+func (r *cachedUserRepository) SetPassword(ctx context.Context, id int, password string) error {
+	defer r.invalidate(ctx, id)
+	return r.UserRepository.SetPassword(ctx, id, password)
+}
+
+func (r *cachedUserRepository) SetEmail(ctx context.Context, id int, email string) (*models.User, error) {
+	defer r.invalidate(ctx, id)
+	return r.UserRepository.SetEmail(ctx, id, email)
+}
+
+func (r *cachedUserRepository) UpgradePasswordHash(ctx context.Context, id int, oldHash string, newHash string) error {
+	defer r.invalidate(ctx, id)
+	return r.UserRepository.UpgradePasswordHash(ctx, id, oldHash, newHash)
+}
+
+func (r *cachedUserRepository) SetStatus(ctx context.Context, id int, current string, change models.StatusChange) (*models.User, error) {
+	defer r.invalidate(ctx, id)
+	return r.UserRepository.SetStatus(ctx, id, current, change)
+}
+
+func (r *cachedUserRepository) RevokeTokens(ctx context.Context, id int) error {
+	defer r.invalidate(ctx, id)
+	return r.UserRepository.RevokeTokens(ctx, id)
+}
+
+func (r *cachedUserRepository) DeleteUser(ctx context.Context, id int) (bool, error) {
+	defer r.invalidate(ctx, id)
+	return r.UserRepository.DeleteUser(ctx, id)
+}
+
+type cachingTransactor struct {
+	Transactor
+	cache  cache.Cache
+	logger *slog.Logger
+}
+
+// NewCachingTransactor makes the user repository of every unit of work
+// evict what it changes from c once the work commits. Use it with the
+// cache given to NewCachedUserRepository.
+func NewCachingTransactor(next Transactor, c cache.Cache, logger *slog.Logger) Transactor {
+	return &cachingTransactor{Transactor: next, cache: c, logger: logger}
+}
+
+func (t *cachingTransactor) WithTx(ctx context.Context, fn func(repos Repositories) error) error {
+	var pending []string
+	err := t.Transactor.WithTx(ctx, func(repos Repositories) error {
+		// A retried attempt starts over
+		pending = pending[:0]
+		repos.Users = &cachedUserRepository{UserRepository: repos.Users, cache: t.cache, logger: t.logger, pending: &pending}
+		return fn(repos)
+	})
+	if err == nil && len(pending) > 0 {
+		evict(ctx, t.cache, t.logger, pending...)
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+package repositories
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"server-go/cache"
+	"server-go/logging"
+	"server-go/models"
+	"server-go/tenancy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingUsers is the database behind the cache; it counts lookups.
+type countingUsers struct {
+	UserRepository
+	mu      sync.Mutex
+	users   map[int]models.User
+	lookups atomic.Int32
+}
+
+func (r *countingUsers) FindByID(ctx context.Context, id int) (*models.User, error) {
+	r.lookups.Add(1)
+	// Slow enough for concurrent misses to overlap
+	time.Sleep(5 * time.Millisecond)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (r *countingUsers) UpdateUser(ctx context.Context, user *models.User) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[user.Id] = *user
+	return user, nil
+}
+
+func (r *countingUsers) SetStatus(ctx context.Context, id int, current string, change models.StatusChange) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := r.users[id]
+	user.Status = change.To
+	r.users[id] = user
+	return &user, nil
+}
+
+func (r *countingUsers) DeleteUser(ctx context.Context, id int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.users, id)
+	return true, nil
+}
+
+// passThrough runs units of work without a database.
+type passThrough struct {
+	users UserRepository
+	fail  error
+}
+
+func (t passThrough) WithTx(ctx context.Context, fn func(repos Repositories) error) error {
+	if err := fn(Repositories{Users: t.users}); err != nil {
+		return err
+	}
+	return t.fail
+}
+
+func TestCachedUserRepository(t *testing.T) {
+	passwordChanged := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	db := &countingUsers{users: map[int]models.User{
+		1: {Id: 1, Name: "Jane", Email: "jane@example.com", Password: "hash", TenantId: 3, Status: models.StatusActive, PasswordChangedAt: passwordChanged},
+	}}
+	backend := cache.NewLRU(100)
+	users := NewCachedUserRepository(db, backend, time.Minute, logging.Discard())
+	ctx := tenancy.WithTenant(context.Background(), 3)
+
+	t.Run("concurrent misses reach the database once", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				user, err := users.FindByID(ctx, 1)
+				assert.NoError(t, err)
+				assert.Equal(t, "Jane", user.Name)
+			}()
+		}
+		wg.Wait()
+		assert.EqualValues(t, 1, db.lookups.Load())
+
+		user, err := users.FindByID(ctx, 1)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, db.lookups.Load(), "served from the cache")
+		assert.Equal(t, passwordChanged, user.PasswordChangedAt, "fields hidden from JSON survive the cache")
+		assert.Equal(t, "hash", user.Password)
+	})
+
+	t.Run("tenants do not share entries", func(t *testing.T) {
+		_, err := users.FindByID(tenancy.WithTenant(context.Background(), 4), 1)
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, db.lookups.Load(), "another tenant's lookup misses")
+	})
+
+	t.Run("writes evict", func(t *testing.T) {
+		_, err := users.UpdateUser(ctx, &models.User{Id: 1, Name: "Janet", TenantId: 3, Status: models.StatusActive})
+		require.NoError(t, err)
+		user, _ := users.FindByID(ctx, 1)
+		assert.Equal(t, "Janet", user.Name)
+
+		_, err = users.SetStatus(ctx, 1, models.StatusActive, models.StatusChange{To: models.StatusSuspended})
+		require.NoError(t, err)
+		user, _ = users.FindByID(ctx, 1)
+		assert.Equal(t, models.StatusSuspended, user.Status)
+
+		_, err = users.DeleteUser(ctx, 1)
+		require.NoError(t, err)
+		user, _ = users.FindByID(ctx, 1)
+		assert.Nil(t, user)
+	})
+
+	t.Run("units of work evict once they commit", func(t *testing.T) {
+		db.users[2] = models.User{Id: 2, Name: "Jim", TenantId: 3}
+		_, err := users.FindByID(ctx, 2)
+		require.NoError(t, err)
+
+		failed := NewCachingTransactor(passThrough{users: db, fail: assert.AnError}, backend, logging.Discard())
+		err = failed.WithTx(ctx, func(repos Repositories) error {
+			_, err := repos.Users.UpdateUser(ctx, &models.User{Id: 2, Name: "rolled back", TenantId: 3})
+			return err
+		})
+		require.Error(t, err)
+		_, cached, _ := backend.Get(ctx, userCacheKey(3, 2))
+		assert.True(t, cached, "nothing is evicted when the work does not commit")
+
+		tx := NewCachingTransactor(passThrough{users: db}, backend, logging.Discard())
+		err = tx.WithTx(ctx, func(repos Repositories) error {
+			if _, err := repos.Users.UpdateUser(ctx, &models.User{Id: 2, Name: "James", TenantId: 3}); err != nil {
+				return err
+			}
+			user, err := repos.Users.FindByID(ctx, 2)
+			require.NoError(t, err)
+			assert.Equal(t, "James", user.Name, "reads inside the work skip the cache")
+			_, cached, _ := backend.Get(ctx, userCacheKey(3, 2))
+			assert.True(t, cached, "evicted only after commit")
+			return nil
+		})
+		require.NoError(t, err)
+		user, _ := users.FindByID(ctx, 2)
+		assert.Equal(t, "James", user.Name)
+	})
+}
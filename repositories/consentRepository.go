@@ -0,0 +1,51 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"server-go/models"
+
+	"github.com/lib/pq"
+)
+
+// ConsentRepository is scoped to the tenant in the context.
+type ConsentRepository interface {
+	Find(ctx context.Context, userId int, clientId string) (*models.Consent, error)
+	// Grant replaces the scopes the user allowed the client.
+	Grant(ctx context.Context, userId int, clientId string, scopes []string) error
+}
+
+type consentRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewConsentRepository(DB *sql.DB) ConsentRepository {
+	return &consentRepositoryImpl{DB: DB}
+}
+
+func (r *consentRepositoryImpl) Find(ctx context.Context, userId int, clientId string) (*models.Consent, error) {
+	query := `SELECT user_id, client_id, scopes, granted_at FROM oauth_consents
+        WHERE tenant_id = $1 AND user_id = $2 AND client_id = $3`
+
+	consent := &models.Consent{}
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		return tx.QueryRowContext(ctx, query, tenantId, userId, clientId).
+			Scan(&consent.UserId, &consent.ClientId, pq.Array(&consent.Scopes), &consent.GrantedAt)
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return consent, nil
+}
+
+func (r *consentRepositoryImpl) Grant(ctx context.Context, userId int, clientId string, scopes []string) error {
+	query := `INSERT INTO oauth_consents (tenant_id, user_id, client_id, scopes) VALUES ($1, $2, $3, $4)
+        ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = EXCLUDED.scopes, granted_at = now()`
+	return inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		_, err := tx.ExecContext(ctx, query, tenantId, userId, clientId, pq.Array(scopes))
+		return err
+	})
+}
@@ -0,0 +1,58 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// EmailChangeRepository records requested email address changes, scoped to
+// the tenant in the context.
+type EmailChangeRepository interface {
+	// Create records a change of userId's address to newEmail. Earlier
+	// pending requests of the user stop being valid.
+	Create(ctx context.Context, userId int, newEmail string, expiresAt time.Time) (int, error)
+	// Consume marks an unused, unexpired request as used and returns its
+	// user and new address.
+	Consume(ctx context.Context, id int) (userId int, newEmail string, ok bool, err error)
+}
+
+type emailChangeRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewEmailChangeRepository(DB *sql.DB) EmailChangeRepository {
+	return &emailChangeRepositoryImpl{DB: DB}
+}
+
+func (r *emailChangeRepositoryImpl) Create(ctx context.Context, userId int, newEmail string, expiresAt time.Time) (int, error) {
+	var id int
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		expire := `UPDATE email_changes SET expires_at = now() WHERE tenant_id = $1 AND user_id = $2 AND used_at IS NULL AND expires_at > now()`
+		if _, err := tx.ExecContext(ctx, expire, tenantId, userId); err != nil {
+			return err
+		}
+		query := `INSERT INTO email_changes (tenant_id, user_id, new_email, expires_at) VALUES ($1, $2, $3, $4) RETURNING id`
+		return tx.QueryRowContext(ctx, query, tenantId, userId, newEmail, expiresAt).Scan(&id)
+	})
+	return id, err
+}
+
+func (r *emailChangeRepositoryImpl) Consume(ctx context.Context, id int) (int, string, bool, error) {
+	query := `UPDATE email_changes SET used_at = now()
+        WHERE tenant_id = $1 AND id = $2 AND used_at IS NULL AND expires_at > now()
+        RETURNING user_id, new_email`
+
+	var userId int
+	var newEmail string
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		return tx.QueryRowContext(ctx, query, tenantId, id).Scan(&userId, &newEmail)
+	})
+	if err == sql.ErrNoRows {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+	return userId, newEmail, true, nil
+}
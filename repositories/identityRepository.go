@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"server-go/models"
+)
+
+// IdentityRepository is scoped to the tenant in the context; the same
+// external account may be linked in several organizations.
+type IdentityRepository interface {
+	Find(ctx context.Context, provider string, subject string) (*models.Identity, error)
+	Link(ctx context.Context, userId int, provider string, subject string, email string) (*models.Identity, error)
+	// Touch records a sign-in through the identity.
+	Touch(ctx context.Context, id int) error
+}
+
+type identityRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewIdentityRepository(DB *sql.DB) IdentityRepository {
+	return &identityRepositoryImpl{DB: DB}
+}
+
+const identityColumns = "id, user_id, provider, subject, email, created_at, last_login_at"
+
+func scanIdentity(row rowScanner) (*models.Identity, error) {
+	identity := &models.Identity{}
+	var lastLoginAt sql.NullTime
+	err := row.Scan(&identity.Id, &identity.UserId, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt, &lastLoginAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastLoginAt.Valid {
+		identity.LastLoginAt = &lastLoginAt.Time
+	}
+	return identity, nil
+}
+
+func (r *identityRepositoryImpl) Find(ctx context.Context, provider string, subject string) (*models.Identity, error) {
+	query := "SELECT " + identityColumns + " FROM identities WHERE tenant_id = $1 AND provider = $2 AND subject = $3"
+
+	var identity *models.Identity
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		var err error
+		identity, err = scanIdentity(tx.QueryRowContext(ctx, query, tenantId, provider, subject))
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return identity, err
+}
+
+func (r *identityRepositoryImpl) Link(ctx context.Context, userId int, provider string, subject string, email string) (*models.Identity, error) {
+	query := `INSERT INTO identities (tenant_id, user_id, provider, subject, email) VALUES ($1, $2, $3, $4, $5)
+        RETURNING ` + identityColumns
+
+	var identity *models.Identity
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		var err error
+		identity, err = scanIdentity(tx.QueryRowContext(ctx, query, tenantId, userId, provider, subject, email))
+		return err
+	})
+	return identity, err
+}
+
+func (r *identityRepositoryImpl) Touch(ctx context.Context, id int) error {
+	query := `UPDATE identities SET last_login_at = now() WHERE tenant_id = $1 AND id = $2`
+	return inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		_, err := tx.ExecContext(ctx, query, tenantId, id)
+		return err
+	})
+}
@@ -0,0 +1,87 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"server-go/models"
+	"time"
+)
+
+type InvitationRepository interface {
+	Create(ctx context.Context, orgId int, email string, role string, invitedBy int, expiresAt time.Time) (*models.Invitation, error)
+	Find(ctx context.Context, id int) (*models.Invitation, error)
+	// MarkAccepted succeeds only once per invitation.
+	MarkAccepted(ctx context.Context, id int, userId int) (bool, error)
+	ListPending(ctx context.Context, orgId int) ([]models.Invitation, error)
+}
+
+type invitationRepositoryImpl struct {
+	DB DBTX
+}
+
+func NewInvitationRepository(DB *sql.DB) InvitationRepository {
+	return &invitationRepositoryImpl{DB: DB}
+}
+
+const invitationColumns = "id, organization_id, email, role, invited_by, expires_at, accepted_at, created_at"
+
+func scanInvitation(row rowScanner) (*models.Invitation, error) {
+	inv := &models.Invitation{}
+	var acceptedAt sql.NullTime
+	err := row.Scan(&inv.Id, &inv.OrganizationId, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.ExpiresAt, &acceptedAt, &inv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if acceptedAt.Valid {
+		inv.AcceptedAt = &acceptedAt.Time
+	}
+	return inv, nil
+}
+
+func (r *invitationRepositoryImpl) Create(ctx context.Context, orgId int, email string, role string, invitedBy int, expiresAt time.Time) (*models.Invitation, error) {
+	query := `INSERT INTO organization_invitations (organization_id, email, role, invited_by, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING ` + invitationColumns
+	return scanInvitation(r.DB.QueryRowContext(ctx, query, orgId, email, role, invitedBy, expiresAt))
+}
+
+func (r *invitationRepositoryImpl) Find(ctx context.Context, id int) (*models.Invitation, error) {
+	inv, err := scanInvitation(r.DB.QueryRowContext(ctx, "SELECT "+invitationColumns+" FROM organization_invitations WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return inv, err
+}
+
+func (r *invitationRepositoryImpl) MarkAccepted(ctx context.Context, id int, userId int) (bool, error) {
+	query := `UPDATE organization_invitations SET accepted_at = now(), accepted_by = $2
+        WHERE id = $1 AND accepted_at IS NULL AND expires_at > now()`
+	res, err := r.DB.ExecContext(ctx, query, id, userId)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *invitationRepositoryImpl) ListPending(ctx context.Context, orgId int) ([]models.Invitation, error) {
+	query := "SELECT " + invitationColumns + ` FROM organization_invitations
+        WHERE organization_id = $1 AND accepted_at IS NULL AND expires_at > now()
+        ORDER BY created_at DESC`
+
+	rows, err := r.DB.QueryContext(ctx, query, orgId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []models.Invitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *inv)
+	}
+	return invitations, rows.Err()
+}
@@ -0,0 +1,150 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"server-go/models"
+
+	"github.com/lib/pq"
+)
+
+// JobRepository is the queue behind the jobs package. It is not
+// tenant-scoped; jobs that act on a tenant name it in their arguments.
+type JobRepository interface {
+	// Enqueue adds job and fills in its id; a zero RunAt means now. It
+	// returns false, and adds nothing, when a job with the same UniqueKey is
+	// pending or running.
+	Enqueue(ctx context.Context, job *models.Job) (bool, error)
+	// EnqueueScheduled enqueues job as the run of the recurring job name
+	// due at runAt, unless that run, or a later one, was enqueued already.
+	EnqueueScheduled(ctx context.Context, name string, runAt time.Time, job *models.Job) (bool, error)
+	// Claim leases up to limit due jobs of the given kinds, counting an
+	// attempt for each. Running jobs whose lease ran out are due again.
+	Claim(ctx context.Context, kinds []string, limit int, lease time.Duration) ([]models.Job, error)
+	// Extend renews the lease of a running job.
+	Extend(ctx context.Context, id int64, lease time.Duration) error
+	// Complete, Retry and Fail settle the claim that counted attempt. They
+	// return false, and change nothing, when the lease ran out and the job
+	// was claimed again or settled since.
+	Complete(ctx context.Context, id int64, attempt int) (bool, error)
+	// Retry records a failed attempt and makes the job due again at runAt.
+	Retry(ctx context.Context, id int64, attempt int, lastErr string, runAt time.Time) (bool, error)
+	// Fail records the last attempt and keeps the job for inspection.
+	Fail(ctx context.Context, id int64, attempt int, lastErr string) (bool, error)
+}
+
+type jobRepositoryImpl struct {
+	DB DBTX
+}
+
+func NewJobRepository(DB *sql.DB) JobRepository {
+	return &jobRepositoryImpl{DB: DB}
+}
+
+func scanJob(row rowScanner) (models.Job, error) {
+	var j models.Job
+	var args []byte
+	var uniqueKey, lastErr sql.NullString
+	err := row.Scan(&j.Id, &j.Kind, &args, &uniqueKey, &j.State, &j.Attempts, &j.MaxAttempts, &j.RunAt, &lastErr, &j.CreatedAt)
+	j.Args = args
+	j.UniqueKey = uniqueKey.String
+	j.LastError = lastErr.String
+	return j, err
+}
+
+// Both enqueues share these; a job without a key stores a NULL key, which
+// never conflicts.
+const insertJob = `INSERT INTO jobs (kind, args, unique_key, max_attempts, run_at)`
+
+const onJobConflict = `ON CONFLICT (unique_key) WHERE state IN ('pending', 'running') DO NOTHING
+        RETURNING id, state, run_at, created_at`
+
+func (r *jobRepositoryImpl) Enqueue(ctx context.Context, job *models.Job) (bool, error) {
+	query := insertJob + ` VALUES ($1, $2, NULLIF($3, ''), $4, COALESCE($5, now())) ` + onJobConflict
+	return r.insert(ctx, job, query, job.Kind, []byte(job.Args), job.UniqueKey, job.MaxAttempts, sql.NullTime{Time: job.RunAt, Valid: !job.RunAt.IsZero()})
+}
+
+func (r *jobRepositoryImpl) EnqueueScheduled(ctx context.Context, name string, runAt time.Time, job *models.Job) (bool, error) {
+	query := `WITH run AS (
+            INSERT INTO job_schedules (name, last_run_at) VALUES ($6, $5)
+            ON CONFLICT (name) DO UPDATE SET last_run_at = EXCLUDED.last_run_at
+            WHERE job_schedules.last_run_at < EXCLUDED.last_run_at
+            RETURNING name
+        )
+        ` + insertJob + ` SELECT $1, $2, NULLIF($3, ''), $4, $5 FROM run ` + onJobConflict
+	return r.insert(ctx, job, query, job.Kind, []byte(job.Args), job.UniqueKey, job.MaxAttempts, runAt, name)
+}
+
+func (r *jobRepositoryImpl) insert(ctx context.Context, job *models.Job, query string, args ...any) (bool, error) {
+	err := r.DB.QueryRowContext(ctx, query, args...).Scan(&job.Id, &job.State, &job.RunAt, &job.CreatedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *jobRepositoryImpl) Claim(ctx context.Context, kinds []string, limit int, lease time.Duration) ([]models.Job, error) {
+	query := `WITH due AS (
+            SELECT id FROM jobs
+            WHERE kind = ANY($1) AND (
+                (state = 'pending' AND run_at <= now()) OR (state = 'running' AND locked_until <= now())
+            )
+            ORDER BY run_at, id LIMIT $2 FOR UPDATE SKIP LOCKED
+        )
+        UPDATE jobs SET state = 'running', attempts = attempts + 1, locked_until = now() + make_interval(secs => $3)
+        FROM due WHERE jobs.id = due.id
+        RETURNING jobs.id, jobs.kind, jobs.args, jobs.unique_key, jobs.state, jobs.attempts, jobs.max_attempts, jobs.run_at, jobs.last_error, jobs.created_at`
+
+	rows, err := r.DB.QueryContext(ctx, query, pq.Array(kinds), limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []models.Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	// RETURNING has no order
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Id < jobs[j].Id })
+	return jobs, rows.Err()
+}
+
+func (r *jobRepositoryImpl) Extend(ctx context.Context, id int64, lease time.Duration) error {
+	query := `UPDATE jobs SET locked_until = now() + make_interval(secs => $2) WHERE id = $1 AND state = 'running'`
+	_, err := r.DB.ExecContext(ctx, query, id, lease.Seconds())
+	return err
+}
+
+// A claim is held while the job runs under the attempt it counted.
+const heldJob = `id = $1 AND state = 'running' AND attempts = $2`
+
+func (r *jobRepositoryImpl) Complete(ctx context.Context, id int64, attempt int) (bool, error) {
+	return r.settle(ctx, `DELETE FROM jobs WHERE `+heldJob, id, attempt)
+}
+
+func (r *jobRepositoryImpl) Retry(ctx context.Context, id int64, attempt int, lastErr string, runAt time.Time) (bool, error) {
+	query := `UPDATE jobs SET state = 'pending', last_error = $3, run_at = $4, locked_until = NULL WHERE ` + heldJob
+	return r.settle(ctx, query, id, attempt, lastErr, runAt)
+}
+
+func (r *jobRepositoryImpl) Fail(ctx context.Context, id int64, attempt int, lastErr string) (bool, error) {
+	query := `UPDATE jobs SET state = 'failed', last_error = $3, locked_until = NULL WHERE ` + heldJob
+	return r.settle(ctx, query, id, attempt, lastErr)
+}
+
+func (r *jobRepositoryImpl) settle(ctx context.Context, query string, args ...any) (bool, error) {
+	result, err := r.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// MagicLinkRepository records email sign-in links, scoped to the tenant in
+// the context.
+type MagicLinkRepository interface {
+	// Create records a link for email unless limit links were already
+	// requested for that address within window; ok is false when the limit
+	// was hit. A limit of zero disables it.
+	Create(ctx context.Context, email string, userId *int, expiresAt time.Time, limit int, window time.Duration) (id int, ok bool, err error)
+	// Consume marks an unused, unexpired link as used and returns its user.
+	Consume(ctx context.Context, id int) (int, bool, error)
+}
+
+type magicLinkRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewMagicLinkRepository(DB *sql.DB) MagicLinkRepository {
+	return &magicLinkRepositoryImpl{DB: DB}
+}
+
+func (r *magicLinkRepositoryImpl) Create(ctx context.Context, email string, userId *int, expiresAt time.Time, limit int, window time.Duration) (int, bool, error) {
+	var id int
+	ok := true
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		if limit > 0 {
+			// Serialize requests for one address so concurrent ones cannot
+			// all pass the count
+			lock := "SELECT pg_advisory_xact_lock(hashtext('magic_links:' || $1::text || ':' || lower($2)))"
+			if _, err := tx.ExecContext(ctx, lock, tenantId, email); err != nil {
+				return err
+			}
+			var recent int
+			query := `SELECT COUNT(*) FROM magic_links WHERE tenant_id = $1 AND lower(email) = lower($2) AND created_at > $3`
+			if err := tx.QueryRowContext(ctx, query, tenantId, email, time.Now().Add(-window)).Scan(&recent); err != nil {
+				return err
+			}
+			if recent >= limit {
+				ok = false
+				return nil
+			}
+		}
+		query := `INSERT INTO magic_links (tenant_id, user_id, email, expires_at) VALUES ($1, $2, $3, $4) RETURNING id`
+		return tx.QueryRowContext(ctx, query, tenantId, userId, email, expiresAt).Scan(&id)
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return id, ok, nil
+}
+
+func (r *magicLinkRepositoryImpl) Consume(ctx context.Context, id int) (int, bool, error) {
+	query := `UPDATE magic_links SET used_at = now()
+        WHERE tenant_id = $1 AND id = $2 AND user_id IS NOT NULL AND used_at IS NULL AND expires_at > now()
+        RETURNING user_id`
+
+	var userId int
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		return tx.QueryRowContext(ctx, query, tenantId, id).Scan(&userId)
+	})
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return userId, true, nil
+}
@@ -0,0 +1,124 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"server-go/models"
+)
+
+// MembershipRepository spans organizations by design; callers pass the
+// organization explicitly. ListMembers runs inside that organization's
+// tenant scope because it reads the users table.
+type MembershipRepository interface {
+	Find(ctx context.Context, userId int, orgId int) (*models.Membership, error)
+	Add(ctx context.Context, userId int, orgId int, role string) (*models.Membership, error)
+	SetRole(ctx context.Context, userId int, orgId int, role string) (*models.Membership, error)
+	CountOwners(ctx context.Context, orgId int) (int, error)
+	ListForUser(ctx context.Context, userId int) ([]models.Membership, error)
+	ListMembers(ctx context.Context, orgId int) ([]models.Membership, error)
+}
+
+type membershipRepositoryImpl struct {
+	DB DBTX
+}
+
+func NewMembershipRepository(DB *sql.DB) MembershipRepository {
+	return &membershipRepositoryImpl{DB: DB}
+}
+
+func (r *membershipRepositoryImpl) findOne(ctx context.Context, query string, args ...any) (*models.Membership, error) {
+	m := &models.Membership{}
+	err := r.DB.QueryRowContext(ctx, query, args...).Scan(&m.UserId, &m.OrganizationId, &m.Role, &m.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (r *membershipRepositoryImpl) Find(ctx context.Context, userId int, orgId int) (*models.Membership, error) {
+	query := `SELECT user_id, organization_id, role, created_at FROM memberships WHERE user_id = $1 AND organization_id = $2`
+	return r.findOne(ctx, query, userId, orgId)
+}
+
+// Add keeps an existing membership (and its role) if there is one.
+func (r *membershipRepositoryImpl) Add(ctx context.Context, userId int, orgId int, role string) (*models.Membership, error) {
+	query := `INSERT INTO memberships (user_id, organization_id, role) VALUES ($1, $2, $3)
+        ON CONFLICT (user_id, organization_id) DO NOTHING`
+	if _, err := r.DB.ExecContext(ctx, query, userId, orgId, role); err != nil {
+		return nil, err
+	}
+	return r.Find(ctx, userId, orgId)
+}
+
+func (r *membershipRepositoryImpl) SetRole(ctx context.Context, userId int, orgId int, role string) (*models.Membership, error) {
+	query := `UPDATE memberships SET role = $3 WHERE user_id = $1 AND organization_id = $2
+        RETURNING user_id, organization_id, role, created_at`
+	return r.findOne(ctx, query, userId, orgId, role)
+}
+
+func (r *membershipRepositoryImpl) CountOwners(ctx context.Context, orgId int) (int, error) {
+	var n int
+	err := r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM memberships WHERE organization_id = $1 AND role = 'owner'`, orgId).Scan(&n)
+	return n, err
+}
+
+func (r *membershipRepositoryImpl) ListForUser(ctx context.Context, userId int) ([]models.Membership, error) {
+	query := `SELECT m.user_id, m.organization_id, m.role, m.created_at, o.id, o.slug, o.name, o.created_at
+        FROM memberships m JOIN organizations o ON o.id = m.organization_id
+        WHERE m.user_id = $1
+        ORDER BY o.name`
+
+	rows, err := r.DB.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []models.Membership{}
+	for rows.Next() {
+		m := models.Membership{Organization: &models.Organization{}}
+		err := rows.Scan(&m.UserId, &m.OrganizationId, &m.Role, &m.CreatedAt,
+			&m.Organization.Id, &m.Organization.Slug, &m.Organization.Name, &m.Organization.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, m)
+	}
+	return memberships, rows.Err()
+}
+
+func (r *membershipRepositoryImpl) ListMembers(ctx context.Context, orgId int) ([]models.Membership, error) {
+	query := `SELECT m.user_id, m.organization_id, m.role, m.created_at, u.id, u.name, u.lastName, u.email, u.tenant_id
+        FROM memberships m JOIN users u ON u.id = m.user_id
+        WHERE m.organization_id = $1 AND u.deleted_at IS NULL
+        ORDER BY u.lastName, u.name`
+
+	memberships := []models.Membership{}
+	// Runs as orgId so the member_visibility policy lets us read users whose
+	// home is another organization.
+	err := inTenant(tenancyContext(ctx, orgId), r.DB, func(tx *sql.Tx, tenantId int) error {
+		rows, err := tx.QueryContext(ctx, query, tenantId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			m := models.Membership{User: &models.User{}}
+			err := rows.Scan(&m.UserId, &m.OrganizationId, &m.Role, &m.CreatedAt,
+				&m.User.Id, &m.User.Name, &m.User.LastName, &m.User.Email, &m.User.TenantId)
+			if err != nil {
+				return err
+			}
+			memberships = append(memberships, m)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
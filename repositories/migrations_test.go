@@ -0,0 +1,126 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+
+	"server-go/logging"
+	"server-go/tenancy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Migrations run in the order of their numeric prefix; a new one takes the
+// next number.
+var migrationName = regexp.MustCompile(`^(\d{4})_[a-z0-9_]+\.sql$`)
+
+func migrationFiles(t *testing.T) []string {
+	files, err := filepath.Glob(filepath.Join("..", "migrations", "*.sql"))
+	require.NoError(t, err)
+	sort.Strings(files)
+	return files
+}
+
+func TestMigrationsAreNumbered(t *testing.T) {
+	files := migrationFiles(t)
+	require.NotEmpty(t, files)
+	for i, file := range files {
+		match := migrationName.FindStringSubmatch(filepath.Base(file))
+		require.NotNil(t, match, "%s needs a NNNN_ prefix", file)
+		assert.Equal(t, fmt.Sprintf("%04d", i+1), match[1], "%s is out of sequence", file)
+	}
+}
+
+// TestRowLevelSecurity builds a database from the migrations and checks
+// that Postgres itself, not just the repositories' WHERE clauses, keeps
+// tenants apart. TEST_DATABASE_URL names a scratch database; its public
+// schema is dropped and recreated.
+func TestRowLevelSecurity(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := sql.Open("postgres", url)
+	require.NoError(t, err)
+	defer db.Close()
+	// SET ROLE below holds for this one connection
+	db.SetMaxOpenConns(1)
+	ctx := context.Background()
+
+	_, err = db.ExecContext(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+	require.NoError(t, err)
+	for _, file := range migrationFiles(t) {
+		migration, err := os.ReadFile(file)
+		require.NoError(t, err)
+		_, err = db.ExecContext(ctx, string(migration))
+		require.NoError(t, err, "applying %s", filepath.Base(file))
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO organizations (id, slug, name) VALUES (2, 'acme', 'Acme'), (3, 'globex', 'Globex')`)
+	require.NoError(t, err)
+
+	// Superusers bypass row-level security; act as an ordinary role
+	var privileged bool
+	require.NoError(t, db.QueryRowContext(ctx, `SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user`).Scan(&privileged))
+	if privileged {
+		_, err = db.ExecContext(ctx, `DO $$ BEGIN CREATE ROLE users_rls_test NOLOGIN; EXCEPTION WHEN duplicate_object THEN NULL; END $$;
+            GRANT USAGE ON SCHEMA public TO users_rls_test;
+            GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO users_rls_test;
+            GRANT USAGE ON ALL SEQUENCES IN SCHEMA public TO users_rls_test;
+            SET ROLE users_rls_test`)
+		require.NoError(t, err)
+	}
+
+	users := NewUserRepository(db, logging.Discard())
+	acme := tenancy.WithTenant(ctx, 2)
+	globex := tenancy.WithTenant(ctx, 3)
+	jane, err := users.RegisterUser(acme, "Jane", "Doe", "jane@example.com", "hash")
+	require.NoError(t, err)
+	_, err = users.RegisterUser(globex, "Jim", "Doe", "jim@example.com", "hash")
+	require.NoError(t, err)
+
+	visible := func(ctx context.Context) []string {
+		var emails []string
+		require.NoError(t, inTenant(ctx, db, func(tx *sql.Tx, _ int) error {
+			rows, err := tx.QueryContext(ctx, "SELECT email FROM users ORDER BY id")
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var email string
+				if err := rows.Scan(&email); err != nil {
+					return err
+				}
+				emails = append(emails, email)
+			}
+			return rows.Err()
+		}))
+		return emails
+	}
+	assert.Equal(t, []string{"jane@example.com"}, visible(acme), "an unfiltered query sees one tenant")
+	assert.Equal(t, []string{"jim@example.com"}, visible(globex))
+
+	var count int
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&count))
+	assert.Zero(t, count, "no tenant, no rows")
+
+	err = inTenant(globex, db, func(tx *sql.Tx, _ int) error {
+		_, err := tx.ExecContext(globex, "UPDATE users SET tenant_id = 3 WHERE id = $1", jane.Id)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"jane@example.com"}, visible(acme), "other tenants' rows cannot be changed")
+
+	err = inTenant(globex, db, func(tx *sql.Tx, _ int) error {
+		_, err := tx.ExecContext(globex, `INSERT INTO users (name, lastName, email, password, tenant_id) VALUES ('Eve', 'Doe', 'eve@example.com', 'hash', 2)`)
+		return err
+	})
+	assert.Error(t, err, "rows cannot be written into another tenant")
+}
@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"server-go/models"
+
+	"github.com/lib/pq"
+)
+
+// OAuthClientRepository is not tenant-scoped: one client serves users of
+// every organization.
+type OAuthClientRepository interface {
+	FindByClientID(ctx context.Context, clientId string) (*models.OAuthClient, error)
+	Create(ctx context.Context, client *models.OAuthClient) (*models.OAuthClient, error)
+	List(ctx context.Context) ([]models.OAuthClient, error)
+	Delete(ctx context.Context, clientId string) (bool, error)
+}
+
+type oauthClientRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewOAuthClientRepository(DB *sql.DB) OAuthClientRepository {
+	return &oauthClientRepositoryImpl{DB: DB}
+}
+
+const oauthClientColumns = "id, client_id, secret_hash, name, redirect_uris, scopes, created_at"
+
+func scanOAuthClient(row rowScanner) (*models.OAuthClient, error) {
+	client := &models.OAuthClient{}
+	err := row.Scan(&client.Id, &client.ClientId, &client.SecretHash, &client.Name,
+		pq.Array(&client.RedirectURIs), pq.Array(&client.Scopes), &client.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (r *oauthClientRepositoryImpl) FindByClientID(ctx context.Context, clientId string) (*models.OAuthClient, error) {
+	client, err := scanOAuthClient(r.DB.QueryRowContext(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients WHERE client_id = $1", clientId))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return client, err
+}
+
+func (r *oauthClientRepositoryImpl) Create(ctx context.Context, client *models.OAuthClient) (*models.OAuthClient, error) {
+	query := `INSERT INTO oauth_clients (client_id, secret_hash, name, redirect_uris, scopes) VALUES ($1, $2, $3, $4, $5)
+        RETURNING ` + oauthClientColumns
+	return scanOAuthClient(r.DB.QueryRowContext(ctx, query, client.ClientId, client.SecretHash, client.Name,
+		pq.Array(client.RedirectURIs), pq.Array(client.Scopes)))
+}
+
+func (r *oauthClientRepositoryImpl) List(ctx context.Context) ([]models.OAuthClient, error) {
+	rows, err := r.DB.QueryContext(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []models.OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *client)
+	}
+	return clients, rows.Err()
+}
+
+func (r *oauthClientRepositoryImpl) Delete(ctx context.Context, clientId string) (bool, error) {
+	res, err := r.DB.ExecContext(ctx, "DELETE FROM oauth_clients WHERE client_id = $1", clientId)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
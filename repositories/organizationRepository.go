@@ -0,0 +1,71 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"server-go/models"
+)
+
+// OrganizationRepository is not tenant-scoped: it is what tenants are
+// resolved from.
+type OrganizationRepository interface {
+	FindBySlug(ctx context.Context, slug string) (*models.Organization, error)
+	FindByID(ctx context.Context, id int) (*models.Organization, error)
+	Create(ctx context.Context, slug string, name string) (*models.Organization, error)
+	List(ctx context.Context) ([]models.Organization, error)
+}
+
+type organizationRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewOrganizationRepository(DB *sql.DB) OrganizationRepository {
+	return &organizationRepositoryImpl{DB: DB}
+}
+
+func scanOrganization(row rowScanner) (*models.Organization, error) {
+	org := &models.Organization{}
+	if err := row.Scan(&org.Id, &org.Slug, &org.Name, &org.CreatedAt); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+func (r *organizationRepositoryImpl) findOne(ctx context.Context, query string, args ...any) (*models.Organization, error) {
+	org, err := scanOrganization(r.DB.QueryRowContext(ctx, query, args...))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return org, err
+}
+
+func (r *organizationRepositoryImpl) FindBySlug(ctx context.Context, slug string) (*models.Organization, error) {
+	return r.findOne(ctx, "SELECT id, slug, name, created_at FROM organizations WHERE slug = $1", slug)
+}
+
+func (r *organizationRepositoryImpl) FindByID(ctx context.Context, id int) (*models.Organization, error) {
+	return r.findOne(ctx, "SELECT id, slug, name, created_at FROM organizations WHERE id = $1", id)
+}
+
+func (r *organizationRepositoryImpl) Create(ctx context.Context, slug string, name string) (*models.Organization, error) {
+	query := `INSERT INTO organizations (slug, name) VALUES ($1, $2) RETURNING id, slug, name, created_at`
+	return r.findOne(ctx, query, slug, name)
+}
+
+func (r *organizationRepositoryImpl) List(ctx context.Context) ([]models.Organization, error) {
+	rows, err := r.DB.QueryContext(ctx, "SELECT id, slug, name, created_at FROM organizations ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []models.Organization{}
+	for rows.Next() {
+		org, err := scanOrganization(rows)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, *org)
+	}
+	return orgs, rows.Err()
+}
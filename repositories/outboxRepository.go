@@ -0,0 +1,118 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"server-go/models"
+)
+
+// OutboxRepository stores domain events until the relay delivers them.
+// Append is tenant-scoped and belongs in the unit of work that makes the
+// change; the relay methods span every tenant.
+type OutboxRepository interface {
+	Append(ctx context.Context, event *models.Event) error
+	// Claim leases up to limit events that are due. Only the oldest pending
+	// event of each user is eligible, so a user's events go out in order
+	// even with several relays.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]models.Event, error)
+	Delivered(ctx context.Context, id int64) error
+	// Retry records a failed attempt and makes the event due again at next.
+	Retry(ctx context.Context, id int64, lastErr string, next time.Time) error
+	// DeadLetter moves the event out of the outbox, unblocking the user's
+	// later events.
+	DeadLetter(ctx context.Context, id int64, lastErr string) error
+	ListDeadLetters(ctx context.Context, limit int) ([]models.DeadLetter, error)
+}
+
+type outboxRepositoryImpl struct {
+	DB DBTX
+}
+
+func NewOutboxRepository(DB *sql.DB) OutboxRepository {
+	return &outboxRepositoryImpl{DB: DB}
+}
+
+const outboxColumns = "id, tenant_id, user_id, type, payload, created_at, attempts"
+
+func (r *outboxRepositoryImpl) Append(ctx context.Context, event *models.Event) error {
+	query := `INSERT INTO outbox (tenant_id, user_id, type, payload) VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+	return inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		event.TenantId = tenantId
+		return tx.QueryRowContext(ctx, query, tenantId, event.UserId, event.Type, []byte(event.Payload)).Scan(&event.Id, &event.CreatedAt)
+	})
+}
+
+func (r *outboxRepositoryImpl) Claim(ctx context.Context, limit int, lease time.Duration) ([]models.Event, error) {
+	query := `WITH heads AS (
+            SELECT DISTINCT ON (tenant_id, user_id) id FROM outbox ORDER BY tenant_id, user_id, id
+        ), due AS (
+            SELECT o.id FROM outbox o JOIN heads h ON h.id = o.id
+            WHERE o.available_at <= now() ORDER BY o.id LIMIT $1 FOR UPDATE OF o SKIP LOCKED
+        )
+        UPDATE outbox SET available_at = now() + make_interval(secs => $2)
+        FROM due WHERE outbox.id = due.id
+        RETURNING outbox.id, outbox.tenant_id, outbox.user_id, outbox.type, outbox.payload, outbox.created_at, outbox.attempts`
+
+	rows, err := r.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.Event
+	for rows.Next() {
+		var e models.Event
+		var payload []byte
+		if err := rows.Scan(&e.Id, &e.TenantId, &e.UserId, &e.Type, &payload, &e.CreatedAt, &e.Attempts); err != nil {
+			return nil, err
+		}
+		e.Payload = payload
+		events = append(events, e)
+	}
+	// RETURNING has no order
+	sort.Slice(events, func(i, j int) bool { return events[i].Id < events[j].Id })
+	return events, rows.Err()
+}
+
+func (r *outboxRepositoryImpl) Delivered(ctx context.Context, id int64) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM outbox WHERE id = $1`, id)
+	return err
+}
+
+func (r *outboxRepositoryImpl) Retry(ctx context.Context, id int64, lastErr string, next time.Time) error {
+	query := `UPDATE outbox SET attempts = attempts + 1, last_error = $2, available_at = $3 WHERE id = $1`
+	_, err := r.DB.ExecContext(ctx, query, id, lastErr, next)
+	return err
+}
+
+func (r *outboxRepositoryImpl) DeadLetter(ctx context.Context, id int64, lastErr string) error {
+	query := `WITH moved AS (DELETE FROM outbox WHERE id = $1 RETURNING ` + outboxColumns + `)
+        INSERT INTO outbox_dead_letters (id, tenant_id, user_id, type, payload, created_at, attempts, last_error)
+        SELECT id, tenant_id, user_id, type, payload, created_at, attempts + 1, $2 FROM moved`
+	_, err := r.DB.ExecContext(ctx, query, id, lastErr)
+	return err
+}
+
+func (r *outboxRepositoryImpl) ListDeadLetters(ctx context.Context, limit int) ([]models.DeadLetter, error) {
+	query := `SELECT ` + outboxColumns + `, last_error, failed_at FROM outbox_dead_letters ORDER BY id DESC LIMIT $1`
+	rows, err := r.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var letters []models.DeadLetter
+	for rows.Next() {
+		var d models.DeadLetter
+		var payload []byte
+		if err := rows.Scan(&d.Id, &d.TenantId, &d.UserId, &d.Type, &payload, &d.CreatedAt, &d.Attempts, &d.LastError, &d.FailedAt); err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		letters = append(letters, d)
+	}
+	return letters, rows.Err()
+}
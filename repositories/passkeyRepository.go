@@ -0,0 +1,165 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"server-go/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PasskeyRepository stores WebAuthn credentials, scoped to the tenant in
+// the context.
+type PasskeyRepository interface {
+	Create(ctx context.Context, passkey *models.Passkey) (*models.Passkey, error)
+	FindByCredentialID(ctx context.Context, credentialId string) (*models.Passkey, error)
+	ListForUser(ctx context.Context, userId int) ([]models.Passkey, error)
+	// RecordUse stores the signature counter of a successful login.
+	RecordUse(ctx context.Context, id int, signCount uint32) error
+	Delete(ctx context.Context, userId int, id int) (bool, error)
+
+	// CreateChallenge stores the challenge of a ceremony op begins and
+	// returns its id.
+	CreateChallenge(ctx context.Context, op string, challenge string, expiresAt time.Time) (int, error)
+	// ConsumeChallenge marks the challenge used and returns it, reporting
+	// false when it is unknown, expired, already used or for another op.
+	ConsumeChallenge(ctx context.Context, id int, op string) (string, bool, error)
+}
+
+type passkeyRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewPasskeyRepository(DB *sql.DB) PasskeyRepository {
+	return &passkeyRepositoryImpl{DB: DB}
+}
+
+const passkeyColumns = "id, user_id, name, credential_id, public_key, sign_count, aaguid, attestation, transports, created_at, last_used_at"
+
+func scanPasskey(row rowScanner) (*models.Passkey, error) {
+	passkey := &models.Passkey{}
+	var signCount int64
+	var lastUsedAt sql.NullTime
+	err := row.Scan(&passkey.Id, &passkey.UserId, &passkey.Name, &passkey.CredentialId, &passkey.PublicKey, &signCount,
+		&passkey.AAGUID, &passkey.Attestation, pq.Array(&passkey.Transports), &passkey.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	passkey.SignCount = uint32(signCount)
+	if lastUsedAt.Valid {
+		passkey.LastUsedAt = &lastUsedAt.Time
+	}
+	return passkey, nil
+}
+
+func (r *passkeyRepositoryImpl) Create(ctx context.Context, passkey *models.Passkey) (*models.Passkey, error) {
+	query := `INSERT INTO webauthn_credentials (tenant_id, user_id, name, credential_id, public_key, sign_count, aaguid, attestation, transports)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING ` + passkeyColumns
+
+	transports := passkey.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	var created *models.Passkey
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		var err error
+		created, err = scanPasskey(tx.QueryRowContext(ctx, query, tenantId, passkey.UserId, passkey.Name, passkey.CredentialId,
+			passkey.PublicKey, int64(passkey.SignCount), passkey.AAGUID, passkey.Attestation, pq.Array(transports)))
+		return err
+	})
+	return created, err
+}
+
+func (r *passkeyRepositoryImpl) FindByCredentialID(ctx context.Context, credentialId string) (*models.Passkey, error) {
+	query := "SELECT " + passkeyColumns + " FROM webauthn_credentials WHERE tenant_id = $1 AND credential_id = $2"
+
+	var passkey *models.Passkey
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		var err error
+		passkey, err = scanPasskey(tx.QueryRowContext(ctx, query, tenantId, credentialId))
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return passkey, err
+}
+
+func (r *passkeyRepositoryImpl) ListForUser(ctx context.Context, userId int) ([]models.Passkey, error) {
+	query := "SELECT " + passkeyColumns + " FROM webauthn_credentials WHERE tenant_id = $1 AND user_id = $2 ORDER BY created_at"
+
+	passkeys := []models.Passkey{}
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		rows, err := tx.QueryContext(ctx, query, tenantId, userId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			passkey, err := scanPasskey(rows)
+			if err != nil {
+				return err
+			}
+			passkeys = append(passkeys, *passkey)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+func (r *passkeyRepositoryImpl) RecordUse(ctx context.Context, id int, signCount uint32) error {
+	query := `UPDATE webauthn_credentials SET sign_count = $3, last_used_at = now() WHERE tenant_id = $1 AND id = $2`
+	return inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		_, err := tx.ExecContext(ctx, query, tenantId, id, int64(signCount))
+		return err
+	})
+}
+
+func (r *passkeyRepositoryImpl) Delete(ctx context.Context, userId int, id int) (bool, error) {
+	query := `DELETE FROM webauthn_credentials WHERE tenant_id = $1 AND user_id = $2 AND id = $3`
+
+	var n int64
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		res, err := tx.ExecContext(ctx, query, tenantId, userId, id)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return n > 0, err
+}
+
+func (r *passkeyRepositoryImpl) CreateChallenge(ctx context.Context, op string, challenge string, expiresAt time.Time) (int, error) {
+	query := `INSERT INTO webauthn_challenges (tenant_id, op, challenge, expires_at) VALUES ($1, $2, $3, $4) RETURNING id`
+
+	var id int
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		return tx.QueryRowContext(ctx, query, tenantId, op, challenge, expiresAt).Scan(&id)
+	})
+	return id, err
+}
+
+func (r *passkeyRepositoryImpl) ConsumeChallenge(ctx context.Context, id int, op string) (string, bool, error) {
+	query := `UPDATE webauthn_challenges SET used_at = now()
+        WHERE tenant_id = $1 AND id = $2 AND op = $3 AND used_at IS NULL AND expires_at > now()
+        RETURNING challenge`
+
+	var challenge string
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		return tx.QueryRowContext(ctx, query, tenantId, id, op).Scan(&challenge)
+	})
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return challenge, true, nil
+}
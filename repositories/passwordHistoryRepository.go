@@ -0,0 +1,58 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+)
+
+// PasswordHistoryRepository keeps the hashes of a user's previous
+// passwords, scoped to the tenant in the context.
+type PasswordHistoryRepository interface {
+	// Add records hash and forgets all but the newest keep entries.
+	Add(ctx context.Context, userId int, hash string, keep int) error
+	// Recent returns up to limit hashes, newest first.
+	Recent(ctx context.Context, userId int, limit int) ([]string, error)
+}
+
+type passwordHistoryRepositoryImpl struct {
+	DB DBTX
+}
+
+func NewPasswordHistoryRepository(DB *sql.DB) PasswordHistoryRepository {
+	return &passwordHistoryRepositoryImpl{DB: DB}
+}
+
+func (r *passwordHistoryRepositoryImpl) Add(ctx context.Context, userId int, hash string, keep int) error {
+	return inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		query := `INSERT INTO password_history (tenant_id, user_id, password_hash) VALUES ($1, $2, $3)`
+		if _, err := tx.ExecContext(ctx, query, tenantId, userId, hash); err != nil {
+			return err
+		}
+		query = `DELETE FROM password_history WHERE tenant_id = $1 AND user_id = $2 AND id NOT IN (
+            SELECT id FROM password_history WHERE tenant_id = $1 AND user_id = $2 ORDER BY id DESC LIMIT $3)`
+		_, err := tx.ExecContext(ctx, query, tenantId, userId, keep)
+		return err
+	})
+}
+
+func (r *passwordHistoryRepositoryImpl) Recent(ctx context.Context, userId int, limit int) ([]string, error) {
+	query := `SELECT password_hash FROM password_history WHERE tenant_id = $1 AND user_id = $2 ORDER BY id DESC LIMIT $3`
+
+	var hashes []string
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		rows, err := tx.QueryContext(ctx, query, tenantId, userId, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var hash string
+			if err := rows.Scan(&hash); err != nil {
+				return err
+			}
+			hashes = append(hashes, hash)
+		}
+		return rows.Err()
+	})
+	return hashes, err
+}
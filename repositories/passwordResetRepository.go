@@ -0,0 +1,60 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PasswordResetRepository is scoped to the tenant in the context, so a
+// token only works on the organization it was issued for.
+type PasswordResetRepository interface {
+	Create(ctx context.Context, userId int, tokenHash string, expiresAt time.Time) error
+	// Find returns the user of an unused, unexpired token without using it.
+	Find(ctx context.Context, tokenHash string) (int, bool, error)
+	// Consume marks an unused, unexpired token as used and returns its user.
+	Consume(ctx context.Context, tokenHash string) (int, bool, error)
+}
+
+type passwordResetRepositoryImpl struct {
+	DB DBTX
+}
+
+func NewPasswordResetRepository(DB *sql.DB) PasswordResetRepository {
+	return &passwordResetRepositoryImpl{DB: DB}
+}
+
+func (r *passwordResetRepositoryImpl) Create(ctx context.Context, userId int, tokenHash string, expiresAt time.Time) error {
+	query := `INSERT INTO password_resets (tenant_id, user_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)`
+	return inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		_, err := tx.ExecContext(ctx, query, tenantId, userId, tokenHash, expiresAt)
+		return err
+	})
+}
+
+func (r *passwordResetRepositoryImpl) Find(ctx context.Context, tokenHash string) (int, bool, error) {
+	query := `SELECT user_id FROM password_resets
+        WHERE tenant_id = $1 AND token_hash = $2 AND used_at IS NULL AND expires_at > now()`
+	return r.userOf(ctx, query, tokenHash)
+}
+
+func (r *passwordResetRepositoryImpl) Consume(ctx context.Context, tokenHash string) (int, bool, error) {
+	query := `UPDATE password_resets SET used_at = now()
+        WHERE tenant_id = $1 AND token_hash = $2 AND used_at IS NULL AND expires_at > now()
+        RETURNING user_id`
+	return r.userOf(ctx, query, tokenHash)
+}
+
+func (r *passwordResetRepositoryImpl) userOf(ctx context.Context, query string, tokenHash string) (int, bool, error) {
+	var userId int
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		return tx.QueryRowContext(ctx, query, tenantId, tokenHash).Scan(&userId)
+	})
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return userId, true, nil
+}
@@ -0,0 +1,143 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"server-go/models"
+	"time"
+)
+
+// SessionRepository records session tokens per device. All methods are
+// scoped to the tenant in the context, which must be the user's home
+// organization.
+type SessionRepository interface {
+	Create(ctx context.Context, session *models.Session) (*models.Session, error)
+	// ListActive returns the user's sessions whose tokens are still
+	// accepted, newest first.
+	ListActive(ctx context.Context, userId int) ([]models.Session, error)
+	// Revoke ends one session and returns it, or nil when the user has no
+	// such active session.
+	Revoke(ctx context.Context, userId int, id int) (*models.Session, error)
+	// EvictBeyond ends all but the newest keep active sessions and returns
+	// the ended ones.
+	EvictBeyond(ctx context.Context, userId int, keep int) ([]models.Session, error)
+	// Rotate moves an active session to a reissued token.
+	Rotate(ctx context.Context, jti string, newJti string, expiresAt time.Time) (bool, error)
+	// Touch records a request made with the session, at most once a minute.
+	Touch(ctx context.Context, jti string) error
+}
+
+type sessionRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewSessionRepository(DB *sql.DB) SessionRepository {
+	return &sessionRepositoryImpl{DB: DB}
+}
+
+const sessionColumns = "s.id, s.user_id, s.jti, s.device_name, s.user_agent, s.ip, s.created_at, s.last_seen_at, s.expires_at"
+
+// activeSession matches sessions of user $2 whose token would still pass
+// ValidateToken: not expired and not revoked one by one or all at once.
+const activeSession = `s.tenant_id = $1 AND s.user_id = $2 AND s.revoked_at IS NULL AND s.expires_at > now()
+        AND NOT EXISTS (SELECT 1 FROM revoked_tokens r WHERE r.tenant_id = $1 AND r.jti = s.jti)
+        AND NOT EXISTS (SELECT 1 FROM users u WHERE u.tenant_id = $1 AND u.id = s.user_id
+            AND s.created_at < GREATEST(u.tokens_revoked_at, u.deleted_at))`
+
+func scanSession(row rowScanner) (*models.Session, error) {
+	session := &models.Session{}
+	err := row.Scan(&session.Id, &session.UserId, &session.JTI, &session.DeviceName, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *sessionRepositoryImpl) Create(ctx context.Context, session *models.Session) (*models.Session, error) {
+	query := `INSERT INTO sessions AS s (tenant_id, user_id, jti, device_name, user_agent, ip, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING ` + sessionColumns
+
+	var created *models.Session
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		var err error
+		created, err = scanSession(tx.QueryRowContext(ctx, query, tenantId, session.UserId, session.JTI,
+			session.DeviceName, session.UserAgent, session.IP, session.ExpiresAt))
+		return err
+	})
+	return created, err
+}
+
+// query runs a statement returning sessions, with the tenant id as $1.
+func (r *sessionRepositoryImpl) query(ctx context.Context, query string, args ...any) ([]models.Session, error) {
+	sessions := []models.Session{}
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		rows, err := tx.QueryContext(ctx, query, append([]any{tenantId}, args...)...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			session, err := scanSession(rows)
+			if err != nil {
+				return err
+			}
+			sessions = append(sessions, *session)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *sessionRepositoryImpl) ListActive(ctx context.Context, userId int) ([]models.Session, error) {
+	query := "SELECT " + sessionColumns + " FROM sessions s WHERE " + activeSession + " ORDER BY s.created_at DESC, s.id DESC"
+	return r.query(ctx, query, userId)
+}
+
+func (r *sessionRepositoryImpl) Revoke(ctx context.Context, userId int, id int) (*models.Session, error) {
+	query := "UPDATE sessions s SET revoked_at = now() WHERE " + activeSession + " AND s.id = $3 RETURNING " + sessionColumns
+	sessions, err := r.query(ctx, query, userId, id)
+	if err != nil || len(sessions) == 0 {
+		return nil, err
+	}
+	return &sessions[0], nil
+}
+
+func (r *sessionRepositoryImpl) EvictBeyond(ctx context.Context, userId int, keep int) ([]models.Session, error) {
+	query := `UPDATE sessions s SET revoked_at = now()
+        WHERE s.tenant_id = $1 AND s.id IN (
+            SELECT s.id FROM sessions s WHERE ` + activeSession + `
+            ORDER BY s.created_at DESC, s.id DESC OFFSET $3)
+        RETURNING ` + sessionColumns
+	return r.query(ctx, query, userId, keep)
+}
+
+func (r *sessionRepositoryImpl) Rotate(ctx context.Context, jti string, newJti string, expiresAt time.Time) (bool, error) {
+	query := `UPDATE sessions SET jti = $3, expires_at = $4, last_seen_at = now()
+        WHERE tenant_id = $1 AND jti = $2 AND revoked_at IS NULL`
+
+	var n int64
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		res, err := tx.ExecContext(ctx, query, tenantId, jti, newJti, expiresAt)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return n > 0, err
+}
+
+func (r *sessionRepositoryImpl) Touch(ctx context.Context, jti string) error {
+	query := `UPDATE sessions SET last_seen_at = now()
+        WHERE tenant_id = $1 AND jti = $2 AND last_seen_at < now() - interval '1 minute'`
+	return inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		_, err := tx.ExecContext(ctx, query, tenantId, jti)
+		return err
+	})
+}
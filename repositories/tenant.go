@@ -0,0 +1,54 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"server-go/tenancy"
+)
+
+// DBTX is what repositories run their statements on: the pool, or the
+// transaction of a Transactor unit of work.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// inTenant runs fn in a transaction bound to the context's tenant. The id
+// is passed to fn for explicit filtering and also set as app.tenant_id,
+// which the row-level security policies check as a second line of defense.
+// Inside a unit of work fn joins the open transaction, which WithTx commits.
+func inTenant(ctx context.Context, db DBTX, fn func(tx *sql.Tx, tenantId int) error) error {
+	tenantId, ok := tenancy.TenantID(ctx)
+	if !ok {
+		return tenancy.ErrNoTenant
+	}
+
+	if tx, ok := db.(*sql.Tx); ok {
+		if _, err := tx.ExecContext(ctx, "SELECT set_config('app.tenant_id', $1, true)", strconv.Itoa(tenantId)); err != nil {
+			return err
+		}
+		return mapError(fn(tx, tenantId))
+	}
+
+	tx, err := db.(*sql.DB).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT set_config('app.tenant_id', $1, true)", strconv.Itoa(tenantId)); err != nil {
+		return err
+	}
+	if err := fn(tx, tenantId); err != nil {
+		return mapError(err)
+	}
+	return mapError(tx.Commit())
+}
+
+func tenancyContext(ctx context.Context, tenantId int) context.Context {
+	return tenancy.WithTenant(ctx, tenantId)
+}
@@ -0,0 +1,323 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/tenancy"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder is a database/sql driver that returns no rows and remembers
+// every statement, grouped by transaction.
+type recorder struct {
+	mu  sync.Mutex
+	txs [][]recordedStmt
+	// rows, when set, answers queries instead of the empty result.
+	rows func(query string) [][]driver.Value
+	// commitErrs fail the next commits, in order.
+	commitErrs []error
+	isolation  driver.IsolationLevel
+}
+
+type recordedStmt struct {
+	query string
+	args  []driver.Value
+}
+
+func (r *recorder) Open(string) (driver.Conn, error) { return &recConn{r: r}, nil }
+
+type recConn struct {
+	r    *recorder
+	inTx bool
+}
+
+func (c *recConn) Prepare(query string) (driver.Stmt, error) {
+	return &recStmt{c: c, query: query}, nil
+}
+func (c *recConn) Close() error { return nil }
+func (c *recConn) Begin() (driver.Tx, error) {
+	c.r.mu.Lock()
+	c.r.txs = append(c.r.txs, nil)
+	c.r.mu.Unlock()
+	c.inTx = true
+	return c, nil
+}
+func (c *recConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.r.mu.Lock()
+	c.r.isolation = opts.Isolation
+	c.r.mu.Unlock()
+	return c.Begin()
+}
+func (c *recConn) Commit() error {
+	c.inTx = false
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	if len(c.r.commitErrs) == 0 {
+		return nil
+	}
+	err := c.r.commitErrs[0]
+	c.r.commitErrs = c.r.commitErrs[1:]
+	return err
+}
+func (c *recConn) Rollback() error { c.inTx = false; return nil }
+
+type recStmt struct {
+	c     *recConn
+	query string
+}
+
+func (s *recStmt) Close() error  { return nil }
+func (s *recStmt) NumInput() int { return -1 }
+func (s *recStmt) record(args []driver.Value) {
+	s.c.r.mu.Lock()
+	defer s.c.r.mu.Unlock()
+	if !s.c.inTx {
+		// Statements outside a transaction get a group of their own.
+		s.c.r.txs = append(s.c.r.txs, nil)
+	}
+	last := len(s.c.r.txs) - 1
+	s.c.r.txs[last] = append(s.c.r.txs[last], recordedStmt{query: s.query, args: args})
+}
+func (s *recStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.record(args)
+	return driver.RowsAffected(0), nil
+}
+func (s *recStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.record(args)
+	if s.c.r.rows != nil {
+		if values := s.c.r.rows(s.query); len(values) > 0 {
+			return &sliceRows{values: values}, nil
+		}
+	}
+	return emptyRows{}, nil
+}
+
+type sliceRows struct {
+	values [][]driver.Value
+}
+
+func (r *sliceRows) Columns() []string { return make([]string, len(r.values[0])) }
+func (r *sliceRows) Close() error      { return nil }
+func (r *sliceRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string              { return nil }
+func (emptyRows) Close() error                   { return nil }
+func (emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+func newRecordingDB(t *testing.T) (*sql.DB, *recorder) {
+	rec := &recorder{}
+	name := "recorder-" + t.Name()
+	sql.Register(name, rec)
+	db, err := sql.Open(name, "")
+	assert.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	return db, rec
+}
+
+// tenantScopedCalls exercises every tenant-scoped repository method.
+func tenantScopedCalls(db *sql.DB) map[string]func(ctx context.Context) error {
+	users := NewUserRepository(db, logging.Discard())
+	resets := NewPasswordResetRepository(db)
+	audit := NewAuditRepository(db, true, logging.Discard())
+	identities := NewIdentityRepository(db)
+	consents := NewConsentRepository(db)
+	accessTokens := NewAccessTokenRepository(db)
+	sessions := NewSessionRepository(db)
+	passkeys := NewPasskeyRepository(db)
+	magicLinks := NewMagicLinkRepository(db)
+	history := NewPasswordHistoryRepository(db)
+	emailChanges := NewEmailChangeRepository(db)
+	outbox := NewOutboxRepository(db)
+	webhooks := NewWebhookRepository(db)
+
+	return map[string]func(ctx context.Context) error{
+		"FindByEmail": func(ctx context.Context) error { _, err := users.FindByEmail(ctx, "a@b.c"); return err },
+		"FindByID":    func(ctx context.Context) error { _, err := users.FindByID(ctx, 1); return err },
+		"ListUsers":   func(ctx context.Context) error { _, err := users.ListUsers(ctx, 10, 0); return err },
+		"RegisterUser": func(ctx context.Context) error {
+			_, err := users.RegisterUser(ctx, "a", "b", "a@b.c", "x")
+			return ignoreNoRows(err)
+		},
+		"RegisterUsers": func(ctx context.Context) error {
+			_, _, err := users.RegisterUsers(ctx, []models.User{{Name: "a", Email: "a@b.c"}})
+			return err
+		},
+		"ExistingEmails":      func(ctx context.Context) error { _, err := users.ExistingEmails(ctx, []string{"a@b.c"}); return err },
+		"EachUser":            func(ctx context.Context) error { return users.EachUser(ctx, func(models.User) error { return nil }) },
+		"UpdateUser":          func(ctx context.Context) error { _, err := users.UpdateUser(ctx, &models.User{Id: 1}); return err },
+		"SetRole":             func(ctx context.Context) error { _, err := users.SetRole(ctx, 1, "admin"); return err },
+		"SetPassword":         func(ctx context.Context) error { return users.SetPassword(ctx, 1, "x") },
+		"SetEmail":            func(ctx context.Context) error { _, err := users.SetEmail(ctx, 1, "a@b.c"); return err },
+		"UpgradePasswordHash": func(ctx context.Context) error { return users.UpgradePasswordHash(ctx, 1, "old", "new") },
+		"SetStatus": func(ctx context.Context) error {
+			_, err := users.SetStatus(ctx, 1, models.StatusActive, models.StatusChange{From: models.StatusActive, To: models.StatusSuspended})
+			return err
+		},
+		"StatusHistory": func(ctx context.Context) error { _, err := users.StatusHistory(ctx, 1); return err },
+		"Status":        func(ctx context.Context) error { _, err := users.Status(ctx, 1); return err },
+		"DeleteUser":    func(ctx context.Context) error { _, err := users.DeleteUser(ctx, 1); return err },
+		"PurgeDeleted": func(ctx context.Context) error {
+			_, err := users.PurgeDeleted(ctx, time.Now())
+			return ignoreNoRows(err)
+		},
+		"RevokeTokens":    func(ctx context.Context) error { return users.RevokeTokens(ctx, 1) },
+		"TokensRevokedAt": func(ctx context.Context) error { _, err := users.TokensRevokedAt(ctx, 1); return err },
+		"CreateServiceAccount": func(ctx context.Context) error {
+			_, err := users.CreateServiceAccount(ctx, "ci", "ci@example.com")
+			return ignoreNoRows(err)
+		},
+		"RevokeToken":  func(ctx context.Context) error { return users.RevokeToken(ctx, 1, "jti", time.Now()) },
+		"TokenRevoked": func(ctx context.Context) error { _, err := users.TokenRevoked(ctx, "jti"); return err },
+		"CreateReset":  func(ctx context.Context) error { return resets.Create(ctx, 1, "h", time.Now()) },
+		"FindReset":    func(ctx context.Context) error { _, _, err := resets.Find(ctx, "h"); return err },
+		"ConsumeReset": func(ctx context.Context) error { _, _, err := resets.Consume(ctx, "h"); return err },
+		"AuditAppend": func(ctx context.Context) error {
+			return ignoreNoRows(audit.Append(ctx, &models.AuditEvent{Action: models.AuditLogin}))
+		},
+		"FindIdentity": func(ctx context.Context) error { _, err := identities.Find(ctx, "google", "abc"); return err },
+		"LinkIdentity": func(ctx context.Context) error {
+			_, err := identities.Link(ctx, 1, "google", "abc", "")
+			return ignoreNoRows(err)
+		},
+		"TouchIdentity": func(ctx context.Context) error { return identities.Touch(ctx, 1) },
+		"FindConsent":   func(ctx context.Context) error { _, err := consents.Find(ctx, 1, "app"); return err },
+		"GrantConsent":  func(ctx context.Context) error { return consents.Grant(ctx, 1, "app", []string{"openid"}) },
+		"CreateAccessToken": func(ctx context.Context) error {
+			_, err := accessTokens.Create(ctx, &models.AccessToken{UserId: 1, Name: "ci"}, "h")
+			return ignoreNoRows(err)
+		},
+		"ListAccessTokens":  func(ctx context.Context) error { _, err := accessTokens.ListForUser(ctx, 1); return err },
+		"RevokeAccessToken": func(ctx context.Context) error { _, err := accessTokens.Revoke(ctx, 1, 2); return err },
+		"CreateSession": func(ctx context.Context) error {
+			_, err := sessions.Create(ctx, &models.Session{UserId: 1, JTI: "jti"})
+			return ignoreNoRows(err)
+		},
+		"ListSessions":  func(ctx context.Context) error { _, err := sessions.ListActive(ctx, 1); return err },
+		"RevokeSession": func(ctx context.Context) error { _, err := sessions.Revoke(ctx, 1, 2); return err },
+		"EvictSessions": func(ctx context.Context) error { _, err := sessions.EvictBeyond(ctx, 1, 3); return err },
+		"RotateSession": func(ctx context.Context) error {
+			_, err := sessions.Rotate(ctx, "jti", "new", time.Now())
+			return err
+		},
+		"TouchSession": func(ctx context.Context) error { return sessions.Touch(ctx, "jti") },
+		"CreatePasskey": func(ctx context.Context) error {
+			_, err := passkeys.Create(ctx, &models.Passkey{UserId: 1, CredentialId: "abc"})
+			return ignoreNoRows(err)
+		},
+		"FindPasskey":      func(ctx context.Context) error { _, err := passkeys.FindByCredentialID(ctx, "abc"); return err },
+		"ListPasskeys":     func(ctx context.Context) error { _, err := passkeys.ListForUser(ctx, 1); return err },
+		"RecordPasskeyUse": func(ctx context.Context) error { return passkeys.RecordUse(ctx, 1, 2) },
+		"DeletePasskey":    func(ctx context.Context) error { _, err := passkeys.Delete(ctx, 1, 2); return err },
+		"CreateChallenge": func(ctx context.Context) error {
+			_, err := passkeys.CreateChallenge(ctx, "login", "abc", time.Now())
+			return ignoreNoRows(err)
+		},
+		"ConsumeChallenge": func(ctx context.Context) error { _, _, err := passkeys.ConsumeChallenge(ctx, 1, "login"); return err },
+		"CreateMagicLink": func(ctx context.Context) error {
+			_, _, err := magicLinks.Create(ctx, "a@b.c", nil, time.Now(), 5, time.Hour)
+			return ignoreNoRows(err)
+		},
+		"ConsumeMagicLink":     func(ctx context.Context) error { _, _, err := magicLinks.Consume(ctx, 1); return err },
+		"AddPasswordHistory":   func(ctx context.Context) error { return history.Add(ctx, 1, "h", 5) },
+		"RecentPasswordHashes": func(ctx context.Context) error { _, err := history.Recent(ctx, 1, 5); return err },
+		"CreateEmailChange": func(ctx context.Context) error {
+			_, err := emailChanges.Create(ctx, 1, "a@b.c", time.Now())
+			return ignoreNoRows(err)
+		},
+		"ConsumeEmailChange": func(ctx context.Context) error { _, _, _, err := emailChanges.Consume(ctx, 1); return err },
+		"AppendEvent": func(ctx context.Context) error {
+			return ignoreNoRows(outbox.Append(ctx, &models.Event{UserId: 1, Type: models.EventUserUpdated, Payload: []byte("{}")}))
+		},
+		"CreateWebhookEndpoint": func(ctx context.Context) error {
+			_, err := webhooks.CreateEndpoint(ctx, "https://example.com/hook", "s", []string{"*"})
+			return ignoreNoRows(err)
+		},
+		"ListWebhookEndpoints":  func(ctx context.Context) error { _, err := webhooks.ListEndpoints(ctx); return err },
+		"FindWebhookEndpoint":   func(ctx context.Context) error { _, err := webhooks.FindEndpoint(ctx, 1); return err },
+		"DeleteWebhookEndpoint": func(ctx context.Context) error { _, err := webhooks.DeleteEndpoint(ctx, 1); return err },
+		"EnableWebhookEndpoint": func(ctx context.Context) error { _, err := webhooks.EnableEndpoint(ctx, 1); return err },
+		"EnqueueWebhooks": func(ctx context.Context) error {
+			_, err := webhooks.Enqueue(ctx, models.Event{Id: 1, Type: models.EventUserUpdated, Payload: []byte("{}")})
+			return err
+		},
+		"ListWebhookDeliveries": func(ctx context.Context) error { _, err := webhooks.ListDeliveries(ctx, 1, 10); return err },
+		"FindWebhookDelivery":   func(ctx context.Context) error { _, err := webhooks.FindDelivery(ctx, 1, 2); return err },
+		"RedeliverWebhook":      func(ctx context.Context) error { _, err := webhooks.Redeliver(ctx, 1, 2); return err },
+		"AuditList": func(ctx context.Context) error {
+			_, _, err := audit.List(ctx, models.AuditFilter{Limit: 5})
+			return ignoreNoRows(err)
+		},
+		"AuditEach": func(ctx context.Context) error {
+			return audit.Each(ctx, models.AuditFilter{}, func(models.AuditEvent) error { return nil })
+		},
+	}
+}
+
+// The recorder returns no rows, so INSERT ... RETURNING and COUNT(*)
+// report ErrNoRows.
+func ignoreNoRows(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+func TestRepositoriesRequireTenant(t *testing.T) {
+	db, rec := newRecordingDB(t)
+	for name, call := range tenantScopedCalls(db) {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, call(context.Background()), tenancy.ErrNoTenant)
+		})
+	}
+	assert.Empty(t, rec.txs, "no statement may run without a tenant")
+}
+
+func TestRepositoriesScopeEveryStatementToTenant(t *testing.T) {
+	const tenant = 7
+	for name := range tenantScopedCalls(nil) {
+		t.Run(name, func(t *testing.T) {
+			db, rec := newRecordingDB(t)
+			call := tenantScopedCalls(db)[name]
+			assert.NoError(t, call(tenancy.WithTenant(context.Background(), tenant)))
+			assert.NotEmpty(t, rec.txs)
+
+			for _, tx := range rec.txs {
+				if assert.NotEmpty(t, tx) {
+					// RLS setting first, within the same transaction
+					assert.Contains(t, tx[0].query, "set_config('app.tenant_id'")
+					assert.Equal(t, "7", tx[0].args[0])
+				}
+				for _, stmt := range tx[1:] {
+					if strings.HasPrefix(stmt.query, "SAVEPOINT") || strings.HasPrefix(stmt.query, "ROLLBACK") {
+						continue
+					}
+					// Application-level filter: tenant_id is bound as $1
+					if !strings.Contains(stmt.query, "pg_advisory_xact_lock") {
+						assert.Contains(t, stmt.query, "tenant_id", stmt.query)
+					}
+					assert.EqualValues(t, tenant, stmt.args[0], stmt.query)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"server-go/models"
+
+	"github.com/lib/pq"
+)
+
+// Repositories are bound to one transaction for the length of a WithTx
+// callback. Tenant-scoped calls still need a tenant in the context.
+type Repositories struct {
+	Users           UserRepository
+	PasswordResets  PasswordResetRepository
+	Memberships     MembershipRepository
+	Invitations     InvitationRepository
+	PasswordHistory PasswordHistoryRepository
+	Outbox          OutboxRepository
+}
+
+// Transactor runs a unit of work: every repository call inside fn commits
+// together or not at all. Serialization failures and deadlocks roll back
+// and run fn again, so fn must not have effects outside the database.
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(repos Repositories) error) error
+}
+
+// TxOptions configure a Transactor. MaxRetries is the number of extra
+// attempts after a serialization failure or deadlock.
+type TxOptions struct {
+	Isolation  sql.IsolationLevel
+	MaxRetries int
+}
+
+type transactor struct {
+	DB      *sql.DB
+	options TxOptions
+	logger  *slog.Logger
+}
+
+func NewTransactor(DB *sql.DB, options TxOptions, logger *slog.Logger) Transactor {
+	return &transactor{DB: DB, options: options, logger: logger}
+}
+
+const retryBackoff = 20 * time.Millisecond
+
+func (t *transactor) WithTx(ctx context.Context, fn func(repos Repositories) error) error {
+	for attempt := 0; ; attempt++ {
+		err := t.attempt(ctx, fn)
+		if err == nil || !retryable(err) || attempt >= t.options.MaxRetries {
+			return mapError(err)
+		}
+		t.logger.WarnContext(ctx, "retrying transaction", "attempt", attempt+1, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryBackoff << attempt):
+		}
+	}
+}
+
+func (t *transactor) attempt(ctx context.Context, fn func(repos Repositories) error) error {
+	tx, err := t.DB.BeginTx(ctx, &sql.TxOptions{Isolation: t.options.Isolation})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(bind(tx, t.logger)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func bind(tx *sql.Tx, logger *slog.Logger) Repositories {
+	return Repositories{
+		Users:           &userRepositoryImpl{DB: tx, logger: logger},
+		PasswordResets:  &passwordResetRepositoryImpl{DB: tx},
+		Memberships:     &membershipRepositoryImpl{DB: tx},
+		Invitations:     &invitationRepositoryImpl{DB: tx},
+		PasswordHistory: &passwordHistoryRepositoryImpl{DB: tx},
+		Outbox:          &outboxRepositoryImpl{DB: tx},
+	}
+}
+
+// retryable reports serialization failures and deadlocks, which Postgres
+// resolves by aborting one of the transactions involved.
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "40001" || pqErr.Code == "40P01"
+}
+
+// mapError turns unique violations into *models.ConflictError so services
+// do not depend on the driver.
+func mapError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return &models.ConflictError{Constraint: pqErr.Constraint}
+	}
+	return err
+}
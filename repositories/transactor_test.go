@@ -0,0 +1,95 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/tenancy"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTxSharesOneTransaction(t *testing.T) {
+	db, rec := newRecordingDB(t)
+	tx := NewTransactor(db, TxOptions{Isolation: sql.LevelSerializable}, logging.Discard())
+	ctx := tenancy.WithTenant(context.Background(), 7)
+
+	err := tx.WithTx(ctx, func(repos Repositories) error {
+		if _, err := repos.Users.FindByEmail(ctx, "a@b.c"); err != nil {
+			return err
+		}
+		if err := repos.PasswordResets.Create(ctx, 1, "h", time.Now()); err != nil {
+			return err
+		}
+		_, err := repos.Memberships.Find(ctx, 1, 7)
+		return err
+	})
+	require.NoError(t, err)
+
+	require.Len(t, rec.txs, 1, "every repository ran in the same transaction")
+	assert.Equal(t, driver.IsolationLevel(sql.LevelSerializable), rec.isolation)
+	var tenantSet int
+	for _, stmt := range rec.txs[0] {
+		if strings.Contains(stmt.query, "set_config('app.tenant_id'") {
+			tenantSet++
+		}
+	}
+	assert.Equal(t, 2, tenantSet, "tenant-scoped calls still set the tenant")
+
+	err = tx.WithTx(context.Background(), func(repos Repositories) error {
+		_, err := repos.Users.FindByID(context.Background(), 1)
+		return err
+	})
+	assert.ErrorIs(t, err, tenancy.ErrNoTenant)
+}
+
+func TestWithTxRetriesSerializationFailures(t *testing.T) {
+	serialization := &pq.Error{Code: "40001"}
+	deadlock := &pq.Error{Code: "40P01"}
+
+	t.Run("until it commits", func(t *testing.T) {
+		db, rec := newRecordingDB(t)
+		rec.commitErrs = []error{serialization, deadlock}
+		calls := 0
+		err := NewTransactor(db, TxOptions{MaxRetries: 3}, logging.Discard()).WithTx(context.Background(), func(Repositories) error {
+			calls++
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("up to the limit", func(t *testing.T) {
+		db, rec := newRecordingDB(t)
+		rec.commitErrs = []error{serialization, serialization, serialization}
+		calls := 0
+		err := NewTransactor(db, TxOptions{MaxRetries: 1}, logging.Discard()).WithTx(context.Background(), func(Repositories) error {
+			calls++
+			return nil
+		})
+		assert.ErrorIs(t, err, serialization)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("not other errors", func(t *testing.T) {
+		db, rec := newRecordingDB(t)
+		rec.commitErrs = []error{&pq.Error{Code: "23505", Constraint: "idx_users_tenant_email_lower"}}
+		calls := 0
+		err := NewTransactor(db, TxOptions{MaxRetries: 3}, logging.Discard()).WithTx(context.Background(), func(Repositories) error {
+			calls++
+			return nil
+		})
+		var conflict *models.ConflictError
+		require.ErrorAs(t, err, &conflict)
+		assert.Equal(t, "idx_users_tenant_email_lower", conflict.Constraint)
+		assert.Equal(t, 1, calls)
+	})
+}
@@ -3,113 +3,471 @@ package repositories
 import (
 	"context"
 	"database/sql"
-	"log"
+	"fmt"
+	"log/slog"
 	"server-go/models"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
 )
 
+// UserRepository is scoped to the tenant in the context: every method
+// filters on it and fails with tenancy.ErrNoTenant when there is none.
 type UserRepository interface {
 	FindByEmail(ctx context.Context, email string) (*models.User, error)
 	FindByID(ctx context.Context, id int) (*models.User, error)
+	ListUsers(ctx context.Context, limit int, offset int) ([]models.User, error)
 	RegisterUser(ctx context.Context, name string, lastName string, email string, password string) (*models.User, error)
+	RegisterUsers(ctx context.Context, users []models.User) ([]*models.User, []error, error)
+	// ExistingEmails reports which of emails are taken, ignoring case; the
+	// map is keyed by the lowercased address.
+	ExistingEmails(ctx context.Context, emails []string) (map[string]bool, error)
+	EachUser(ctx context.Context, fn func(models.User) error) error
 	UpdateUser(ctx context.Context, user *models.User) (*models.User, error)
+	SetRole(ctx context.Context, id int, role string) (*models.User, error)
+	// SetPassword also bumps the token version, ending the user's sessions
+	// where AuthMiddleware loads users fresh.
+	SetPassword(ctx context.Context, id int, password string) error
+	SetEmail(ctx context.Context, id int, email string) (*models.User, error)
+	// UpgradePasswordHash replaces oldHash with a new hash of the same
+	// password. It is a no-op when the password changed in the meantime,
+	// and does not count as a password change.
+	UpgradePasswordHash(ctx context.Context, id int, oldHash string, newHash string) error
+	// SetStatus applies change to a user whose stored status is current and
+	// records it in the status history, bumping the token version. It
+	// returns nil when there is no such user, including when the status
+	// changed in the meantime.
+	SetStatus(ctx context.Context, id int, current string, change models.StatusChange) (*models.User, error)
+	// StatusHistory lists the user's status changes, newest first.
+	StatusHistory(ctx context.Context, id int) ([]models.StatusChange, error)
+	// Status returns the user's status, with expired suspensions and locks
+	// reported as active, or "" when there is no such user.
+	Status(ctx context.Context, id int) (string, error)
+	DeleteUser(ctx context.Context, id int) (bool, error)
+	// PurgeDeleted scrubs the personal data and credentials of users
+	// deleted before the given time and returns how many it purged.
+	PurgeDeleted(ctx context.Context, before time.Time) (int, error)
+	// CreateServiceAccount adds a user that cannot log in with a password.
+	CreateServiceAccount(ctx context.Context, name string, email string) (*models.User, error)
+	// RevokeTokens ends every token issued to the user so far.
+	RevokeTokens(ctx context.Context, id int) error
+	TokensRevokedAt(ctx context.Context, id int) (time.Time, error)
+	// RevokeToken blocks a single token by its jti until it expires.
+	RevokeToken(ctx context.Context, id int, jti string, expiresAt time.Time) error
+	TokenRevoked(ctx context.Context, jti string) (bool, error)
 }
 
 type userRepositoryImpl struct {
-	DB *sql.DB
+	DB     DBTX
+	logger *slog.Logger
 }
 
-func NewUserRepository(DB *sql.DB) UserRepository {
-	return &userRepositoryImpl{DB: DB}
+func NewUserRepository(DB *sql.DB, logger *slog.Logger) UserRepository {
+	return &userRepositoryImpl{DB: DB, logger: logger}
 }
 
-func (r *userRepositoryImpl) FindByID(ctx context.Context, id int) (*models.User, error) {
-	query := "SELECT id FROM users WHERE id =$1"
+const userColumns = "id, name, lastName, email, password, role, tenant_id, disabled_at, service_account, password_changed_at, status, status_reason, status_until, token_version"
+
+func scanUser(row rowScanner) (*models.User, error) {
 	user := &models.User{}
+	var disabledAt, statusUntil sql.NullTime
 
-	err := r.DB.QueryRowContext(ctx, query, id).Scan(
+	err := row.Scan(
 		&user.Id,
 		&user.Name,
 		&user.LastName,
 		&user.Email,
 		&user.Password,
+		&user.Role,
+		&user.TenantId,
+		&disabledAt,
+		&user.ServiceAccount,
+		&user.PasswordChangedAt,
+		&user.Status,
+		&user.StatusReason,
+		&statusUntil,
+		&user.TokenVersion,
 	)
+	if err != nil {
+		return nil, err
+	}
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
+	if statusUntil.Valid {
+		user.StatusUntil = &statusUntil.Time
+	}
+	return user, nil
+}
+
+// findOne runs a single-row user query, mapping no rows to (nil, nil).
+// The tenant id is always $1; every query also filters on deleted_at so
+// soft-deleted users stay invisible.
+func (r *userRepositoryImpl) findOne(ctx context.Context, query string, args ...any) (*models.User, error) {
+	var user *models.User
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		var err error
+		user, err = scanUser(tx.QueryRowContext(ctx, query, append([]any{tenantId}, args...)...))
+		return err
+	})
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Println("User not found")
+			r.logger.DebugContext(ctx, "user not found")
 			return nil, nil
 		}
 		return nil, err
 	}
-
 	return user, nil
 }
 
+// exec runs a statement whose $1 is the tenant id and returns the rows affected.
+func (r *userRepositoryImpl) exec(ctx context.Context, query string, args ...any) (int64, error) {
+	var n int64
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		res, err := tx.ExecContext(ctx, query, append([]any{tenantId}, args...)...)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return n, err
+}
+
+func (r *userRepositoryImpl) FindByID(ctx context.Context, id int) (*models.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NULL"
+	return r.findOne(ctx, query, id)
+}
+
 func (r *userRepositoryImpl) FindByEmail(ctx context.Context, email string) (*models.User, error) {
-	query := "SELECT id, name, lastName, email, password FROM users WHERE email = $1"
-	user := &models.User{}
+	// Matches the lower(email) unique index: addresses differing in case
+	// are the same account
+	query := "SELECT " + userColumns + " FROM users WHERE tenant_id = $1 AND lower(email) = lower($2) AND deleted_at IS NULL"
+	return r.findOne(ctx, query, email)
+}
 
-	err := r.DB.QueryRowContext(ctx, query, email).Scan(
-		&user.Id,
-		&user.Name,
-		&user.LastName,
-		&user.Email,
-		&user.Password,
-	)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Println("User not found")
-			return nil, nil
+func (r *userRepositoryImpl) ListUsers(ctx context.Context, limit int, offset int) ([]models.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY id LIMIT $2 OFFSET $3"
+
+	users := []models.User{}
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		rows, err := tx.QueryContext(ctx, query, tenantId, limit, offset)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			user, err := scanUser(rows)
+			if err != nil {
+				return err
+			}
+			users = append(users, *user)
 		}
+		return rows.Err()
+	})
+	if err != nil {
 		return nil, err
 	}
-	return user, nil
+	return users, nil
 }
 
 func (r *userRepositoryImpl) UpdateUser(ctx context.Context, user *models.User) (*models.User, error) {
-	query := `UPDATE users SET name = $1, lastName = $2, email = $3, password = $4 WHERE id = $5 RETURNING id, name, lastName, email, password`
+	query := `UPDATE users SET name = $2, lastName = $3, email = $4, password = $5,
+            password_changed_at = CASE WHEN password = $5 THEN password_changed_at ELSE now() END,
+            token_version = CASE WHEN password = $5 THEN token_version ELSE token_version + 1 END
+        WHERE tenant_id = $1 AND id = $6 AND deleted_at IS NULL
+        RETURNING ` + userColumns
+	return r.findOne(ctx, query, user.Name, user.LastName, user.Email, user.Password, user.Id)
+}
 
-	err := r.DB.QueryRowContext(ctx, query, user.Name, user.LastName, user.Email, user.Password, user.Id).Scan(
-		&user.Id,
-		&user.Name,
-		&user.LastName,
-		&user.Email,
-		&user.Password,
-	)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Println("User not found")
-			return nil, nil
+func (r *userRepositoryImpl) SetRole(ctx context.Context, id int, role string) (*models.User, error) {
+	query := `UPDATE users SET role = $2 WHERE tenant_id = $1 AND id = $3 AND deleted_at IS NULL RETURNING ` + userColumns
+	return r.findOne(ctx, query, role, id)
+}
+
+func (r *userRepositoryImpl) SetPassword(ctx context.Context, id int, password string) error {
+	query := `UPDATE users SET password = $2, password_changed_at = now(), token_version = token_version + 1 WHERE tenant_id = $1 AND id = $3 AND deleted_at IS NULL`
+	_, err := r.exec(ctx, query, password, id)
+	return err
+}
+
+func (r *userRepositoryImpl) SetEmail(ctx context.Context, id int, email string) (*models.User, error) {
+	query := `UPDATE users SET email = $2 WHERE tenant_id = $1 AND id = $3 AND deleted_at IS NULL RETURNING ` + userColumns
+	return r.findOne(ctx, query, email, id)
+}
+
+func (r *userRepositoryImpl) UpgradePasswordHash(ctx context.Context, id int, oldHash string, newHash string) error {
+	query := `UPDATE users SET password = $2 WHERE tenant_id = $1 AND id = $3 AND password = $4 AND deleted_at IS NULL`
+	_, err := r.exec(ctx, query, newHash, id, oldHash)
+	return err
+}
+
+func (r *userRepositoryImpl) SetStatus(ctx context.Context, id int, current string, change models.StatusChange) (*models.User, error) {
+	// disabled_at keeps meaning "deactivated at" for exports and usersctl
+	update := `UPDATE users SET status = $2, status_reason = $3, status_until = $4, token_version = token_version + 1,
+            disabled_at = CASE WHEN $2 = 'deactivated' THEN COALESCE(disabled_at, now()) END
+        WHERE tenant_id = $1 AND id = $5 AND status = $6 AND deleted_at IS NULL
+        RETURNING ` + userColumns
+	insert := `INSERT INTO user_status_history (tenant_id, user_id, from_status, to_status, reason, until, actor_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	var user *models.User
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		var err error
+		user, err = scanUser(tx.QueryRowContext(ctx, update, tenantId, change.To, change.Reason, change.Until, id, current))
+		if err != nil {
+			return err
 		}
+		_, err = tx.ExecContext(ctx, insert, tenantId, id, change.From, change.To, change.Reason, change.Until, change.ActorId)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (r *userRepositoryImpl) RegisterUser(ctx context.Context, name string, lastName string, email string, password string) (*models.User, error) {
-	query := `INSERT INTO users (name, lastName, email, password)
-        VALUES ($1, $2, $3, $4)
-        RETURNING id, name, lastName, email, password;`
-
-	user := &models.User{
-		Name:     name,
-		LastName: lastName,
-		Email:    email,
-		Password: password,
+func (r *userRepositoryImpl) StatusHistory(ctx context.Context, id int) ([]models.StatusChange, error) {
+	query := `SELECT id, user_id, from_status, to_status, reason, until, actor_id, created_at
+        FROM user_status_history WHERE tenant_id = $1 AND user_id = $2 ORDER BY id DESC`
+
+	changes := []models.StatusChange{}
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		rows, err := tx.QueryContext(ctx, query, tenantId, id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var change models.StatusChange
+			var until sql.NullTime
+			var actorId sql.NullInt64
+			if err := rows.Scan(&change.Id, &change.UserId, &change.From, &change.To, &change.Reason, &until, &actorId, &change.CreatedAt); err != nil {
+				return err
+			}
+			if until.Valid {
+				change.Until = &until.Time
+			}
+			if actorId.Valid {
+				actor := int(actorId.Int64)
+				change.ActorId = &actor
+			}
+			changes = append(changes, change)
+		}
+		return rows.Err()
+	})
+	return changes, err
+}
+
+func (r *userRepositoryImpl) Status(ctx context.Context, id int) (string, error) {
+	query := `SELECT CASE WHEN status IN ('suspended', 'locked') AND status_until <= now() THEN 'active' ELSE status END
+        FROM users WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NULL`
+
+	var status string
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		return tx.QueryRowContext(ctx, query, tenantId, id).Scan(&status)
+	})
+	if err == sql.ErrNoRows {
+		return "", nil
 	}
+	return status, err
+}
 
-	err := r.DB.QueryRowContext(ctx, query, name, lastName, email, password).Scan(
-		&user.Id,
-		&user.Name,
-		&user.LastName,
-		&user.Email,
-		&user.Password,
-	)
+func (r *userRepositoryImpl) DeleteUser(ctx context.Context, id int) (bool, error) {
+	query := `UPDATE users SET deleted_at = now() WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NULL`
+	n, err := r.exec(ctx, query, id)
+	return n > 0, err
+}
+
+func (r *userRepositoryImpl) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	query := `WITH purged AS (
+            UPDATE users SET name = 'purged-' || id, lastName = '', email = 'purged-' || id || '@invalid',
+                password = '!', purged_at = now()
+            WHERE tenant_id = $1 AND deleted_at < $2 AND purged_at IS NULL
+            RETURNING id
+        ), history AS (
+            DELETE FROM password_history WHERE tenant_id = $1 AND user_id IN (SELECT id FROM purged)
+        ), identities AS (
+            DELETE FROM identities WHERE tenant_id = $1 AND user_id IN (SELECT id FROM purged)
+        ), passkeys AS (
+            DELETE FROM webauthn_credentials WHERE tenant_id = $1 AND user_id IN (SELECT id FROM purged)
+        )
+        SELECT count(*) FROM purged`
+
+	var n int
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		return tx.QueryRowContext(ctx, query, tenantId, before).Scan(&n)
+	})
+	return n, err
+}
+
+func (r *userRepositoryImpl) CreateServiceAccount(ctx context.Context, name string, email string) (*models.User, error) {
+	query := `INSERT INTO users (tenant_id, name, lastName, email, password, service_account)
+        VALUES ($1, $2, '', $3, '!', true)
+        RETURNING ` + userColumns
+
+	var user *models.User
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		var err error
+		user, err = scanUser(tx.QueryRowContext(ctx, query, tenantId, name, email))
+		return err
+	})
+	return user, err
+}
+
+func (r *userRepositoryImpl) RevokeTokens(ctx context.Context, id int) error {
+	query := `UPDATE users SET tokens_revoked_at = now(), token_version = token_version + 1 WHERE tenant_id = $1 AND id = $2`
+	_, err := r.exec(ctx, query, id)
+	return err
+}
+
+// TokensRevokedAt returns the instant before which the user's tokens are no
+// longer accepted. Deleted users report the deletion time; unknown users
+// report the zero time.
+func (r *userRepositoryImpl) TokensRevokedAt(ctx context.Context, id int) (time.Time, error) {
+	query := `SELECT GREATEST(tokens_revoked_at, deleted_at) FROM users WHERE tenant_id = $1 AND id = $2`
+	var revokedAt sql.NullTime
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		return tx.QueryRowContext(ctx, query, tenantId, id).Scan(&revokedAt)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, err
+	}
+	return revokedAt.Time, nil
+}
+
+func (r *userRepositoryImpl) RevokeToken(ctx context.Context, id int, jti string, expiresAt time.Time) error {
+	query := `INSERT INTO revoked_tokens (tenant_id, user_id, jti, expires_at) VALUES ($1, $2, $3, $4)
+        ON CONFLICT (jti) DO NOTHING`
+	_, err := r.exec(ctx, query, id, jti, expiresAt)
+	return err
+}
+
+func (r *userRepositoryImpl) TokenRevoked(ctx context.Context, jti string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE tenant_id = $1 AND jti = $2)`
+	var revoked bool
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		return tx.QueryRowContext(ctx, query, tenantId, jti).Scan(&revoked)
+	})
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return revoked, err
+}
+
+func (r *userRepositoryImpl) RegisterUser(ctx context.Context, name string, lastName string, email string, password string) (*models.User, error) {
+	query := `INSERT INTO users (tenant_id, name, lastName, email, password)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING ` + userColumns + `;`
 
+	var user *models.User
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		var err error
+		user, err = scanUser(tx.QueryRowContext(ctx, query, tenantId, name, lastName, email, password))
+		return err
+	})
 	if err != nil {
-		log.Printf("Error registering user: %v", err)
+		r.logger.ErrorContext(ctx, "error registering user", "error", err)
 		return nil, err
 	}
 
-	log.Printf("User registered successfully: %+v", user)
+	r.logger.InfoContext(ctx, "user registered", "registered_user_id", user.Id)
 	return user, nil
 }
+
+// RegisterUsers inserts users in one transaction. Each row gets its own
+// savepoint, so a bad row is reported in rowErrs without aborting the rest;
+// err is only set when the transaction itself fails.
+func (r *userRepositoryImpl) RegisterUsers(ctx context.Context, users []models.User) ([]*models.User, []error, error) {
+	created := make([]*models.User, len(users))
+	rowErrs := make([]error, len(users))
+
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		stmt, err := tx.PrepareContext(ctx, `INSERT INTO users (tenant_id, name, lastName, email, password, role, status)
+            VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, ''), 'active'))
+            RETURNING `+userColumns)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for i, u := range users {
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT import_row"); err != nil {
+				return err
+			}
+			user, err := scanUser(stmt.QueryRowContext(ctx, tenantId, u.Name, u.LastName, u.Email, u.Password, u.Role, u.Status))
+			if err != nil {
+				rowErrs[i] = mapError(err)
+				if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_row"); err != nil {
+					return err
+				}
+				continue
+			}
+			created[i] = user
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return created, rowErrs, nil
+}
+
+func (r *userRepositoryImpl) ExistingEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	query := `SELECT lower(email) FROM users WHERE tenant_id = $1 AND lower(email) = ANY($2) AND deleted_at IS NULL`
+
+	lowered := make([]string, len(emails))
+	for i, email := range emails {
+		lowered[i] = strings.ToLower(email)
+	}
+	existing := map[string]bool{}
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		rows, err := tx.QueryContext(ctx, query, tenantId, pq.Array(lowered))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var email string
+			if err := rows.Scan(&email); err != nil {
+				return err
+			}
+			existing[email] = true
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// EachUser streams every user in id order without loading them all.
+func (r *userRepositoryImpl) EachUser(ctx context.Context, fn func(models.User) error) error {
+	query := "SELECT " + userColumns + " FROM users WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY id"
+
+	return inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		rows, err := tx.QueryContext(ctx, query, tenantId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			user, err := scanUser(rows)
+			if err != nil {
+				return fmt.Errorf("scanning user: %w", err)
+			}
+			if err := fn(*user); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
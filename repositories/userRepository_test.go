@@ -0,0 +1,70 @@
+package repositories
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/tenancy"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailLookupsIgnoreCase(t *testing.T) {
+	db, rec := newRecordingDB(t)
+	users := NewUserRepository(db, logging.Discard())
+	ctx := tenancy.WithTenant(context.Background(), 7)
+	rec.rows = func(query string) [][]driver.Value {
+		if strings.HasPrefix(query, "SELECT lower(email)") {
+			return [][]driver.Value{{"jane@example.com"}}
+		}
+		return nil
+	}
+
+	_, err := users.FindByEmail(ctx, "Jane@Example.com")
+	require.NoError(t, err)
+	existing, err := users.ExistingEmails(ctx, []string{"Jane@Example.com", "john@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"jane@example.com": true}, existing)
+
+	var queries []recordedStmt
+	for _, tx := range rec.txs {
+		for _, stmt := range tx {
+			if !strings.Contains(stmt.query, "set_config") {
+				queries = append(queries, stmt)
+			}
+		}
+	}
+	require.Len(t, queries, 2)
+	assert.Contains(t, queries[0].query, "lower(email) = lower($2)")
+	assert.Contains(t, queries[1].query, "lower(email) = ANY($2)")
+	lowered, _ := pq.Array([]string{"jane@example.com", "john@example.com"}).Value()
+	assert.Equal(t, lowered, queries[1].args[1])
+}
+
+func TestPasswordChangesBumpTokenVersion(t *testing.T) {
+	db, rec := newRecordingDB(t)
+	users := NewUserRepository(db, logging.Discard())
+	ctx := tenancy.WithTenant(context.Background(), 7)
+
+	_, err := users.UpdateUser(ctx, &models.User{Id: 1, Password: "hash"})
+	require.NoError(t, ignoreNoRows(err))
+	require.NoError(t, users.SetPassword(ctx, 1, "hash"))
+
+	var updates []string
+	for _, tx := range rec.txs {
+		for _, stmt := range tx {
+			if strings.HasPrefix(stmt.query, "UPDATE users") {
+				updates = append(updates, stmt.query)
+			}
+		}
+	}
+	require.Len(t, updates, 2)
+	assert.Contains(t, updates[0], "token_version = CASE WHEN password = $5 THEN token_version ELSE token_version + 1 END")
+	assert.Contains(t, updates[1], "token_version = token_version + 1")
+}
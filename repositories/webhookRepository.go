@@ -0,0 +1,323 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"server-go/models"
+
+	"github.com/lib/pq"
+)
+
+// WebhookRepository keeps endpoints, their deliveries and the delivery log.
+// Endpoint and delivery management is tenant-scoped; ClaimDeliveries and
+// RecordAttempt serve the dispatcher and span every tenant.
+type WebhookRepository interface {
+	CreateEndpoint(ctx context.Context, url string, secret string, events []string) (*models.WebhookEndpoint, error)
+	ListEndpoints(ctx context.Context) ([]models.WebhookEndpoint, error)
+	FindEndpoint(ctx context.Context, id int) (*models.WebhookEndpoint, error)
+	// DeleteEndpoint also gives up on its pending deliveries.
+	DeleteEndpoint(ctx context.Context, id int) (bool, error)
+	// EnableEndpoint clears an automatic or manual disable.
+	EnableEndpoint(ctx context.Context, id int) (bool, error)
+	// Enqueue creates a delivery of event for every enabled endpoint that
+	// subscribed to it. Enqueueing the same event again adds nothing.
+	Enqueue(ctx context.Context, event models.Event) (int, error)
+	ListDeliveries(ctx context.Context, endpointId int, limit int) ([]models.WebhookDelivery, error)
+	// FindDelivery returns the delivery with its log.
+	FindDelivery(ctx context.Context, endpointId int, id int) (*models.WebhookDelivery, error)
+	// Redeliver makes a delivery due now with a fresh set of attempts.
+	Redeliver(ctx context.Context, endpointId int, id int) (bool, error)
+
+	// ClaimDeliveries leases up to limit due deliveries of enabled
+	// endpoints, with the endpoint's URL and secret filled in.
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	// RecordAttempt logs an attempt, stores delivery.Status and
+	// NextAttemptAt, and counts the endpoint's consecutive failures,
+	// disabling it at disableAfter. It reports whether the endpoint is
+	// disabled. It records nothing, and returns false for held, when
+	// another dispatcher recorded an attempt since delivery was claimed
+	// with delivery.Attempts.
+	RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery, attempt models.WebhookAttempt, disableAfter int) (held bool, disabled bool, err error)
+}
+
+type webhookRepositoryImpl struct {
+	DB DBTX
+}
+
+func NewWebhookRepository(DB *sql.DB) WebhookRepository {
+	return &webhookRepositoryImpl{DB: DB}
+}
+
+const webhookEndpointColumns = "id, tenant_id, url, events, consecutive_failures, disabled_at, disabled_reason, created_at"
+
+func scanWebhookEndpoint(row rowScanner) (*models.WebhookEndpoint, error) {
+	e := &models.WebhookEndpoint{}
+	var disabledAt sql.NullTime
+	err := row.Scan(&e.Id, &e.TenantId, &e.URL, pq.Array(&e.Events), &e.ConsecutiveFailures, &disabledAt, &e.DisabledReason, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if disabledAt.Valid {
+		e.DisabledAt = &disabledAt.Time
+	}
+	return e, nil
+}
+
+const webhookDeliveryColumns = "id, tenant_id, endpoint_id, event_id, event_type, payload, status, attempts, next_attempt_at, created_at, delivered_at"
+
+func scanWebhookDelivery(row rowScanner, extra ...any) (*models.WebhookDelivery, error) {
+	d := &models.WebhookDelivery{}
+	var payload []byte
+	var nextAttemptAt, deliveredAt sql.NullTime
+	dest := append([]any{&d.Id, &d.TenantId, &d.EndpointId, &d.EventId, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&nextAttemptAt, &d.CreatedAt, &deliveredAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	d.Payload = payload
+	if nextAttemptAt.Valid {
+		d.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+	return d, nil
+}
+
+func (r *webhookRepositoryImpl) CreateEndpoint(ctx context.Context, url string, secret string, events []string) (*models.WebhookEndpoint, error) {
+	query := `INSERT INTO webhook_endpoints (tenant_id, url, secret, events) VALUES ($1, $2, $3, $4) RETURNING ` + webhookEndpointColumns
+
+	var endpoint *models.WebhookEndpoint
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		var err error
+		endpoint, err = scanWebhookEndpoint(tx.QueryRowContext(ctx, query, tenantId, url, secret, pq.Array(events)))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	endpoint.Secret = secret
+	return endpoint, nil
+}
+
+func (r *webhookRepositoryImpl) ListEndpoints(ctx context.Context) ([]models.WebhookEndpoint, error) {
+	query := `SELECT ` + webhookEndpointColumns + ` FROM webhook_endpoints WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY id`
+
+	var endpoints []models.WebhookEndpoint
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		rows, err := tx.QueryContext(ctx, query, tenantId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			e, err := scanWebhookEndpoint(rows)
+			if err != nil {
+				return err
+			}
+			endpoints = append(endpoints, *e)
+		}
+		return rows.Err()
+	})
+	return endpoints, err
+}
+
+func (r *webhookRepositoryImpl) FindEndpoint(ctx context.Context, id int) (*models.WebhookEndpoint, error) {
+	query := `SELECT ` + webhookEndpointColumns + ` FROM webhook_endpoints WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NULL`
+
+	var endpoint *models.WebhookEndpoint
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		var err error
+		endpoint, err = scanWebhookEndpoint(tx.QueryRowContext(ctx, query, tenantId, id))
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return endpoint, err
+}
+
+func (r *webhookRepositoryImpl) DeleteEndpoint(ctx context.Context, id int) (bool, error) {
+	var found bool
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		res, err := tx.ExecContext(ctx, `UPDATE webhook_endpoints SET deleted_at = now() WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NULL`, tenantId, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil || n == 0 {
+			return err
+		}
+		found = true
+		query := `UPDATE webhook_deliveries SET status = 'failed', next_attempt_at = NULL WHERE tenant_id = $1 AND endpoint_id = $2 AND status = 'pending'`
+		_, err = tx.ExecContext(ctx, query, tenantId, id)
+		return err
+	})
+	return found, err
+}
+
+func (r *webhookRepositoryImpl) EnableEndpoint(ctx context.Context, id int) (bool, error) {
+	query := `UPDATE webhook_endpoints SET disabled_at = NULL, disabled_reason = '', consecutive_failures = 0
+        WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NULL`
+
+	var found bool
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		res, err := tx.ExecContext(ctx, query, tenantId, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		found = n > 0
+		return err
+	})
+	return found, err
+}
+
+func (r *webhookRepositoryImpl) Enqueue(ctx context.Context, event models.Event) (int, error) {
+	query := `INSERT INTO webhook_deliveries (tenant_id, endpoint_id, event_id, event_type, payload)
+        SELECT tenant_id, id, $2, $3, $4 FROM webhook_endpoints
+        WHERE tenant_id = $1 AND deleted_at IS NULL AND disabled_at IS NULL AND ($3 = ANY(events) OR '*' = ANY(events))
+        ON CONFLICT (endpoint_id, event_id) DO NOTHING`
+
+	var n int64
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		res, err := tx.ExecContext(ctx, query, tenantId, event.Id, event.Type, []byte(event.Payload))
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return int(n), err
+}
+
+func (r *webhookRepositoryImpl) ListDeliveries(ctx context.Context, endpointId int, limit int) ([]models.WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE tenant_id = $1 AND endpoint_id = $2 ORDER BY id DESC LIMIT $3`
+
+	var deliveries []models.WebhookDelivery
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		rows, err := tx.QueryContext(ctx, query, tenantId, endpointId, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			d, err := scanWebhookDelivery(rows)
+			if err != nil {
+				return err
+			}
+			deliveries = append(deliveries, *d)
+		}
+		return rows.Err()
+	})
+	return deliveries, err
+}
+
+func (r *webhookRepositoryImpl) FindDelivery(ctx context.Context, endpointId int, id int) (*models.WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE tenant_id = $1 AND endpoint_id = $2 AND id = $3`
+	logQuery := `SELECT id, delivery_id, COALESCE(status_code, 0), error, duration_ms, created_at FROM webhook_attempts
+        WHERE tenant_id = $1 AND delivery_id = $2 ORDER BY id`
+
+	var delivery *models.WebhookDelivery
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		var err error
+		if delivery, err = scanWebhookDelivery(tx.QueryRowContext(ctx, query, tenantId, endpointId, id)); err != nil {
+			return err
+		}
+		rows, err := tx.QueryContext(ctx, logQuery, tenantId, id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var a models.WebhookAttempt
+			if err := rows.Scan(&a.Id, &a.DeliveryId, &a.StatusCode, &a.Error, &a.DurationMs, &a.CreatedAt); err != nil {
+				return err
+			}
+			delivery.Log = append(delivery.Log, a)
+		}
+		return rows.Err()
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return delivery, err
+}
+
+func (r *webhookRepositoryImpl) Redeliver(ctx context.Context, endpointId int, id int) (bool, error) {
+	query := `UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = now(), delivered_at = NULL
+        WHERE tenant_id = $1 AND endpoint_id = $2 AND id = $3`
+
+	var found bool
+	err := inTenant(ctx, r.DB, func(tx *sql.Tx, tenantId int) error {
+		res, err := tx.ExecContext(ctx, query, tenantId, endpointId, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		found = n > 0
+		return err
+	})
+	return found, err
+}
+
+func (r *webhookRepositoryImpl) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	query := `WITH due AS (
+            SELECT d.id FROM webhook_deliveries d JOIN webhook_endpoints e ON e.id = d.endpoint_id
+            WHERE d.status = 'pending' AND d.next_attempt_at <= now() AND e.disabled_at IS NULL AND e.deleted_at IS NULL
+            ORDER BY d.next_attempt_at LIMIT $1 FOR UPDATE OF d SKIP LOCKED
+        )
+        UPDATE webhook_deliveries d SET next_attempt_at = now() + make_interval(secs => $2)
+        FROM due, webhook_endpoints e WHERE d.id = due.id AND e.id = d.endpoint_id
+        RETURNING d.id, d.tenant_id, d.endpoint_id, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+            d.next_attempt_at, d.created_at, d.delivered_at, e.url, e.secret`
+
+	rows, err := r.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var url, secret string
+		d, err := scanWebhookDelivery(rows, &url, &secret)
+		if err != nil {
+			return nil, err
+		}
+		d.URL, d.Secret = url, secret
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (r *webhookRepositoryImpl) RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery, attempt models.WebhookAttempt, disableAfter int) (bool, bool, error) {
+	// One statement, so the log, the delivery and the endpoint agree; the
+	// attempts check drops the outcome of a claim whose lease ran out
+	query := `WITH delivery AS (
+            UPDATE webhook_deliveries SET attempts = attempts + 1, status = $6, next_attempt_at = $7,
+                delivered_at = CASE WHEN $6 = 'succeeded' THEN now() END
+            WHERE id = $2 AND status = 'pending' AND attempts = $10
+            RETURNING id
+        ), logged AS (
+            INSERT INTO webhook_attempts (tenant_id, delivery_id, status_code, error, duration_ms)
+            SELECT $1::INTEGER, id, NULLIF($3::INTEGER, 0), $4::TEXT, $5::INTEGER FROM delivery
+        )
+        UPDATE webhook_endpoints SET
+            consecutive_failures = CASE WHEN $6 = 'succeeded' THEN 0 ELSE consecutive_failures + 1 END,
+            disabled_at = CASE WHEN $6 <> 'succeeded' AND disabled_at IS NULL AND consecutive_failures + 1 >= $9 THEN now() ELSE disabled_at END,
+            disabled_reason = CASE WHEN $6 <> 'succeeded' AND disabled_at IS NULL AND consecutive_failures + 1 >= $9
+                THEN 'too many failed deliveries' ELSE disabled_reason END
+        WHERE id = $8 AND EXISTS (SELECT 1 FROM delivery)
+        RETURNING disabled_at IS NOT NULL`
+
+	var disabled bool
+	err := r.DB.QueryRowContext(ctx, query, delivery.TenantId, delivery.Id, attempt.StatusCode, attempt.Error, attempt.DurationMs,
+		delivery.Status, delivery.NextAttemptAt, delivery.EndpointId, disableAfter, delivery.Attempts).Scan(&disabled)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	return err == nil, disabled, err
+}
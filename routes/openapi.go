@@ -0,0 +1,534 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"server-go/models"
+	"server-go/oidc"
+	"server-go/openapi"
+	"server-go/webauthn"
+)
+
+const (
+	bearer      = "bearerAuth"
+	clientBasic = "clientBasic"
+)
+
+var (
+	errorBody   = openapi.Object{"error": ""}
+	messageBody = openapi.Object{"message": ""}
+	loginBody   = openapi.Object{"token": "", "message": ""}
+	html        = openapi.Content{"text/html": openapi.String()}
+)
+
+// Spec describes every route SetUpRoutes registers. routes_test fails when
+// the two disagree, so add a route here along with the handler.
+func Spec() *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title:   "server-go",
+		Version: "1.0.0",
+		Description: "User accounts, organizations and sign-in. Requests name their organization " +
+			"by subdomain or the X-Tenant header; tokens are only valid in the organization they were issued for.\n\n" +
+			"The JSON API is versioned: call it under /v1, or at the unversioned paths with a version parameter " +
+			"in Accept, such as \"application/json; version=1\". Unversioned requests without one are deprecated; " +
+			"their responses carry Deprecation, Sunset and Link headers.",
+	})
+	doc.ErrorBody = errorBody
+	doc.Components.SecuritySchemes[bearer] = openapi.SecurityScheme{
+		Type: "http", Scheme: "bearer", BearerFormat: "JWT",
+		Description: "A session token from a sign-in route, or a personal access token.",
+	}
+	doc.Components.SecuritySchemes[clientBasic] = openapi.SecurityScheme{
+		Type: "http", Scheme: "basic",
+		Description: "OAuth client id and secret; they may also be sent as client_id and client_secret form fields.",
+	}
+	doc.Tags = []openapi.Tag{
+		{Name: "auth", Description: "Signing in and out"},
+		{Name: "account", Description: "The caller's own account"},
+		{Name: "organizations", Description: "Memberships, invitations and webhooks of the active organization"},
+		{Name: "oauth", Description: "OpenID Connect provider and token endpoints"},
+		{Name: "admin", Description: "Administration of the users of an organization"},
+		{Name: "system", Description: "Service endpoints"},
+	}
+
+	system(doc)
+	auth(doc)
+	account(doc)
+	organizations(doc)
+	oauth(doc)
+	admin(doc)
+	return doc
+}
+
+// versionedDoc documents each route of the JSON API under every version
+// and, deprecated, at its unversioned path, as SetUpRoutes registers them.
+type versionedDoc struct {
+	*openapi.Document
+}
+
+func (d versionedDoc) Route(method string, path string, op openapi.Op) {
+	for _, version := range apiVersions {
+		prefix := "/v" + strconv.Itoa(version)
+		versioned := op
+		if op.Path != "" {
+			versioned.Path = prefix + op.Path
+		}
+		d.Document.Route(method, prefix+path, versioned)
+	}
+	op.Deprecated = true
+	d.Document.Route(method, path, op)
+}
+
+func system(doc *openapi.Document) {
+	doc.Route(http.MethodGet, "/", openapi.Op{
+		Summary: "Check that the service is up", Tags: []string{"system"},
+		Responses: map[int]any{200: messageBody},
+	})
+	doc.Route(http.MethodGet, "/metrics", openapi.Op{
+		Summary: "Prometheus metrics", Tags: []string{"system"},
+		Responses: map[int]any{200: openapi.Content{"text/plain": openapi.String()}},
+	})
+	doc.Route(http.MethodGet, "/openapi.json", openapi.Op{
+		Summary: "This document", Tags: []string{"system"},
+		Responses: map[int]any{200: openapi.Any()},
+	})
+	doc.Route(http.MethodGet, "/docs", openapi.Op{
+		Summary: "Interactive documentation", Tags: []string{"system"},
+		Responses: map[int]any{200: html},
+	})
+}
+
+func auth(doc *openapi.Document) {
+	api := versionedDoc{doc}
+	tags := []string{"auth"}
+	api.Route(http.MethodPost, "/login", openapi.Op{
+		Summary: "Sign in with email and password", Tags: tags,
+		Description: "Also sets the session_token cookie. 403 means the account is not active or the password expired.",
+		Body:        models.LoginUser{},
+		Responses:   map[int]any{200: loginBody},
+		Errors:      []int{400, 401, 403, 404},
+	})
+	api.Route(http.MethodPost, "/register", openapi.Op{
+		Summary: "Create an account", Tags: tags,
+		Description: "inviteToken joins the organization that sent the invitation instead of the requested one. Also accepts the invitation page's form.",
+		Body:        models.User{},
+		Responses:   map[int]any{201: openapi.Object{"message": "", "token": ""}},
+		Errors:      []int{400, 403, 409},
+	})
+	doc.Route(http.MethodGet, "/password/reset", openapi.Op{
+		Summary: "Page a password reset link opens", Tags: tags,
+		Query:     []openapi.Parameter{{Name: "token", In: "query", Required: true, Schema: openapi.String()}},
+		Responses: map[int]any{200: html, 400: html},
+	})
+	api.Route(http.MethodPost, "/password/reset", openapi.Op{
+		Summary: "Set a new password with a reset token", Tags: tags,
+		Description: "The organization is the one the token was issued in.",
+		Body:        models.PasswordReset{},
+		Responses:   map[int]any{200: messageBody},
+		Errors:      []int{400},
+	})
+	api.Route(http.MethodPost, "/logout", openapi.Op{
+		Summary: "Sign out the current session", Tags: tags, Security: []string{bearer},
+		Responses: map[int]any{200: messageBody},
+		Errors:    []int{401},
+	})
+
+	api.Route(http.MethodPost, "/login/magic", openapi.Op{
+		Summary: "Email a sign-in link", Tags: tags,
+		Body:      models.MagicLinkRequest{},
+		Responses: map[int]any{202: messageBody},
+		Errors:    []int{400, 429},
+	})
+	doc.Route(http.MethodGet, "/login/magic/verify", openapi.Op{
+		Summary: "Page the emailed sign-in link opens", Tags: tags,
+		Query:     []openapi.Parameter{{Name: "token", In: "query", Required: true, Schema: openapi.String()}},
+		Responses: map[int]any{200: html, 400: html},
+	})
+	api.Route(http.MethodPost, "/login/magic/verify", openapi.Op{
+		Summary: "Redeem a sign-in link", Tags: tags,
+		Body:      models.MagicLinkConfirm{},
+		Responses: map[int]any{200: loginBody},
+		Errors:    []int{400, 401, 403},
+	})
+
+	api.Route(http.MethodGet, "/auth/providers", openapi.Op{
+		Summary: "Configured social login providers", Tags: tags,
+		Responses: map[int]any{200: openapi.Object{"providers": []string{}}},
+	})
+	doc.Route(http.MethodGet, "/auth/:provider/start", openapi.Op{
+		Summary: "Start signing in with a provider", Tags: tags,
+		Responses: map[int]any{302: nil},
+		Errors:    []int{404, 502},
+	})
+	doc.Route(http.MethodGet, "/auth/:provider/callback", openapi.Op{
+		Summary: "Finish signing in with a provider", Tags: tags,
+		Query: []openapi.Parameter{
+			{Name: "code", In: "query", Schema: openapi.String()},
+			{Name: "state", In: "query", Schema: openapi.String()},
+			{Name: "error", In: "query", Description: "Set by the provider when the user did not sign in", Schema: openapi.String()},
+		},
+		Responses: map[int]any{200: loginBody},
+		Errors:    []int{400, 401, 403, 404, 409},
+	})
+
+	api.Route(http.MethodPost, "/webauthn/login/begin", openapi.Op{
+		Summary: "Start signing in with a passkey", Tags: tags,
+		Body:      models.BeginPasskeyLogin{},
+		Responses: map[int]any{200: openapi.Object{"publicKey": webauthn.RequestOptions{}}},
+		Errors:    []int{400},
+	})
+	api.Route(http.MethodPost, "/webauthn/login/finish", openapi.Op{
+		Summary: "Finish signing in with a passkey", Tags: tags,
+		Body:      webauthn.AssertionResponse{},
+		Responses: map[int]any{200: loginBody},
+		Errors:    []int{400, 401, 403},
+	})
+}
+
+func account(doc *openapi.Document) {
+	api := versionedDoc{doc}
+	tags := []string{"account"}
+	secured := []string{bearer}
+	api.Route(http.MethodGet, "/me", openapi.Op{
+		Summary: "The signed-in user", Tags: tags, Security: secured,
+		Responses: map[int]any{200: openapi.Object{"userID": 0, "name": "", "lastName": "", "Email": "", "password": ""}},
+		Errors:    []int{401},
+	})
+	api.Route(http.MethodPut, "/user/:id", openapi.Op{
+		Summary: "Update a user", Tags: tags, Security: secured,
+		Description: "Users update themselves, admins anyone. Needs a recent sign-in. Passwords and emails change through /me/password and /me/email.",
+		Body:        models.User{},
+		Responses:   map[int]any{200: openapi.Object{"message": "", "user": models.User{}}},
+		Errors:      []int{400, 401, 403, 404, 409},
+	})
+	api.Route(http.MethodPost, "/me/password", openapi.Op{
+		Summary: "Change the password", Tags: tags, Security: secured,
+		Description: "Needs a recent sign-in. Other sessions are signed out; this one continues with the returned token.",
+		Body:        models.ChangePassword{},
+		Responses:   map[int]any{200: openapi.Object{"message": "", "token": ""}},
+		Errors:      []int{400, 401, 403, 404},
+	})
+	api.Route(http.MethodPost, "/me/email", openapi.Op{
+		Summary: "Ask to change the email address", Tags: tags, Security: secured,
+		Description: "Needs a recent sign-in. The address changes once the link sent to it is followed.",
+		Body:        models.ChangeEmail{},
+		Responses:   map[int]any{202: messageBody},
+		Errors:      []int{400, 401, 403, 404, 409},
+	})
+	doc.Route(http.MethodGet, "/email/confirm", openapi.Op{
+		Summary: "Page the emailed confirmation link opens", Tags: tags,
+		Query:     []openapi.Parameter{{Name: "token", In: "query", Required: true, Schema: openapi.String()}},
+		Responses: map[int]any{200: html, 400: html},
+	})
+	api.Route(http.MethodPost, "/email/confirm", openapi.Op{
+		Summary: "Confirm an email change", Tags: tags,
+		Body:      models.EmailChangeConfirm{},
+		Responses: map[int]any{200: openapi.Object{"message": "", "email": ""}},
+		Errors:    []int{400, 409},
+	})
+
+	api.Route(http.MethodGet, "/me/sessions", openapi.Op{
+		Summary: "Signed-in sessions", Tags: tags, Security: secured,
+		Responses: map[int]any{200: openapi.Object{"sessions": []models.Session{}}},
+		Errors:    []int{401},
+	})
+	api.Route(http.MethodDelete, "/me/sessions/:id", openapi.Op{
+		Summary: "Sign out a session", Tags: tags, Security: secured,
+		Responses: map[int]any{204: nil},
+		Errors:    []int{400, 401, 404},
+	})
+
+	api.Route(http.MethodGet, "/me/passkeys", openapi.Op{
+		Summary: "Registered passkeys", Tags: tags, Security: secured,
+		Responses: map[int]any{200: openapi.Object{"passkeys": []models.Passkey{}}},
+		Errors:    []int{401},
+	})
+	api.Route(http.MethodDelete, "/me/passkeys/:id", openapi.Op{
+		Summary: "Remove a passkey", Tags: tags, Security: secured,
+		Responses: map[int]any{204: nil},
+		Errors:    []int{400, 401, 404},
+	})
+	api.Route(http.MethodPost, "/webauthn/register/begin", openapi.Op{
+		Summary: "Start registering a passkey", Tags: tags, Security: secured,
+		Responses: map[int]any{200: openapi.Object{"publicKey": webauthn.CreationOptions{}}},
+		Errors:    []int{401, 403, 404},
+	})
+	api.Route(http.MethodPost, "/webauthn/register/finish", openapi.Op{
+		Summary: "Finish registering a passkey", Tags: tags, Security: secured,
+		Body:      models.FinishPasskeyRegistration{},
+		Responses: map[int]any{201: models.Passkey{}},
+		Errors:    []int{400, 401, 409},
+	})
+
+	api.Route(http.MethodGet, "/me/tokens", openapi.Op{
+		Summary: "Personal access tokens", Tags: tags, Security: secured,
+		Responses: map[int]any{200: openapi.Object{"tokens": []models.AccessToken{}}},
+		Errors:    []int{401},
+	})
+	api.Route(http.MethodPost, "/me/tokens", openapi.Op{
+		Summary: "Create a personal access token", Tags: tags, Security: secured,
+		Description: "The token is only ever returned here. Called with an access token, the new token may have no other scopes and expires no later.",
+		Body:        models.CreateAccessToken{},
+		Responses:   map[int]any{201: models.NewAccessToken{}},
+		Errors:      []int{400, 401, 403, 404},
+	})
+	api.Route(http.MethodDelete, "/me/tokens/:id", openapi.Op{
+		Summary: "Revoke a personal access token", Tags: tags, Security: secured,
+		Responses: map[int]any{204: nil},
+		Errors:    []int{400, 401, 404},
+	})
+}
+
+func organizations(doc *openapi.Document) {
+	api := versionedDoc{doc}
+	tags := []string{"organizations"}
+	secured := []string{bearer}
+	api.Route(http.MethodGet, "/me/organizations", openapi.Op{
+		Summary: "Organizations the user belongs to", Tags: tags, Security: secured,
+		Responses: map[int]any{200: openapi.Object{"organizations": []models.Membership{}, "active": 0}},
+		Errors:    []int{401},
+	})
+	api.Route(http.MethodPost, "/orgs/switch", openapi.Op{
+		Summary: "Get a token for another organization", Tags: tags, Security: secured,
+		Body:      models.SwitchOrganization{},
+		Responses: map[int]any{200: openapi.Object{"token": "", "organizationId": 0}},
+		Errors:    []int{400, 401, 403, 404},
+	})
+	api.Route(http.MethodGet, "/org/members", openapi.Op{
+		Summary: "Members of the active organization", Tags: tags, Security: secured,
+		Responses: map[int]any{200: openapi.Object{"members": []models.Membership{}}},
+		Errors:    []int{401},
+	})
+	api.Route(http.MethodPut, "/org/members/:userId", openapi.Op{
+		Summary: "Change a member's role", Tags: tags, Security: secured,
+		Description: "Owners and admins only.",
+		Body:        models.MemberRole{},
+		Responses:   map[int]any{200: openapi.Object{"membership": models.Membership{}}},
+		Errors:      []int{400, 401, 403, 404, 409},
+	})
+	api.Route(http.MethodPost, "/org/invitations", openapi.Op{
+		Summary: "Invite someone by email", Tags: tags, Security: secured,
+		Description: "Owners and admins only.",
+		Body:        models.InviteRequest{},
+		Responses:   map[int]any{201: openapi.Object{"invitation": models.Invitation{}}},
+		Errors:      []int{400, 401, 403},
+	})
+	api.Route(http.MethodGet, "/org/invitations", openapi.Op{
+		Summary: "Pending invitations", Tags: tags, Security: secured,
+		Description: "Owners and admins only.",
+		Responses:   map[int]any{200: openapi.Object{"invitations": []models.Invitation{}}},
+		Errors:      []int{401, 403},
+	})
+	doc.Route(http.MethodGet, "/invitations/accept", openapi.Op{
+		Summary: "Page an emailed invitation link opens", Tags: tags,
+		Description: "Lets new invitees register; existing accounts accept with the POST.",
+		Query:       []openapi.Parameter{{Name: "token", In: "query", Required: true, Schema: openapi.String()}},
+		Responses:   map[int]any{200: html, 400: html, 500: html},
+	})
+	api.Route(http.MethodPost, "/invitations/accept", openapi.Op{
+		Summary: "Join the organization that sent an invitation", Tags: tags, Security: secured,
+		Body:      models.AcceptInvitation{},
+		Responses: map[int]any{200: openapi.Object{"membership": models.Membership{}}},
+		Errors:    []int{400, 401},
+	})
+
+	webhook := openapi.Object{"webhook": models.WebhookEndpoint{}}
+	api.Route(http.MethodGet, "/org/webhooks", openapi.Op{
+		Summary: "Webhook endpoints", Tags: tags, Security: secured,
+		Description: "Owners and admins only.",
+		Responses:   map[int]any{200: openapi.Object{"webhooks": []models.WebhookEndpoint{}}},
+		Errors:      []int{401, 403},
+	})
+	api.Route(http.MethodPost, "/org/webhooks", openapi.Op{
+		Summary: "Add a webhook endpoint", Tags: tags, Security: secured,
+		Description: "Owners and admins only. A secret, if given, must be at least 32 characters; otherwise one is generated. The signing secret is only ever returned here.",
+		Body:        models.WebhookEndpointInput{},
+		Responses:   map[int]any{201: webhook},
+		Errors:      []int{400, 401, 403},
+	})
+	api.Route(http.MethodDelete, "/org/webhooks/:id", openapi.Op{
+		Summary: "Remove a webhook endpoint", Tags: tags, Security: secured,
+		Responses: map[int]any{204: nil},
+		Errors:    []int{400, 401, 403, 404},
+	})
+	api.Route(http.MethodPost, "/org/webhooks/:id/enable", openapi.Op{
+		Summary: "Re-enable an endpoint disabled after failing", Tags: tags, Security: secured,
+		Responses: map[int]any{204: nil},
+		Errors:    []int{400, 401, 403, 404},
+	})
+	api.Route(http.MethodGet, "/org/webhooks/:id/deliveries", openapi.Op{
+		Summary: "Recent deliveries to an endpoint", Tags: tags, Security: secured,
+		Responses: map[int]any{200: openapi.Object{"deliveries": []models.WebhookDelivery{}}},
+		Errors:    []int{400, 401, 403, 404},
+	})
+	api.Route(http.MethodGet, "/org/webhooks/:id/deliveries/:deliveryId", openapi.Op{
+		Summary: "A delivery with its attempts", Tags: tags, Security: secured,
+		Responses: map[int]any{200: openapi.Object{"delivery": models.WebhookDelivery{}}},
+		Errors:    []int{400, 401, 403, 404},
+	})
+	api.Route(http.MethodPost, "/org/webhooks/:id/deliveries/:deliveryId/redeliver", openapi.Op{
+		Summary: "Send a delivery again", Tags: tags, Security: secured,
+		Responses: map[int]any{202: messageBody},
+		Errors:    []int{400, 401, 403, 404},
+	})
+}
+
+func oauth(doc *openapi.Document) {
+	tags := []string{"oauth"}
+	oauthError := models.OAuthError{}
+	doc.Route(http.MethodGet, "/.well-known/openid-configuration", openapi.Op{
+		Summary: "OpenID Connect discovery", Tags: tags,
+		Responses: map[int]any{200: map[string]any{}},
+	})
+	doc.Route(http.MethodGet, "/.well-known/jwks.json", openapi.Op{
+		Summary: "Keys ID tokens are signed with", Tags: tags,
+		Responses: map[int]any{200: oidc.JWKS{}},
+	})
+	doc.Route(http.MethodGet, "/authorize", openapi.Op{
+		Summary: "Authorization endpoint", Tags: tags,
+		Description: "Shows the sign-in or consent page, or redirects back to the client.",
+		QueryStruct: models.AuthorizationRequest{},
+		Responses:   map[int]any{200: html, 302: nil, 400: html},
+	})
+	doc.Route(http.MethodPost, "/authorize", openapi.Op{
+		Summary: "Submit the sign-in or consent page", Tags: tags,
+		QueryStruct: models.AuthorizationRequest{},
+		Form: openapi.Content{"application/x-www-form-urlencoded": &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{
+			"email":    openapi.String(),
+			"password": openapi.String(),
+			"consent":  openapi.Enum("allow", "deny"),
+		}}},
+		Responses: map[int]any{200: html, 302: nil, 400: html, 401: html},
+	})
+	doc.Route(http.MethodPost, "/token", openapi.Op{
+		Summary: "Exchange an authorization code", Tags: tags, Security: []string{clientBasic},
+		Form:      models.TokenRequest{},
+		Responses: map[int]any{200: models.TokenResponse{}, 400: oauthError, 401: oauthError},
+	})
+	userInfo := openapi.Op{
+		Summary: "Claims about the user of an access token", Tags: tags, Security: []string{bearer},
+		Responses: map[int]any{200: map[string]any{}, 401: oauthError},
+	}
+	doc.Route(http.MethodGet, "/userinfo", userInfo)
+	doc.Route(http.MethodPost, "/userinfo", userInfo)
+
+	tokenForm := openapi.Content{"application/x-www-form-urlencoded": &openapi.Schema{
+		Type:       "object",
+		Properties: map[string]*openapi.Schema{"token": openapi.String(), "token_type_hint": openapi.String()},
+		Required:   []string{"token"},
+	}}
+	doc.Route(http.MethodPost, "/oauth/introspect", openapi.Op{
+		Summary: "Describe a token (RFC 7662)", Tags: tags, Security: []string{clientBasic},
+		Form:      tokenForm,
+		Responses: map[int]any{200: map[string]any{}, 400: oauthError, 401: oauthError},
+	})
+	doc.Route(http.MethodPost, "/oauth/revoke", openapi.Op{
+		Summary: "Revoke a token (RFC 7009)", Tags: tags, Security: []string{clientBasic},
+		Description: "Unknown and already invalid tokens also get 200.",
+		Form:        tokenForm,
+		Responses:   map[int]any{200: nil, 400: oauthError, 401: oauthError},
+	})
+}
+
+func admin(doc *openapi.Document) {
+	api := versionedDoc{doc}
+	tags := []string{"admin"}
+	secured := []string{bearer}
+	userErrors := []int{400, 401, 403, 404}
+
+	api.Route(http.MethodGet, "/audit", openapi.Op{
+		Summary: "Audit events, newest first", Tags: tags, Security: secured,
+		Query: []openapi.Parameter{
+			{Name: "actor_id", In: "query", Schema: openapi.Integer()},
+			{Name: "target_id", In: "query", Schema: openapi.Integer()},
+			{Name: "action", In: "query", Schema: openapi.String()},
+			{Name: "since", In: "query", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+			{Name: "until", In: "query", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+			{Name: "limit", In: "query", Schema: openapi.Integer()},
+			{Name: "offset", In: "query", Schema: openapi.Integer()},
+		},
+		Responses: map[int]any{200: openapi.Object{"events": []models.AuditEvent{}, "total": 0, "limit": 0, "offset": 0}},
+		Errors:    []int{400, 401, 403},
+	})
+
+	format := openapi.Parameter{Name: "format", In: "query", Description: "Taken from the Accept header when missing", Schema: openapi.Enum(models.FormatCSV, models.FormatNDJSON)}
+	api.Route(http.MethodPost, "/admin/users:verb", openapi.Op{
+		Summary: "Import users from CSV or NDJSON", Tags: tags, Security: secured,
+		Path: "/admin/users:import",
+		Query: []openapi.Parameter{
+			format,
+			{Name: "mode", In: "query", Schema: openapi.Enum("dry-run", "commit")},
+			{Name: "invite", In: "query", Description: "Email the imported users a link to set their password", Schema: &openapi.Schema{Type: "boolean"}},
+			{Name: "batch_size", In: "query", Schema: openapi.Integer()},
+		},
+		Body:      openapi.Content{"text/csv": openapi.String(), "application/x-ndjson": openapi.String()},
+		Responses: map[int]any{200: models.ImportReport{}, 415: errorBody},
+		Errors:    []int{400, 401, 403, 500},
+	})
+	api.Route(http.MethodPost, "/admin/users:verb", openapi.Op{
+		Summary: "Export users in the background", Tags: tags, Security: secured,
+		Description: "The caller is emailed a download link once the export is ready.",
+		Path:        "/admin/users:export",
+		Query:       []openapi.Parameter{format},
+		Responses:   map[int]any{202: messageBody},
+		Errors:      []int{401, 403, 409},
+	})
+	api.Route(http.MethodGet, "/admin/users:verb", openapi.Op{
+		Summary: "Export users", Tags: tags, Security: secured,
+		Path:      "/admin/users:export",
+		Query:     []openapi.Parameter{format},
+		Responses: map[int]any{200: openapi.Content{"text/csv": openapi.String(), "application/x-ndjson": openapi.String()}},
+		Errors:    []int{401, 403},
+	})
+	api.Route(http.MethodGet, "/admin/exports/:name", openapi.Op{
+		Summary: "Download a finished export", Tags: tags, Security: secured,
+		Responses: map[int]any{200: openapi.Content{"application/octet-stream": openapi.Binary()}},
+		Errors:    []int{401, 403, 404},
+	})
+
+	api.Route(http.MethodPost, "/admin/service-accounts", openapi.Op{
+		Summary: "Create a service account", Tags: tags, Security: secured,
+		Body:      models.CreateServiceAccount{},
+		Responses: map[int]any{201: models.User{}},
+		Errors:    []int{400, 401, 403, 409},
+	})
+	status := openapi.Object{"id": 0, "status": "", "statusReason": "", "statusUntil": (*time.Time)(nil)}
+	api.Route(http.MethodPost, "/admin/user/:id/suspend", openapi.Op{
+		Summary: "Suspend an account", Tags: tags, Security: secured,
+		Description:  "The account is signed out everywhere.",
+		Body:         models.StatusUpdate{},
+		BodyOptional: true,
+		Responses:    map[int]any{200: status},
+		Errors:       append(userErrors, 409),
+	})
+	api.Route(http.MethodPost, "/admin/user/:id/reactivate", openapi.Op{
+		Summary: "Make an account active again", Tags: tags, Security: secured,
+		Body:         models.StatusUpdate{},
+		BodyOptional: true,
+		Responses:    map[int]any{200: status},
+		Errors:       append(userErrors, 409),
+	})
+	api.Route(http.MethodGet, "/admin/user/:id/status-history", openapi.Op{
+		Summary: "An account's status changes, newest first", Tags: tags, Security: secured,
+		Responses: map[int]any{200: openapi.Object{"history": []models.StatusChange{}}},
+		Errors:    userErrors,
+	})
+	api.Route(http.MethodGet, "/admin/user/:id/tokens", openapi.Op{
+		Summary: "A user's access tokens", Tags: tags, Security: secured,
+		Responses: map[int]any{200: openapi.Object{"tokens": []models.AccessToken{}}},
+		Errors:    userErrors,
+	})
+	api.Route(http.MethodPost, "/admin/user/:id/tokens", openapi.Op{
+		Summary: "Create an access token for a user", Tags: tags, Security: secured,
+		Body:      models.CreateAccessToken{},
+		Responses: map[int]any{201: models.NewAccessToken{}},
+		Errors:    userErrors,
+	})
+	api.Route(http.MethodDelete, "/admin/user/:id/tokens/:tokenId", openapi.Op{
+		Summary: "Revoke a user's access token", Tags: tags, Security: secured,
+		Responses: map[int]any{204: nil},
+		Errors:    userErrors,
+	})
+}
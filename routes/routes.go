@@ -1,21 +1,174 @@
 package routes
 
 import (
+	"log/slog"
+	"net/http"
+	"server-go/cache"
 	"server-go/controllers"
 	"server-go/middlewares"
+	"server-go/models"
+	"server-go/openapi"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetUpRoutes(r *gin.Engine, userController *controllers.UserController) {
+func SetUpRoutes(r *gin.Engine, userController *controllers.UserController, auditController *controllers.AuditController, bulkController *controllers.UserBulkController, orgController *controllers.OrganizationController, socialController *controllers.SocialLoginController, oidcController *controllers.OIDCProviderController, tokenController *controllers.TokenController, accessTokenController *controllers.AccessTokenController, sessionController *controllers.SessionController, webauthnController *controllers.WebAuthnController, magicLinkController *controllers.MagicLinkController, accountController *controllers.AccountController, webhookController *controllers.WebhookController, reauthWindow time.Duration, revocations middlewares.RevocationChecker, fresh middlewares.RevocationChecker, deprecation middlewares.Deprecation, logger *slog.Logger) {
+	auth := middlewares.AuthMiddleware(logger, revocations)
+	// Routes acting on the caller's account, role or credentials may check
+	// the stored user rather than the token's claims; see config.FreshUsers
+	freshAuth := middlewares.AuthMiddleware(logger, fresh)
+	// Changing credentials needs a recent login, not just a valid token
+	recentAuth := middlewares.RequireRecentAuth(reauthWindow)
+	admin := middlewares.RequireRole(models.RoleAdmin)
+	orgAdmin := middlewares.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin)
+
 	r.GET("/", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message": "Hello",
 		})
 	})
-	r.POST("/login", userController.Login)
-	r.POST("/register", userController.Register)
-	r.PUT("/user/:id", middlewares.AuthMiddleware(), userController.UpdateUser)
-	r.GET("/me", middlewares.AuthMiddleware(), userController.Me)
-	r.POST("/logout", middlewares.AuthMiddleware(), userController.Logout)
+	r.GET("/metrics", func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4")
+		if err := cache.WriteMetrics(c.Writer); err != nil {
+			logger.ErrorContext(c.Request.Context(), "could not write metrics", "error", err)
+		}
+	})
+	spec := Spec()
+	r.GET("/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	})
+	r.GET("/docs", func(c *gin.Context) {
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := openapi.WriteDocsPage(c.Writer, spec.Info.Title, "/openapi.json"); err != nil {
+			logger.ErrorContext(c.Request.Context(), "could not write the docs page", "error", err)
+		}
+	})
+	tenant := middlewares.RequireTenant()
+
+	// The JSON API is served under each version and, deprecated, at the
+	// paths it had before versioning. Protocol endpoints and the pages
+	// emailed links open keep fixed paths.
+	api := versioned{r.Group("", middlewares.NegotiateVersion(apiVersions, deprecation))}
+	for _, version := range apiVersions {
+		api = append(api, r.Group("/v"+strconv.Itoa(version), middlewares.APIVersion(version)))
+	}
+	api.POST("/login", tenant, userController.Login)
+	api.POST("/login/magic", tenant, magicLinkController.Request)
+	// The organization comes from the signed link
+	r.GET("/login/magic/verify", magicLinkController.Confirm)
+	api.POST("/login/magic/verify", magicLinkController.Verify)
+	// An invitation token names the organization instead
+	api.POST("/register", userController.Register)
+	// The organization comes from the reset token
+	r.GET("/password/reset", userController.ResetPasswordPage)
+	api.POST("/password/reset", userController.ResetPassword)
+	// The organization comes from the signed link
+	r.GET("/email/confirm", accountController.ConfirmPage)
+	api.POST("/email/confirm", accountController.ConfirmEmail)
+	api.GET("/auth/providers", socialController.Providers)
+	r.GET("/auth/:provider/start", tenant, socialController.Start)
+	// The organization comes from the flow cookie set by /start
+	r.GET("/auth/:provider/callback", socialController.Callback)
+	api.POST("/webauthn/login/begin", tenant, webauthnController.BeginLogin)
+	// The organization comes from the flow cookie set by /begin
+	api.POST("/webauthn/login/finish", webauthnController.FinishLogin)
+	api.POST("/webauthn/register/begin", freshAuth, webauthnController.BeginRegistration)
+	api.POST("/webauthn/register/finish", freshAuth, webauthnController.FinishRegistration)
+	r.GET("/.well-known/openid-configuration", oidcController.Discovery)
+	r.GET("/.well-known/jwks.json", oidcController.JWKS)
+	r.GET("/authorize", tenant, oidcController.Authorize)
+	r.POST("/authorize", tenant, oidcController.Authorize)
+	r.POST("/token", oidcController.Token)
+	r.GET("/userinfo", oidcController.UserInfo)
+	r.POST("/userinfo", oidcController.UserInfo)
+	r.POST("/oauth/introspect", tokenController.Introspect)
+	r.POST("/oauth/revoke", tokenController.Revoke)
+	api.PUT("/user/:id", freshAuth, recentAuth, middlewares.RequireSelfOrRole("id", models.RoleAdmin), userController.UpdateUser)
+	api.GET("/me", freshAuth, userController.Me)
+	api.POST("/me/password", freshAuth, recentAuth, accountController.ChangePassword)
+	api.POST("/me/email", freshAuth, recentAuth, accountController.ChangeEmail)
+	api.POST("/logout", freshAuth, userController.Logout)
+	api.GET("/me/organizations", auth, orgController.Organizations)
+	api.POST("/orgs/switch", freshAuth, orgController.Switch)
+	api.GET("/org/members", auth, orgController.Members)
+	api.PUT("/org/members/:userId", freshAuth, orgAdmin, orgController.SetMemberRole)
+	api.POST("/org/invitations", freshAuth, orgAdmin, orgController.Invite)
+	api.GET("/org/invitations", freshAuth, orgAdmin, orgController.Invitations)
+	// The organization comes from the signed link
+	r.GET("/invitations/accept", orgController.InvitationPage)
+	api.POST("/invitations/accept", auth, orgController.AcceptInvitation)
+	api.GET("/org/webhooks", freshAuth, orgAdmin, webhookController.List)
+	api.POST("/org/webhooks", freshAuth, orgAdmin, webhookController.Create)
+	api.DELETE("/org/webhooks/:id", freshAuth, orgAdmin, webhookController.Delete)
+	api.POST("/org/webhooks/:id/enable", freshAuth, orgAdmin, webhookController.Enable)
+	api.GET("/org/webhooks/:id/deliveries", freshAuth, orgAdmin, webhookController.Deliveries)
+	api.GET("/org/webhooks/:id/deliveries/:deliveryId", freshAuth, orgAdmin, webhookController.Delivery)
+	api.POST("/org/webhooks/:id/deliveries/:deliveryId/redeliver", freshAuth, orgAdmin, webhookController.Redeliver)
+	api.GET("/me/sessions", freshAuth, sessionController.List)
+	api.DELETE("/me/sessions/:id", freshAuth, sessionController.Revoke)
+	api.GET("/me/passkeys", freshAuth, webauthnController.List)
+	api.DELETE("/me/passkeys/:id", freshAuth, webauthnController.Delete)
+	api.GET("/me/tokens", freshAuth, accessTokenController.List)
+	api.POST("/me/tokens", freshAuth, accessTokenController.Create)
+	api.DELETE("/me/tokens/:id", freshAuth, accessTokenController.Revoke)
+	api.GET("/audit", freshAuth, admin, auditController.List)
+	api.POST("/admin/users:verb", freshAuth, admin, customMethods(map[string]gin.HandlerFunc{"import": bulkController.Import, "export": bulkController.ExportLater}))
+	api.POST("/admin/service-accounts", freshAuth, admin, accessTokenController.CreateServiceAccount)
+	api.POST("/admin/user/:id/suspend", freshAuth, admin, userController.Suspend)
+	api.POST("/admin/user/:id/reactivate", freshAuth, admin, userController.Reactivate)
+	api.GET("/admin/user/:id/status-history", freshAuth, admin, userController.StatusHistory)
+	api.GET("/admin/user/:id/tokens", freshAuth, admin, accessTokenController.ListForUser)
+	api.POST("/admin/user/:id/tokens", freshAuth, admin, accessTokenController.CreateForUser)
+	api.DELETE("/admin/user/:id/tokens/:tokenId", freshAuth, admin, accessTokenController.RevokeForUser)
+	api.GET("/admin/users:verb", freshAuth, admin, customMethods(map[string]gin.HandlerFunc{"export": bulkController.Export}))
+	api.GET("/admin/exports/:name", freshAuth, admin, bulkController.DownloadExport)
+}
+
+// apiVersions are the versions of the JSON API, oldest first.
+var apiVersions = []int{1}
+
+// versioned registers each route in every group.
+type versioned []*gin.RouterGroup
+
+func (v versioned) GET(path string, handlers ...gin.HandlerFunc) {
+	for _, group := range v {
+		group.GET(path, handlers...)
+	}
+}
+
+func (v versioned) POST(path string, handlers ...gin.HandlerFunc) {
+	for _, group := range v {
+		group.POST(path, handlers...)
+	}
+}
+
+func (v versioned) PUT(path string, handlers ...gin.HandlerFunc) {
+	for _, group := range v {
+		group.PUT(path, handlers...)
+	}
+}
+
+func (v versioned) DELETE(path string, handlers ...gin.HandlerFunc) {
+	for _, group := range v {
+		group.DELETE(path, handlers...)
+	}
+}
+
+// customMethods serves "collection:verb" style routes. gin 1.9 cannot escape
+// ':' in a path, so "/admin/users:verb" captures everything after "users" in
+// the verb parameter and the handler is picked here.
+func customMethods(handlers map[string]gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		verb := c.Param("verb")
+		handler, ok := handlers[strings.TrimPrefix(verb, ":")]
+		if !ok || !strings.HasPrefix(verb, ":") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			return
+		}
+		handler(c)
+	}
 }
@@ -0,0 +1,180 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"server-go/controllers"
+	"server-go/logging"
+	"server-go/middlewares"
+	"server-go/models"
+	"server-go/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setUp(t *testing.T) *gin.Engine {
+	return setUpWith(t, nil)
+}
+
+// setUpWith routes /audit to auditController; the other handlers are never
+// called, so their controllers can be nil.
+func setUpWith(t *testing.T, auditController *controllers.AuditController) *gin.Engine {
+	return setUpFresh(t, auditController, nil)
+}
+
+// setUpFresh checks the routes that use freshAuth with fresh.
+func setUpFresh(t *testing.T, auditController *controllers.AuditController, fresh middlewares.RevocationChecker) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	SetUpRoutes(r, nil, auditController, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, time.Minute, nil, fresh, middlewares.Deprecation{Since: time.Now()}, logging.Discard())
+	return r
+}
+
+// allRevoked treats every token as revoked, like a stale token_version.
+type allRevoked struct{}
+
+func (allRevoked) TokensRevokedAt(ctx context.Context, id int) (time.Time, error) {
+	return time.Now().Add(time.Hour), nil
+}
+
+func (allRevoked) TokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return true, nil
+}
+
+func TestCredentialRoutesCheckFreshUsers(t *testing.T) {
+	middlewares.JwtSecret = []byte("test-secret")
+	r := setUpFresh(t, nil, allRevoked{})
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"iat":           time.Now().Unix(),
+		"exp":           time.Now().Add(time.Hour).Unix(),
+		"user_id":       7,
+		"user_Name":     "Jane",
+		"user_LastName": "Doe",
+		"user_Email":    "jane@example.com",
+		"user_Role":     models.RoleUser,
+		"tenant_id":     2,
+	}).SignedString(middlewares.JwtSecret)
+	require.NoError(t, err)
+
+	for _, route := range [][2]string{
+		{http.MethodPost, "/v1/webauthn/register/begin"},
+		{http.MethodPost, "/v1/webauthn/register/finish"},
+		{http.MethodPost, "/v1/logout"},
+		{http.MethodGet, "/v1/me/sessions"},
+		{http.MethodDelete, "/v1/me/sessions/1"},
+		{http.MethodGet, "/v1/me/passkeys"},
+		{http.MethodDelete, "/v1/me/passkeys/1"},
+		{http.MethodGet, "/v1/me/tokens"},
+		{http.MethodPost, "/v1/me/tokens"},
+		{http.MethodDelete, "/v1/me/tokens/1"},
+	} {
+		req := httptest.NewRequest(route[0], route[1], nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code, "%s %s", route[0], route[1])
+	}
+}
+
+type emptyAudit struct {
+	services.AuditService
+}
+
+func (emptyAudit) List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, int, error) {
+	return []models.AuditEvent{}, 0, nil
+}
+
+func TestAuditIsAdminOnly(t *testing.T) {
+	middlewares.JwtSecret = []byte("test-secret")
+	r := setUpWith(t, controllers.NewAuditController(emptyAudit{}))
+
+	token := func(role string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+			"iat":           time.Now().Unix(),
+			"exp":           time.Now().Add(time.Hour).Unix(),
+			"user_id":       7,
+			"user_Name":     "Jane",
+			"user_LastName": "Doe",
+			"user_Email":    "jane@example.com",
+			"user_Role":     role,
+			"tenant_id":     2,
+		}).SignedString(middlewares.JwtSecret)
+		require.NoError(t, err)
+		return token
+	}
+	get := func(path string, token string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	for _, path := range []string{"/v1/audit", "/audit"} {
+		assert.Equal(t, http.StatusUnauthorized, get(path, ""), path)
+		assert.Equal(t, http.StatusForbidden, get(path, token(models.RoleUser)), path)
+		assert.Equal(t, http.StatusOK, get(path, token(models.RoleAdmin)), path)
+	}
+}
+
+func TestSpecCoversRoutes(t *testing.T) {
+	r := setUp(t)
+	spec := Spec()
+
+	registered := map[string]bool{}
+	for _, route := range r.Routes() {
+		registered[route.Method+" "+route.Path] = true
+		assert.True(t, spec.Documents(route.Method, route.Path), "%s %s is missing from the OpenAPI document", route.Method, route.Path)
+	}
+	for _, route := range spec.Routes() {
+		assert.True(t, registered[route], "%s is documented but not registered", route)
+	}
+}
+
+func TestServeSpec(t *testing.T) {
+	r := setUp(t)
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	var doc struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]any `json:"properties"`
+				Required   []string       `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &doc))
+	assert.Equal(t, "3.1.0", doc.OpenAPI)
+	assert.Contains(t, doc.Paths, "/v1/user/{id}")
+	assert.Contains(t, doc.Paths["/v1/admin/users:import"], "post")
+	assert.Contains(t, doc.Paths["/v1/admin/users:export"], "get")
+	assert.Equal(t, true, doc.Paths["/user/{id}"]["put"]["deprecated"], "unversioned paths are deprecated")
+	assert.NotContains(t, doc.Paths["/v1/user/{id}"]["put"], "deprecated")
+	assert.NotContains(t, doc.Paths["/token"]["post"], "deprecated", "protocol routes are not versioned")
+
+	user := doc.Components.Schemas["User"]
+	assert.Contains(t, user.Properties, "lastName")
+	assert.Contains(t, user.Required, "email")
+	assert.NotContains(t, user.Properties, "TokenId", "fields hidden from JSON are left out")
+	assert.NotContains(t, user.Required, "status", "omitempty fields are optional")
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.True(t, strings.Contains(resp.Body.String(), `"/openapi.json"`))
+}
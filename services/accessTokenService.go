@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/mail"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessTokenService manages personal access tokens: long-lived, scoped
+// bearer tokens for scripts and service accounts. Only a SHA-256 hash of
+// each token is stored.
+type AccessTokenService interface {
+	// Create issues a token for userId. An actor signed in with an access
+	// token can only issue tokens with its scopes, expiring no later.
+	Create(ctx context.Context, actor *models.User, userId int, input models.CreateAccessToken) (*models.NewAccessToken, error)
+	List(ctx context.Context, userId int) ([]models.AccessToken, error)
+	Revoke(ctx context.Context, userId int, id int) error
+	CreateServiceAccount(ctx context.Context, input models.CreateServiceAccount) (*models.User, error)
+
+	// AuthenticateAccessToken returns the owner of an active token, with
+	// Scopes set, and the token itself. Unknown, expired and revoked tokens
+	// and disabled owners yield a nil user and no error.
+	AuthenticateAccessToken(ctx context.Context, token string) (*models.User, *models.AccessToken, error)
+}
+
+type accessTokenService struct {
+	tx             repositories.Transactor
+	tokens         repositories.AccessTokenRepository
+	userRepository repositories.UserRepository
+	memberships    repositories.MembershipRepository
+	audit          AuditService
+	logger         *slog.Logger
+}
+
+func NewAccessTokenService(tx repositories.Transactor, tokenRepo repositories.AccessTokenRepository, userRepo repositories.UserRepository, membershipRepo repositories.MembershipRepository, audit AuditService, logger *slog.Logger) AccessTokenService {
+	return &accessTokenService{tx: tx, tokens: tokenRepo, userRepository: userRepo, memberships: membershipRepo, audit: audit, logger: logger}
+}
+
+func (s *accessTokenService) Create(ctx context.Context, actor *models.User, userId int, input models.CreateAccessToken) (*models.NewAccessToken, error) {
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	if len(input.Scopes) == 0 {
+		return nil, errors.New("invalid scope")
+	}
+	for _, scope := range input.Scopes {
+		if !models.ValidTokenScope(scope) {
+			return nil, errors.New("invalid scope")
+		}
+	}
+	days := input.ExpiresInDays
+	if days == 0 {
+		days = models.DefaultAccessTokenDays
+	}
+	if days < 0 || days > models.MaxAccessTokenDays {
+		return nil, errors.New("invalid expiry")
+	}
+	expiresAt := time.Now().Add(time.Duration(days) * 24 * time.Hour).UTC()
+
+	// Otherwise a leaked read or write token could mint itself an admin
+	// token, or outlive its own expiry
+	if actor.Scopes != nil {
+		for _, scope := range input.Scopes {
+			if !models.GrantsScope(actor.Scopes, scope) {
+				return nil, errors.New("scope not granted")
+			}
+		}
+		if actor.TokenExpiresAt.Before(expiresAt) {
+			expiresAt = actor.TokenExpiresAt.UTC()
+		}
+	}
+
+	user, err := s.userRepository.FindByID(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	plain := models.AccessTokenPrefix + secret
+	token, err := s.tokens.Create(ctx, &models.AccessToken{
+		UserId: userId,
+		Name:   name,
+		// Enough to recognize the token in a list, far too little to guess it
+		Prefix:    plain[:len(models.AccessTokenPrefix)+8],
+		Scopes:    input.Scopes,
+		ExpiresAt: expiresAt,
+	}, hashToken(plain))
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "access token created", "user", userId, "token", token.Id)
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditAccessTokenCreated,
+		TargetId: intPtr(userId),
+		Details:  map[string]string{"token": strconv.Itoa(token.Id), "name": name, "scopes": strings.Join(token.Scopes, " ")},
+	})
+	return &models.NewAccessToken{AccessToken: *token, Token: plain}, nil
+}
+
+func (s *accessTokenService) List(ctx context.Context, userId int) ([]models.AccessToken, error) {
+	return s.tokens.ListForUser(ctx, userId)
+}
+
+func (s *accessTokenService) Revoke(ctx context.Context, userId int, id int) error {
+	revoked, err := s.tokens.Revoke(ctx, userId, id)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return errors.New("token not found")
+	}
+
+	s.logger.InfoContext(ctx, "access token revoked", "user", userId, "token", id)
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditAccessTokenRevoked,
+		TargetId: intPtr(userId),
+		Details:  map[string]string{"token": strconv.Itoa(id)},
+	})
+	return nil
+}
+
+// CreateServiceAccount adds a user without a password, which can only
+// authenticate with access tokens.
+func (s *accessTokenService) CreateServiceAccount(ctx context.Context, input models.CreateServiceAccount) (*models.User, error) {
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	if _, err := mail.ParseAddress(input.Email); err != nil {
+		return nil, errors.New("invalid email")
+	}
+
+	var user *models.User
+	err := s.tx.WithTx(ctx, func(repos repositories.Repositories) error {
+		existing, err := repos.Users.FindByEmail(ctx, input.Email)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return errUserExists
+		}
+		if user, err = repos.Users.CreateServiceAccount(ctx, name, input.Email); err != nil {
+			return err
+		}
+		return recordUserEvent(ctx, repos, models.EventUserRegistered, user)
+	})
+	var conflict *models.ConflictError
+	if errors.Is(err, errUserExists) || errors.As(err, &conflict) {
+		return nil, errUserExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "service account created", "user", user.Id)
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditServiceAccountCreated,
+		TargetId: intPtr(user.Id),
+		Changes:  diffUsers(nil, user),
+	})
+	return user, nil
+}
+
+func (s *accessTokenService) AuthenticateAccessToken(ctx context.Context, plain string) (*models.User, *models.AccessToken, error) {
+	token, err := s.tokens.FindByHash(ctx, hashToken(plain))
+	if err != nil || token == nil {
+		return nil, nil, err
+	}
+	if token.RevokedAt != nil || !time.Now().Before(token.ExpiresAt) {
+		s.logger.DebugContext(ctx, "inactive access token", "token", token.Id)
+		return nil, nil, nil
+	}
+
+	// Access tokens are always active in the organization they were
+	// created in
+	ctx = tenancy.WithTenant(ctx, token.TenantId)
+	user, err := s.userRepository.FindByID(ctx, token.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil || !user.Active() {
+		s.logger.DebugContext(ctx, "access token of unavailable user", "token", token.Id)
+		return nil, nil, nil
+	}
+	membership, err := s.memberships.Find(ctx, user.Id, user.TenantId)
+	if err != nil {
+		return nil, nil, err
+	}
+	user.OrgId = user.TenantId
+	if membership != nil {
+		user.OrgRole = membership.Role
+	}
+	user.Password = ""
+	user.Scopes = token.Scopes
+	user.TokenExpiresAt = token.ExpiresAt
+
+	if err := s.tokens.Touch(ctx, token.Id); err != nil {
+		// Bookkeeping only, the token is still good
+		s.logger.WarnContext(ctx, "failed to record access token use", "token", token.Id, "error", err)
+	}
+	return user, token, nil
+}
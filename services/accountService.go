@@ -0,0 +1,312 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"server-go/mailer"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const emailChangeTTL = 24 * time.Hour
+
+// AccountService lets signed-in users change their own credentials. Routes
+// using it also require a recent authentication.
+type AccountService interface {
+	// ChangePassword sets a new password after checking the current one,
+	// and signs out every other session of the user. The calling session
+	// gets a new token, also set as the session cookie on w, since the
+	// change outdates the one it used.
+	ChangePassword(ctx context.Context, user *models.User, input models.ChangePassword, w http.ResponseWriter) (string, error)
+	// RequestEmailChange sends a confirmation link to the new address and
+	// tells the current one about the request. The address only changes
+	// once the link is followed.
+	RequestEmailChange(ctx context.Context, user *models.User, input models.ChangeEmail) error
+	// CheckEmailChange validates a link from RequestEmailChange without
+	// redeeming it and returns the new address.
+	CheckEmailChange(ctx context.Context, token string) (string, error)
+	// ConfirmEmailChange redeems a link from RequestEmailChange and returns
+	// the updated user.
+	ConfirmEmailChange(ctx context.Context, token string) (*models.User, error)
+}
+
+type accountService struct {
+	tx             repositories.Transactor
+	userRepository repositories.UserRepository
+	emailChanges   repositories.EmailChangeRepository
+	passwords      PasswordService
+	sessions       SessionService
+	mailer         mailer.Mailer
+	audit          AuditService
+	appURL         string
+	logger         *slog.Logger
+}
+
+func NewAccountService(tx repositories.Transactor, userRepo repositories.UserRepository, emailChangeRepo repositories.EmailChangeRepository, passwords PasswordService, sessions SessionService, m mailer.Mailer, audit AuditService, appURL string, logger *slog.Logger) AccountService {
+	return &accountService{
+		tx:             tx,
+		userRepository: userRepo,
+		emailChanges:   emailChangeRepo,
+		passwords:      passwords,
+		sessions:       sessions,
+		mailer:         m,
+		audit:          audit,
+		appURL:         appURL,
+		logger:         logger,
+	}
+}
+
+// account loads the caller's own record from their home organization.
+func (s *accountService) account(ctx context.Context, caller *models.User) (context.Context, *models.User, error) {
+	ctx = tenancy.WithTenant(ctx, caller.TenantId)
+	user, err := s.userRepository.FindByID(ctx, caller.Id)
+	if err != nil {
+		return ctx, nil, err
+	}
+	if user == nil {
+		return ctx, nil, errors.New("user not found")
+	}
+	return ctx, user, nil
+}
+
+func (s *accountService) ChangePassword(ctx context.Context, caller *models.User, input models.ChangePassword, w http.ResponseWriter) (string, error) {
+	ctx, user, err := s.account(ctx, caller)
+	if err != nil {
+		return "", err
+	}
+	if user.ServiceAccount {
+		return "", errors.New("service accounts have no password")
+	}
+
+	match, _, err := s.passwords.Verify(user.Password, input.CurrentPassword)
+	if err != nil || !match {
+		s.logger.InfoContext(ctx, "password change rejected: wrong current password", "user", user.Id)
+		s.audit.Record(ctx, models.AuditEvent{
+			Action:   models.AuditLoginFailed,
+			ActorId:  intPtr(user.Id),
+			TargetId: intPtr(user.Id),
+			Details:  map[string]string{"method": "change_password", "reason": "invalid credentials"},
+		})
+		return "", errors.New("invalid current password")
+	}
+
+	if err := s.passwords.Validate(ctx, user, input.NewPassword); err != nil {
+		return "", err
+	}
+	hashedPassword, err := s.passwords.Hash(input.NewPassword)
+	if err != nil {
+		return "", errors.New("failed to hash password")
+	}
+	var changed *models.User
+	err = s.tx.WithTx(ctx, func(repos repositories.Repositories) error {
+		if err := repos.Users.SetPassword(ctx, user.Id, hashedPassword); err != nil {
+			return err
+		}
+		if changed, err = repos.Users.FindByID(ctx, user.Id); err != nil || changed == nil {
+			return err
+		}
+		return recordUserEvent(ctx, repos, models.EventUserUpdated, changed)
+	})
+	if err != nil {
+		return "", err
+	}
+	if changed == nil {
+		return "", errors.New("user not found")
+	}
+	if err := s.passwords.Remember(ctx, user.Id, hashedPassword); err != nil {
+		return "", err
+	}
+
+	// The device making the change stays signed in, with a token of the
+	// new version
+	if err := s.sessions.RevokeOthers(ctx, caller, "password changed"); err != nil {
+		return "", err
+	}
+	if changed, err = s.userRepository.FindByID(ctx, user.Id); err != nil {
+		return "", err
+	}
+	if changed == nil {
+		return "", errors.New("user not found")
+	}
+	changed.OrgId, changed.OrgRole, changed.AuthTime = caller.OrgId, caller.OrgRole, caller.AuthTime
+	token, err := s.sessions.Reissue(ctx, changed, caller.TokenId)
+	if err != nil {
+		return "", err
+	}
+	setSessionCookie(w, token)
+
+	s.logger.InfoContext(ctx, "password changed", "user", user.Id)
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditPasswordChanged,
+		ActorId:  intPtr(user.Id),
+		TargetId: intPtr(user.Id),
+	})
+	return token, nil
+}
+
+func (s *accountService) RequestEmailChange(ctx context.Context, caller *models.User, input models.ChangeEmail) error {
+	email := strings.TrimSpace(input.Email)
+	if email == "" || !strings.Contains(email, "@") {
+		return errors.New("invalid email")
+	}
+	ctx, user, err := s.account(ctx, caller)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(email, user.Email) {
+		return errors.New("email unchanged")
+	}
+	existing, err := s.userRepository.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errors.New("email already in use")
+	}
+
+	expiresAt := time.Now().Add(emailChangeTTL)
+	id, err := s.emailChanges.Create(ctx, user.Id, email, expiresAt)
+	if err != nil {
+		return err
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"iss":   "server-go",
+		"typ":   "email_change",
+		"cid":   id,
+		"tid":   user.TenantId,
+		"email": email,
+		"iat":   time.Now().Unix(),
+		"exp":   expiresAt.Unix(),
+	}).SignedString(jwtSecret)
+	if err != nil {
+		return err
+	}
+
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hello %s,\n\nConfirm that you want to use this address for your account:\n\n%s/email/confirm?token=%s\n\nThe link expires in %d hours. If you did not ask for this, you can ignore this email.\n",
+			user.Name, s.appURL, url.QueryEscape(token), int(emailChangeTTL.Hours())),
+	})
+	if err != nil {
+		return err
+	}
+	// The current owner of the account hears about it whoever asked
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Email change requested",
+		Body: fmt.Sprintf("Hello %s,\n\nSomeone asked to change the email address of your account to %s. It changes once the new address is confirmed.\n\nIf this was not you, change your password and sign out your other sessions.\n",
+			user.Name, email),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "email change requested", "user", user.Id, "email", email)
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditEmailChangeRequested,
+		ActorId:  intPtr(user.Id),
+		TargetId: intPtr(user.Id),
+		Details:  map[string]string{"requestId": strconv.Itoa(id), "email": email},
+	})
+	return nil
+}
+
+func (s *accountService) CheckEmailChange(ctx context.Context, token string) (string, error) {
+	_, _, email, err := parseEmailChange(token)
+	return email, err
+}
+
+func (s *accountService) ConfirmEmailChange(ctx context.Context, token string) (*models.User, error) {
+	invalid := errors.New("invalid or expired link")
+
+	changeId, tenantId, _, err := parseEmailChange(token)
+	if err != nil {
+		return nil, err
+	}
+	ctx = tenancy.WithTenant(ctx, tenantId)
+
+	userId, email, ok, err := s.emailChanges.Consume(ctx, changeId)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.logger.InfoContext(ctx, "email change rejected: used or expired", "request", changeId)
+		return nil, invalid
+	}
+
+	before, err := s.userRepository.FindByID(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if before == nil {
+		return nil, invalid
+	}
+	// Someone may have taken the address since the request
+	existing, err := s.userRepository.FindByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.Id != userId {
+		return nil, errors.New("email already in use")
+	}
+
+	var updated *models.User
+	err = s.tx.WithTx(ctx, func(repos repositories.Repositories) error {
+		if updated, err = repos.Users.SetEmail(ctx, userId, email); err != nil || updated == nil {
+			return err
+		}
+		return recordUserEvent(ctx, repos, models.EventUserUpdated, updated)
+	})
+	var conflict *models.ConflictError
+	if errors.As(err, &conflict) {
+		return nil, errors.New("email already in use")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if updated == nil {
+		return nil, invalid
+	}
+
+	s.logger.InfoContext(ctx, "email changed", "user", userId)
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditEmailChanged,
+		ActorId:  intPtr(userId),
+		TargetId: intPtr(userId),
+		Changes:  diffUsers(before, updated),
+	})
+	return updated, nil
+}
+
+func parseEmailChange(token string) (changeId int, tenantId int, email string, err error) {
+	invalid := errors.New("invalid or expired link")
+
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS512 {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !parsed.Valid || claims["typ"] != "email_change" {
+		return 0, 0, "", invalid
+	}
+	id, ok := claims["cid"].(float64)
+	tid, tok := claims["tid"].(float64)
+	email, eok := claims["email"].(string)
+	if !ok || !tok || !eok {
+		return 0, 0, "", invalid
+	}
+	return int(id), int(tid), email, nil
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/passwords"
+	"server-go/repositories"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (r *memoryUsers) SetEmail(ctx context.Context, id int, email string) (*models.User, error) {
+	for _, u := range r.users {
+		if u.Id == id {
+			u.Email = email
+			found := *u
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryUsers) RevokeToken(ctx context.Context, id int, jti string, expiresAt time.Time) error {
+	return nil
+}
+
+type memoryEmailChanges struct {
+	repositories.EmailChangeRepository
+	changes []emailChangeRow
+}
+
+type emailChangeRow struct {
+	userId int
+	email  string
+	used   bool
+}
+
+func (r *memoryEmailChanges) Create(ctx context.Context, userId int, newEmail string, expiresAt time.Time) (int, error) {
+	for i := range r.changes {
+		if r.changes[i].userId == userId {
+			r.changes[i].used = true
+		}
+	}
+	r.changes = append(r.changes, emailChangeRow{userId: userId, email: newEmail})
+	return len(r.changes), nil
+}
+
+func (r *memoryEmailChanges) Consume(ctx context.Context, id int) (int, string, bool, error) {
+	c := &r.changes[id-1]
+	if c.used {
+		return 0, "", false, nil
+	}
+	c.used = true
+	return c.userId, c.email, true, nil
+}
+
+var emailChangeToken = regexp.MustCompile(`/email/confirm\?token=(\S+)`)
+
+func TestChangePassword(t *testing.T) {
+	hasher := fastHasher(t)
+	hash, err := hasher.Hash("correct horse")
+	require.NoError(t, err)
+	users := &memoryUsers{users: []*models.User{{Id: 1, Name: "Jane", Email: "jane@example.com", Password: hash, TenantId: 3}}}
+	sessionRepo := &memorySessions{}
+	sessions := NewSessionService(sessionRepo, users, &membershipsRepo{roles: map[int]string{}}, 0, discardAudit{}, logging.Discard())
+	pw := NewPasswordService(hasher, passwords.Policy{MinLength: 8}, nil, logging.Discard())
+	events := &memoryOutbox{}
+	tx := inlineTx{repositories.Repositories{Users: users, Outbox: events}}
+	svc := NewAccountService(tx, users, &memoryEmailChanges{}, pw, sessions, &outbox{}, discardAudit{}, "http://app", logging.Discard())
+
+	ctx := context.Background()
+	var current string
+	for i := 0; i < 3; i++ {
+		token, err := sessions.Start(ctx, &models.User{Id: 1, TenantId: 3}, "")
+		require.NoError(t, err)
+		current = tokenJTI(t, token)
+	}
+	caller := &models.User{Id: 1, TenantId: 3, TokenId: current}
+
+	_, err = svc.ChangePassword(ctx, caller, models.ChangePassword{CurrentPassword: "wrong", NewPassword: "battery staple"}, httptest.NewRecorder())
+	assert.EqualError(t, err, "invalid current password")
+
+	_, err = svc.ChangePassword(ctx, caller, models.ChangePassword{CurrentPassword: "correct horse", NewPassword: "short"}, httptest.NewRecorder())
+	var invalid *models.ValidationError
+	require.ErrorAs(t, err, &invalid)
+	assert.Equal(t, hash, users.users[0].Password)
+
+	resp := httptest.NewRecorder()
+	token, err := svc.ChangePassword(ctx, caller, models.ChangePassword{CurrentPassword: "correct horse", NewPassword: "battery staple"}, resp)
+	require.NoError(t, err)
+	match, _, err := hasher.Verify(users.users[0].Password, "battery staple")
+	require.NoError(t, err)
+	assert.True(t, match)
+	require.Len(t, events.events, 1, "only the successful change is recorded")
+	assert.Equal(t, models.EventUserUpdated, events.events[0].Type)
+	assert.NotContains(t, string(events.events[0].Payload), "password")
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, claims["token_version"], "the new token carries the new version")
+	assert.Contains(t, resp.Header().Get("Set-Cookie"), "session_token="+token)
+
+	caller.TokenId = tokenJTI(t, token)
+	active, err := sessions.List(ctx, caller)
+	require.NoError(t, err)
+	require.Len(t, active, 1, "other sessions are signed out")
+	assert.True(t, active[0].Current, "the calling session continues with the new token")
+}
+
+func TestChangeEmail(t *testing.T) {
+	user := &models.User{Id: 1, Name: "Jane", Email: "jane@example.com", TenantId: 3}
+	other := &models.User{Id: 2, Name: "Jim", Email: "jim@example.com", TenantId: 3}
+	users := &memoryUsers{users: []*models.User{user, other}}
+	changes := &memoryEmailChanges{}
+	mail := &outbox{}
+	events := &memoryOutbox{}
+	tx := inlineTx{repositories.Repositories{Users: users, Outbox: events}}
+	svc := NewAccountService(tx, users, changes, nil, nil, mail, discardAudit{}, "http://app", logging.Discard())
+	ctx := context.Background()
+	caller := &models.User{Id: 1, TenantId: 3}
+
+	confirmation := func(t *testing.T) string {
+		require.Len(t, mail.sent, 2)
+		match := emailChangeToken.FindStringSubmatch(mail.sent[0].Body)
+		require.NotNil(t, match)
+		token, err := url.QueryUnescape(match[1])
+		require.NoError(t, err)
+		return token
+	}
+
+	assert.EqualError(t, svc.RequestEmailChange(ctx, caller, models.ChangeEmail{Email: "nope"}), "invalid email")
+	assert.EqualError(t, svc.RequestEmailChange(ctx, caller, models.ChangeEmail{Email: "JANE@example.com"}), "email unchanged")
+	assert.EqualError(t, svc.RequestEmailChange(ctx, caller, models.ChangeEmail{Email: "jim@example.com"}), "email already in use")
+	assert.Empty(t, mail.sent)
+
+	t.Run("swaps only after confirmation", func(t *testing.T) {
+		require.NoError(t, svc.RequestEmailChange(ctx, caller, models.ChangeEmail{Email: "jane@new.example"}))
+		token := confirmation(t)
+		assert.Equal(t, "jane@new.example", mail.sent[0].To)
+		assert.Equal(t, "jane@example.com", mail.sent[1].To, "the old address is told")
+		assert.Contains(t, mail.sent[1].Body, "jane@new.example")
+		assert.Equal(t, "jane@example.com", user.Email)
+
+		email, err := svc.CheckEmailChange(ctx, token)
+		require.NoError(t, err)
+		assert.Equal(t, "jane@new.example", email)
+		assert.Equal(t, "jane@example.com", user.Email, "checking changes nothing")
+
+		updated, err := svc.ConfirmEmailChange(ctx, token)
+		require.NoError(t, err)
+		assert.Equal(t, "jane@new.example", updated.Email)
+		assert.Equal(t, "jane@new.example", user.Email)
+		require.Len(t, events.events, 1)
+		assert.Equal(t, models.EventUserUpdated, events.events[0].Type)
+		assert.Contains(t, string(events.events[0].Payload), "jane@new.example")
+
+		_, err = svc.ConfirmEmailChange(ctx, token)
+		assert.EqualError(t, err, "invalid or expired link", "links work once")
+	})
+
+	t.Run("a newer request replaces the pending one", func(t *testing.T) {
+		mail.sent = nil
+		require.NoError(t, svc.RequestEmailChange(ctx, caller, models.ChangeEmail{Email: "first@example.com"}))
+		stale := confirmation(t)
+		mail.sent = nil
+		require.NoError(t, svc.RequestEmailChange(ctx, caller, models.ChangeEmail{Email: "second@example.com"}))
+
+		_, err := svc.ConfirmEmailChange(ctx, stale)
+		assert.EqualError(t, err, "invalid or expired link")
+	})
+
+	t.Run("address taken in the meantime", func(t *testing.T) {
+		mail.sent = nil
+		require.NoError(t, svc.RequestEmailChange(ctx, caller, models.ChangeEmail{Email: "taken@example.com"}))
+		token := confirmation(t)
+		other.Email = "taken@example.com"
+
+		_, err := svc.ConfirmEmailChange(ctx, token)
+		assert.EqualError(t, err, "email already in use")
+	})
+
+	t.Run("forged tokens", func(t *testing.T) {
+		_, err := svc.ConfirmEmailChange(ctx, "not-a-token")
+		assert.EqualError(t, err, "invalid or expired link")
+	})
+}
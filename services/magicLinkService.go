@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"server-go/mailer"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const magicLinkWindow = time.Hour
+
+// MagicLinkService signs users in through single-use links sent by email.
+// Opening a link only shows a confirmation page; the link is redeemed by
+// the POST that page makes, so mail scanners that prefetch URLs cannot use
+// it up.
+type MagicLinkService interface {
+	// Request sends a sign-in link when the email belongs to an account.
+	// Unknown addresses get the same answer, so it reveals nothing about who
+	// has an account.
+	Request(ctx context.Context, input models.MagicLinkRequest) error
+	// Check validates a link without redeeming it and returns its email.
+	Check(ctx context.Context, token string) (string, error)
+	// Redeem uses up the link and issues a session token the same way
+	// Login does.
+	Redeem(ctx context.Context, input models.MagicLinkConfirm, w http.ResponseWriter) (string, error)
+}
+
+type magicLinkService struct {
+	links          repositories.MagicLinkRepository
+	userRepository repositories.UserRepository
+	sessions       SessionService
+	mailer         mailer.Mailer
+	audit          AuditService
+	appURL         string
+	ttl            time.Duration
+	perHour        int
+	logger         *slog.Logger
+}
+
+func NewMagicLinkService(linkRepo repositories.MagicLinkRepository, userRepo repositories.UserRepository, sessions SessionService, m mailer.Mailer, audit AuditService, appURL string, ttl time.Duration, perHour int, logger *slog.Logger) MagicLinkService {
+	return &magicLinkService{
+		links:          linkRepo,
+		userRepository: userRepo,
+		sessions:       sessions,
+		mailer:         m,
+		audit:          audit,
+		appURL:         appURL,
+		ttl:            ttl,
+		perHour:        perHour,
+		logger:         logger,
+	}
+}
+
+func (s *magicLinkService) Request(ctx context.Context, input models.MagicLinkRequest) error {
+	email := strings.TrimSpace(input.Email)
+	if email == "" || !strings.Contains(email, "@") {
+		return errors.New("invalid email")
+	}
+	tenantId, ok := tenancy.TenantID(ctx)
+	if !ok {
+		return tenancy.ErrNoTenant
+	}
+
+	user, err := s.userRepository.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	// Service accounts and inactive accounts cannot sign in this way; they
+	// are treated like unknown addresses
+	var userId *int
+	if user != nil && !user.ServiceAccount && user.Active() {
+		userId = intPtr(user.Id)
+	}
+
+	expiresAt := time.Now().Add(s.ttl)
+	id, ok, err := s.links.Create(ctx, email, userId, expiresAt, s.perHour, magicLinkWindow)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.logger.InfoContext(ctx, "magic link rate limited", "email", email)
+		return errors.New("too many requests")
+	}
+	if userId == nil {
+		s.logger.InfoContext(ctx, "magic link not sent: no eligible account", "email", email)
+		return nil
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"iss":   "server-go",
+		"typ":   "magic_link",
+		"lid":   id,
+		"tid":   tenantId,
+		"email": user.Email,
+		"iat":   time.Now().Unix(),
+		"exp":   expiresAt.Unix(),
+	}).SignedString(jwtSecret)
+	if err != nil {
+		return err
+	}
+
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Hello %s,\n\nUse this link to sign in:\n\n%s/login/magic/verify?token=%s\n\nThe link works once and expires in %d minutes. If you did not ask for it, you can ignore this email.\n",
+			user.Name, s.appURL, url.QueryEscape(token), int(s.ttl.Minutes())),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditMagicLinkSent,
+		TargetId: intPtr(user.Id),
+		Details:  map[string]string{"linkId": strconv.Itoa(id)},
+	})
+	return nil
+}
+
+func (s *magicLinkService) Check(ctx context.Context, token string) (string, error) {
+	_, _, email, err := parseMagicLink(token)
+	return email, err
+}
+
+func (s *magicLinkService) Redeem(ctx context.Context, input models.MagicLinkConfirm, w http.ResponseWriter) (string, error) {
+	invalid := errors.New("invalid or expired link")
+
+	linkId, tenantId, email, err := parseMagicLink(input.Token)
+	if err != nil {
+		return "", err
+	}
+	ctx = tenancy.WithTenant(ctx, tenantId)
+
+	userId, ok, err := s.links.Consume(ctx, linkId)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		s.logger.InfoContext(ctx, "magic link rejected: used or expired", "link", linkId)
+		return "", invalid
+	}
+
+	user, err := s.userRepository.FindByID(ctx, userId)
+	if err != nil {
+		return "", err
+	}
+	// The address must still be the account's: a link sent before an email
+	// change does not follow the account
+	if user == nil || !strings.EqualFold(user.Email, email) || user.ServiceAccount {
+		return "", invalid
+	}
+	if !user.Active() {
+		s.audit.Record(ctx, models.AuditEvent{
+			Action:   models.AuditLoginFailed,
+			TargetId: intPtr(user.Id),
+			Details:  map[string]string{"method": "magic_link", "reason": "account disabled"},
+		})
+		return "", errors.New("account disabled")
+	}
+
+	token, err := s.sessions.Start(ctx, user, input.DeviceName)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error issuing session", "user", user.Id, "error", err)
+		return "", err
+	}
+	setSessionCookie(w, token)
+
+	s.logger.InfoContext(ctx, "login successful", "user", user.Id, "method", "magic_link")
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditLogin,
+		ActorId:  intPtr(user.Id),
+		TargetId: intPtr(user.Id),
+		Details:  map[string]string{"method": "magic_link"},
+	})
+	return token, nil
+}
+
+func parseMagicLink(token string) (linkId int, tenantId int, email string, err error) {
+	invalid := errors.New("invalid or expired link")
+
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS512 {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !parsed.Valid || claims["typ"] != "magic_link" {
+		return 0, 0, "", invalid
+	}
+	id, ok := claims["lid"].(float64)
+	tid, tok := claims["tid"].(float64)
+	email, eok := claims["email"].(string)
+	if !ok || !tok || !eok {
+		return 0, 0, "", invalid
+	}
+	return int(id), int(tid), email, nil
+}
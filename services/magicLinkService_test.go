@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/mailer"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryMagicLinks struct {
+	repositories.MagicLinkRepository
+	links []magicLinkRow
+}
+
+type magicLinkRow struct {
+	email     string
+	userId    *int
+	expiresAt time.Time
+	used      bool
+}
+
+func (r *memoryMagicLinks) Create(ctx context.Context, email string, userId *int, expiresAt time.Time, limit int, window time.Duration) (int, bool, error) {
+	recent := 0
+	for _, l := range r.links {
+		if strings.EqualFold(l.email, email) {
+			recent++
+		}
+	}
+	if limit > 0 && recent >= limit {
+		return 0, false, nil
+	}
+	r.links = append(r.links, magicLinkRow{email: email, userId: userId, expiresAt: expiresAt})
+	return len(r.links), true, nil
+}
+
+func (r *memoryMagicLinks) Consume(ctx context.Context, id int) (int, bool, error) {
+	l := &r.links[id-1]
+	if l.used || l.userId == nil || time.Now().After(l.expiresAt) {
+		return 0, false, nil
+	}
+	l.used = true
+	return *l.userId, true, nil
+}
+
+type outbox struct {
+	sent []mailer.Message
+}
+
+func (m *outbox) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var linkToken = regexp.MustCompile(`/login/magic/verify\?token=(\S+)`)
+
+func (m *outbox) lastLink(t *testing.T) string {
+	require.NotEmpty(t, m.sent)
+	match := linkToken.FindStringSubmatch(m.sent[len(m.sent)-1].Body)
+	require.NotNil(t, match)
+	token, err := url.QueryUnescape(match[1])
+	require.NoError(t, err)
+	return token
+}
+
+func TestMagicLinks(t *testing.T) {
+	user := &models.User{Id: 1, Name: "Jane", Email: "jane@example.com", TenantId: 3}
+	users := &memoryUsers{users: []*models.User{user}}
+	links := &memoryMagicLinks{}
+	mail := &outbox{}
+	sessions := NewSessionService(&memorySessions{}, users, &membershipsRepo{roles: map[int]string{}}, 0, discardAudit{}, logging.Discard())
+	svc := NewMagicLinkService(links, users, sessions, mail, discardAudit{}, "http://app", 15*time.Minute, 3, logging.Discard())
+	ctx := tenancy.WithTenant(context.Background(), 3)
+
+	require.NoError(t, svc.Request(ctx, models.MagicLinkRequest{Email: " jane@example.com "}))
+	require.Len(t, mail.sent, 1)
+	assert.Equal(t, "jane@example.com", mail.sent[0].To)
+	token := mail.lastLink(t)
+
+	t.Run("checking does not use up the link", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			email, err := svc.Check(context.Background(), token)
+			require.NoError(t, err)
+			assert.Equal(t, "jane@example.com", email)
+		}
+		assert.False(t, links.links[0].used)
+	})
+
+	t.Run("redeem issues a session once", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		session, err := svc.Redeem(context.Background(), models.MagicLinkConfirm{Token: token}, w)
+		require.NoError(t, err)
+		assert.NotEmpty(t, tokenJTI(t, session))
+		assert.Contains(t, w.Header().Get("Set-Cookie"), "session_token="+session)
+
+		_, err = svc.Redeem(context.Background(), models.MagicLinkConfirm{Token: token}, httptest.NewRecorder())
+		assert.EqualError(t, err, "invalid or expired link")
+	})
+
+	t.Run("unknown email sends nothing", func(t *testing.T) {
+		require.NoError(t, svc.Request(ctx, models.MagicLinkRequest{Email: "nobody@example.com"}))
+		assert.Len(t, mail.sent, 1)
+	})
+
+	t.Run("rate limited per email", func(t *testing.T) {
+		require.NoError(t, svc.Request(ctx, models.MagicLinkRequest{Email: "jane@example.com"}))
+		require.NoError(t, svc.Request(ctx, models.MagicLinkRequest{Email: "jane@example.com"}))
+		assert.EqualError(t, svc.Request(ctx, models.MagicLinkRequest{Email: "jane@example.com"}), "too many requests")
+		assert.Len(t, mail.sent, 3)
+	})
+
+	t.Run("link does not follow an email change", func(t *testing.T) {
+		token := mail.lastLink(t)
+		user.Email = "jane@other.example.com"
+		defer func() { user.Email = "jane@example.com" }()
+		_, err := svc.Redeem(context.Background(), models.MagicLinkConfirm{Token: token}, httptest.NewRecorder())
+		assert.EqualError(t, err, "invalid or expired link")
+	})
+
+	t.Run("tampered link", func(t *testing.T) {
+		_, err := svc.Check(context.Background(), token[:len(token)-2]+"xx")
+		assert.EqualError(t, err, "invalid or expired link")
+	})
+}
@@ -0,0 +1,413 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"server-go/models"
+	"server-go/oidc"
+	"server-go/repositories"
+	"server-go/tenancy"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	authorizationCodeTTL = time.Minute
+	oidcTokenTTL         = time.Hour
+)
+
+// OIDCProviderService lets registered client applications sign users in
+// through this service (OpenID Connect Core, authorization code flow).
+type OIDCProviderService interface {
+	Discovery() map[string]any
+	JWKS() oidc.JWKS
+	// CheckAuthorization validates an /authorize request. When the client
+	// is returned its redirect URI is trusted and errors may be sent there.
+	CheckAuthorization(ctx context.Context, req models.AuthorizationRequest) (*models.OAuthClient, error)
+	NeedsConsent(ctx context.Context, user *models.User, req models.AuthorizationRequest) (bool, error)
+	// Authorize issues a code for user and returns the redirect back to
+	// the client. grant records the user's consent to the requested scopes.
+	Authorize(ctx context.Context, user *models.User, req models.AuthorizationRequest, grant bool) (string, error)
+	Deny(ctx context.Context, user *models.User, req models.AuthorizationRequest) string
+	Token(ctx context.Context, req models.TokenRequest) (*models.TokenResponse, error)
+	UserInfo(ctx context.Context, accessToken string) (map[string]any, error)
+}
+
+type oidcProviderService struct {
+	issuer         string
+	key            *rsa.PrivateKey
+	keyId          string
+	clients        repositories.OAuthClientRepository
+	consents       repositories.ConsentRepository
+	codes          repositories.AuthorizationCodeRepository
+	userRepository repositories.UserRepository
+	audit          AuditService
+	logger         *slog.Logger
+}
+
+func NewOIDCProviderService(issuer string, key *rsa.PrivateKey, clientRepo repositories.OAuthClientRepository, consentRepo repositories.ConsentRepository, codeRepo repositories.AuthorizationCodeRepository, userRepo repositories.UserRepository, audit AuditService, logger *slog.Logger) OIDCProviderService {
+	return &oidcProviderService{
+		issuer:         strings.TrimRight(issuer, "/"),
+		key:            key,
+		keyId:          oidc.Thumbprint(&key.PublicKey),
+		clients:        clientRepo,
+		consents:       consentRepo,
+		codes:          codeRepo,
+		userRepository: userRepo,
+		audit:          audit,
+		logger:         logger,
+	}
+}
+
+// NewOAuthClient prepares a client registration. The secret is returned
+// once and only its hash is kept; public clients get none.
+func NewOAuthClient(name string, redirectURIs []string, scopes []string, public bool) (*models.OAuthClient, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, "", errors.New("name is required")
+	}
+	if len(redirectURIs) == 0 {
+		return nil, "", errors.New("at least one redirect URI is required")
+	}
+	for _, uri := range redirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return nil, "", fmt.Errorf("invalid redirect URI %q", uri)
+		}
+	}
+	if len(scopes) == 0 {
+		scopes = []string{models.ScopeOpenID, models.ScopeProfile, models.ScopeEmail}
+	}
+
+	clientId, err := randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+	client := &models.OAuthClient{ClientId: clientId, Name: name, RedirectURIs: redirectURIs, Scopes: scopes}
+	if public {
+		return client, "", nil
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	client.SecretHash = hashToken(secret)
+	return client, secret, nil
+}
+
+func (s *oidcProviderService) Discovery() map[string]any {
+	return map[string]any{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"userinfo_endpoint":                     s.issuer + "/userinfo",
+		"jwks_uri":                              s.issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{models.ScopeOpenID, models.ScopeProfile, models.ScopeEmail},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"name", "given_name", "family_name", "picture", "email", "email_verified"},
+	}
+}
+
+func (s *oidcProviderService) JWKS() oidc.JWKS {
+	return oidc.JWKS{Keys: []oidc.JWK{oidc.RSAJWK(s.keyId, &s.key.PublicKey)}}
+}
+
+func (s *oidcProviderService) CheckAuthorization(ctx context.Context, req models.AuthorizationRequest) (*models.OAuthClient, error) {
+	client, err := s.clients.FindByClientID(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, &models.OAuthError{Code: "invalid_client", Description: "unknown client"}
+	}
+	// Redirect URIs must match a registered one exactly
+	if !contains(client.RedirectURIs, req.RedirectURI) {
+		return nil, &models.OAuthError{Code: "invalid_request", Description: "redirect_uri is not registered"}
+	}
+
+	if req.ResponseType != "code" {
+		return client, &models.OAuthError{Code: "unsupported_response_type", Description: "only code is supported"}
+	}
+	scopes := strings.Fields(req.Scope)
+	if !contains(scopes, models.ScopeOpenID) {
+		return client, &models.OAuthError{Code: "invalid_scope", Description: "openid scope is required"}
+	}
+	for _, scope := range scopes {
+		if !contains(client.Scopes, scope) {
+			return client, &models.OAuthError{Code: "invalid_scope", Description: "scope " + scope + " is not allowed"}
+		}
+	}
+	if req.CodeChallenge != "" && req.CodeChallengeMethod != "S256" {
+		return client, &models.OAuthError{Code: "invalid_request", Description: "code_challenge_method must be S256"}
+	}
+	if req.CodeChallenge == "" && client.Public() {
+		return client, &models.OAuthError{Code: "invalid_request", Description: "public clients must use PKCE"}
+	}
+	return client, nil
+}
+
+func (s *oidcProviderService) NeedsConsent(ctx context.Context, user *models.User, req models.AuthorizationRequest) (bool, error) {
+	consent, err := s.consents.Find(tenancy.WithTenant(ctx, user.TenantId), user.Id, req.ClientId)
+	if err != nil {
+		return false, err
+	}
+	if consent == nil {
+		return true, nil
+	}
+	for _, scope := range strings.Fields(req.Scope) {
+		if !contains(consent.Scopes, scope) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *oidcProviderService) Authorize(ctx context.Context, user *models.User, req models.AuthorizationRequest, grant bool) (string, error) {
+	ctx = tenancy.WithTenant(ctx, user.TenantId)
+	scopes := strings.Fields(req.Scope)
+
+	if grant {
+		if err := s.consents.Grant(ctx, user.Id, req.ClientId, scopes); err != nil {
+			return "", err
+		}
+		s.audit.Record(ctx, models.AuditEvent{
+			Action:   models.AuditConsentGranted,
+			ActorId:  intPtr(user.Id),
+			TargetId: intPtr(user.Id),
+			Details:  map[string]string{"clientId": req.ClientId, "scope": req.Scope},
+		})
+	} else {
+		needed, err := s.NeedsConsent(ctx, user, req)
+		if err != nil {
+			return "", err
+		}
+		if needed {
+			return s.Deny(ctx, user, req), nil
+		}
+	}
+
+	code, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	err = s.codes.Create(ctx, &models.AuthorizationCode{
+		CodeHash:      hashToken(code),
+		ClientId:      req.ClientId,
+		UserId:        user.Id,
+		TenantId:      user.TenantId,
+		RedirectURI:   req.RedirectURI,
+		Scope:         strings.Join(scopes, " "),
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      user.AuthTime,
+		ExpiresAt:     now.Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return redirectWith(req.RedirectURI, url.Values{"code": {code}, "state": {req.State}}), nil
+}
+
+func (s *oidcProviderService) Deny(ctx context.Context, user *models.User, req models.AuthorizationRequest) string {
+	if user != nil {
+		s.audit.Record(tenancy.WithTenant(ctx, user.TenantId), models.AuditEvent{
+			Action:   models.AuditConsentDenied,
+			ActorId:  intPtr(user.Id),
+			TargetId: intPtr(user.Id),
+			Details:  map[string]string{"clientId": req.ClientId, "scope": req.Scope},
+		})
+	}
+	return AuthorizationErrorRedirect(req, &models.OAuthError{Code: "access_denied"})
+}
+
+// AuthorizationErrorRedirect sends an error back to a verified redirect URI.
+func AuthorizationErrorRedirect(req models.AuthorizationRequest, oauthErr *models.OAuthError) string {
+	q := url.Values{"error": {oauthErr.Code}, "state": {req.State}}
+	if oauthErr.Description != "" {
+		q.Set("error_description", oauthErr.Description)
+	}
+	return redirectWith(req.RedirectURI, q)
+}
+
+func redirectWith(uri string, params url.Values) string {
+	if params.Get("state") == "" {
+		params.Del("state")
+	}
+	sep := "?"
+	if strings.Contains(uri, "?") {
+		sep = "&"
+	}
+	return uri + sep + params.Encode()
+}
+
+func (s *oidcProviderService) Token(ctx context.Context, req models.TokenRequest) (*models.TokenResponse, error) {
+	if req.GrantType != "authorization_code" {
+		return nil, &models.OAuthError{Code: "unsupported_grant_type"}
+	}
+
+	client, err := authenticateClient(ctx, s.clients, req.ClientId, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	invalidGrant := &models.OAuthError{Code: "invalid_grant"}
+	code, err := s.codes.Consume(ctx, hashToken(req.Code))
+	if err != nil {
+		return nil, err
+	}
+	if code == nil || code.ClientId != client.ClientId || code.RedirectURI != req.RedirectURI {
+		return nil, invalidGrant
+	}
+	if code.CodeChallenge != "" && subtle.ConstantTimeCompare([]byte(oidc.S256(req.CodeVerifier)), []byte(code.CodeChallenge)) != 1 {
+		return nil, invalidGrant
+	}
+
+	ctx = tenancy.WithTenant(ctx, code.TenantId)
+	user, err := s.userRepository.FindByID(ctx, code.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !user.Active() {
+		return nil, invalidGrant
+	}
+
+	now := time.Now()
+	scopes := strings.Fields(code.Scope)
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := s.sign("at+jwt", jwt.MapClaims{
+		"iss":       s.issuer,
+		"sub":       strconv.Itoa(user.Id),
+		"aud":       client.ClientId,
+		"client_id": client.ClientId,
+		"scope":     code.Scope,
+		"tenant_id": user.TenantId,
+		"jti":       jti,
+		"iat":       now.Unix(),
+		"exp":       now.Add(oidcTokenTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	idClaims := jwt.MapClaims{
+		"iss":       s.issuer,
+		"aud":       client.ClientId,
+		"iat":       now.Unix(),
+		"exp":       now.Add(oidcTokenTTL).Unix(),
+		"auth_time": code.AuthTime.Unix(),
+	}
+	if code.Nonce != "" {
+		idClaims["nonce"] = code.Nonce
+	}
+	for k, v := range userClaims(user, scopes) {
+		idClaims[k] = v
+	}
+	idToken, err := s.sign("JWT", idClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(oidcTokenTTL.Seconds()),
+		IDToken:     idToken,
+		Scope:       code.Scope,
+	}, nil
+}
+
+// authenticateClient checks client credentials; public clients have no
+// secret to check.
+func authenticateClient(ctx context.Context, clients repositories.OAuthClientRepository, clientId string, secret string) (*models.OAuthClient, error) {
+	client, err := clients.FindByClientID(ctx, clientId)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, &models.OAuthError{Code: "invalid_client"}
+	}
+	if !client.Public() && subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, &models.OAuthError{Code: "invalid_client"}
+	}
+	return client, nil
+}
+
+func (s *oidcProviderService) sign(typ string, claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyId
+	token.Header["typ"] = typ
+	return token.SignedString(s.key)
+}
+
+func (s *oidcProviderService) UserInfo(ctx context.Context, accessToken string) (map[string]any, error) {
+	invalid := &models.OAuthError{Code: "invalid_token"}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.NewParser(jwt.WithValidMethods([]string{"RS256"})).ParseWithClaims(accessToken, claims, func(t *jwt.Token) (interface{}, error) {
+		return &s.key.PublicKey, nil
+	})
+	if err != nil || !token.Valid || token.Header["typ"] != "at+jwt" || !claims.VerifyIssuer(s.issuer, true) {
+		return nil, invalid
+	}
+	sub, _ := claims["sub"].(string)
+	userId, err := strconv.Atoi(sub)
+	tenantId, ok := claims["tenant_id"].(float64)
+	if err != nil || !ok {
+		return nil, invalid
+	}
+
+	user, err := s.userRepository.FindByID(tenancy.WithTenant(ctx, int(tenantId)), userId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !user.Active() {
+		return nil, invalid
+	}
+	scope, _ := claims["scope"].(string)
+	return userClaims(user, strings.Fields(scope)), nil
+}
+
+// userClaims maps a user to the standard claims released for scopes.
+func userClaims(user *models.User, scopes []string) map[string]any {
+	claims := map[string]any{"sub": strconv.Itoa(user.Id)}
+	if contains(scopes, models.ScopeProfile) {
+		claims["name"] = strings.TrimSpace(user.Name + " " + user.LastName)
+		claims["given_name"] = user.Name
+		claims["family_name"] = user.LastName
+		if user.Avatar != nil && *user.Avatar != "" {
+			claims["picture"] = *user.Avatar
+		}
+	}
+	if contains(scopes, models.ScopeEmail) {
+		claims["email"] = user.Email
+		// Addresses are not confirmed at registration
+		claims["email_verified"] = false
+	}
+	return claims
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
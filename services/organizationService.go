@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"server-go/mailer"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type OrganizationService interface {
+	ListMemberships(ctx context.Context, userId int) ([]models.Membership, error)
+	ListMembers(ctx context.Context, orgId int) ([]models.Membership, error)
+	SetMemberRole(ctx context.Context, actor *models.User, userId int, role string) (*models.Membership, error)
+	Invite(ctx context.Context, actor *models.User, input models.InviteRequest) (*models.Invitation, error)
+	ListInvitations(ctx context.Context, orgId int) ([]models.Invitation, error)
+	// CheckInvitation returns the pending invitation an emailed link names
+	// and the organization it joins, accepting nothing.
+	CheckInvitation(ctx context.Context, token string) (*models.Invitation, *models.Organization, error)
+	AcceptInvitation(ctx context.Context, user *models.User, token string) (*models.Membership, error)
+	// Switch reissues the session token with orgId as the active organization.
+	Switch(ctx context.Context, user *models.User, orgId int, w http.ResponseWriter) (string, error)
+}
+
+type organizationService struct {
+	userRepository repositories.UserRepository
+	organizations  repositories.OrganizationRepository
+	memberships    repositories.MembershipRepository
+	invitations    repositories.InvitationRepository
+	sessions       SessionService
+	mailer         mailer.Mailer
+	audit          AuditService
+	appURL         string
+	logger         *slog.Logger
+}
+
+func NewOrganizationService(userRepo repositories.UserRepository, orgRepo repositories.OrganizationRepository, membershipRepo repositories.MembershipRepository, invitationRepo repositories.InvitationRepository, sessions SessionService, m mailer.Mailer, audit AuditService, appURL string, logger *slog.Logger) OrganizationService {
+	return &organizationService{
+		userRepository: userRepo,
+		organizations:  orgRepo,
+		memberships:    membershipRepo,
+		invitations:    invitationRepo,
+		sessions:       sessions,
+		mailer:         m,
+		audit:          audit,
+		appURL:         appURL,
+		logger:         logger,
+	}
+}
+
+func (s *organizationService) ListMemberships(ctx context.Context, userId int) ([]models.Membership, error) {
+	return s.memberships.ListForUser(ctx, userId)
+}
+
+func (s *organizationService) ListMembers(ctx context.Context, orgId int) ([]models.Membership, error) {
+	return s.memberships.ListMembers(ctx, orgId)
+}
+
+// SetMemberRole changes a member's role in the actor's active organization.
+// Only owners may grant or take away ownership, and the last owner stays.
+func (s *organizationService) SetMemberRole(ctx context.Context, actor *models.User, userId int, role string) (*models.Membership, error) {
+	if !models.ValidOrgRole(role) {
+		return nil, errors.New("invalid role")
+	}
+
+	before, err := s.memberships.Find(ctx, userId, actor.OrgId)
+	if err != nil {
+		return nil, err
+	}
+	if before == nil {
+		return nil, errors.New("member not found")
+	}
+	if before.Role == role {
+		return before, nil
+	}
+	if (role == models.OrgRoleOwner || before.Role == models.OrgRoleOwner) && actor.OrgRole != models.OrgRoleOwner {
+		return nil, errors.New("forbidden")
+	}
+	if before.Role == models.OrgRoleOwner {
+		owners, err := s.memberships.CountOwners(ctx, actor.OrgId)
+		if err != nil {
+			return nil, err
+		}
+		if owners <= 1 {
+			return nil, errors.New("last owner")
+		}
+	}
+
+	membership, err := s.memberships.SetRole(ctx, userId, actor.OrgId, role)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditMemberRoleChange,
+		TargetId: intPtr(userId),
+		Changes:  map[string]models.FieldChange{"orgRole": {Old: before.Role, New: role}},
+	})
+	return membership, nil
+}
+
+// Invite emails a signed link that joins the actor's active organization,
+// either while registering or from an existing account.
+func (s *organizationService) Invite(ctx context.Context, actor *models.User, input models.InviteRequest) (*models.Invitation, error) {
+	input.Email = strings.TrimSpace(input.Email)
+	if input.Role == "" {
+		input.Role = models.OrgRoleMember
+	}
+	if input.Email == "" || !strings.Contains(input.Email, "@") {
+		return nil, errors.New("invalid email")
+	}
+	if !models.ValidOrgRole(input.Role) {
+		return nil, errors.New("invalid role")
+	}
+	if input.Role == models.OrgRoleOwner && actor.OrgRole != models.OrgRoleOwner {
+		return nil, errors.New("forbidden")
+	}
+
+	org, err := s.organizations.FindByID(ctx, actor.OrgId)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, errors.New("organization not found")
+	}
+
+	invitation, err := s.invitations.Create(ctx, org.Id, input.Email, input.Role, actor.Id, time.Now().Add(inviteTTL))
+	if err != nil {
+		return nil, err
+	}
+	token, err := signInvitation(invitation)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      invitation.Email,
+		Subject: "You have been invited to " + org.Name,
+		Body: fmt.Sprintf("Hello,\n\n%s %s invited you to join %s as %s. Accept the invitation here:\n\n%s/invitations/accept?token=%s\n\nThe link expires in %d days.\n",
+			actor.Name, actor.LastName, org.Name, invitation.Role, s.appURL, url.QueryEscape(token), int(inviteTTL.Hours()/24)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:  models.AuditMemberInvited,
+		Details: map[string]string{"invitationId": strconv.Itoa(invitation.Id), "email": invitation.Email, "role": invitation.Role},
+	})
+	return invitation, nil
+}
+
+func (s *organizationService) ListInvitations(ctx context.Context, orgId int) ([]models.Invitation, error) {
+	return s.invitations.ListPending(ctx, orgId)
+}
+
+func (s *organizationService) CheckInvitation(ctx context.Context, token string) (*models.Invitation, *models.Organization, error) {
+	invitation, err := openInvitation(ctx, s.invitations, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	org, err := s.organizations.FindByID(ctx, invitation.OrganizationId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if org == nil {
+		return nil, nil, errors.New("invalid or expired invitation")
+	}
+	return invitation, org, nil
+}
+
+func (s *organizationService) AcceptInvitation(ctx context.Context, user *models.User, token string) (*models.Membership, error) {
+	invitation, err := pendingInvitation(ctx, s.invitations, token, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	accepted, err := s.invitations.MarkAccepted(ctx, invitation.Id, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !accepted {
+		return nil, errors.New("invalid or expired invitation")
+	}
+
+	membership, err := s.memberships.Add(ctx, user.Id, invitation.OrganizationId, invitation.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(tenancy.WithTenant(ctx, invitation.OrganizationId), models.AuditEvent{
+		Action:   models.AuditInviteAccepted,
+		TargetId: intPtr(user.Id),
+		Details:  map[string]string{"invitationId": strconv.Itoa(invitation.Id), "role": membership.Role},
+	})
+	return membership, nil
+}
+
+func (s *organizationService) Switch(ctx context.Context, current *models.User, orgId int, w http.ResponseWriter) (string, error) {
+	membership, err := s.memberships.Find(ctx, current.Id, orgId)
+	if err != nil {
+		return "", err
+	}
+	if membership == nil {
+		return "", errors.New("not a member")
+	}
+
+	// The account itself always lives in the home organization
+	user, err := s.userRepository.FindByID(tenancy.WithTenant(ctx, current.TenantId), current.Id)
+	if err != nil {
+		return "", err
+	}
+	if user == nil {
+		return "", errors.New("user not found")
+	}
+	if !user.Active() {
+		return "", errors.New("account disabled")
+	}
+	user.OrgId = orgId
+	user.OrgRole = membership.Role
+
+	token, err := s.sessions.Reissue(ctx, user, current.TokenId)
+	if err != nil {
+		return "", err
+	}
+	setSessionCookie(w, token)
+
+	s.audit.Record(tenancy.WithTenant(ctx, orgId), models.AuditEvent{
+		Action:   models.AuditOrganizationSwitch,
+		TargetId: intPtr(user.Id),
+		Details:  map[string]string{"from": strconv.Itoa(current.OrgId), "to": strconv.Itoa(orgId)},
+	})
+	return token, nil
+}
+
+// Invitation links carry a signed token naming the invitation row; the row
+// is still checked so that revoked or accepted invitations stop working.
+func signInvitation(invitation *models.Invitation) (string, error) {
+	claims := jwt.MapClaims{
+		"iss":   "server-go",
+		"typ":   "invite",
+		"inv":   invitation.Id,
+		"org":   invitation.OrganizationId,
+		"email": invitation.Email,
+		"iat":   time.Now().Unix(),
+		"exp":   invitation.ExpiresAt.Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString(jwtSecret)
+}
+
+func pendingInvitation(ctx context.Context, invitations repositories.InvitationRepository, token string, email string) (*models.Invitation, error) {
+	invitation, err := openInvitation(ctx, invitations, token)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(invitation.Email, strings.TrimSpace(email)) {
+		return nil, errors.New("invitation is for another email")
+	}
+	return invitation, nil
+}
+
+// openInvitation returns the pending invitation a link's token names,
+// whoever follows it.
+func openInvitation(ctx context.Context, invitations repositories.InvitationRepository, token string) (*models.Invitation, error) {
+	invalid := errors.New("invalid or expired invitation")
+
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS512 {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !parsed.Valid || claims["typ"] != "invite" {
+		return nil, invalid
+	}
+	id, ok := claims["inv"].(float64)
+	if !ok {
+		return nil, invalid
+	}
+
+	invitation, err := invitations.Find(ctx, int(id))
+	if err != nil {
+		return nil, err
+	}
+	if invitation == nil || invitation.AcceptedAt != nil || time.Now().After(invitation.ExpiresAt) {
+		return nil, invalid
+	}
+	return invitation, nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/repositories"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type membershipsRepo struct {
+	repositories.MembershipRepository
+	roles map[int]string
+}
+
+func (r *membershipsRepo) Find(ctx context.Context, userId int, orgId int) (*models.Membership, error) {
+	role, ok := r.roles[userId]
+	if !ok {
+		return nil, nil
+	}
+	return &models.Membership{UserId: userId, OrganizationId: orgId, Role: role}, nil
+}
+
+func (r *membershipsRepo) SetRole(ctx context.Context, userId int, orgId int, role string) (*models.Membership, error) {
+	r.roles[userId] = role
+	return r.Find(ctx, userId, orgId)
+}
+
+func (r *membershipsRepo) CountOwners(ctx context.Context, orgId int) (int, error) {
+	n := 0
+	for _, role := range r.roles {
+		if role == models.OrgRoleOwner {
+			n++
+		}
+	}
+	return n, nil
+}
+
+type invitationsRepo struct {
+	repositories.InvitationRepository
+	invitation models.Invitation
+}
+
+func (r *invitationsRepo) Find(ctx context.Context, id int) (*models.Invitation, error) {
+	if id != r.invitation.Id {
+		return nil, nil
+	}
+	inv := r.invitation
+	return &inv, nil
+}
+
+type discardAudit struct{ AuditService }
+
+func (discardAudit) Record(ctx context.Context, event models.AuditEvent) {}
+
+func TestSetMemberRole(t *testing.T) {
+	repo := &membershipsRepo{roles: map[int]string{1: models.OrgRoleOwner, 2: models.OrgRoleAdmin, 3: models.OrgRoleMember}}
+	svc := NewOrganizationService(nil, nil, repo, nil, nil, nil, discardAudit{}, "", logging.Discard())
+	owner := &models.User{Id: 1, OrgId: 5, OrgRole: models.OrgRoleOwner}
+	admin := &models.User{Id: 2, OrgId: 5, OrgRole: models.OrgRoleAdmin}
+
+	_, err := svc.SetMemberRole(context.Background(), admin, 3, models.OrgRoleOwner)
+	assert.EqualError(t, err, "forbidden", "admins cannot grant ownership")
+
+	_, err = svc.SetMemberRole(context.Background(), owner, 1, models.OrgRoleMember)
+	assert.EqualError(t, err, "last owner")
+
+	m, err := svc.SetMemberRole(context.Background(), admin, 3, models.OrgRoleAdmin)
+	assert.NoError(t, err)
+	assert.Equal(t, models.OrgRoleAdmin, m.Role)
+
+	_, err = svc.SetMemberRole(context.Background(), owner, 2, models.OrgRoleOwner)
+	assert.NoError(t, err)
+	_, err = svc.SetMemberRole(context.Background(), owner, 1, models.OrgRoleMember)
+	assert.NoError(t, err, "a second owner lets the first step down")
+
+	_, err = svc.SetMemberRole(context.Background(), owner, 9, models.OrgRoleMember)
+	assert.EqualError(t, err, "member not found")
+}
+
+func TestPendingInvitation(t *testing.T) {
+	invitation := models.Invitation{Id: 4, OrganizationId: 5, Email: "jane@example.com", Role: models.OrgRoleAdmin, ExpiresAt: time.Now().Add(time.Hour)}
+	repo := &invitationsRepo{invitation: invitation}
+	token, err := signInvitation(&invitation)
+	assert.NoError(t, err)
+
+	got, err := pendingInvitation(context.Background(), repo, token, "Jane@Example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, got.OrganizationId)
+
+	_, err = pendingInvitation(context.Background(), repo, token, "john@example.com")
+	assert.EqualError(t, err, "invitation is for another email")
+
+	_, err = pendingInvitation(context.Background(), repo, token+"x", "jane@example.com")
+	assert.EqualError(t, err, "invalid or expired invitation")
+
+	session, _, _, _ := generateJWT(&models.User{Id: 1, Email: "jane@example.com"})
+	_, err = pendingInvitation(context.Background(), repo, session, "jane@example.com")
+	assert.EqualError(t, err, "invalid or expired invitation", "session tokens are not invitations")
+
+	accepted := time.Now()
+	repo.invitation.AcceptedAt = &accepted
+	_, err = pendingInvitation(context.Background(), repo, token, "jane@example.com")
+	assert.EqualError(t, err, "invalid or expired invitation")
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"server-go/models"
+	"server-go/passwords"
+	"server-go/repositories"
+)
+
+// PasswordService hashes passwords and applies the password policy. Every
+// flow that sets a password validates it here first and remembers the new
+// hash afterwards.
+type PasswordService interface {
+	passwords.Hasher
+	// Validate checks password as the new password of user, whose name and
+	// email are the values the account will have. For an existing account
+	// (Id set, Password its current hash) previous passwords are checked
+	// too. Violations are returned as *models.ValidationError.
+	Validate(ctx context.Context, user *models.User, password string) error
+	// Remember adds a newly set hash to the user's password history.
+	Remember(ctx context.Context, userId int, hash string) error
+	// Expired reports whether the user's password is past its maximum age.
+	Expired(user *models.User) bool
+}
+
+type passwordService struct {
+	passwords.Hasher
+	policy  passwords.Policy
+	history repositories.PasswordHistoryRepository
+	logger  *slog.Logger
+}
+
+func NewPasswordService(hasher passwords.Hasher, policy passwords.Policy, historyRepo repositories.PasswordHistoryRepository, logger *slog.Logger) PasswordService {
+	return &passwordService{Hasher: hasher, policy: policy, history: historyRepo, logger: logger}
+}
+
+func (s *passwordService) Validate(ctx context.Context, user *models.User, password string) error {
+	violations, err := s.policy.Check(password, user.Name, user.LastName, user.Email)
+	if err != nil {
+		// An unreadable breach corpus should not stop every password change
+		s.logger.ErrorContext(ctx, "breached password check failed", "error", err)
+	}
+
+	if s.policy.History > 0 && user.Id != 0 {
+		reused, err := s.reused(ctx, user, password)
+		if err != nil {
+			return err
+		}
+		if reused {
+			violations = append(violations, passwords.Violation{Code: "reused", Message: "must differ from your recent passwords"})
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	invalid := &models.ValidationError{}
+	for _, v := range violations {
+		invalid.Errors = append(invalid.Errors, models.FieldError{Field: "password", Code: v.Code, Message: v.Message})
+	}
+	return invalid
+}
+
+func (s *passwordService) reused(ctx context.Context, user *models.User, password string) (bool, error) {
+	hashes, err := s.history.Recent(ctx, user.Id, s.policy.History)
+	if err != nil {
+		return false, err
+	}
+	// Accounts from before the history was kept only have their current hash
+	if user.Password != "" {
+		hashes = append(hashes, user.Password)
+	}
+	for _, hash := range hashes {
+		if match, _, _ := s.Verify(hash, password); match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *passwordService) Remember(ctx context.Context, userId int, hash string) error {
+	if s.policy.History == 0 {
+		return nil
+	}
+	return s.history.Add(ctx, userId, hash, s.policy.History)
+}
+
+func (s *passwordService) Expired(user *models.User) bool {
+	return s.policy.Expired(user.PasswordChangedAt)
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"server-go/jobs"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+	"strconv"
+	"time"
+)
+
+// PurgeUsersJob runs RetentionService.PurgeUsers, usually on a schedule.
+var PurgeUsersJob = jobs.Kind[PurgeUsers]{Name: "users.purge"}
+
+type PurgeUsers struct {
+	RetentionDays int `json:"retentionDays"`
+}
+
+// RetentionService enforces how long data about deleted users is kept.
+type RetentionService interface {
+	// PurgeUsers scrubs the personal data of users deleted more than
+	// RetentionDays ago, in every organization.
+	PurgeUsers(ctx context.Context, args PurgeUsers) error
+}
+
+type retentionService struct {
+	orgRepository  repositories.OrganizationRepository
+	userRepository repositories.UserRepository
+	audit          AuditService
+	logger         *slog.Logger
+}
+
+func NewRetentionService(orgRepo repositories.OrganizationRepository, userRepo repositories.UserRepository, audit AuditService, logger *slog.Logger) RetentionService {
+	return &retentionService{orgRepository: orgRepo, userRepository: userRepo, audit: audit, logger: logger}
+}
+
+func (s *retentionService) PurgeUsers(ctx context.Context, args PurgeUsers) error {
+	if args.RetentionDays <= 0 {
+		return jobs.Permanent(errors.New("retention must be at least a day"))
+	}
+	before := time.Now().AddDate(0, 0, -args.RetentionDays)
+	orgs, err := s.orgRepository.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	// One organization failing does not hold up the others; the job is
+	// retried and purging is idempotent
+	var errs []error
+	for _, org := range orgs {
+		ctx := tenancy.WithTenant(ctx, org.Id)
+		n, err := s.userRepository.PurgeDeleted(ctx, before)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		s.logger.InfoContext(ctx, "deleted users purged", "org", org.Id, "count", n)
+		s.audit.Record(ctx, models.AuditEvent{
+			Action:  models.AuditPurge,
+			Details: map[string]string{"count": strconv.Itoa(n), "retentionDays": strconv.Itoa(args.RetentionDays)},
+		})
+	}
+	return errors.Join(errs...)
+}
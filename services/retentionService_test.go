@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type orgList struct {
+	repositories.OrganizationRepository
+	orgs []models.Organization
+}
+
+func (r *orgList) List(ctx context.Context) ([]models.Organization, error) {
+	return r.orgs, nil
+}
+
+// purgingUsers records the cutoff PurgeDeleted ran with in each tenant.
+type purgingUsers struct {
+	repositories.UserRepository
+	cutoffs map[int]time.Time
+	failFor int
+}
+
+func (r *purgingUsers) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	tenantId, _ := tenancy.TenantID(ctx)
+	if tenantId == r.failFor {
+		return 0, errors.New("connection reset")
+	}
+	r.cutoffs[tenantId] = before
+	return 2, nil
+}
+
+func TestPurgeUsers(t *testing.T) {
+	users := &purgingUsers{cutoffs: map[int]time.Time{}, failFor: 2}
+	orgs := &orgList{orgs: []models.Organization{{Id: 1}, {Id: 2}, {Id: 3}}}
+	svc := NewRetentionService(orgs, users, discardAudit{}, logging.Discard())
+
+	err := svc.PurgeUsers(context.Background(), PurgeUsers{RetentionDays: 30})
+	assert.EqualError(t, err, "connection reset", "the failure is reported so the job is retried")
+	require.Len(t, users.cutoffs, 2, "the other organizations are purged anyway")
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, -30), users.cutoffs[3], time.Minute)
+
+	assert.Error(t, svc.PurgeUsers(context.Background(), PurgeUsers{}))
+}
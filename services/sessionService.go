@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"server-go/logging"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionService issues session tokens and keeps a record of each one, so
+// users can see where they are signed in and sign devices out. Sessions
+// live in the user's home organization whichever one is active.
+type SessionService interface {
+	// Start signs a token for user, active in their home organization, and
+	// records the session. device names it; when empty a name is derived
+	// from the user agent. The user has just authenticated, which the token
+	// records as its auth_time.
+	Start(ctx context.Context, user *models.User, device string) (string, error)
+	// Reissue signs a token for user's current OrgId and OrgRole that
+	// replaces the session of previousJti, which stops being accepted. It
+	// keeps the user's AuthTime.
+	Reissue(ctx context.Context, user *models.User, previousJti string) (string, error)
+	List(ctx context.Context, user *models.User) ([]models.Session, error)
+	Revoke(ctx context.Context, user *models.User, id int) error
+	// RevokeOthers signs out every session of user except the one the
+	// request was made with.
+	RevokeOthers(ctx context.Context, user *models.User, reason string) error
+	// TouchSession records that the session of jti made a request.
+	TouchSession(ctx context.Context, jti string) error
+}
+
+type sessionService struct {
+	sessions       repositories.SessionRepository
+	userRepository repositories.UserRepository
+	memberships    repositories.MembershipRepository
+	maxPerUser     int
+	audit          AuditService
+	logger         *slog.Logger
+}
+
+// NewSessionService returns a SessionService that signs out the oldest
+// sessions beyond maxPerUser; zero means no limit.
+func NewSessionService(sessionRepo repositories.SessionRepository, userRepo repositories.UserRepository, membershipRepo repositories.MembershipRepository, maxPerUser int, audit AuditService, logger *slog.Logger) SessionService {
+	return &sessionService{sessions: sessionRepo, userRepository: userRepo, memberships: membershipRepo, maxPerUser: maxPerUser, audit: audit, logger: logger}
+}
+
+func (s *sessionService) Start(ctx context.Context, user *models.User, device string) (string, error) {
+	ctx = tenancy.WithTenant(ctx, user.TenantId)
+	membership, err := s.memberships.Find(ctx, user.Id, user.TenantId)
+	if err != nil {
+		return "", fmt.Errorf("database error: %v", err)
+	}
+	user.OrgId = user.TenantId
+	if membership != nil {
+		user.OrgRole = membership.Role
+	}
+	user.AuthTime = time.Now()
+
+	token, jti, expiresAt, err := generateJWT(user)
+	if err != nil {
+		return "", fmt.Errorf("error generating token: %v", err)
+	}
+	if err := s.record(ctx, user, jti, expiresAt, device); err != nil {
+		return "", err
+	}
+	if err := s.evict(ctx, user.Id); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *sessionService) Reissue(ctx context.Context, user *models.User, previousJti string) (string, error) {
+	ctx = tenancy.WithTenant(ctx, user.TenantId)
+	token, jti, expiresAt, err := generateJWT(user)
+	if err != nil {
+		return "", fmt.Errorf("error generating token: %v", err)
+	}
+
+	rotated := false
+	if previousJti != "" {
+		if rotated, err = s.sessions.Rotate(ctx, previousJti, jti, expiresAt); err != nil {
+			return "", err
+		}
+	}
+	if !rotated {
+		// Tokens issued before sessions were recorded get a new session
+		return token, s.record(ctx, user, jti, expiresAt, "")
+	}
+	// Session tokens never outlive their successor
+	if err := s.userRepository.RevokeToken(ctx, user.Id, previousJti, expiresAt); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *sessionService) record(ctx context.Context, user *models.User, jti string, expiresAt time.Time, device string) error {
+	client := logging.Client(ctx)
+	if device == "" {
+		device = describeDevice(client.UserAgent)
+	}
+	_, err := s.sessions.Create(ctx, &models.Session{
+		UserId:     user.Id,
+		JTI:        jti,
+		DeviceName: device,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		ExpiresAt:  expiresAt,
+	})
+	return err
+}
+
+// evict signs out the oldest sessions beyond the limit.
+func (s *sessionService) evict(ctx context.Context, userId int) error {
+	if s.maxPerUser <= 0 {
+		return nil
+	}
+	evicted, err := s.sessions.EvictBeyond(ctx, userId, s.maxPerUser)
+	if err != nil {
+		return err
+	}
+	for _, session := range evicted {
+		if err := s.end(ctx, userId, session, "session limit"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// end blocks the session's token and records why.
+func (s *sessionService) end(ctx context.Context, userId int, session models.Session, reason string) error {
+	if err := s.userRepository.RevokeToken(ctx, userId, session.JTI, session.ExpiresAt); err != nil {
+		return err
+	}
+	s.logger.InfoContext(ctx, "session revoked", "user", userId, "session", session.Id, "reason", reason)
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditSessionRevoked,
+		TargetId: intPtr(userId),
+		Details:  map[string]string{"session": strconv.Itoa(session.Id), "device": session.DeviceName, "reason": reason},
+	})
+	return nil
+}
+
+func (s *sessionService) List(ctx context.Context, user *models.User) ([]models.Session, error) {
+	sessions, err := s.sessions.ListActive(tenancy.WithTenant(ctx, user.TenantId), user.Id)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = user.TokenId != "" && sessions[i].JTI == user.TokenId
+	}
+	return sessions, nil
+}
+
+func (s *sessionService) Revoke(ctx context.Context, user *models.User, id int) error {
+	ctx = tenancy.WithTenant(ctx, user.TenantId)
+	session, err := s.sessions.Revoke(ctx, user.Id, id)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return errors.New("session not found")
+	}
+	return s.end(ctx, user.Id, *session, "revoked by user")
+}
+
+func (s *sessionService) RevokeOthers(ctx context.Context, user *models.User, reason string) error {
+	ctx = tenancy.WithTenant(ctx, user.TenantId)
+	sessions, err := s.sessions.ListActive(ctx, user.Id)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.JTI == user.TokenId {
+			continue
+		}
+		revoked, err := s.sessions.Revoke(ctx, user.Id, session.Id)
+		if err != nil {
+			return err
+		}
+		if revoked == nil {
+			// Ended in the meantime
+			continue
+		}
+		if err := s.end(ctx, user.Id, *revoked, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sessionService) TouchSession(ctx context.Context, jti string) error {
+	return s.sessions.Touch(ctx, jti)
+}
+
+// describeDevice names a session after the browser and operating system in
+// its user agent, e.g. "Firefox on Linux".
+func describeDevice(userAgent string) string {
+	browser := firstMatch(userAgent, [][2]string{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"},
+		{"Safari/", "Safari"}, {"curl/", "curl"}, {"Go-http-client", "Go client"},
+	})
+	os := firstMatch(userAgent, [][2]string{
+		{"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Android", "Android"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	})
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	default:
+		return "Unknown device"
+	}
+}
+
+func firstMatch(s string, patterns [][2]string) string {
+	for _, p := range patterns {
+		if strings.Contains(s, p[0]) {
+			return p[1]
+		}
+	}
+	return ""
+}
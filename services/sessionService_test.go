@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/repositories"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memorySessions struct {
+	repositories.SessionRepository
+	sessions []*models.Session
+}
+
+func (r *memorySessions) Create(ctx context.Context, session *models.Session) (*models.Session, error) {
+	created := *session
+	created.Id = len(r.sessions) + 1
+	created.CreatedAt = time.Now()
+	created.LastSeenAt = created.CreatedAt
+	r.sessions = append(r.sessions, &created)
+	return &created, nil
+}
+
+// active returns the user's sessions newest first.
+func (r *memorySessions) active(userId int) []*models.Session {
+	var active []*models.Session
+	for i := len(r.sessions) - 1; i >= 0; i-- {
+		if s := r.sessions[i]; s.UserId == userId && s.ExpiresAt.After(time.Now()) {
+			active = append(active, s)
+		}
+	}
+	return active
+}
+
+func (r *memorySessions) ListActive(ctx context.Context, userId int) ([]models.Session, error) {
+	sessions := []models.Session{}
+	for _, s := range r.active(userId) {
+		sessions = append(sessions, *s)
+	}
+	return sessions, nil
+}
+
+func (r *memorySessions) Revoke(ctx context.Context, userId int, id int) (*models.Session, error) {
+	for _, s := range r.active(userId) {
+		if s.Id == id {
+			s.ExpiresAt = time.Time{}
+			return s, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memorySessions) EvictBeyond(ctx context.Context, userId int, keep int) ([]models.Session, error) {
+	var evicted []models.Session
+	for i, s := range r.active(userId) {
+		if i >= keep {
+			evicted = append(evicted, *s)
+			s.ExpiresAt = time.Time{}
+		}
+	}
+	return evicted, nil
+}
+
+func (r *memorySessions) Rotate(ctx context.Context, jti string, newJti string, expiresAt time.Time) (bool, error) {
+	for _, s := range r.sessions {
+		if s.JTI == jti && s.ExpiresAt.After(time.Now()) {
+			s.JTI, s.ExpiresAt = newJti, expiresAt
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// revokingUsers remembers the jtis revoked through RevokeToken.
+type revokingUsers struct {
+	repositories.UserRepository
+	revoked []string
+}
+
+func (r *revokingUsers) RevokeToken(ctx context.Context, id int, jti string, expiresAt time.Time) error {
+	r.revoked = append(r.revoked, jti)
+	return nil
+}
+
+func tokenJTI(t *testing.T, token string) string {
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+	return claims["jti"].(string)
+}
+
+func TestSessions(t *testing.T) {
+	repo := &memorySessions{}
+	users := &revokingUsers{}
+	svc := NewSessionService(repo, users, &membershipsRepo{roles: map[int]string{1: models.OrgRoleOwner}}, 2, discardAudit{}, logging.Discard())
+	ctx := logging.WithClient(context.Background(), logging.ClientInfo{
+		IP:        "203.0.113.9",
+		UserAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0",
+	})
+	user := &models.User{Id: 1, Email: "jane@example.com", TenantId: 3}
+
+	first, err := svc.Start(ctx, user, "")
+	require.NoError(t, err)
+	require.Len(t, repo.sessions, 1)
+	assert.Equal(t, tokenJTI(t, first), repo.sessions[0].JTI)
+	assert.Equal(t, "Firefox on Linux", repo.sessions[0].DeviceName)
+	assert.Equal(t, "203.0.113.9", repo.sessions[0].IP)
+	assert.Equal(t, models.OrgRoleOwner, user.OrgRole)
+
+	t.Run("oldest sessions are evicted beyond the limit", func(t *testing.T) {
+		_, err := svc.Start(ctx, user, "CI laptop")
+		require.NoError(t, err)
+		assert.Empty(t, users.revoked)
+
+		_, err = svc.Start(ctx, user, "")
+		require.NoError(t, err)
+		assert.Equal(t, []string{tokenJTI(t, first)}, users.revoked)
+
+		sessions, err := svc.List(ctx, user)
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		assert.Equal(t, "CI laptop", sessions[1].DeviceName)
+	})
+
+	t.Run("current session", func(t *testing.T) {
+		current := &models.User{Id: 1, TenantId: 3, TokenId: repo.sessions[1].JTI}
+		sessions, err := svc.List(ctx, current)
+		require.NoError(t, err)
+		assert.False(t, sessions[0].Current)
+		assert.True(t, sessions[1].Current)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		users.revoked = nil
+		assert.EqualError(t, svc.Revoke(ctx, user, 1), "session not found", "already evicted")
+		assert.EqualError(t, svc.Revoke(ctx, &models.User{Id: 2, TenantId: 3}, 2), "session not found", "someone else's")
+
+		jti := repo.sessions[1].JTI
+		require.NoError(t, svc.Revoke(ctx, user, 2))
+		assert.Equal(t, []string{jti}, users.revoked)
+	})
+
+	t.Run("reissue replaces the session token", func(t *testing.T) {
+		users.revoked = nil
+		previous := repo.sessions[2].JTI
+		token, err := svc.Reissue(ctx, user, previous)
+		require.NoError(t, err)
+		assert.Equal(t, tokenJTI(t, token), repo.sessions[2].JTI)
+		assert.Equal(t, []string{previous}, users.revoked)
+		assert.Len(t, repo.sessions, 3)
+	})
+}
+
+func TestDescribeDevice(t *testing.T) {
+	for ua, want := range map[string]string{
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15":                   "Safari on macOS",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36 Edg/119.0.0.0":           "Edge on Windows",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1": "Safari on iOS",
+		"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Mobile Safari/537.36":                            "Chrome on Android",
+		"curl/8.4.0": "curl",
+		"":           "Unknown device",
+	} {
+		assert.Equal(t, want, describeDevice(ua), ua)
+	}
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"server-go/models"
+	"server-go/oidc"
+	"server-go/repositories"
+	"server-go/tenancy"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// OAuthFlowCookie carries the state, nonce and PKCE verifier of a social
+// login between /start and /callback.
+const OAuthFlowCookie = "oauth_flow"
+
+const oauthFlowTTL = 10 * time.Minute
+
+type SocialLoginService interface {
+	Providers() []string
+	// Start returns the provider's authorization URL and remembers the
+	// flow in a short-lived cookie.
+	Start(ctx context.Context, provider string, w http.ResponseWriter) (string, error)
+	// Callback completes the flow and signs the user in the way Login does.
+	Callback(ctx context.Context, provider string, code string, state string, flow string, w http.ResponseWriter) (string, error)
+}
+
+type socialLoginService struct {
+	tx             repositories.Transactor
+	providers      *oidc.Registry
+	userRepository repositories.UserRepository
+	identities     repositories.IdentityRepository
+	sessions       SessionService
+	audit          AuditService
+	logger         *slog.Logger
+}
+
+func NewSocialLoginService(tx repositories.Transactor, providers *oidc.Registry, userRepo repositories.UserRepository, identityRepo repositories.IdentityRepository, sessions SessionService, audit AuditService, logger *slog.Logger) SocialLoginService {
+	return &socialLoginService{
+		tx:             tx,
+		providers:      providers,
+		userRepository: userRepo,
+		identities:     identityRepo,
+		sessions:       sessions,
+		audit:          audit,
+		logger:         logger,
+	}
+}
+
+func (s *socialLoginService) Providers() []string {
+	return s.providers.Names()
+}
+
+func (s *socialLoginService) Start(ctx context.Context, name string, w http.ResponseWriter) (string, error) {
+	provider, err := s.providers.Get(name)
+	if err != nil {
+		return "", err
+	}
+	tenantId, ok := tenancy.TenantID(ctx)
+	if !ok {
+		return "", tenancy.ErrNoTenant
+	}
+
+	state, err := oidc.RandomString(24)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := oidc.RandomString(24)
+	if err != nil {
+		return "", err
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "oauth provider unavailable", "provider", name, "error", err)
+		return "", errors.New("provider unavailable")
+	}
+
+	// The callback arrives without our tenant header, so the flow
+	// remembers which organization the user is signing in to.
+	flow, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"typ":   "oauth_flow",
+		"prv":   name,
+		"state": state,
+		"nonce": nonce,
+		"cv":    verifier,
+		"tid":   tenantId,
+		"exp":   time.Now().Add(oauthFlowTTL).Unix(),
+	}).SignedString(jwtSecret)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     OAuthFlowCookie,
+		Value:    flow,
+		Expires:  time.Now().Add(oauthFlowTTL),
+		HttpOnly: true,
+		Secure:   false,
+		Path:     "/auth/" + name,
+		// Lax, so the cookie survives the top-level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+	return authURL, nil
+}
+
+func (s *socialLoginService) Callback(ctx context.Context, name string, code string, state string, flow string, w http.ResponseWriter) (string, error) {
+	provider, err := s.providers.Get(name)
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: OAuthFlowCookie, Value: "", Path: "/auth/" + name, Expires: time.Now().Add(-time.Hour), HttpOnly: true})
+
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(flow, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS512 {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return jwtSecret, nil
+	})
+	expected, _ := claims["state"].(string)
+	if err != nil || !parsed.Valid || claims["typ"] != "oauth_flow" || claims["prv"] != name ||
+		expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(state)) != 1 {
+		s.logger.InfoContext(ctx, "oauth callback with invalid state", "provider", name)
+		return "", errors.New("invalid oauth state")
+	}
+	tenantId, _ := claims["tid"].(float64)
+	nonce, _ := claims["nonce"].(string)
+	verifier, _ := claims["cv"].(string)
+	ctx = tenancy.WithTenant(ctx, int(tenantId))
+
+	token, err := provider.Exchange(ctx, code, verifier)
+	if err != nil {
+		s.logger.InfoContext(ctx, "oauth code exchange failed", "provider", name, "error", err)
+		return "", errors.New("provider rejected the sign-in")
+	}
+	external, err := provider.Identity(ctx, token, nonce)
+	if err != nil {
+		s.logger.InfoContext(ctx, "oauth identity rejected", "provider", name, "error", err)
+		return "", errors.New("provider rejected the sign-in")
+	}
+
+	user, err := s.resolveUser(ctx, name, external)
+	if err != nil {
+		return "", err
+	}
+	if !user.Active() {
+		s.audit.Record(ctx, models.AuditEvent{
+			Action:   models.AuditLoginFailed,
+			TargetId: intPtr(user.Id),
+			Details:  map[string]string{"email": user.Email, "reason": "account disabled", "provider": name},
+		})
+		return "", errors.New("account disabled")
+	}
+
+	session, err := s.sessions.Start(ctx, user, "")
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error issuing session", "user", user.Id, "error", err)
+		return "", err
+	}
+	setSessionCookie(w, session)
+
+	s.logger.InfoContext(ctx, "login successful", "user", user.Id, "provider", name)
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditLogin,
+		ActorId:  intPtr(user.Id),
+		TargetId: intPtr(user.Id),
+		Details:  map[string]string{"provider": name},
+	})
+	return session, nil
+}
+
+// resolveUser finds the user linked to the external account. Unlinked
+// accounts are linked to the user with the same email when the provider
+// verified it, or get a new passwordless user.
+func (s *socialLoginService) resolveUser(ctx context.Context, provider string, external *oidc.Claims) (*models.User, error) {
+	identity, err := s.identities.Find(ctx, provider, external.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		user, err := s.userRepository.FindByID(ctx, identity.UserId)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, errors.New("user not found")
+		}
+		if user.ServiceAccount {
+			return nil, errors.New("service account")
+		}
+		if err := s.identities.Touch(ctx, identity.Id); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	email := strings.TrimSpace(external.Email)
+	if email == "" {
+		return nil, errors.New("email not provided")
+	}
+	user, err := s.userRepository.FindByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil && user.ServiceAccount {
+		return nil, errors.New("service account")
+	}
+	if user != nil && !external.EmailVerified {
+		// Linking on an unverified address would hand the account to
+		// whoever typed it in at the provider
+		return nil, errors.New("email not verified")
+	}
+	if user == nil {
+		name, lastName := splitName(external, email)
+		err = s.tx.WithTx(ctx, func(repos repositories.Repositories) error {
+			if user, err = repos.Users.RegisterUser(ctx, name, lastName, email, unusablePassword); err != nil {
+				return err
+			}
+			return recordUserEvent(ctx, repos, models.EventUserRegistered, user)
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.audit.Record(ctx, models.AuditEvent{
+			Action:   models.AuditRegister,
+			ActorId:  intPtr(user.Id),
+			TargetId: intPtr(user.Id),
+			Changes:  diffUsers(nil, user),
+			Details:  map[string]string{"provider": provider},
+		})
+	}
+
+	identity, err = s.identities.Link(ctx, user.Id, provider, external.Subject, email)
+	if err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditIdentityLinked,
+		ActorId:  intPtr(user.Id),
+		TargetId: intPtr(user.Id),
+		Details:  map[string]string{"provider": provider, "subject": identity.Subject},
+	})
+	return user, nil
+}
+
+func splitName(external *oidc.Claims, email string) (string, string) {
+	if external.GivenName != "" {
+		return external.GivenName, external.FamilyName
+	}
+	if name := strings.TrimSpace(external.Name); name != "" {
+		first, last, _ := strings.Cut(name, " ")
+		return first, strings.TrimSpace(last)
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return local, ""
+}
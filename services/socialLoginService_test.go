@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/oidc"
+	"server-go/oidc/oidctest"
+	"server-go/repositories"
+	"server-go/tenancy"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryUsers struct {
+	repositories.UserRepository
+	users []*models.User
+}
+
+func (r *memoryUsers) FindByID(ctx context.Context, id int) (*models.User, error) {
+	for _, u := range r.users {
+		if u.Id == id {
+			found := *u
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryUsers) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	for _, u := range r.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryUsers) RegisterUser(ctx context.Context, name, lastName, email, password string) (*models.User, error) {
+	tenantId, _ := tenancy.TenantID(ctx)
+	u := &models.User{Id: len(r.users) + 1, Name: name, LastName: lastName, Email: email, Password: password, Role: models.RoleUser, TenantId: tenantId}
+	r.users = append(r.users, u)
+	return u, nil
+}
+
+type memoryIdentities struct {
+	repositories.IdentityRepository
+	linked []models.Identity
+}
+
+func (r *memoryIdentities) Find(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	for _, i := range r.linked {
+		if i.Provider == provider && i.Subject == subject {
+			return &i, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryIdentities) Link(ctx context.Context, userId int, provider, subject, email string) (*models.Identity, error) {
+	i := models.Identity{Id: len(r.linked) + 1, UserId: userId, Provider: provider, Subject: subject, Email: email}
+	r.linked = append(r.linked, i)
+	return &i, nil
+}
+
+func (r *memoryIdentities) Touch(ctx context.Context, id int) error { return nil }
+
+// signIn runs /start, the provider's consent page and /callback.
+func signIn(t *testing.T, svc SocialLoginService, idp *oidctest.Server, user oidctest.User) (string, error) {
+	ctx := tenancy.WithTenant(context.Background(), 3)
+	start := httptest.NewRecorder()
+	authURL, err := svc.Start(ctx, "fake", start)
+	require.NoError(t, err)
+	flow := start.Result().Cookies()[0]
+	require.Equal(t, OAuthFlowCookie, flow.Name)
+
+	code, state, err := idp.Authorize(authURL, user)
+	require.NoError(t, err)
+	return svc.Callback(context.Background(), "fake", code, state, flow.Value, httptest.NewRecorder())
+}
+
+func TestSocialLogin(t *testing.T) {
+	idp := oidctest.NewServer("client", "secret")
+	defer idp.Close()
+	users := &memoryUsers{users: []*models.User{{Id: 1, Name: "Jim", Email: "jim@example.com", TenantId: 3}}}
+	identities := &memoryIdentities{}
+	memberships := &membershipsRepo{roles: map[int]string{}}
+	registry := oidc.NewRegistry(nil, idp.Config("fake", "http://app/auth/fake/callback"))
+	sessions := NewSessionService(&memorySessions{}, users, memberships, 0, discardAudit{}, logging.Discard())
+	events := &memoryOutbox{}
+	svc := NewSocialLoginService(inlineTx{repositories.Repositories{Users: users, Outbox: events}}, registry, users, identities, sessions, discardAudit{}, logging.Discard())
+
+	t.Run("new account", func(t *testing.T) {
+		token, err := signIn(t, svc, idp, oidctest.User{Subject: "s-1", Email: "jane@example.com", Name: "Jane Doe"})
+		require.NoError(t, err)
+
+		claims := jwt.MapClaims{}
+		_, _, err = jwt.NewParser().ParseUnverified(token, claims)
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, claims["user_id"])
+		assert.EqualValues(t, 3, claims["tenant_id"], "the tenant comes from the flow")
+		assert.Equal(t, "Doe", users.users[1].LastName)
+		assert.Equal(t, unusablePassword, users.users[1].Password)
+		require.Len(t, events.events, 1)
+		assert.Equal(t, models.EventUserRegistered, events.events[0].Type)
+		assert.Equal(t, 2, events.events[0].UserId)
+	})
+
+	t.Run("linked account", func(t *testing.T) {
+		_, err := signIn(t, svc, idp, oidctest.User{Subject: "s-1", Email: "changed@example.com"})
+		require.NoError(t, err)
+		assert.Len(t, users.users, 2)
+		assert.Len(t, identities.linked, 1)
+	})
+
+	t.Run("unverified email of an existing account", func(t *testing.T) {
+		_, err := signIn(t, svc, idp, oidctest.User{Subject: "s-2", Email: "jim@example.com"})
+		assert.EqualError(t, err, "email not verified")
+	})
+
+	t.Run("verified email of an existing account", func(t *testing.T) {
+		_, err := signIn(t, svc, idp, oidctest.User{Subject: "s-2", Email: "jim@example.com", EmailVerified: true})
+		require.NoError(t, err)
+		assert.Equal(t, 1, identities.linked[1].UserId)
+	})
+
+	t.Run("state mismatch", func(t *testing.T) {
+		start := httptest.NewRecorder()
+		authURL, err := svc.Start(tenancy.WithTenant(context.Background(), 3), "fake", start)
+		require.NoError(t, err)
+		code, _, _ := idp.Authorize(authURL, oidctest.User{Subject: "s-1"})
+
+		_, err = svc.Callback(context.Background(), "fake", code, "forged", start.Result().Cookies()[0].Value, httptest.NewRecorder())
+		assert.EqualError(t, err, "invalid oauth state")
+		_, err = svc.Callback(context.Background(), "fake", code, "forged", "", httptest.NewRecorder())
+		assert.EqualError(t, err, "invalid oauth state")
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		_, err := svc.Start(tenancy.WithTenant(context.Background(), 3), "other", httptest.NewRecorder())
+		assert.ErrorIs(t, err, oidc.ErrUnknownProvider)
+	})
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const passwordResetTTL = time.Hour
+
+func (s *userService) GetUser(ctx context.Context, id int) (*models.User, error) {
+	user, err := s.userRepository.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+func (s *userService) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := s.userRepository.FindByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+func (s *userService) ListUsers(ctx context.Context, limit int, offset int) ([]models.User, error) {
+	return s.userRepository.ListUsers(ctx, limit, offset)
+}
+
+// CreateUser registers a user on an operator's behalf, honouring input.Role.
+func (s *userService) CreateUser(ctx context.Context, input models.User) (*models.User, error) {
+	if input.Role != "" && input.Role != models.RoleUser && input.Role != models.RoleAdmin {
+		return nil, errors.New("invalid role")
+	}
+	applicant := &models.User{Name: input.Name, LastName: input.LastName, Email: input.Email}
+	if err := s.passwords.Validate(ctx, applicant, input.Password); err != nil {
+		return nil, err
+	}
+	hashedPassword, err := s.passwords.Hash(input.Password)
+	if err != nil {
+		return nil, errors.New("failed to hash password")
+	}
+
+	var user *models.User
+	err = s.tx.WithTx(ctx, func(repos repositories.Repositories) error {
+		existingUser, err := repos.Users.FindByEmail(ctx, input.Email)
+		if err != nil {
+			return err
+		}
+		if existingUser != nil {
+			return errUserExists
+		}
+		if user, err = repos.Users.RegisterUser(ctx, input.Name, input.LastName, input.Email, hashedPassword); err != nil {
+			return err
+		}
+		if input.Role != "" && input.Role != user.Role {
+			if user, err = repos.Users.SetRole(ctx, user.Id, input.Role); err != nil {
+				return err
+			}
+		}
+		return recordUserEvent(ctx, repos, models.EventUserRegistered, user)
+	})
+	var conflict *models.ConflictError
+	if errors.As(err, &conflict) {
+		return nil, errUserExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.passwords.Remember(ctx, user.Id, hashedPassword); err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditCreate,
+		TargetId: intPtr(user.Id),
+		Changes:  diffUsers(nil, user),
+	})
+	return user, nil
+}
+
+// DeleteUser soft-deletes the account; the row is kept for the audit trail.
+func (s *userService) DeleteUser(ctx context.Context, id int) error {
+	err := s.tx.WithTx(ctx, func(repos repositories.Repositories) error {
+		user, err := repos.Users.FindByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		found := user != nil
+		if found {
+			found, err = repos.Users.DeleteUser(ctx, id)
+		}
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errors.New("user not found")
+		}
+		return recordUserEvent(ctx, repos, models.EventUserDeleted, user)
+	})
+	if err != nil {
+		return err
+	}
+	s.audit.Record(ctx, models.AuditEvent{Action: models.AuditDelete, TargetId: intPtr(id)})
+	return nil
+}
+
+// CreatePasswordResetToken returns a single-use token valid for an hour.
+// Only its SHA-256 is stored.
+func (s *userService) CreatePasswordResetToken(ctx context.Context, id int) (string, error) {
+	if _, err := s.GetUser(ctx, id); err != nil {
+		return "", err
+	}
+
+	token, err := newResetToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := s.resetRepository.Create(ctx, id, hashToken(token), time.Now().Add(passwordResetTTL)); err != nil {
+		return "", err
+	}
+
+	s.audit.Record(ctx, models.AuditEvent{Action: models.AuditPasswordResetLink, TargetId: intPtr(id)})
+	return token, nil
+}
+
+// ResetPassword redeems a token from CreatePasswordResetToken. The token
+// is only used up once the new password passes the policy. Pending
+// accounts become active.
+func (s *userService) ResetPassword(ctx context.Context, token string, password string) error {
+	ctx = resetTokenContext(ctx, token)
+	if _, ok := tenancy.TenantID(ctx); !ok {
+		return errors.New("invalid or expired token")
+	}
+	id, ok, err := s.resetRepository.Find(ctx, hashToken(token))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("invalid or expired token")
+	}
+	user, err := s.GetUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.passwords.Validate(ctx, user, password); err != nil {
+		return err
+	}
+
+	if _, ok, err = s.resetRepository.Consume(ctx, hashToken(token)); err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("invalid or expired token")
+	}
+	if err := s.setPassword(ctx, id, password); err != nil {
+		return err
+	}
+	// Choosing a password through an invite activates the account
+	if user.Status == models.StatusPending {
+		if _, err := s.changeStatus(ctx, user, models.StatusChange{From: models.StatusPending, To: models.StatusActive, Reason: "invitation accepted"}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetTemporaryPassword replaces the password with a random one and returns it.
+func (s *userService) SetTemporaryPassword(ctx context.Context, id int) (string, error) {
+	if _, err := s.GetUser(ctx, id); err != nil {
+		return "", err
+	}
+
+	password, err := randomToken(12)
+	if err != nil {
+		return "", err
+	}
+	if err := s.setPassword(ctx, id, password); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+func (s *userService) setPassword(ctx context.Context, id int, password string) error {
+	hashedPassword, err := s.passwords.Hash(password)
+	if err != nil {
+		return errors.New("failed to hash password")
+	}
+	// A new password logs out every existing session
+	err = s.tx.WithTx(ctx, func(repos repositories.Repositories) error {
+		if err := repos.Users.SetPassword(ctx, id, hashedPassword); err != nil {
+			return err
+		}
+		if err := repos.Users.RevokeTokens(ctx, id); err != nil {
+			return err
+		}
+		user, err := repos.Users.FindByID(ctx, id)
+		if err != nil || user == nil {
+			return err
+		}
+		return recordUserEvent(ctx, repos, models.EventUserUpdated, user)
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.passwords.Remember(ctx, id, hashedPassword); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, models.AuditEvent{Action: models.AuditPasswordReset, TargetId: intPtr(id)})
+	return nil
+}
+
+// RevokeSessions invalidates every token issued to the user so far.
+func (s *userService) RevokeSessions(ctx context.Context, id int) error {
+	if _, err := s.GetUser(ctx, id); err != nil {
+		return err
+	}
+	if err := s.userRepository.RevokeTokens(ctx, id); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, models.AuditEvent{Action: models.AuditSessionsRevoked, TargetId: intPtr(id)})
+	return nil
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newResetToken returns a password reset token for the context's
+// organization. The organization's id leads the token, so that the emailed
+// link works on whichever host it is opened; the random part is what
+// proves it.
+func newResetToken(ctx context.Context) (string, error) {
+	tenantId, ok := tenancy.TenantID(ctx)
+	if !ok {
+		return "", tenancy.ErrNoTenant
+	}
+	random, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(tenantId) + "." + random, nil
+}
+
+// resetTokenContext scopes ctx to the organization named by a token from
+// newResetToken. Tokens issued before the organization was part of them
+// are looked up in the request's.
+func resetTokenContext(ctx context.Context, token string) context.Context {
+	prefix, _, found := strings.Cut(token, ".")
+	if id, err := strconv.Atoi(prefix); found && err == nil {
+		return tenancy.WithTenant(ctx, id)
+	}
+	return ctx
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,574 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"server-go/jobs"
+	"server-go/logging"
+	"server-go/mailer"
+	"server-go/models"
+	"server-go/passwords"
+	"server-go/repositories"
+	"server-go/tenancy"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultImportBatchSize = 500
+	inviteTTL              = 7 * 24 * time.Hour
+	// Stored for invited users until they choose a password; never a valid hash.
+	unusablePassword = "!"
+	exportTTL        = 7 * 24 * time.Hour
+)
+
+// ExportUsersJob runs UserBulkService.RunExport for ExportLater.
+var ExportUsersJob = jobs.Kind[ExportUsers]{Name: "users.export"}
+
+// ExportUsers never includes password hashes: the file is downloaded over
+// HTTP. usersctl exports them directly.
+type ExportUsers struct {
+	TenantId    int    `json:"tenantId"`
+	RequestedBy int    `json:"requestedBy"`
+	Format      string `json:"format"`
+}
+
+// exportName is <tenant>-<random>.<format>; the tenant prefix keeps
+// organizations from downloading each other's exports.
+var exportName = regexp.MustCompile(`^(\d+)-[A-Za-z0-9_-]{22}\.(csv|ndjson)$`)
+
+type UserBulkService interface {
+	// Import reads users in opts.Format from r, validating every row and, when
+	// opts.Commit is set, inserting them in batches of opts.BatchSize.
+	Import(ctx context.Context, r io.Reader, opts models.ImportOptions) (*models.ImportReport, error)
+	Export(ctx context.Context, w io.Writer, opts models.ExportOptions) error
+	// ExportLater queues an export of the tenant's users, never with
+	// password hashes. The caller gets a download link by mail once it is
+	// ready.
+	ExportLater(ctx context.Context, caller *models.User, opts models.ExportOptions) error
+	// RunExport writes a queued export to the export directory.
+	RunExport(ctx context.Context, args ExportUsers) error
+	// ExportFile returns the path of a finished export of the tenant in ctx.
+	ExportFile(ctx context.Context, name string) (string, error)
+}
+
+type userBulkService struct {
+	tx              repositories.Transactor
+	userRepository  repositories.UserRepository
+	resetRepository repositories.PasswordResetRepository
+	mailer          mailer.Mailer
+	audit           AuditService
+	queue           jobs.Queue
+	exportDir       string
+	appURL          string
+	logger          *slog.Logger
+}
+
+func NewUserBulkService(tx repositories.Transactor, userRepo repositories.UserRepository, resetRepo repositories.PasswordResetRepository, mailer mailer.Mailer, audit AuditService, queue jobs.Queue, exportDir string, appURL string, logger *slog.Logger) UserBulkService {
+	return &userBulkService{
+		tx:              tx,
+		userRepository:  userRepo,
+		resetRepository: resetRepo,
+		mailer:          mailer,
+		audit:           audit,
+		queue:           queue,
+		exportDir:       exportDir,
+		appURL:          appURL,
+		logger:          logger,
+	}
+}
+
+type importRow struct {
+	row  int
+	user models.ImportUser
+}
+
+func (s *userBulkService) Import(ctx context.Context, r io.Reader, opts models.ImportOptions) (*models.ImportReport, error) {
+	next, err := importReader(r, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultImportBatchSize
+	}
+
+	report := &models.ImportReport{Committed: opts.Commit, Errors: []models.ImportRowError{}}
+	seen := map[string]int{}
+	batch := make([]importRow, 0, opts.BatchSize)
+
+	for row := 1; ; row++ {
+		user, err := next()
+		if err == io.EOF {
+			break
+		}
+		report.Rows++
+
+		var problems []string
+		if err != nil {
+			var parseErr rowParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			problems = []string{parseErr.Error()}
+		} else {
+			problems = validateImportUser(&user, opts.Invite)
+			if first, dup := seen[strings.ToLower(user.Email)]; dup && user.Email != "" {
+				problems = append(problems, fmt.Sprintf("duplicate email, first seen on row %d", first))
+			} else if user.Email != "" {
+				seen[strings.ToLower(user.Email)] = row
+			}
+		}
+		if len(problems) > 0 {
+			report.Fail(row, user.Email, problems...)
+			continue
+		}
+
+		batch = append(batch, importRow{row: row, user: user})
+		if len(batch) == opts.BatchSize {
+			if err := s.importBatch(ctx, batch, opts, report); err != nil {
+				return report, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := s.importBatch(ctx, batch, opts, report); err != nil {
+			return report, err
+		}
+	}
+
+	report.Failed = len(report.Errors)
+	return report, nil
+}
+
+func (s *userBulkService) importBatch(ctx context.Context, batch []importRow, opts models.ImportOptions, report *models.ImportReport) error {
+	emails := make([]string, len(batch))
+	for i, r := range batch {
+		emails[i] = r.user.Email
+	}
+	existing, err := s.userRepository.ExistingEmails(ctx, emails)
+	if err != nil {
+		return err
+	}
+
+	var rows []importRow
+	var users []models.User
+	for _, r := range batch {
+		if existing[strings.ToLower(r.user.Email)] {
+			report.Fail(r.row, r.user.Email, "a user with this email already exists")
+			continue
+		}
+		// Invited users stay pending until they choose a password
+		password, status := r.user.PasswordHash, models.StatusActive
+		if password == "" {
+			password, status = unusablePassword, models.StatusPending
+		}
+		rows = append(rows, r)
+		users = append(users, models.User{
+			Name:     r.user.Name,
+			LastName: r.user.LastName,
+			Email:    r.user.Email,
+			Password: password,
+			Role:     r.user.Role,
+			Status:   status,
+		})
+	}
+	report.Valid += len(rows)
+	if !opts.Commit || len(users) == 0 {
+		return nil
+	}
+
+	// The batch and its events commit together
+	var created []*models.User
+	var rowErrs []error
+	err = s.tx.WithTx(ctx, func(repos repositories.Repositories) error {
+		var err error
+		if created, rowErrs, err = repos.Users.RegisterUsers(ctx, users); err != nil {
+			return err
+		}
+		for i, user := range created {
+			if rowErrs[i] == nil {
+				if err := recordUserEvent(ctx, repos, models.EventUserRegistered, user); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, user := range created {
+		if rowErrs[i] != nil {
+			report.Valid--
+			report.Fail(rows[i].row, rows[i].user.Email, importInsertError(rowErrs[i]))
+			continue
+		}
+		report.Created++
+		s.audit.Record(ctx, models.AuditEvent{
+			Action:   models.AuditImport,
+			TargetId: intPtr(user.Id),
+			Changes:  diffUsers(nil, user),
+		})
+
+		if user.Password == unusablePassword {
+			if err := s.invite(ctx, user); err != nil {
+				s.logger.ErrorContext(ctx, "failed to send invite", "user", user.Id, "error", err)
+				report.Fail(rows[i].row, user.Email, "user created but the invite could not be sent")
+				continue
+			}
+			report.Invited++
+		}
+	}
+	return nil
+}
+
+func (s *userBulkService) invite(ctx context.Context, user *models.User) error {
+	token, err := newResetToken(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.resetRepository.Create(ctx, user.Id, hashToken(token), time.Now().Add(inviteTTL)); err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "You have been invited",
+		Body: fmt.Sprintf("Hello %s,\n\nAn account has been created for you. Choose a password here:\n\n%s/password/reset?token=%s\n\nThe link expires in %d days.\n",
+			user.Name, s.appURL, url.QueryEscape(token), int(inviteTTL.Hours()/24)),
+	})
+}
+
+func (s *userBulkService) Export(ctx context.Context, w io.Writer, opts models.ExportOptions) error {
+	var write func(models.User) error
+	var flush func() error
+
+	switch opts.Format {
+	case models.FormatCSV:
+		cw := csv.NewWriter(w)
+		header := []string{"id", "name", "lastName", "email", "role", "disabledAt"}
+		if opts.IncludeHashes {
+			header = append(header, "passwordHash")
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		write = func(u models.User) error {
+			disabledAt := ""
+			if u.DisabledAt != nil {
+				disabledAt = u.DisabledAt.UTC().Format(time.RFC3339)
+			}
+			record := []string{strconv.Itoa(u.Id), u.Name, u.LastName, u.Email, u.Role, disabledAt}
+			if opts.IncludeHashes {
+				record = append(record, u.Password)
+			}
+			return cw.Write(record)
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case models.FormatNDJSON:
+		enc := json.NewEncoder(w)
+		write = func(u models.User) error {
+			record := exportUser{Id: u.Id, Name: u.Name, LastName: u.LastName, Email: u.Email, Role: u.Role, DisabledAt: u.DisabledAt}
+			if opts.IncludeHashes {
+				record.PasswordHash = u.Password
+			}
+			return enc.Encode(record)
+		}
+		flush = func() error { return nil }
+	default:
+		return fmt.Errorf("unsupported format %q", opts.Format)
+	}
+
+	if err := s.userRepository.EachUser(ctx, write); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:  models.AuditExport,
+		Details: map[string]string{"format": opts.Format, "includeHashes": strconv.FormatBool(opts.IncludeHashes)},
+	})
+	return flush()
+}
+
+func (s *userBulkService) ExportLater(ctx context.Context, caller *models.User, opts models.ExportOptions) error {
+	if opts.Format != models.FormatCSV && opts.Format != models.FormatNDJSON {
+		return fmt.Errorf("unsupported format %q", opts.Format)
+	}
+	tenantId, ok := tenancy.TenantID(ctx)
+	if !ok {
+		return tenancy.ErrNoTenant
+	}
+	args := ExportUsers{TenantId: tenantId, RequestedBy: caller.Id, Format: opts.Format}
+	added, err := ExportUsersJob.Enqueue(ctx, s.queue, args, jobs.EnqueueOptions{
+		UniqueKey: fmt.Sprintf("%s:%d:%d", ExportUsersJob.Name, tenantId, caller.Id),
+	})
+	if err != nil {
+		return err
+	}
+	if !added {
+		return errors.New("export already in progress")
+	}
+	s.logger.InfoContext(ctx, "user export queued", "format", opts.Format)
+	return nil
+}
+
+func (s *userBulkService) RunExport(ctx context.Context, args ExportUsers) error {
+	ctx = tenancy.WithTenant(ctx, args.TenantId)
+	// The audit entry names the admin who asked, not the worker
+	ctx = logging.WithUserID(ctx, args.RequestedBy)
+	admin, err := s.userRepository.FindByID(ctx, args.RequestedBy)
+	if err != nil {
+		return err
+	}
+	if admin == nil {
+		return jobs.Permanent(errors.New("requesting user not found"))
+	}
+
+	token, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.%s", args.TenantId, token, args.Format)
+	// Written under a temporary name so a half-written file is never served
+	tmp, err := os.CreateTemp(s.exportDir, ".export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = s.Export(ctx, tmp, models.ExportOptions{Format: args.Format})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.exportDir, name)); err != nil {
+		return err
+	}
+	s.removeExpiredExports(ctx)
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      admin.Email,
+		Subject: "Your user export is ready",
+		Body: fmt.Sprintf("Hello %s,\n\nThe export you asked for is ready to download:\n\n%s/v1/admin/exports/%s\n\nThe link expires in %d days.\n",
+			admin.Name, s.appURL, name, int(exportTTL.Hours()/24)),
+	})
+}
+
+func (s *userBulkService) ExportFile(ctx context.Context, name string) (string, error) {
+	notFound := errors.New("export not found")
+	tenantId, ok := tenancy.TenantID(ctx)
+	if !ok {
+		return "", tenancy.ErrNoTenant
+	}
+	match := exportName.FindStringSubmatch(name)
+	if match == nil || match[1] != strconv.Itoa(tenantId) {
+		return "", notFound
+	}
+	path := filepath.Join(s.exportDir, name)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > exportTTL {
+		return "", notFound
+	}
+	return path, nil
+}
+
+// removeExpiredExports deletes exports whose links have run out, of every
+// tenant; any export job will do.
+func (s *userBulkService) removeExpiredExports(ctx context.Context) {
+	entries, err := os.ReadDir(s.exportDir)
+	if err != nil {
+		s.logger.WarnContext(ctx, "could not list exports", "error", err)
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !exportName.MatchString(entry.Name()) || time.Since(info.ModTime()) <= exportTTL {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.exportDir, entry.Name())); err != nil {
+			s.logger.WarnContext(ctx, "could not remove expired export", "file", entry.Name(), "error", err)
+		}
+	}
+}
+
+// exportUser mirrors ImportUser so an export can be imported elsewhere.
+type exportUser struct {
+	Id           int        `json:"id"`
+	Name         string     `json:"name"`
+	LastName     string     `json:"lastName"`
+	Email        string     `json:"email"`
+	Role         string     `json:"role"`
+	DisabledAt   *time.Time `json:"disabledAt,omitempty"`
+	PasswordHash string     `json:"passwordHash,omitempty"`
+}
+
+// rowParseError is a row that could not be decoded; the import carries on.
+type rowParseError struct {
+	msg string
+}
+
+func (r rowParseError) Error() string {
+	return r.msg
+}
+
+// importReader returns a function yielding one row per call and io.EOF at
+// the end of the input.
+func importReader(r io.Reader, format string) (func() (models.ImportUser, error), error) {
+	switch format {
+	case models.FormatCSV:
+		return csvImportReader(r)
+	case models.FormatNDJSON:
+		return ndjsonImportReader(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+func csvImportReader(r io.Reader) (func() (models.ImportUser, error), error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return func() (models.ImportUser, error) { return models.ImportUser{}, io.EOF }, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, h := range header {
+		key := strings.ToLower(strings.NewReplacer("_", "", " ", "").Replace(strings.TrimSpace(h)))
+		columns[key] = i
+	}
+	for _, required := range []string{"name", "lastname", "email"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %q column", required)
+		}
+	}
+
+	return func() (models.ImportUser, error) {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return models.ImportUser{}, io.EOF
+		}
+		if err != nil {
+			return models.ImportUser{}, rowParseError{msg: err.Error()}
+		}
+		if len(record) != len(header) {
+			return models.ImportUser{}, rowParseError{msg: fmt.Sprintf("expected %d fields, got %d", len(header), len(record))}
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return record[i]
+			}
+			return ""
+		}
+		return models.ImportUser{
+			Name:         field("name"),
+			LastName:     field("lastname"),
+			Email:        field("email"),
+			PasswordHash: field("passwordhash"),
+			Role:         field("role"),
+		}, nil
+	}, nil
+}
+
+func ndjsonImportReader(r io.Reader) func() (models.ImportUser, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	return func() (models.ImportUser, error) {
+		var line []byte
+		for len(line) == 0 {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return models.ImportUser{}, err
+				}
+				return models.ImportUser{}, io.EOF
+			}
+			line = bytes.TrimSpace(scanner.Bytes())
+		}
+
+		var user models.ImportUser
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&user); err != nil {
+			return models.ImportUser{}, rowParseError{msg: "invalid JSON: " + err.Error()}
+		}
+		return user, nil
+	}
+}
+
+// validateImportUser normalises u in place and lists what is wrong with it.
+// Limits follow the users table definition.
+func validateImportUser(u *models.ImportUser, invite bool) []string {
+	u.Name = strings.TrimSpace(u.Name)
+	u.LastName = strings.TrimSpace(u.LastName)
+	u.Email = strings.TrimSpace(u.Email)
+	u.PasswordHash = strings.TrimSpace(u.PasswordHash)
+	u.Role = strings.TrimSpace(u.Role)
+	if u.Role == "" {
+		u.Role = models.RoleUser
+	}
+
+	var problems []string
+	switch {
+	case u.Name == "":
+		problems = append(problems, "name is required")
+	case len(u.Name) > 20:
+		problems = append(problems, "name must be at most 20 characters")
+	}
+	switch {
+	case u.LastName == "":
+		problems = append(problems, "lastName is required")
+	case len(u.LastName) > 100:
+		problems = append(problems, "lastName must be at most 100 characters")
+	}
+	switch {
+	case u.Email == "":
+		problems = append(problems, "email is required")
+	case len(u.Email) > 100:
+		problems = append(problems, "email must be at most 100 characters")
+	default:
+		if addr, err := mail.ParseAddress(u.Email); err != nil || addr.Address != u.Email {
+			problems = append(problems, "email is not a valid address")
+		}
+	}
+	if u.Role != models.RoleUser && u.Role != models.RoleAdmin {
+		problems = append(problems, "role must be user or admin")
+	}
+	if u.PasswordHash != "" {
+		if !passwords.Recognized(u.PasswordHash) {
+			problems = append(problems, "passwordHash is not a bcrypt or argon2id hash")
+		}
+	} else if !invite {
+		problems = append(problems, "passwordHash is required unless inviting")
+	}
+	return problems
+}
+
+func importInsertError(err error) string {
+	var conflict *models.ConflictError
+	if errors.As(err, &conflict) {
+		return "conflicts with an existing user (" + conflict.Constraint + ")"
+	}
+	return "could not be inserted"
+}
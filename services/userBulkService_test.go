@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+
+	"server-go/jobs"
+	"server-go/logging"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// emailsRepo answers ExistingEmails from a fixed set; a dry run needs nothing else.
+type emailsRepo struct {
+	repositories.UserRepository
+	existing map[string]bool
+}
+
+func (r *emailsRepo) ExistingEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	found := map[string]bool{}
+	for _, e := range emails {
+		if e = strings.ToLower(e); r.existing[e] {
+			found[e] = true
+		}
+	}
+	return found, nil
+}
+
+func TestImportDryRun(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	repo := &emailsRepo{existing: map[string]bool{"taken@example.com": true}}
+	svc := NewUserBulkService(nil, repo, nil, nil, nil, nil, "", "http://localhost", logging.Discard())
+
+	t.Run("csv", func(t *testing.T) {
+		input := "name,last_name,email,password_hash,role\n" +
+			"John,Doe,john@example.com," + string(hash) + ",\n" +
+			"Jane,Doe,not-an-email,,superuser\n" +
+			"Jim,Doe,Taken@Example.com," + string(hash) + ",user\n" +
+			"Jill,Doe,JOHN@example.com," + string(hash) + ",admin\n" +
+			"too,few\n"
+
+		report, err := svc.Import(context.Background(), strings.NewReader(input), models.ImportOptions{Format: models.FormatCSV, BatchSize: 2})
+		assert.NoError(t, err)
+		assert.False(t, report.Committed)
+		assert.Equal(t, 5, report.Rows)
+		assert.Equal(t, 1, report.Valid)
+		assert.Equal(t, 0, report.Created)
+		assert.Equal(t, 4, report.Failed)
+
+		byRow := map[int]string{}
+		for _, e := range report.Errors {
+			byRow[e.Row] = strings.Join(e.Errors, "; ")
+		}
+		assert.Contains(t, byRow[2], "email is not a valid address")
+		assert.Contains(t, byRow[2], "role must be user or admin")
+		assert.Contains(t, byRow[2], "passwordHash is required")
+		assert.Contains(t, byRow[3], "already exists")
+		assert.Contains(t, byRow[4], "first seen on row 1")
+		assert.Contains(t, byRow[5], "expected 5 fields")
+	})
+
+	t.Run("ndjson with invites", func(t *testing.T) {
+		input := `{"name":"John","lastName":"Doe","email":"john@example.com"}` + "\n\n" +
+			`{"name":"Jane","lastName":"Doe","email":"jane@example.com","pasword":"typo"}` + "\n"
+
+		report, err := svc.Import(context.Background(), strings.NewReader(input), models.ImportOptions{Format: models.FormatNDJSON, Invite: true})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, report.Rows)
+		assert.Equal(t, 1, report.Valid)
+		assert.Len(t, report.Errors, 1)
+		assert.Contains(t, report.Errors[0].Errors[0], "invalid JSON")
+	})
+
+	t.Run("missing column", func(t *testing.T) {
+		_, err := svc.Import(context.Background(), strings.NewReader("name,email\n"), models.ImportOptions{Format: models.FormatCSV})
+		assert.Error(t, err)
+	})
+}
+
+func (r *memoryUsers) EachUser(ctx context.Context, fn func(models.User) error) error {
+	tenantId, _ := tenancy.TenantID(ctx)
+	for _, u := range r.users {
+		if u.TenantId == tenantId {
+			if err := fn(*u); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var exportLink = regexp.MustCompile(`/admin/exports/(\S+)`)
+
+func TestExportLater(t *testing.T) {
+	users := &memoryUsers{users: []*models.User{
+		{Id: 1, Name: "Jane", Email: "jane@example.com", Role: models.RoleAdmin, TenantId: 3},
+		{Id: 2, Name: "Jim", Email: "jim@example.com", Role: models.RoleUser, TenantId: 3},
+		{Id: 3, Name: "Other", Email: "other@example.com", TenantId: 4},
+	}}
+	runner := jobs.NewRunner(jobs.NewMemoryStore(), jobs.Options{MaxAttempts: 3}, logging.Discard())
+	mail := &outbox{}
+	dir := t.TempDir()
+	svc := NewUserBulkService(nil, users, nil, mail, discardAudit{}, runner, dir, "http://app", logging.Discard())
+	ExportUsersJob.Handle(runner, svc.RunExport)
+	ctx := tenancy.WithTenant(context.Background(), 3)
+	caller := &models.User{Id: 1, TenantId: 3}
+
+	assert.EqualError(t, svc.ExportLater(ctx, caller, models.ExportOptions{Format: "xml"}), `unsupported format "xml"`)
+	require.NoError(t, svc.ExportLater(ctx, caller, models.ExportOptions{Format: models.FormatCSV, IncludeHashes: true}))
+	assert.EqualError(t, svc.ExportLater(ctx, caller, models.ExportOptions{Format: models.FormatCSV}), "export already in progress")
+	assert.Empty(t, mail.sent, "nothing happens until a worker runs the job")
+
+	n, err := runner.Work(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	require.Len(t, mail.sent, 1)
+	assert.Equal(t, "jane@example.com", mail.sent[0].To)
+	match := exportLink.FindStringSubmatch(mail.sent[0].Body)
+	require.NotNil(t, match)
+
+	file, err := svc.ExportFile(ctx, match[1])
+	require.NoError(t, err)
+	assert.Equal(t, dir, path.Dir(file))
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "jim@example.com")
+	assert.NotContains(t, string(content), "other@example.com")
+	assert.NotContains(t, string(content), "passwordHash", "downloaded exports never carry hashes")
+
+	_, err = svc.ExportFile(tenancy.WithTenant(context.Background(), 4), match[1])
+	assert.EqualError(t, err, "export not found", "other organizations cannot download it")
+	_, err = svc.ExportFile(ctx, "../"+match[1])
+	assert.EqualError(t, err, "export not found")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left behind")
+}
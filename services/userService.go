@@ -3,71 +3,178 @@ package services
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"server-go/logging"
 	"server-go/models"
 	"server-go/repositories"
+	"server-go/tenancy"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
 
 type UserService interface {
-	Login(input models.LoginUser, w http.ResponseWriter) (string, error)
-	Register(input models.User) (string, error)
+	Login(ctx context.Context, input models.LoginUser, w http.ResponseWriter) (string, error)
+	Register(ctx context.Context, input models.User) (string, error)
+	// UpdateUser changes a user's profile. It refuses password and email
+	// changes, which AccountService makes.
 	UpdateUser(ctx context.Context, user *models.User) (*models.User, error)
-	Logout(w http.ResponseWriter) error
+	SetRole(ctx context.Context, id int, role string) (*models.User, error)
+	Logout(ctx context.Context, w http.ResponseWriter) error
+	ResetPassword(ctx context.Context, token string, password string) error
+
+	// Account administration, used by usersctl.
+	GetUser(ctx context.Context, id int) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	ListUsers(ctx context.Context, limit int, offset int) ([]models.User, error)
+	CreateUser(ctx context.Context, input models.User) (*models.User, error)
+	SetDisabled(ctx context.Context, id int, disabled bool) (*models.User, error)
+	SetStatus(ctx context.Context, id int, status string, update models.StatusUpdate) (*models.User, error)
+	StatusHistory(ctx context.Context, id int) ([]models.StatusChange, error)
+	// AccountStatus lets AuthMiddleware reject tokens of inactive users.
+	AccountStatus(ctx context.Context, id int) (string, error)
+	DeleteUser(ctx context.Context, id int) error
+	CreatePasswordResetToken(ctx context.Context, id int) (string, error)
+	SetTemporaryPassword(ctx context.Context, id int) (string, error)
+	RevokeSessions(ctx context.Context, id int) error
 }
 
 type userService struct {
-	userRepository repositories.UserRepository
+	tx              repositories.Transactor
+	userRepository  repositories.UserRepository
+	resetRepository repositories.PasswordResetRepository
+	memberships     repositories.MembershipRepository
+	invitations     repositories.InvitationRepository
+	sessions        SessionService
+	passwords       PasswordService
+	audit           AuditService
+	logger          *slog.Logger
 }
 
 // Login implements AuthService.
-func (s *userService) Login(input models.LoginUser, w http.ResponseWriter) (string, error) {
-	log.Printf("Login attempt for Email: %s", input.Email)
+func (s *userService) Login(ctx context.Context, input models.LoginUser, w http.ResponseWriter) (string, error) {
+	s.logger.DebugContext(ctx, "login attempt", "email", input.Email)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	user, err := s.userRepository.FindByEmail(ctx, input.Email)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Printf("User not found for Email: %s", input.Email)
+			s.logger.InfoContext(ctx, "login failed: user not found", "email", input.Email)
+			s.recordLoginFailure(ctx, nil, input.Email, "user not found")
 			return "", errors.New("user not found")
 		}
-		log.Printf("Database error while finding user: %v", err)
+		s.logger.ErrorContext(ctx, "database error while finding user", "error", err)
 		return "", fmt.Errorf("database error: %v", err)
 	}
 
 	if user == nil {
-		log.Printf("User is nil for Email: %s", input.Email)
+		s.logger.InfoContext(ctx, "login failed: user not found", "email", input.Email)
+		s.recordLoginFailure(ctx, nil, input.Email, "user not found")
 		return "", errors.New("user not found")
 	}
 
-	log.Printf("User found for Email: %s", input.Email)
+	// Service accounts authenticate with access tokens only
+	if user.ServiceAccount {
+		s.logger.InfoContext(ctx, "login failed: service account", "user", user.Id)
+		s.recordLoginFailure(ctx, &user.Id, input.Email, "service account")
+		return "", errors.New("invalid credentials")
+	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
-		log.Printf("Invalid credentials for Email: %s", input.Email)
+	match, rehash, err := s.passwords.Verify(user.Password, input.Password)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "stored password hash is unreadable", "user", user.Id, "error", err)
+	}
+	if !match {
+		s.logger.InfoContext(ctx, "login failed: invalid credentials", "user", user.Id)
+		s.recordLoginFailure(ctx, &user.Id, input.Email, "invalid credentials")
 		return "", errors.New("invalid credentials")
 	}
 
-	log.Printf("Password comparison successful for Email: %s", input.Email)
+	s.settleStatus(ctx, user)
+	if err := inactive(user); err != nil {
+		status := err.(*AccountInactiveError).Status
+		s.logger.InfoContext(ctx, "login failed: account not active", "user", user.Id, "status", status)
+		s.recordLoginFailure(ctx, &user.Id, input.Email, "account "+status)
+		return "", err
+	}
+
+	if rehash {
+		s.rehashPassword(ctx, user, input.Password)
+	}
 
-	token, err := generateJWT(user)
+	// An expired password still proves who the user is, so the answer
+	// carries a reset token to choose a new one with
+	if s.passwords.Expired(user) {
+		s.logger.InfoContext(ctx, "login refused: password expired", "user", user.Id)
+		s.recordLoginFailure(ctx, &user.Id, input.Email, "password expired")
+		resetToken, err := s.CreatePasswordResetToken(ctx, user.Id)
+		if err != nil {
+			return "", err
+		}
+		return "", &PasswordExpiredError{ResetToken: resetToken}
+	}
+
+	token, err := s.sessions.Start(ctx, user, input.DeviceName)
 	if err != nil {
-		log.Printf("Error generating JWT for Email: %s. Error: %v", input.Email, err)
-		return "", fmt.Errorf("error generating token: %v", err)
+		s.logger.ErrorContext(ctx, "error issuing session", "user", user.Id, "error", err)
+		return "", err
 	}
+	setSessionCookie(w, token)
 
-	log.Printf("JWT generated successfully for Email: %s", input.Email)
+	s.logger.InfoContext(ctx, "login successful", "user", user.Id)
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditLogin,
+		ActorId:  intPtr(user.Id),
+		TargetId: intPtr(user.Id),
+	})
+	return token, nil
+}
 
+// rehashPassword replaces a hash made with outdated settings while the
+// plain password is at hand. Failing to do so does not fail the login.
+func (s *userService) rehashPassword(ctx context.Context, user *models.User, password string) {
+	hash, err := s.passwords.Hash(password)
+	if err == nil {
+		err = s.userRepository.UpgradePasswordHash(ctx, user.Id, user.Password, hash)
+	}
+	if err != nil {
+		s.logger.WarnContext(ctx, "failed to upgrade password hash", "user", user.Id, "error", err)
+		return
+	}
+	s.logger.InfoContext(ctx, "password hash upgraded", "user", user.Id)
+}
+
+// PasswordExpiredError is returned by Login when the credentials are right
+// but the password is past the policy's maximum age.
+type PasswordExpiredError struct {
+	// ResetToken sets a new password through ResetPassword.
+	ResetToken string
+}
+
+func (e *PasswordExpiredError) Error() string {
+	return "password expired"
+}
+
+func (s *userService) recordLoginFailure(ctx context.Context, userId *int, email string, reason string) {
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditLoginFailed,
+		TargetId: userId,
+		Details:  map[string]string{"email": email, "reason": reason},
+	})
+}
+
+func setSessionCookie(w http.ResponseWriter, token string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_token",
 		Value:    token,
@@ -77,92 +184,247 @@ func (s *userService) Login(input models.LoginUser, w http.ResponseWriter) (stri
 		Path:     "/",
 		SameSite: http.SameSiteLaxMode,
 	})
-
-	log.Printf("Login successful for Email: %s", input.Email)
-	return token, nil
 }
 
-func generateJWT(user *models.User) (string, error) {
+// generateJWT issues a session token. tenant_id is the user's home
+// organization; org_id and org_role name the active one. auth_time is
+// user.AuthTime, which only SessionService.Start moves forward, and
+// token_version is the user's at the time.
+func generateJWT(user *models.User) (token string, jti string, expiresAt time.Time, err error) {
+	orgId := user.OrgId
+	if orgId == 0 {
+		orgId = user.TenantId
+	}
+	// jti lets a single token be revoked
+	if jti, err = randomToken(16); err != nil {
+		return "", "", time.Time{}, err
+	}
+
 	now := time.Now()
+	expiresAt = now.Add(time.Hour * 24)
+	// iat has microseconds, like tokens_revoked_at, so that revoking a
+	// user's tokens also stops those issued earlier in the same second
 	claims := jwt.MapClaims{
 		"iss":           "server-go",
 		"sub":           user.Id,
-		"iat":           now.Unix(),
-		"exp":           now.Add(time.Hour * 24).Unix(),
+		"iat":           float64(now.UnixMicro()) / 1e6,
+		"exp":           expiresAt.Unix(),
 		"nbf":           now.Unix(),
+		"auth_time":     user.AuthTime.Unix(),
+		"jti":           jti,
 		"user_id":       user.Id,
 		"user_Name":     user.Name,
 		"user_LastName": user.LastName,
 		"user_Email":    user.Email,
+		"user_Role":     user.Role,
+		"tenant_id":     user.TenantId,
+		"org_id":        orgId,
+		"org_role":      user.OrgRole,
+		"token_version": user.TokenVersion,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
-	return token.SignedString(jwtSecret)
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString(jwtSecret)
+	return token, jti, expiresAt, err
 }
 
-func (s *userService) Register(input models.User) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (s *userService) Register(ctx context.Context, input models.User) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	// Check if user already exists
-	existingUser, err := s.userRepository.FindByEmail(ctx, input.Email)
-	if err != nil {
-		return "", err
+	// An invitation decides the organization the account is created in
+	var invitation *models.Invitation
+	if input.InviteToken != "" {
+		var err error
+		if invitation, err = pendingInvitation(ctx, s.invitations, input.InviteToken, input.Email); err != nil {
+			return "", err
+		}
+		ctx = tenancy.WithTenant(ctx, invitation.OrganizationId)
 	}
-	if existingUser != nil {
-		return "", errors.New("user already exists")
+
+	applicant := &models.User{Name: input.Name, LastName: input.LastName, Email: input.Email}
+	if err := s.passwords.Validate(ctx, applicant, input.Password); err != nil {
+		return "", err
 	}
 
 	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	hashedPassword, err := s.passwords.Hash(input.Password)
 	if err != nil {
 		return "", errors.New("failed to hash password")
 	}
-	input.Password = string(hashedPassword)
+	input.Password = hashedPassword
 
-	// Register the user
-	registeredUser, err := s.userRepository.RegisterUser(ctx, input.Name, input.LastName, input.Email, input.Password)
+	// The existence check, the insert and the invitation commit together;
+	// the unique index catches a concurrent registration with the same email
+	var registeredUser *models.User
+	err = s.tx.WithTx(ctx, func(repos repositories.Repositories) error {
+		existingUser, err := repos.Users.FindByEmail(ctx, input.Email)
+		if err != nil {
+			return err
+		}
+		if existingUser != nil {
+			return errUserExists
+		}
+		if registeredUser, err = repos.Users.RegisterUser(ctx, input.Name, input.LastName, input.Email, input.Password); err != nil {
+			return err
+		}
+		if invitation != nil {
+			if err := acceptOnRegister(ctx, repos, invitation, registeredUser); err != nil {
+				return err
+			}
+		}
+		return recordUserEvent(ctx, repos, models.EventUserRegistered, registeredUser)
+	})
+	var conflict *models.ConflictError
+	if errors.Is(err, errUserExists) || errors.As(err, &conflict) {
+		s.logger.InfoContext(ctx, "registration rejected: user already exists", "email", input.Email)
+		return "", errUserExists
+	}
 	if err != nil {
 		return "", err
 	}
+	if err := s.passwords.Remember(ctx, registeredUser.Id, registeredUser.Password); err != nil {
+		return "", err
+	}
+
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditRegister,
+		ActorId:  intPtr(registeredUser.Id),
+		TargetId: intPtr(registeredUser.Id),
+		Changes:  diffUsers(nil, registeredUser),
+	})
+	if invitation != nil {
+		s.audit.Record(ctx, models.AuditEvent{
+			Action:   models.AuditInviteAccepted,
+			ActorId:  intPtr(registeredUser.Id),
+			TargetId: intPtr(registeredUser.Id),
+			Details:  map[string]string{"invitationId": strconv.Itoa(invitation.Id), "role": invitation.Role},
+		})
+	}
 
-	// Generate JWT token (assuming you have a function to do this)
-	token, err := generateJWT(registeredUser)
+	return s.sessions.Start(ctx, registeredUser, "")
+}
+
+var errUserExists = errors.New("user already exists")
+
+// recordUserEvent adds a user event to the outbox of the unit of work, so
+// it is published only if the change commits.
+func recordUserEvent(ctx context.Context, repos repositories.Repositories, eventType string, user *models.User) error {
+	payload, err := json.Marshal(models.NewUserSnapshot(user))
 	if err != nil {
-		return "", err
+		return err
 	}
+	return repos.Outbox.Append(ctx, &models.Event{UserId: user.Id, Type: eventType, Payload: payload})
+}
 
-	return token, nil
+func acceptOnRegister(ctx context.Context, repos repositories.Repositories, invitation *models.Invitation, user *models.User) error {
+	accepted, err := repos.Invitations.MarkAccepted(ctx, invitation.Id, user.Id)
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		return errors.New("invalid or expired invitation")
+	}
+	if _, err := repos.Memberships.SetRole(ctx, user.Id, invitation.OrganizationId, invitation.Role); err != nil {
+		return err
+	}
+	user.OrgRole = invitation.Role
+	return nil
 }
 
 // update implements AuthService
 func (s *userService) UpdateUser(ctx context.Context, user *models.User) (*models.User, error) {
-	// Hash the password before updating the user
+	before, err := s.userRepository.FindByID(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	if before == nil {
+		return nil, errors.New("user not found")
+	}
+
+	// Roles only change through SetRole. Password and email changes need
+	// the current password or a confirmed address, so they go through
+	// AccountService; an empty email keeps the current one
 	if user.Password != "" {
-		hashPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-		if err != nil {
-			return nil, errors.New("failed to hash password")
+		return nil, errors.New("password cannot be changed here")
+	}
+	if user.Email != "" && !strings.EqualFold(user.Email, before.Email) {
+		return nil, errors.New("email cannot be changed here")
+	}
+	user.Email = before.Email
+	user.Password = before.Password
+	user.Role = before.Role
+
+	// The change and its event commit together
+	var updatedUser *models.User
+	err = s.tx.WithTx(ctx, func(repos repositories.Repositories) error {
+		updated, err := repos.Users.UpdateUser(ctx, user)
+		if err != nil || updated == nil {
+			return err
 		}
-		user.Password = string(hashPassword)
+		// Fetch the updated user from the repository to ensure we have the latest data
+		if updatedUser, err = repos.Users.FindByID(ctx, user.Id); err != nil {
+			return err
+		}
+		return recordUserEvent(ctx, repos, models.EventUserUpdated, updatedUser)
+	})
+	var conflict *models.ConflictError
+	if errors.As(err, &conflict) {
+		return nil, errors.New("email already in use")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if updatedUser == nil {
+		return nil, errors.New("user not found")
+	}
+
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditUpdate,
+		TargetId: intPtr(updatedUser.Id),
+		Changes:  diffUsers(before, updatedUser),
+	})
+
+	return updatedUser, nil
+}
+
+// SetRole changes a user's role and records it in the audit trail.
+func (s *userService) SetRole(ctx context.Context, id int, role string) (*models.User, error) {
+	if role != models.RoleUser && role != models.RoleAdmin {
+		return nil, errors.New("invalid role")
 	}
 
-	// Update the user in the repository
-	updatedUser, err := s.userRepository.UpdateUser(ctx, user)
+	before, err := s.userRepository.FindByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
+	if before == nil {
+		return nil, errors.New("user not found")
+	}
 
-	// Fetch the updated user from the repository to ensure we have the latest data
-	updatedUser, err = s.userRepository.FindByID(ctx, user.Id)
+	var updatedUser *models.User
+	err = s.tx.WithTx(ctx, func(repos repositories.Repositories) error {
+		if updatedUser, err = repos.Users.SetRole(ctx, id, role); err != nil || updatedUser == nil {
+			return err
+		}
+		return recordUserEvent(ctx, repos, models.EventUserUpdated, updatedUser)
+	})
 	if err != nil {
 		return nil, err
 	}
+	if updatedUser == nil {
+		return nil, errors.New("user not found")
+	}
 
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditRoleChange,
+		TargetId: intPtr(id),
+		Changes:  diffUsers(before, updatedUser),
+	})
 	return updatedUser, nil
 }
 
 // Logout implements AuthService.
-func (s *userService) Logout(w http.ResponseWriter) error {
+func (s *userService) Logout(ctx context.Context, w http.ResponseWriter) error {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_token",
 		Value:    "",
@@ -173,10 +435,13 @@ func (s *userService) Logout(w http.ResponseWriter) error {
 		SameSite: http.SameSiteLaxMode,
 	})
 
+	if id, ok := logging.UserID(ctx); ok {
+		s.audit.Record(ctx, models.AuditEvent{Action: models.AuditLogout, TargetId: intPtr(id)})
+	}
 	return nil
 }
 
 // use the repo to generate the service
-func NewUserService(userRepo repositories.UserRepository) UserService {
-	return &userService{userRepository: userRepo}
+func NewUserService(tx repositories.Transactor, userRepo repositories.UserRepository, resetRepo repositories.PasswordResetRepository, membershipRepo repositories.MembershipRepository, invitationRepo repositories.InvitationRepository, sessions SessionService, passwords PasswordService, audit AuditService, logger *slog.Logger) UserService {
+	return &userService{tx: tx, userRepository: userRepo, resetRepository: resetRepo, memberships: membershipRepo, invitations: invitationRepo, sessions: sessions, passwords: passwords, audit: audit, logger: logger}
 }
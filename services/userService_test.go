@@ -0,0 +1,383 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/passwords"
+	"server-go/repositories"
+	"server-go/tenancy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (r *memoryUsers) UpgradePasswordHash(ctx context.Context, id int, oldHash string, newHash string) error {
+	for _, u := range r.users {
+		if u.Id == id && u.Password == oldHash {
+			u.Password = newHash
+		}
+	}
+	return nil
+}
+
+func (r *memoryUsers) SetPassword(ctx context.Context, id int, password string) error {
+	for _, u := range r.users {
+		if u.Id == id {
+			u.Password, u.PasswordChangedAt = password, time.Now()
+			u.TokenVersion++
+		}
+	}
+	return nil
+}
+
+func (r *memoryUsers) UpdateUser(ctx context.Context, user *models.User) (*models.User, error) {
+	for _, u := range r.users {
+		if u.Id == user.Id {
+			if u.Password != user.Password {
+				u.PasswordChangedAt = time.Now()
+			}
+			u.Name, u.LastName, u.Email, u.Password = user.Name, user.LastName, user.Email, user.Password
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryUsers) RevokeTokens(ctx context.Context, id int) error { return nil }
+
+func (r *memoryUsers) DeleteUser(ctx context.Context, id int) (bool, error) {
+	for i, u := range r.users {
+		if u.Id == id {
+			r.users = append(r.users[:i], r.users[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type memoryOutbox struct {
+	repositories.OutboxRepository
+	events []models.Event
+}
+
+func (o *memoryOutbox) Append(ctx context.Context, event *models.Event) error {
+	event.Id = int64(len(o.events) + 1)
+	event.TenantId, _ = tenancy.TenantID(ctx)
+	o.events = append(o.events, *event)
+	return nil
+}
+
+type memoryHistory struct {
+	hashes map[int][]string
+}
+
+func (r *memoryHistory) Add(ctx context.Context, userId int, hash string, keep int) error {
+	r.hashes[userId] = append([]string{hash}, r.hashes[userId]...)
+	if len(r.hashes[userId]) > keep {
+		r.hashes[userId] = r.hashes[userId][:keep]
+	}
+	return nil
+}
+
+func (r *memoryHistory) Recent(ctx context.Context, userId int, limit int) ([]string, error) {
+	return r.hashes[userId], nil
+}
+
+type memoryResets struct {
+	repositories.PasswordResetRepository
+	tokens map[string]int
+}
+
+func (r *memoryResets) Create(ctx context.Context, userId int, tokenHash string, expiresAt time.Time) error {
+	r.tokens[tokenHash] = userId
+	return nil
+}
+
+func (r *memoryResets) Find(ctx context.Context, tokenHash string) (int, bool, error) {
+	id, ok := r.tokens[tokenHash]
+	return id, ok, nil
+}
+
+func (r *memoryResets) Consume(ctx context.Context, tokenHash string) (int, bool, error) {
+	id, ok := r.tokens[tokenHash]
+	delete(r.tokens, tokenHash)
+	return id, ok, nil
+}
+
+// fastHasher keeps the tests quick; the parameters are not for production.
+func fastHasher(t *testing.T) passwords.Hasher {
+	hasher, err := passwords.New(passwords.Config{
+		Algorithm: passwords.Argon2id,
+		Argon2:    passwords.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+	})
+	require.NoError(t, err)
+	return hasher
+}
+
+// inlineTx runs units of work straight against the in-memory repositories.
+type inlineTx struct {
+	repos repositories.Repositories
+}
+
+func (t inlineTx) WithTx(ctx context.Context, fn func(repos repositories.Repositories) error) error {
+	return fn(t.repos)
+}
+
+func newTestUserService(t *testing.T, users repositories.UserRepository, resets repositories.PasswordResetRepository, policy passwords.Policy, history repositories.PasswordHistoryRepository) UserService {
+	sessions := NewSessionService(&memorySessions{}, users, &membershipsRepo{roles: map[int]string{}}, 0, discardAudit{}, logging.Discard())
+	pw := NewPasswordService(fastHasher(t), policy, history, logging.Discard())
+	tx := inlineTx{repositories.Repositories{Users: users, Outbox: &memoryOutbox{}}}
+	return NewUserService(tx, users, resets, nil, nil, sessions, pw, discardAudit{}, logging.Discard())
+}
+
+func TestLoginRehashesOutdatedPassword(t *testing.T) {
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	users := &memoryUsers{users: []*models.User{{Id: 1, Email: "jane@example.com", Password: string(legacy), TenantId: 3}}}
+	svc := newTestUserService(t, users, nil, passwords.Policy{}, nil)
+
+	_, err := svc.Login(context.Background(), models.LoginUser{Email: "jane@example.com", Password: "wrong"}, httptest.NewRecorder())
+	assert.EqualError(t, err, "invalid credentials")
+	assert.Equal(t, string(legacy), users.users[0].Password, "a failed login changes nothing")
+
+	_, err = svc.Login(context.Background(), models.LoginUser{Email: "jane@example.com", Password: "correct horse"}, httptest.NewRecorder())
+	require.NoError(t, err)
+	upgraded := users.users[0].Password
+	assert.True(t, strings.HasPrefix(upgraded, "$argon2id$"), upgraded)
+
+	_, err = svc.Login(context.Background(), models.LoginUser{Email: "jane@example.com", Password: "correct horse"}, httptest.NewRecorder())
+	require.NoError(t, err)
+	assert.Equal(t, upgraded, users.users[0].Password, "current hashes are kept")
+}
+
+func fieldCodes(t *testing.T, err error) []string {
+	var invalid *models.ValidationError
+	require.True(t, errors.As(err, &invalid), "%v", err)
+	var codes []string
+	for _, f := range invalid.Errors {
+		assert.Equal(t, "password", f.Field)
+		codes = append(codes, f.Code)
+	}
+	return codes
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	users := &memoryUsers{}
+	resets := &memoryResets{tokens: map[string]int{}}
+	history := &memoryHistory{hashes: map[int][]string{}}
+	policy := passwords.Policy{MinLength: 10, MinClasses: 2, RejectPersonalInfo: true, History: 2}
+	svc := newTestUserService(t, users, resets, policy, history)
+	ctx := tenancy.WithTenant(context.Background(), 3)
+	jane := models.User{Name: "Jane", LastName: "Doe", Email: "jane@example.com"}
+
+	t.Run("register", func(t *testing.T) {
+		input := jane
+		input.Password = "a"
+		_, err := svc.Register(ctx, input)
+		assert.Equal(t, []string{"too_short", "character_classes"}, fieldCodes(t, err))
+
+		input.Password = "Jane-is-great"
+		_, err = svc.Register(ctx, input)
+		assert.Equal(t, []string{"personal_info"}, fieldCodes(t, err))
+
+		input.Password = "correct-horse-1"
+		_, err = svc.Register(ctx, input)
+		require.NoError(t, err)
+		assert.Len(t, history.hashes[1], 1)
+	})
+
+	t.Run("reset cannot reuse a recent password", func(t *testing.T) {
+		token, err := svc.CreatePasswordResetToken(ctx, 1)
+		require.NoError(t, err)
+
+		err = svc.ResetPassword(ctx, token, "correct-horse-1")
+		assert.Equal(t, []string{"reused"}, fieldCodes(t, err))
+
+		require.NoError(t, svc.ResetPassword(ctx, token, "battery-staple-2"), "the token survives a rejected password")
+		assert.Len(t, history.hashes[1], 2)
+	})
+
+	t.Run("only the last passwords are kept", func(t *testing.T) {
+		for _, password := range []string{"tr0ub4dor-and-3", "correct-horse-1"} {
+			token, err := svc.CreatePasswordResetToken(ctx, 1)
+			require.NoError(t, err)
+			require.NoError(t, svc.ResetPassword(ctx, token, password), "correct-horse-1 is no longer among the last two")
+		}
+	})
+
+	t.Run("update leaves passwords and emails alone", func(t *testing.T) {
+		_, err := svc.UpdateUser(ctx, &models.User{Id: 1, Name: "Jane", LastName: "Doe", Password: "another-one-4"})
+		assert.EqualError(t, err, "password cannot be changed here")
+		_, err = svc.UpdateUser(ctx, &models.User{Id: 1, Name: "Jane", LastName: "Doe", Email: "evil@example.com"})
+		assert.EqualError(t, err, "email cannot be changed here")
+
+		updated, err := svc.UpdateUser(ctx, &models.User{Id: 1, Name: "Janet", LastName: "Doe"})
+		require.NoError(t, err)
+		assert.Equal(t, "jane@example.com", updated.Email)
+
+		updated, err = svc.UpdateUser(ctx, &models.User{Id: 1, Name: "Janet", LastName: "Doe", Email: "Jane@Example.com"})
+		require.NoError(t, err, "the same address in another case is no change")
+		assert.Equal(t, "jane@example.com", updated.Email)
+	})
+}
+
+func TestLoginWithExpiredPassword(t *testing.T) {
+	hash, _ := fastHasher(t).Hash("correct horse")
+	users := &memoryUsers{users: []*models.User{{Id: 1, Email: "jane@example.com", Password: hash, TenantId: 3, PasswordChangedAt: time.Now().Add(-100 * 24 * time.Hour)}}}
+	resets := &memoryResets{tokens: map[string]int{}}
+	svc := newTestUserService(t, users, resets, passwords.Policy{MaxAge: 90 * 24 * time.Hour}, nil)
+	ctx := tenancy.WithTenant(context.Background(), 3)
+
+	_, err := svc.Login(ctx, models.LoginUser{Email: "jane@example.com", Password: "correct horse"}, httptest.NewRecorder())
+	var expired *PasswordExpiredError
+	require.True(t, errors.As(err, &expired), "%v", err)
+
+	require.NoError(t, svc.ResetPassword(ctx, expired.ResetToken, "battery staple"))
+	_, err = svc.Login(ctx, models.LoginUser{Email: "jane@example.com", Password: "battery staple"}, httptest.NewRecorder())
+	assert.NoError(t, err)
+}
+
+// racingUsers loses the race for every email: the existence check passes
+// and the unique index rejects the insert.
+type racingUsers struct {
+	*memoryUsers
+}
+
+func (r racingUsers) RegisterUser(ctx context.Context, name, lastName, email, password string) (*models.User, error) {
+	return nil, &models.ConflictError{Constraint: "idx_users_tenant_email_lower"}
+}
+
+func TestRegisterConflict(t *testing.T) {
+	users := racingUsers{&memoryUsers{}}
+	svc := newTestUserService(t, users, nil, passwords.Policy{}, nil)
+	ctx := tenancy.WithTenant(context.Background(), 3)
+
+	_, err := svc.Register(ctx, models.User{Name: "Jane", Email: "jane@example.com", Password: "correct horse"})
+	assert.EqualError(t, err, "user already exists")
+
+	_, err = svc.CreateUser(ctx, models.User{Name: "Jane", Email: "jane@example.com", Password: "correct horse"})
+	assert.EqualError(t, err, "user already exists")
+}
+
+func TestUserEvents(t *testing.T) {
+	users := &memoryUsers{}
+	outbox := &memoryOutbox{}
+	sessions := NewSessionService(&memorySessions{}, users, &membershipsRepo{roles: map[int]string{}}, 0, discardAudit{}, logging.Discard())
+	pw := NewPasswordService(fastHasher(t), passwords.Policy{}, nil, logging.Discard())
+	svc := NewUserService(inlineTx{repositories.Repositories{Users: users, Outbox: outbox}}, users, nil, nil, nil, sessions, pw, discardAudit{}, logging.Discard())
+	ctx := tenancy.WithTenant(context.Background(), 3)
+
+	_, err := svc.Register(ctx, models.User{Name: "Jane", Email: "jane@example.com", Password: "correct horse"})
+	require.NoError(t, err)
+	_, err = svc.UpdateUser(ctx, &models.User{Id: 1, Name: "Janet", Email: "jane@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, svc.DeleteUser(ctx, 1))
+	assert.EqualError(t, svc.DeleteUser(ctx, 1), "user not found")
+
+	require.Len(t, outbox.events, 3)
+	var types []string
+	for _, e := range outbox.events {
+		types = append(types, e.Type)
+		assert.Equal(t, 1, e.UserId)
+		assert.Equal(t, 3, e.TenantId)
+		assert.NotContains(t, string(e.Payload), "password", "no credentials in events")
+	}
+	assert.Equal(t, []string{models.EventUserRegistered, models.EventUserUpdated, models.EventUserDeleted}, types)
+
+	var updated models.UserSnapshot
+	require.NoError(t, json.Unmarshal(outbox.events[1].Payload, &updated))
+	assert.Equal(t, "Janet", updated.Name)
+	assert.Equal(t, models.StatusActive, updated.Status)
+}
+
+// recordingAudit keeps the events as the services pass them, before the
+// request's actor, IP and user agent are filled in.
+type recordingAudit struct {
+	AuditService
+	events []models.AuditEvent
+}
+
+func (a *recordingAudit) Record(ctx context.Context, event models.AuditEvent) {
+	a.events = append(a.events, event)
+}
+
+func TestLoginAndRegistrationAreAudited(t *testing.T) {
+	users := &memoryUsers{}
+	audit := &recordingAudit{}
+	sessions := NewSessionService(&memorySessions{}, users, &membershipsRepo{roles: map[int]string{}}, 0, discardAudit{}, logging.Discard())
+	pw := NewPasswordService(fastHasher(t), passwords.Policy{}, nil, logging.Discard())
+	tx := inlineTx{repositories.Repositories{Users: users, Outbox: &memoryOutbox{}}}
+	svc := NewUserService(tx, users, nil, nil, nil, sessions, pw, audit, logging.Discard())
+	ctx := tenancy.WithTenant(context.Background(), 3)
+
+	_, err := svc.Register(ctx, models.User{Name: "Jane", Email: "jane@example.com", Password: "correct horse"})
+	require.NoError(t, err)
+	_, err = svc.Login(ctx, models.LoginUser{Email: "jane@example.com", Password: "wrong"}, httptest.NewRecorder())
+	require.Error(t, err)
+	_, err = svc.Login(ctx, models.LoginUser{Email: "nobody@example.com", Password: "wrong"}, httptest.NewRecorder())
+	require.Error(t, err)
+	_, err = svc.Login(ctx, models.LoginUser{Email: "jane@example.com", Password: "correct horse"}, httptest.NewRecorder())
+	require.NoError(t, err)
+
+	require.Len(t, audit.events, 4)
+	registered, wrongPassword, unknown, login := audit.events[0], audit.events[1], audit.events[2], audit.events[3]
+
+	assert.Equal(t, models.AuditRegister, registered.Action)
+	assert.Equal(t, intPtr(1), registered.TargetId)
+	assert.Equal(t, "jane@example.com", registered.Changes["email"].New)
+	assert.Equal(t, "[redacted]", registered.Changes["password"].New, "no password hash in the trail")
+
+	assert.Equal(t, models.AuditLoginFailed, wrongPassword.Action)
+	assert.Equal(t, intPtr(1), wrongPassword.TargetId)
+	assert.Equal(t, map[string]string{"email": "jane@example.com", "reason": "invalid credentials"}, wrongPassword.Details)
+
+	assert.Equal(t, models.AuditLoginFailed, unknown.Action)
+	assert.Nil(t, unknown.TargetId, "there is no user to point at")
+	assert.Equal(t, "user not found", unknown.Details["reason"])
+
+	assert.Equal(t, models.AuditLogin, login.Action)
+	assert.Equal(t, intPtr(1), login.ActorId)
+	assert.Equal(t, intPtr(1), login.TargetId)
+}
+
+// tenantResets only finds tokens in the organization they were created in.
+type tenantResets struct {
+	memoryResets
+	tenants map[string]int
+}
+
+func (r *tenantResets) Create(ctx context.Context, userId int, tokenHash string, expiresAt time.Time) error {
+	r.tenants[tokenHash], _ = tenancy.TenantID(ctx)
+	return r.memoryResets.Create(ctx, userId, tokenHash, expiresAt)
+}
+
+func (r *tenantResets) Find(ctx context.Context, tokenHash string) (int, bool, error) {
+	if tenant, _ := tenancy.TenantID(ctx); tenant != r.tenants[tokenHash] {
+		return 0, false, nil
+	}
+	return r.memoryResets.Find(ctx, tokenHash)
+}
+
+func TestResetTokenNamesItsOrganization(t *testing.T) {
+	users := &memoryUsers{users: []*models.User{{Id: 1, Name: "Jane", Email: "jane@example.com", TenantId: 3}}}
+	resets := &tenantResets{memoryResets{tokens: map[string]int{}}, map[string]int{}}
+	svc := newTestUserService(t, users, resets, passwords.Policy{}, nil)
+
+	token, err := svc.CreatePasswordResetToken(tenancy.WithTenant(context.Background(), 3), 1)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, "3."), token)
+
+	// The link may be opened on any host, which resolves another organization or none
+	require.NoError(t, svc.ResetPassword(tenancy.WithTenant(context.Background(), 1), token, "correct horse"))
+	assert.NotEmpty(t, users.users[0].Password)
+
+	assert.EqualError(t, svc.ResetPassword(context.Background(), "legacy-token", "correct horse"), "invalid or expired token")
+}
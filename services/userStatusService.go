@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"server-go/logging"
+	"server-go/models"
+	"server-go/repositories"
+	"time"
+)
+
+// AccountInactiveError is returned when a user whose account is not active
+// tries to sign in. Its message is "account disabled" whatever the status,
+// so callers that only tell active and inactive accounts apart keep working.
+type AccountInactiveError struct {
+	Status string
+	// Until is when a suspension or lock ends on its own, if it does.
+	Until *time.Time
+}
+
+func (e *AccountInactiveError) Error() string {
+	return "account disabled"
+}
+
+// inactive returns an *AccountInactiveError for users that may not sign
+// in, and nil for active ones.
+func inactive(user *models.User) error {
+	status := user.CurrentStatus(time.Now())
+	if status == models.StatusActive {
+		return nil
+	}
+	err := &AccountInactiveError{Status: status}
+	if status == models.StatusSuspended || status == models.StatusLocked {
+		err.Until = user.StatusUntil
+	}
+	return err
+}
+
+// SetStatus moves the account to status if the lifecycle allows it. Leaving
+// active signs the user out everywhere.
+func (s *userService) SetStatus(ctx context.Context, id int, status string, update models.StatusUpdate) (*models.User, error) {
+	if !models.ValidStatus(status) {
+		return nil, errors.New("invalid status")
+	}
+	if actor, ok := logging.UserID(ctx); ok && actor == id {
+		return nil, errors.New("cannot change own status")
+	}
+	user, err := s.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	current := user.CurrentStatus(now)
+	if current == status {
+		return user, nil
+	}
+	if !models.CanTransition(current, status) {
+		return nil, errors.New("invalid status transition")
+	}
+	// Only suspensions and locks run out
+	if update.Until != nil && ((status != models.StatusSuspended && status != models.StatusLocked) || !update.Until.After(now)) {
+		return nil, errors.New("invalid status expiry")
+	}
+
+	return s.changeStatus(ctx, user, models.StatusChange{From: current, To: status, Reason: update.Reason, Until: update.Until})
+}
+
+func (s *userService) changeStatus(ctx context.Context, user *models.User, change models.StatusChange) (*models.User, error) {
+	change.UserId = user.Id
+	if actor, ok := logging.UserID(ctx); ok {
+		change.ActorId = &actor
+	}
+	stored := user.Status
+	if stored == "" {
+		stored = models.StatusActive
+	}
+	var updated *models.User
+	err := s.tx.WithTx(ctx, func(repos repositories.Repositories) error {
+		var err error
+		if updated, err = repos.Users.SetStatus(ctx, user.Id, stored, change); err != nil || updated == nil {
+			return err
+		}
+		if change.To != models.StatusActive {
+			if err := repos.Users.RevokeTokens(ctx, user.Id); err != nil {
+				return err
+			}
+		}
+		return recordUserEvent(ctx, repos, models.EventUserUpdated, updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if updated == nil {
+		// Deleted, or changed by someone else since we looked
+		return nil, errors.New("user not found")
+	}
+
+	action := models.AuditStatusChange
+	switch {
+	case change.To == models.StatusDeactivated:
+		action = models.AuditDisable
+	case change.From == models.StatusDeactivated:
+		action = models.AuditEnable
+	}
+	details := map[string]string{"from": change.From, "to": change.To}
+	if change.Reason != "" {
+		details["reason"] = change.Reason
+	}
+	if change.Until != nil {
+		details["until"] = change.Until.UTC().Format(time.RFC3339)
+	}
+	s.logger.InfoContext(ctx, "account status changed", "user", user.Id, "from", change.From, "to", change.To)
+	s.audit.Record(ctx, models.AuditEvent{Action: action, ActorId: change.ActorId, TargetId: intPtr(user.Id), Details: details})
+	return updated, nil
+}
+
+// StatusHistory lists the account's status changes, newest first.
+func (s *userService) StatusHistory(ctx context.Context, id int) ([]models.StatusChange, error) {
+	if _, err := s.GetUser(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.userRepository.StatusHistory(ctx, id)
+}
+
+func (s *userService) AccountStatus(ctx context.Context, id int) (string, error) {
+	return s.userRepository.Status(ctx, id)
+}
+
+// SetDisabled deactivates or reactivates the account.
+func (s *userService) SetDisabled(ctx context.Context, id int, disabled bool) (*models.User, error) {
+	status := models.StatusActive
+	if disabled {
+		status = models.StatusDeactivated
+	}
+	return s.SetStatus(ctx, id, status, models.StatusUpdate{})
+}
+
+// settleStatus records the end of a suspension or lock that has run out, so
+// the history shows the account becoming active again.
+func (s *userService) settleStatus(ctx context.Context, user *models.User) {
+	stored := user.Status
+	if (stored != models.StatusSuspended && stored != models.StatusLocked) || user.CurrentStatus(time.Now()) != models.StatusActive {
+		return
+	}
+	updated, err := s.changeStatus(ctx, user, models.StatusChange{From: stored, To: models.StatusActive, Reason: "expired"})
+	if err != nil {
+		s.logger.WarnContext(ctx, "failed to record expired status", "user", user.Id, "error", err)
+		return
+	}
+	*user = *updated
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/passwords"
+	"server-go/repositories"
+	"server-go/tenancy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// statusUsers keeps a status history next to memoryUsers.
+type statusUsers struct {
+	*memoryUsers
+	history []models.StatusChange
+}
+
+func (r *statusUsers) SetStatus(ctx context.Context, id int, current string, change models.StatusChange) (*models.User, error) {
+	for _, u := range r.users {
+		stored := u.Status
+		if stored == "" {
+			stored = models.StatusActive
+		}
+		if u.Id == id && stored == current {
+			u.Status, u.StatusReason, u.StatusUntil = change.To, change.Reason, change.Until
+			change.Id = len(r.history) + 1
+			r.history = append([]models.StatusChange{change}, r.history...)
+			found := *u
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *statusUsers) StatusHistory(ctx context.Context, id int) ([]models.StatusChange, error) {
+	return r.history, nil
+}
+
+func TestAccountStatus(t *testing.T) {
+	hash, _ := fastHasher(t).Hash("correct horse")
+	users := &statusUsers{memoryUsers: &memoryUsers{users: []*models.User{
+		{Id: 1, Email: "jane@example.com", Password: hash, TenantId: 3},
+		{Id: 2, Email: "admin@example.com", TenantId: 3, Role: models.RoleAdmin},
+	}}}
+	sessions := NewSessionService(&memorySessions{}, users, &membershipsRepo{roles: map[int]string{}}, 0, discardAudit{}, logging.Discard())
+	pw := NewPasswordService(fastHasher(t), passwords.Policy{}, nil, logging.Discard())
+	svc := NewUserService(inlineTx{repositories.Repositories{Users: users, Outbox: &memoryOutbox{}}}, users, &memoryResets{tokens: map[string]int{}}, nil, nil, sessions, pw, discardAudit{}, logging.Discard())
+	ctx := logging.WithUserID(tenancy.WithTenant(context.Background(), 3), 2)
+	login := func() error {
+		_, err := svc.Login(tenancy.WithTenant(context.Background(), 3), models.LoginUser{Email: "jane@example.com", Password: "correct horse"}, httptest.NewRecorder())
+		return err
+	}
+
+	t.Run("suspension blocks login", func(t *testing.T) {
+		until := time.Now().Add(time.Hour)
+		user, err := svc.SetStatus(ctx, 1, models.StatusSuspended, models.StatusUpdate{Reason: "spam", Until: &until})
+		require.NoError(t, err)
+		assert.Equal(t, models.StatusSuspended, user.Status)
+
+		var inactive *AccountInactiveError
+		require.True(t, errors.As(login(), &inactive))
+		assert.Equal(t, models.StatusSuspended, inactive.Status)
+		assert.Equal(t, &until, inactive.Until)
+		assert.EqualError(t, inactive, "account disabled")
+	})
+
+	t.Run("transitions", func(t *testing.T) {
+		_, err := svc.SetStatus(ctx, 1, models.StatusPending, models.StatusUpdate{})
+		assert.EqualError(t, err, "invalid status transition")
+		_, err = svc.SetStatus(ctx, 1, "banned", models.StatusUpdate{})
+		assert.EqualError(t, err, "invalid status")
+		past := time.Now().Add(-time.Hour)
+		_, err = svc.SetStatus(ctx, 1, models.StatusLocked, models.StatusUpdate{Until: &past})
+		assert.EqualError(t, err, "invalid status expiry")
+		_, err = svc.SetStatus(ctx, 2, models.StatusSuspended, models.StatusUpdate{})
+		assert.EqualError(t, err, "cannot change own status")
+		_, err = svc.SetStatus(ctx, 9, models.StatusSuspended, models.StatusUpdate{})
+		assert.EqualError(t, err, "user not found")
+
+		_, err = svc.SetStatus(ctx, 1, models.StatusActive, models.StatusUpdate{Reason: "appeal upheld"})
+		require.NoError(t, err)
+		assert.NoError(t, login())
+	})
+
+	t.Run("expired suspensions end at the next login", func(t *testing.T) {
+		_, err := svc.SetDisabled(ctx, 1, true)
+		require.NoError(t, err)
+		assert.Error(t, login())
+		_, err = svc.SetDisabled(ctx, 1, false)
+		require.NoError(t, err)
+
+		users.users[0].Status = models.StatusSuspended
+		ended := time.Now().Add(-time.Minute)
+		users.users[0].StatusUntil = &ended
+		require.NoError(t, login())
+		assert.Equal(t, models.StatusActive, users.users[0].Status)
+	})
+
+	t.Run("history", func(t *testing.T) {
+		history, err := svc.StatusHistory(ctx, 1)
+		require.NoError(t, err)
+		var steps [][2]string
+		for i := len(history) - 1; i >= 0; i-- {
+			steps = append(steps, [2]string{history[i].From, history[i].To})
+		}
+		assert.Equal(t, [][2]string{
+			{models.StatusActive, models.StatusSuspended},
+			{models.StatusSuspended, models.StatusActive},
+			{models.StatusActive, models.StatusDeactivated},
+			{models.StatusDeactivated, models.StatusActive},
+			{models.StatusSuspended, models.StatusActive},
+		}, steps)
+		assert.Equal(t, "spam", history[len(history)-1].Reason)
+		assert.Equal(t, 2, *history[len(history)-1].ActorId)
+		assert.Equal(t, "expired", history[0].Reason)
+	})
+}
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+	"server-go/webauthn"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const WebAuthnFlowCookie = "webauthn_flow"
+
+const webauthnFlowTTL = 5 * time.Minute
+
+// WebAuthnService registers passkeys and signs users in with them. Each
+// ceremony's challenge is stored until its finish request consumes it; a
+// signed, short-lived flow cookie names it in between.
+type WebAuthnService interface {
+	BeginRegistration(ctx context.Context, user *models.User, w http.ResponseWriter) (*webauthn.CreationOptions, error)
+	FinishRegistration(ctx context.Context, user *models.User, input models.FinishPasskeyRegistration, flow string, w http.ResponseWriter) (*models.Passkey, error)
+	BeginLogin(ctx context.Context, input models.BeginPasskeyLogin, w http.ResponseWriter) (*webauthn.RequestOptions, error)
+	// FinishLogin verifies the assertion and issues a session token the
+	// same way Login does.
+	FinishLogin(ctx context.Context, response webauthn.AssertionResponse, flow string, w http.ResponseWriter) (string, error)
+	List(ctx context.Context, user *models.User) ([]models.Passkey, error)
+	Delete(ctx context.Context, user *models.User, id int) error
+}
+
+type webauthnService struct {
+	rp             webauthn.Config
+	passkeys       repositories.PasskeyRepository
+	userRepository repositories.UserRepository
+	sessions       SessionService
+	audit          AuditService
+	logger         *slog.Logger
+}
+
+func NewWebAuthnService(rp webauthn.Config, passkeyRepo repositories.PasskeyRepository, userRepo repositories.UserRepository, sessions SessionService, audit AuditService, logger *slog.Logger) WebAuthnService {
+	return &webauthnService{rp: rp, passkeys: passkeyRepo, userRepository: userRepo, sessions: sessions, audit: audit, logger: logger}
+}
+
+// userHandle is the opaque WebAuthn user ID: the user id as 8 bytes.
+func userHandle(userId int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userId))
+}
+
+func (s *webauthnService) BeginRegistration(ctx context.Context, current *models.User, w http.ResponseWriter) (*webauthn.CreationOptions, error) {
+	// Passkeys belong to the account, which lives in the home organization
+	ctx = tenancy.WithTenant(ctx, current.TenantId)
+	user, err := s.userRepository.FindByID(ctx, current.Id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	if user.ServiceAccount {
+		return nil, errors.New("service account")
+	}
+
+	existing, err := s.passkeys.ListForUser(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	var exclude [][]byte
+	for _, passkey := range existing {
+		if id, err := webauthn.Decode(passkey.CredentialId); err == nil {
+			exclude = append(exclude, id)
+		}
+	}
+
+	challenge, err := s.startFlow(ctx, w, "register", user.TenantId, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	options := s.rp.CreationOptions(challenge, userHandle(user.Id), user.Email, strings.TrimSpace(user.Name+" "+user.LastName), exclude)
+	return &options, nil
+}
+
+func (s *webauthnService) FinishRegistration(ctx context.Context, current *models.User, input models.FinishPasskeyRegistration, flow string, w http.ResponseWriter) (*models.Passkey, error) {
+	challenge, tenantId, userId, err := s.finishFlow(ctx, w, flow, "register")
+	if err != nil {
+		return nil, err
+	}
+	if tenantId != current.TenantId || userId != current.Id {
+		return nil, errInvalidFlow
+	}
+	ctx = tenancy.WithTenant(ctx, current.TenantId)
+
+	credential, err := s.rp.VerifyRegistration(input.Credential, challenge)
+	if err != nil {
+		s.logger.InfoContext(ctx, "passkey registration rejected", "user", current.Id, "error", err)
+		return nil, errors.New("invalid passkey")
+	}
+
+	credentialId := webauthn.Encode(credential.ID)
+	existing, err := s.passkeys.FindByCredentialID(ctx, credentialId)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errors.New("passkey already registered")
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	passkey, err := s.passkeys.Create(ctx, &models.Passkey{
+		UserId:       current.Id,
+		Name:         name,
+		CredentialId: credentialId,
+		PublicKey:    credential.PublicKey,
+		SignCount:    credential.SignCount,
+		AAGUID:       credential.AAGUID,
+		Attestation:  credential.Attestation,
+		Transports:   credential.Transports,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "passkey registered", "user", current.Id, "passkey", passkey.Id)
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditPasskeyAdded,
+		ActorId:  intPtr(current.Id),
+		TargetId: intPtr(current.Id),
+		Details:  map[string]string{"passkey": strconv.Itoa(passkey.Id), "name": name},
+	})
+	return passkey, nil
+}
+
+func (s *webauthnService) BeginLogin(ctx context.Context, input models.BeginPasskeyLogin, w http.ResponseWriter) (*webauthn.RequestOptions, error) {
+	tenantId, ok := tenancy.TenantID(ctx)
+	if !ok {
+		return nil, tenancy.ErrNoTenant
+	}
+
+	// Unknown emails get the same answer as accounts without passkeys,
+	// so the options reveal nothing about who has an account
+	var allow [][]byte
+	if email := strings.TrimSpace(input.Email); email != "" {
+		user, err := s.userRepository.FindByEmail(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			passkeys, err := s.passkeys.ListForUser(ctx, user.Id)
+			if err != nil {
+				return nil, err
+			}
+			for _, passkey := range passkeys {
+				if id, err := webauthn.Decode(passkey.CredentialId); err == nil {
+					allow = append(allow, id)
+				}
+			}
+		}
+	}
+
+	challenge, err := s.startFlow(ctx, w, "login", tenantId, 0)
+	if err != nil {
+		return nil, err
+	}
+	options := s.rp.RequestOptions(challenge, allow)
+	return &options, nil
+}
+
+func (s *webauthnService) FinishLogin(ctx context.Context, response webauthn.AssertionResponse, flow string, w http.ResponseWriter) (string, error) {
+	challenge, tenantId, _, err := s.finishFlow(ctx, w, flow, "login")
+	if err != nil {
+		return "", err
+	}
+	ctx = tenancy.WithTenant(ctx, tenantId)
+
+	rawId, err := webauthn.Decode(response.RawID)
+	if err != nil {
+		return "", errors.New("invalid passkey")
+	}
+	passkey, err := s.passkeys.FindByCredentialID(ctx, webauthn.Encode(rawId))
+	if err != nil {
+		return "", err
+	}
+	if passkey == nil {
+		s.logger.InfoContext(ctx, "passkey login with unknown credential")
+		return "", errors.New("invalid passkey")
+	}
+	if response.Response.UserHandle != "" {
+		if handle, err := webauthn.Decode(response.Response.UserHandle); err != nil || string(handle) != string(userHandle(passkey.UserId)) {
+			return "", errors.New("invalid passkey")
+		}
+	}
+
+	signCount, err := s.rp.VerifyAssertion(response, challenge, passkey.PublicKey, passkey.SignCount)
+	if err != nil {
+		s.logger.InfoContext(ctx, "passkey login rejected", "user", passkey.UserId, "error", err)
+		s.recordFailure(ctx, passkey.UserId, "invalid passkey")
+		return "", errors.New("invalid passkey")
+	}
+	if err := s.passkeys.RecordUse(ctx, passkey.Id, signCount); err != nil {
+		return "", err
+	}
+
+	user, err := s.userRepository.FindByID(ctx, passkey.UserId)
+	if err != nil {
+		return "", err
+	}
+	if user == nil {
+		return "", errors.New("invalid passkey")
+	}
+	if !user.Active() {
+		s.recordFailure(ctx, user.Id, "account disabled")
+		return "", errors.New("account disabled")
+	}
+
+	token, err := s.sessions.Start(ctx, user, "")
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error issuing session", "user", user.Id, "error", err)
+		return "", err
+	}
+	setSessionCookie(w, token)
+
+	s.logger.InfoContext(ctx, "login successful", "user", user.Id, "method", "passkey")
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditLogin,
+		ActorId:  intPtr(user.Id),
+		TargetId: intPtr(user.Id),
+		Details:  map[string]string{"method": "passkey", "passkey": strconv.Itoa(passkey.Id)},
+	})
+	return token, nil
+}
+
+func (s *webauthnService) recordFailure(ctx context.Context, userId int, reason string) {
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditLoginFailed,
+		TargetId: intPtr(userId),
+		Details:  map[string]string{"method": "passkey", "reason": reason},
+	})
+}
+
+func (s *webauthnService) List(ctx context.Context, user *models.User) ([]models.Passkey, error) {
+	return s.passkeys.ListForUser(tenancy.WithTenant(ctx, user.TenantId), user.Id)
+}
+
+func (s *webauthnService) Delete(ctx context.Context, user *models.User, id int) error {
+	ctx = tenancy.WithTenant(ctx, user.TenantId)
+	deleted, err := s.passkeys.Delete(ctx, user.Id, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errors.New("passkey not found")
+	}
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:   models.AuditPasskeyRemoved,
+		ActorId:  intPtr(user.Id),
+		TargetId: intPtr(user.Id),
+		Details:  map[string]string{"passkey": strconv.Itoa(id)},
+	})
+	return nil
+}
+
+// startFlow creates a challenge, stores it and sets the flow cookie that
+// names it for the finish request.
+func (s *webauthnService) startFlow(ctx context.Context, w http.ResponseWriter, op string, tenantId int, userId int) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(webauthnFlowTTL)
+	id, err := s.passkeys.CreateChallenge(tenancy.WithTenant(ctx, tenantId), op, webauthn.Encode(challenge), expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	flow, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"typ": "webauthn_flow",
+		"op":  op,
+		"cid": id,
+		"tid": tenantId,
+		"uid": userId,
+		"exp": expiresAt.Unix(),
+	}).SignedString(jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     WebAuthnFlowCookie,
+		Value:    flow,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   false,
+		// The ceremonies are served under each API version too
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+	})
+	return challenge, nil
+}
+
+var errInvalidFlow = errors.New("invalid webauthn flow")
+
+// finishFlow clears the flow cookie and consumes the challenge it names,
+// so a replayed cookie finds the challenge already used. It returns the
+// challenge and the tenant and user startFlow was given.
+func (s *webauthnService) finishFlow(ctx context.Context, w http.ResponseWriter, flow string, op string) ([]byte, int, int, error) {
+	http.SetCookie(w, &http.Cookie{Name: WebAuthnFlowCookie, Value: "", Path: "/", Expires: time.Now().Add(-time.Hour), HttpOnly: true})
+
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(flow, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS512 {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !parsed.Valid || claims["typ"] != "webauthn_flow" || claims["op"] != op {
+		return nil, 0, 0, errInvalidFlow
+	}
+	id, _ := claims["cid"].(float64)
+	tenantId, _ := claims["tid"].(float64)
+	userId, _ := claims["uid"].(float64)
+
+	encoded, ok, err := s.passkeys.ConsumeChallenge(tenancy.WithTenant(ctx, int(tenantId)), int(id), op)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if !ok {
+		return nil, 0, 0, errInvalidFlow
+	}
+	challenge, err := webauthn.Decode(encoded)
+	if err != nil || len(challenge) == 0 {
+		return nil, 0, 0, errInvalidFlow
+	}
+	return challenge, int(tenantId), int(userId), nil
+}
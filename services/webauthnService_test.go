@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"server-go/logging"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+	"server-go/webauthn"
+	"server-go/webauthn/webauthntest"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryPasskeys struct {
+	repositories.PasskeyRepository
+	passkeys   []*models.Passkey
+	challenges []storedChallenge
+}
+
+type storedChallenge struct {
+	tenantId  int
+	op        string
+	challenge string
+	used      bool
+}
+
+func (r *memoryPasskeys) Create(ctx context.Context, passkey *models.Passkey) (*models.Passkey, error) {
+	created := *passkey
+	created.Id = len(r.passkeys) + 1
+	created.CreatedAt = time.Now()
+	r.passkeys = append(r.passkeys, &created)
+	return &created, nil
+}
+
+func (r *memoryPasskeys) FindByCredentialID(ctx context.Context, credentialId string) (*models.Passkey, error) {
+	for _, p := range r.passkeys {
+		if p.CredentialId == credentialId {
+			found := *p
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryPasskeys) ListForUser(ctx context.Context, userId int) ([]models.Passkey, error) {
+	passkeys := []models.Passkey{}
+	for _, p := range r.passkeys {
+		if p.UserId == userId {
+			passkeys = append(passkeys, *p)
+		}
+	}
+	return passkeys, nil
+}
+
+func (r *memoryPasskeys) RecordUse(ctx context.Context, id int, signCount uint32) error {
+	r.passkeys[id-1].SignCount = signCount
+	return nil
+}
+
+func (r *memoryPasskeys) CreateChallenge(ctx context.Context, op string, challenge string, expiresAt time.Time) (int, error) {
+	tenantId, _ := tenancy.TenantID(ctx)
+	r.challenges = append(r.challenges, storedChallenge{tenantId: tenantId, op: op, challenge: challenge})
+	return len(r.challenges), nil
+}
+
+func (r *memoryPasskeys) ConsumeChallenge(ctx context.Context, id int, op string) (string, bool, error) {
+	tenantId, _ := tenancy.TenantID(ctx)
+	if id < 1 || id > len(r.challenges) {
+		return "", false, nil
+	}
+	c := &r.challenges[id-1]
+	if c.used || c.op != op || c.tenantId != tenantId {
+		return "", false, nil
+	}
+	c.used = true
+	return c.challenge, true, nil
+}
+
+// flowCookie returns the flow cookie a begin request set.
+func flowCookie(t *testing.T, w *httptest.ResponseRecorder) string {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == WebAuthnFlowCookie {
+			return c.Value
+		}
+	}
+	t.Fatal("no flow cookie")
+	return ""
+}
+
+func TestPasskeys(t *testing.T) {
+	rp := webauthn.Config{RPID: "localhost", RPName: "Test", Origins: []string{"http://localhost:8080"}, UserVerification: "preferred"}
+	user := &models.User{Id: 1, Name: "Jane", LastName: "Doe", Email: "jane@example.com", TenantId: 3}
+	users := &memoryUsers{users: []*models.User{user}}
+	passkeys := &memoryPasskeys{}
+	sessions := NewSessionService(&memorySessions{}, users, &membershipsRepo{roles: map[int]string{}}, 0, discardAudit{}, logging.Discard())
+	svc := NewWebAuthnService(rp, passkeys, users, sessions, discardAudit{}, logging.Discard())
+	authenticator := webauthntest.New("http://localhost:8080")
+	ctx := context.Background()
+
+	register := func(t *testing.T) (*models.Passkey, error) {
+		w := httptest.NewRecorder()
+		options, err := svc.BeginRegistration(ctx, user, w)
+		require.NoError(t, err)
+		credential, err := authenticator.Register(*options)
+		require.NoError(t, err)
+		return svc.FinishRegistration(ctx, user, models.FinishPasskeyRegistration{Name: "Laptop", Credential: credential}, flowCookie(t, w), httptest.NewRecorder())
+	}
+	login := func(t *testing.T) (string, error) {
+		w := httptest.NewRecorder()
+		options, err := svc.BeginLogin(tenancy.WithTenant(ctx, 3), models.BeginPasskeyLogin{Email: user.Email}, w)
+		require.NoError(t, err)
+		assertion, err := authenticator.Login(*options)
+		require.NoError(t, err)
+		return svc.FinishLogin(ctx, assertion, flowCookie(t, w), httptest.NewRecorder())
+	}
+
+	passkey, err := register(t)
+	require.NoError(t, err)
+	assert.Equal(t, "Laptop", passkey.Name)
+	assert.Equal(t, webauthn.Encode(authenticator.CredentialID), passkey.CredentialId)
+
+	t.Run("login issues a session token", func(t *testing.T) {
+		token, err := login(t)
+		require.NoError(t, err)
+
+		claims := jwt.MapClaims{}
+		_, _, err = jwt.NewParser().ParseUnverified(token, claims)
+		require.NoError(t, err)
+		assert.Equal(t, "jane@example.com", claims["user_Email"])
+		assert.EqualValues(t, 3, claims["tenant_id"])
+		assert.EqualValues(t, 1, passkeys.passkeys[0].SignCount)
+	})
+
+	t.Run("flow is bound to its ceremony", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		_, err := svc.BeginRegistration(ctx, user, w)
+		require.NoError(t, err)
+		assertion, err := authenticator.Login(webauthn.RequestOptions{Challenge: webauthn.Encode([]byte("x"))})
+		require.NoError(t, err)
+		_, err = svc.FinishLogin(ctx, assertion, flowCookie(t, w), httptest.NewRecorder())
+		assert.EqualError(t, err, "invalid webauthn flow")
+	})
+
+	t.Run("replayed assertion", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		options, err := svc.BeginLogin(tenancy.WithTenant(ctx, 3), models.BeginPasskeyLogin{}, w)
+		require.NoError(t, err)
+		assertion, err := authenticator.Login(*options)
+		require.NoError(t, err)
+		flow := flowCookie(t, w)
+		_, err = svc.FinishLogin(ctx, assertion, flow, httptest.NewRecorder())
+		require.NoError(t, err)
+
+		_, err = svc.FinishLogin(ctx, assertion, flow, httptest.NewRecorder())
+		assert.EqualError(t, err, "invalid webauthn flow", "the challenge was used")
+	})
+
+	t.Run("replayed flow with a fresh assertion", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		options, err := svc.BeginLogin(tenancy.WithTenant(ctx, 3), models.BeginPasskeyLogin{}, w)
+		require.NoError(t, err)
+		flow := flowCookie(t, w)
+		first, err := authenticator.Login(*options)
+		require.NoError(t, err)
+		_, err = svc.FinishLogin(ctx, first, flow, httptest.NewRecorder())
+		require.NoError(t, err)
+
+		// Authenticators that keep no counter would pass the sign count check
+		second, err := authenticator.Login(*options)
+		require.NoError(t, err)
+		_, err = svc.FinishLogin(ctx, second, flow, httptest.NewRecorder())
+		assert.EqualError(t, err, "invalid webauthn flow")
+	})
+
+	t.Run("already registered", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		options, err := svc.BeginRegistration(ctx, user, w)
+		require.NoError(t, err)
+		require.Len(t, options.ExcludeCredentials, 1)
+		_, err = authenticator.Register(*options)
+		assert.Error(t, err, "the authenticator refuses excluded credentials")
+	})
+
+	t.Run("disabled account", func(t *testing.T) {
+		now := time.Now()
+		user.DisabledAt = &now
+		defer func() { user.DisabledAt = nil }()
+		_, err := login(t)
+		assert.EqualError(t, err, "account disabled")
+	})
+}
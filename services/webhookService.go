@@ -0,0 +1,324 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"server-go/events"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+)
+
+// WebhookOptions tune delivery. A delivery is retried with doubling
+// backoff up to MaxAttempts; an endpoint is disabled after DisableAfter
+// failed requests in a row.
+type WebhookOptions struct {
+	Interval     time.Duration
+	BatchSize    int
+	Lease        time.Duration
+	MaxAttempts  int
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+	DisableAfter int
+}
+
+// WebhookService lets organization admins register HTTP callbacks for user
+// events, and delivers them signed with the endpoint's secret.
+type WebhookService interface {
+	CreateEndpoint(ctx context.Context, caller *models.User, input models.WebhookEndpointInput) (*models.WebhookEndpoint, error)
+	ListEndpoints(ctx context.Context, caller *models.User) ([]models.WebhookEndpoint, error)
+	DeleteEndpoint(ctx context.Context, caller *models.User, id int) error
+	// EnableEndpoint turns an endpoint back on after it was disabled for
+	// failing; deliveries that queued up meanwhile go out again.
+	EnableEndpoint(ctx context.Context, caller *models.User, id int) error
+	ListDeliveries(ctx context.Context, caller *models.User, endpointId int) ([]models.WebhookDelivery, error)
+	GetDelivery(ctx context.Context, caller *models.User, endpointId int, id int) (*models.WebhookDelivery, error)
+	Redeliver(ctx context.Context, caller *models.User, endpointId int, id int) error
+
+	// Enqueue is an events.Handler: it queues event for the endpoints of
+	// its organization.
+	Enqueue(ctx context.Context, event models.Event) error
+	// Dispatch sends one batch of due deliveries and returns its size.
+	Dispatch(ctx context.Context) (int, error)
+	// Run dispatches until ctx is cancelled.
+	Run(ctx context.Context)
+}
+
+type webhookService struct {
+	webhooks repositories.WebhookRepository
+	client   *http.Client
+	options  WebhookOptions
+	audit    AuditService
+	logger   *slog.Logger
+}
+
+func NewWebhookService(webhookRepo repositories.WebhookRepository, client *http.Client, options WebhookOptions, audit AuditService, logger *slog.Logger) WebhookService {
+	return &webhookService{webhooks: webhookRepo, client: client, options: options, audit: audit, logger: logger}
+}
+
+// minWebhookSecretLength keeps caller-supplied signing secrets about as
+// hard to guess as the generated ones.
+const minWebhookSecretLength = 32
+
+var webhookEventTypes = map[string]bool{
+	"*":                        true,
+	models.EventUserRegistered: true,
+	models.EventUserUpdated:    true,
+	models.EventUserDeleted:    true,
+}
+
+func (s *webhookService) CreateEndpoint(ctx context.Context, caller *models.User, input models.WebhookEndpointInput) (*models.WebhookEndpoint, error) {
+	target, err := url.Parse(input.URL)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+		return nil, errors.New("invalid url")
+	}
+	eventTypes := input.Events
+	if len(eventTypes) == 0 {
+		eventTypes = []string{"*"}
+	}
+	for _, t := range eventTypes {
+		if !webhookEventTypes[t] {
+			return nil, errors.New("invalid event type")
+		}
+	}
+	secret := input.Secret
+	if secret != "" && len(secret) < minWebhookSecretLength {
+		return nil, errors.New("secret too short")
+	}
+	if secret == "" {
+		token, err := randomToken(32)
+		if err != nil {
+			return nil, err
+		}
+		secret = "whsec_" + token
+	}
+
+	ctx = tenancy.WithTenant(ctx, caller.OrgId)
+	endpoint, err := s.webhooks.CreateEndpoint(ctx, input.URL, secret, eventTypes)
+	if err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:  models.AuditWebhookCreated,
+		ActorId: intPtr(caller.Id),
+		Details: map[string]string{"webhookId": strconv.Itoa(endpoint.Id), "url": endpoint.URL},
+	})
+	return endpoint, nil
+}
+
+func (s *webhookService) ListEndpoints(ctx context.Context, caller *models.User) ([]models.WebhookEndpoint, error) {
+	return s.webhooks.ListEndpoints(tenancy.WithTenant(ctx, caller.OrgId))
+}
+
+func (s *webhookService) DeleteEndpoint(ctx context.Context, caller *models.User, id int) error {
+	ctx = tenancy.WithTenant(ctx, caller.OrgId)
+	found, err := s.webhooks.DeleteEndpoint(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("webhook not found")
+	}
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:  models.AuditWebhookDeleted,
+		ActorId: intPtr(caller.Id),
+		Details: map[string]string{"webhookId": strconv.Itoa(id)},
+	})
+	return nil
+}
+
+func (s *webhookService) EnableEndpoint(ctx context.Context, caller *models.User, id int) error {
+	ctx = tenancy.WithTenant(ctx, caller.OrgId)
+	found, err := s.webhooks.EnableEndpoint(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("webhook not found")
+	}
+	s.audit.Record(ctx, models.AuditEvent{
+		Action:  models.AuditWebhookEnabled,
+		ActorId: intPtr(caller.Id),
+		Details: map[string]string{"webhookId": strconv.Itoa(id)},
+	})
+	return nil
+}
+
+func (s *webhookService) ListDeliveries(ctx context.Context, caller *models.User, endpointId int) ([]models.WebhookDelivery, error) {
+	ctx = tenancy.WithTenant(ctx, caller.OrgId)
+	endpoint, err := s.webhooks.FindEndpoint(ctx, endpointId)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint == nil {
+		return nil, errors.New("webhook not found")
+	}
+	return s.webhooks.ListDeliveries(ctx, endpointId, 100)
+}
+
+func (s *webhookService) GetDelivery(ctx context.Context, caller *models.User, endpointId int, id int) (*models.WebhookDelivery, error) {
+	delivery, err := s.webhooks.FindDelivery(tenancy.WithTenant(ctx, caller.OrgId), endpointId, id)
+	if err != nil {
+		return nil, err
+	}
+	if delivery == nil {
+		return nil, errors.New("delivery not found")
+	}
+	return delivery, nil
+}
+
+func (s *webhookService) Redeliver(ctx context.Context, caller *models.User, endpointId int, id int) error {
+	ctx = tenancy.WithTenant(ctx, caller.OrgId)
+	found, err := s.webhooks.Redeliver(ctx, endpointId, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("delivery not found")
+	}
+	s.logger.InfoContext(ctx, "webhook redelivery requested", "webhook", endpointId, "delivery", id)
+	return nil
+}
+
+func (s *webhookService) Enqueue(ctx context.Context, event models.Event) error {
+	n, err := s.webhooks.Enqueue(tenancy.WithTenant(ctx, event.TenantId), event)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		s.logger.DebugContext(ctx, "webhook deliveries queued", "event", event.Id, "type", event.Type, "deliveries", n)
+	}
+	return nil
+}
+
+func (s *webhookService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.options.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := s.Dispatch(ctx)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "webhook dispatch failed", "error", err)
+		}
+		if err == nil && n == s.options.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *webhookService) Dispatch(ctx context.Context) (int, error) {
+	deliveries, err := s.webhooks.ClaimDeliveries(ctx, s.options.BatchSize, s.options.Lease)
+	if err != nil {
+		return 0, err
+	}
+	for i := range deliveries {
+		if err := s.deliver(ctx, &deliveries[i]); err != nil {
+			return len(deliveries), err
+		}
+	}
+	return len(deliveries), nil
+}
+
+// webhookBody is what receivers get; Id is the event id, the same for
+// every attempt, so receivers can drop duplicates.
+type webhookBody struct {
+	Id        int64           `json:"id"`
+	Type      string          `json:"type"`
+	TenantId  int             `json:"tenantId"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func (s *webhookService) deliver(ctx context.Context, delivery *models.WebhookDelivery) error {
+	body, err := json.Marshal(webhookBody{Id: delivery.EventId, Type: delivery.EventType, TenantId: delivery.TenantId, CreatedAt: delivery.CreatedAt, Data: delivery.Payload})
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	attempt := s.send(ctx, delivery, body)
+	attempt.DurationMs = int(time.Since(started).Milliseconds())
+
+	attempts := delivery.Attempts + 1
+	switch {
+	case attempt.Error == "":
+		delivery.Status, delivery.NextAttemptAt = models.DeliverySucceeded, nil
+	case attempts >= s.options.MaxAttempts:
+		delivery.Status, delivery.NextAttemptAt = models.DeliveryFailed, nil
+	default:
+		next := time.Now().Add(s.backoff(attempts))
+		delivery.Status, delivery.NextAttemptAt = models.DeliveryPending, &next
+	}
+	if attempt.Error != "" {
+		s.logger.WarnContext(ctx, "webhook delivery failed", "webhook", delivery.EndpointId, "delivery", delivery.Id, "attempts", attempts, "error", attempt.Error)
+	}
+
+	held, disabled, err := s.webhooks.RecordAttempt(ctx, delivery, attempt, s.options.DisableAfter)
+	if err != nil {
+		return err
+	}
+	if !held {
+		s.logger.WarnContext(ctx, "webhook lease lost before the attempt was recorded", "webhook", delivery.EndpointId, "delivery", delivery.Id)
+		return nil
+	}
+	// Only enabled endpoints are claimed, so this attempt tipped it over
+	if disabled && attempt.Error != "" {
+		tenantCtx := tenancy.WithTenant(ctx, delivery.TenantId)
+		s.logger.WarnContext(tenantCtx, "webhook disabled after repeated failures", "webhook", delivery.EndpointId)
+		s.audit.Record(tenantCtx, models.AuditEvent{
+			Action:  models.AuditWebhookDisabled,
+			Details: map[string]string{"webhookId": strconv.Itoa(delivery.EndpointId), "reason": "too many failed deliveries"},
+		})
+	}
+	return nil
+}
+
+func (s *webhookService) send(ctx context.Context, delivery *models.WebhookDelivery, body []byte) models.WebhookAttempt {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return models.WebhookAttempt{Error: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "server-go-webhooks")
+	req.Header.Set("X-Webhook-Id", strconv.FormatInt(delivery.EventId, 10))
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set(events.SignatureHeader, events.Sign(delivery.Secret, time.Now(), body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return models.WebhookAttempt{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	attempt := models.WebhookAttempt{StatusCode: resp.StatusCode}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return attempt
+}
+
+func (s *webhookService) backoff(attempts int) time.Duration {
+	delay := s.options.Backoff
+	for i := 1; i < attempts && delay < s.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.options.MaxBackoff {
+		delay = s.options.MaxBackoff
+	}
+	return delay
+}
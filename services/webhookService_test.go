@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"server-go/events"
+	"server-go/logging"
+	"server-go/models"
+	"server-go/repositories"
+	"server-go/tenancy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryWebhooks keeps endpoints and deliveries of any tenant; the claim
+// ignores leases since the tests dispatch one batch at a time.
+type memoryWebhooks struct {
+	repositories.WebhookRepository
+	endpoints  []*models.WebhookEndpoint
+	deliveries []*models.WebhookDelivery
+}
+
+func (r *memoryWebhooks) CreateEndpoint(ctx context.Context, url string, secret string, eventTypes []string) (*models.WebhookEndpoint, error) {
+	tenantId, _ := tenancy.TenantID(ctx)
+	e := &models.WebhookEndpoint{Id: len(r.endpoints) + 1, TenantId: tenantId, URL: url, Secret: secret, Events: eventTypes}
+	r.endpoints = append(r.endpoints, e)
+	return e, nil
+}
+
+func (r *memoryWebhooks) EnableEndpoint(ctx context.Context, id int) (bool, error) {
+	for _, e := range r.endpoints {
+		if e.Id == id {
+			e.DisabledAt, e.ConsecutiveFailures = nil, 0
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryWebhooks) Enqueue(ctx context.Context, event models.Event) (int, error) {
+	tenantId, _ := tenancy.TenantID(ctx)
+	n := 0
+	for _, e := range r.endpoints {
+		if e.TenantId != tenantId || e.DisabledAt != nil || !e.Matches(event.Type) || r.find(e.Id, event.Id) != nil {
+			continue
+		}
+		now := time.Now()
+		r.deliveries = append(r.deliveries, &models.WebhookDelivery{Id: len(r.deliveries) + 1, TenantId: tenantId, EndpointId: e.Id,
+			EventId: event.Id, EventType: event.Type, Payload: event.Payload, Status: models.DeliveryPending, NextAttemptAt: &now})
+		n++
+	}
+	return n, nil
+}
+
+func (r *memoryWebhooks) find(endpointId int, eventId int64) *models.WebhookDelivery {
+	for _, d := range r.deliveries {
+		if d.EndpointId == endpointId && d.EventId == eventId {
+			return d
+		}
+	}
+	return nil
+}
+
+func (r *memoryWebhooks) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	var due []models.WebhookDelivery
+	for _, d := range r.deliveries {
+		e := r.endpoints[d.EndpointId-1]
+		if d.Status == models.DeliveryPending && e.DisabledAt == nil && !d.NextAttemptAt.After(time.Now()) {
+			claimed := *d
+			claimed.URL, claimed.Secret = e.URL, e.Secret
+			due = append(due, claimed)
+		}
+	}
+	return due, nil
+}
+
+func (r *memoryWebhooks) RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery, attempt models.WebhookAttempt, disableAfter int) (bool, bool, error) {
+	d := r.deliveries[delivery.Id-1]
+	if d.Status != models.DeliveryPending || d.Attempts != delivery.Attempts {
+		return false, false, nil
+	}
+	d.Attempts++
+	d.Status, d.NextAttemptAt = delivery.Status, delivery.NextAttemptAt
+	attempt.DeliveryId = d.Id
+	d.Log = append(d.Log, attempt)
+
+	e := r.endpoints[d.EndpointId-1]
+	if delivery.Status == models.DeliverySucceeded {
+		e.ConsecutiveFailures = 0
+	} else if e.ConsecutiveFailures++; e.ConsecutiveFailures >= disableAfter {
+		now := time.Now()
+		e.DisabledAt = &now
+	}
+	return true, e.DisabledAt != nil, nil
+}
+
+func (r *memoryWebhooks) Redeliver(ctx context.Context, endpointId int, id int) (bool, error) {
+	for _, d := range r.deliveries {
+		if d.Id == id && d.EndpointId == endpointId {
+			now := time.Now()
+			d.Status, d.Attempts, d.NextAttemptAt = models.DeliveryPending, 0, &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// receiver is a partner endpoint that checks signatures like a partner
+// would and answers with the current status.
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	status   int
+	received []webhookBody
+	invalid  int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	if err := events.Verify(rc.secret, r.Header.Get(events.SignatureHeader), body, 5*time.Minute, time.Now()); err != nil {
+		rc.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var e webhookBody
+	json.Unmarshal(body, &e)
+	rc.received = append(rc.received, e)
+	w.WriteHeader(rc.status)
+}
+
+var testWebhookOptions = WebhookOptions{BatchSize: 10, Lease: time.Minute, MaxAttempts: 3, Backoff: time.Nanosecond, MaxBackoff: time.Nanosecond, DisableAfter: 5}
+
+func TestWebhookDelivery(t *testing.T) {
+	rc := &receiver{status: http.StatusOK}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	repo := &memoryWebhooks{}
+	svc := NewWebhookService(repo, srv.Client(), testWebhookOptions, discardAudit{}, logging.Discard())
+	ctx := context.Background()
+	admin := &models.User{Id: 9, OrgId: 3}
+
+	_, err := svc.CreateEndpoint(ctx, admin, models.WebhookEndpointInput{URL: "ftp://example.com"})
+	assert.EqualError(t, err, "invalid url")
+	_, err = svc.CreateEndpoint(ctx, admin, models.WebhookEndpointInput{URL: srv.URL, Events: []string{"user.exploded"}})
+	assert.EqualError(t, err, "invalid event type")
+	_, err = svc.CreateEndpoint(ctx, admin, models.WebhookEndpointInput{URL: srv.URL, Secret: "hunter2"})
+	assert.EqualError(t, err, "secret too short")
+
+	endpoint, err := svc.CreateEndpoint(ctx, admin, models.WebhookEndpointInput{URL: srv.URL, Events: []string{models.EventUserRegistered}})
+	require.NoError(t, err)
+	assert.Regexp(t, `^whsec_`, endpoint.Secret, "a secret is generated")
+	rc.secret = endpoint.Secret
+
+	dispatch := func() {
+		_, err := svc.Dispatch(ctx)
+		require.NoError(t, err)
+	}
+
+	t.Run("signed and filtered by tenant and type", func(t *testing.T) {
+		registered := models.Event{Id: 1, TenantId: 3, UserId: 7, Type: models.EventUserRegistered, Payload: json.RawMessage(`{"id":7}`)}
+		require.NoError(t, svc.Enqueue(ctx, registered))
+		require.NoError(t, svc.Enqueue(ctx, registered), "a redelivered event is queued once")
+		require.NoError(t, svc.Enqueue(ctx, models.Event{Id: 2, TenantId: 3, Type: models.EventUserDeleted}))
+		require.NoError(t, svc.Enqueue(ctx, models.Event{Id: 3, TenantId: 4, Type: models.EventUserRegistered}))
+		dispatch()
+
+		require.Len(t, rc.received, 1)
+		assert.Equal(t, int64(1), rc.received[0].Id)
+		assert.JSONEq(t, `{"id":7}`, string(rc.received[0].Data))
+		assert.Zero(t, rc.invalid)
+		assert.Equal(t, models.DeliverySucceeded, repo.deliveries[0].Status)
+		assert.Equal(t, http.StatusOK, repo.deliveries[0].Log[0].StatusCode)
+	})
+
+	t.Run("a replayed request fails verification", func(t *testing.T) {
+		body := []byte(`{"id":1}`)
+		old := events.Sign(endpoint.Secret, time.Now().Add(-time.Hour), body)
+		assert.EqualError(t, events.Verify(endpoint.Secret, old, body, 5*time.Minute, time.Now()), "signature timestamp outside tolerance")
+		fresh := events.Sign(endpoint.Secret, time.Now(), body)
+		assert.EqualError(t, events.Verify("other", fresh, body, 5*time.Minute, time.Now()), "signature mismatch")
+		assert.EqualError(t, events.Verify(endpoint.Secret, fresh, []byte(`{"id":2}`), 5*time.Minute, time.Now()), "signature mismatch")
+	})
+
+	t.Run("retries with backoff, then gives up", func(t *testing.T) {
+		rc.status = http.StatusInternalServerError
+		require.NoError(t, svc.Enqueue(ctx, models.Event{Id: 10, TenantId: 3, Type: models.EventUserRegistered, Payload: json.RawMessage(`{}`)}))
+		for i := 0; i < 5; i++ {
+			dispatch()
+		}
+		failed := repo.deliveries[len(repo.deliveries)-1]
+		assert.Equal(t, models.DeliveryFailed, failed.Status)
+		assert.Equal(t, 3, failed.Attempts)
+		require.Len(t, failed.Log, 3)
+		assert.Equal(t, "unexpected status 500", failed.Log[2].Error)
+
+		rc.status = http.StatusOK
+		require.NoError(t, svc.Redeliver(ctx, admin, endpoint.Id, failed.Id))
+		dispatch()
+		assert.Equal(t, models.DeliverySucceeded, failed.Status)
+		assert.EqualError(t, svc.Redeliver(ctx, admin, endpoint.Id, 99), "delivery not found")
+	})
+
+	t.Run("a claim whose lease ran out records nothing", func(t *testing.T) {
+		rc.status = http.StatusInternalServerError
+		require.NoError(t, svc.Enqueue(ctx, models.Event{Id: 15, TenantId: 3, Type: models.EventUserRegistered, Payload: json.RawMessage(`{}`)}))
+		claimed, err := repo.ClaimDeliveries(ctx, 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+		dispatch()
+		pending := repo.deliveries[len(repo.deliveries)-1]
+		failures := repo.endpoints[0].ConsecutiveFailures
+
+		require.NoError(t, svc.(*webhookService).deliver(ctx, &claimed[0]))
+		assert.Equal(t, 1, pending.Attempts)
+		assert.Len(t, pending.Log, 1)
+		assert.Equal(t, failures, repo.endpoints[0].ConsecutiveFailures, "one failure counts once")
+
+		rc.status = http.StatusOK
+		dispatch()
+		assert.Equal(t, models.DeliverySucceeded, pending.Status)
+	})
+
+	t.Run("sustained failures disable the endpoint", func(t *testing.T) {
+		rc.status = http.StatusServiceUnavailable
+		for id := int64(20); id < 22; id++ {
+			require.NoError(t, svc.Enqueue(ctx, models.Event{Id: id, TenantId: 3, Type: models.EventUserRegistered, Payload: json.RawMessage(`{}`)}))
+		}
+		for i := 0; i < 3; i++ {
+			dispatch()
+		}
+		require.NotNil(t, repo.endpoints[0].DisabledAt, "five failed requests in a row")
+		received := len(rc.received)
+		dispatch()
+		assert.Len(t, rc.received, received, "nothing is sent to a disabled endpoint")
+
+		rc.status = http.StatusOK
+		require.NoError(t, svc.EnableEndpoint(ctx, admin, endpoint.Id))
+		require.NoError(t, svc.Redeliver(ctx, admin, endpoint.Id, repo.deliveries[len(repo.deliveries)-1].Id))
+		dispatch()
+		assert.Len(t, rc.received, received+1)
+	})
+}
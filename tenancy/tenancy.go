@@ -0,0 +1,55 @@
+// Package tenancy carries the current organization through a request.
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+// ErrNoTenant is returned by tenant-scoped code called without a tenant,
+// so a missing tenant never widens a query.
+var ErrNoTenant = errors.New("no tenant in context")
+
+type ctxKey struct{}
+
+func WithTenant(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+func TenantID(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(ctxKey{}).(int)
+	return id, ok
+}
+
+// SlugFromHost returns the subdomain label of host directly under
+// baseDomain, e.g. "acme" for acme.example.com with baseDomain example.com.
+func SlugFromHost(host string, baseDomain string) string {
+	if baseDomain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	suffix := "." + strings.ToLower(strings.TrimPrefix(baseDomain, "."))
+	if !strings.HasSuffix(host, suffix) {
+		return ""
+	}
+	slug := strings.TrimSuffix(host, suffix)
+	if strings.Contains(slug, ".") {
+		return ""
+	}
+	return slug
+}
+
+// Options controls how requests are mapped to a tenant.
+type Options struct {
+	// BaseDomain enables subdomain resolution: acme.<BaseDomain> is "acme".
+	BaseDomain string
+	// Header names a request header carrying the organization slug.
+	Header string
+	// DefaultSlug is used when the request names no tenant; empty disables it.
+	DefaultSlug string
+}
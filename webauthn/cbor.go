@@ -0,0 +1,135 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The subset of CBOR (RFC 8949) that authenticators produce: definite
+// lengths only. Unsigned and negative integers decode to int64, byte
+// strings to []byte, text to string, arrays to []any and maps to
+// map[any]any.
+
+const maxCBORDepth = 16
+
+var errCBOR = errors.New("malformed CBOR")
+
+// decodeCBOR decodes the first item in b and returns it with the bytes
+// that follow it.
+func decodeCBOR(b []byte) (any, []byte, error) {
+	return decodeItem(b, 0)
+}
+
+func decodeItem(b []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, fmt.Errorf("%w: nested too deeply", errCBOR)
+	}
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end", errCBOR)
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(b) < n {
+			return nil, nil, fmt.Errorf("%w: unexpected end", errCBOR)
+		}
+		switch n {
+		case 1:
+			arg = uint64(b[0])
+		case 2:
+			arg = uint64(binary.BigEndian.Uint16(b))
+		case 4:
+			arg = uint64(binary.BigEndian.Uint32(b))
+		case 8:
+			arg = binary.BigEndian.Uint64(b)
+		}
+		b = b[n:]
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported additional information %d", errCBOR, info)
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return int64(arg), b, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return -1 - int64(arg), b, nil
+	case 2, 3:
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end", errCBOR)
+		}
+		if major == 2 {
+			return append([]byte(nil), b[:arg]...), b[arg:], nil
+		}
+		return string(b[:arg]), b[arg:], nil
+	case 4:
+		// Every item takes at least a byte, which bounds the allocation
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end", errCBOR)
+		}
+		items := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item any
+			var err error
+			if item, b, err = decodeItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, b, nil
+	case 5:
+		if arg > uint64(len(b))/2 {
+			return nil, nil, fmt.Errorf("%w: unexpected end", errCBOR)
+		}
+		m := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value any
+			var err error
+			if key, b, err = decodeItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key %T", errCBOR, key)
+			}
+			if value, b, err = decodeItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, b, nil
+	case 6:
+		// Tags carry no meaning for WebAuthn
+		return decodeItem(b, depth+1)
+	default:
+		switch {
+		case info == 20:
+			return false, b, nil
+		case info == 21:
+			return true, b, nil
+		case info == 22 || info == 23:
+			return nil, b, nil
+		case info == 25:
+			return nil, nil, fmt.Errorf("%w: half-precision floats are not supported", errCBOR)
+		case info == 26:
+			return float64(math.Float32frombits(uint32(arg))), b, nil
+		case info == 27:
+			return math.Float64frombits(arg), b, nil
+		}
+		return nil, nil, fmt.Errorf("%w: unsupported simple value %d", errCBOR, info)
+	}
+}
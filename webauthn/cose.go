@@ -0,0 +1,117 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers (RFC 9053) accepted for credentials.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// COSE key parameters (RFC 9052 section 7.1 and RFC 9053 section 7).
+const (
+	coseKty = 1
+	coseAlg = 3
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1
+	coseE   = -2
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+)
+
+var errUnsupportedKey = errors.New("unsupported credential public key")
+
+// PublicKey is a credential public key decoded from its COSE form.
+type PublicKey struct {
+	Alg int64
+	Key crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key as stored for a credential.
+func ParsePublicKey(cose []byte) (*PublicKey, error) {
+	key, rest, err := parsePublicKey(cose)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", errUnsupportedKey)
+	}
+	return key, nil
+}
+
+func parsePublicKey(b []byte) (*PublicKey, []byte, error) {
+	decoded, rest, err := decodeCBOR(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: not a map", errUnsupportedKey)
+	}
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, nil, fmt.Errorf("%w: bad EC2 key", errUnsupportedKey)
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, nil, fmt.Errorf("%w: point not on curve", errUnsupportedKey)
+		}
+		return &PublicKey{Alg: alg, Key: key}, rest, nil
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, nil, fmt.Errorf("%w: bad RSA key", errUnsupportedKey)
+		}
+		return &PublicKey{Alg: alg, Key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, rest, nil
+	case kty == coseKtyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != coseCrvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, nil, fmt.Errorf("%w: bad OKP key", errUnsupportedKey)
+		}
+		return &PublicKey{Alg: alg, Key: ed25519.PublicKey(x)}, rest, nil
+	}
+	return nil, nil, fmt.Errorf("%w: kty %d alg %d", errUnsupportedKey, kty, alg)
+}
+
+// Verify checks sig over data with the key's algorithm.
+func (k *PublicKey) Verify(data []byte, sig []byte) bool {
+	switch key := k.Key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, sig)
+	}
+	return false
+}
@@ -0,0 +1,393 @@
+// Package webauthn implements the relying party side of WebAuthn
+// registration and authentication ceremonies for passkeys. Only "none" and
+// self attestation are accepted: the server trusts the credential, not the
+// authenticator model.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Authenticator data flags (WebAuthn section 6.1).
+const (
+	FlagUserPresent  = 0x01
+	FlagUserVerified = 0x04
+	FlagAttestedData = 0x40
+	FlagExtensions   = 0x80
+)
+
+// ErrInvalidResponse is returned when a ceremony response does not verify.
+var ErrInvalidResponse = errors.New("invalid webauthn response")
+
+// Config describes the relying party.
+type Config struct {
+	// RPID is the domain credentials are scoped to, e.g. "example.com".
+	RPID   string
+	RPName string
+	// Origins lists the origins ceremonies may run on, e.g.
+	// "https://app.example.com".
+	Origins []string
+	Timeout time.Duration
+	// UserVerification is "required", "preferred" or "discouraged".
+	UserVerification string
+}
+
+type RelyingParty struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are PublicKeyCredentialCreationOptions in their JSON
+// form; browsers read them with PublicKeyCredential.parseCreationOptionsFromJSON.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+}
+
+// RequestOptions are PublicKeyCredentialRequestOptions in their JSON form.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is a PublicKeyCredential from navigator.credentials.create,
+// serialized with toJSON().
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is a PublicKeyCredential from navigator.credentials.get,
+// serialized with toJSON().
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// Credential is what a successful registration yields and the server stores.
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE_Key, to be passed back to VerifyAssertion.
+	PublicKey   []byte
+	SignCount   uint32
+	AAGUID      []byte
+	Attestation string
+	Transports  []string
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// Present when FlagAttestedData is set
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// NewChallenge returns a random challenge for one ceremony.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// Encode and Decode convert binary fields to and from the unpadded
+// base64url the JSON forms use. Decode also accepts padding.
+func Encode(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func Decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (c Config) timeout() int64 {
+	if c.Timeout <= 0 {
+		return (5 * time.Minute).Milliseconds()
+	}
+	return c.Timeout.Milliseconds()
+}
+
+func (c Config) userVerification() string {
+	if c.UserVerification == "" {
+		return "preferred"
+	}
+	return c.UserVerification
+}
+
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	out := []CredentialDescriptor{}
+	for _, id := range ids {
+		out = append(out, CredentialDescriptor{Type: "public-key", ID: Encode(id)})
+	}
+	return out
+}
+
+// CreationOptions starts a registration for the user with the opaque
+// handle userHandle. Credentials in exclude are refused by authenticators
+// that already hold them.
+func (c Config) CreationOptions(challenge []byte, userHandle []byte, name string, displayName string, exclude [][]byte) CreationOptions {
+	return CreationOptions{
+		Challenge: Encode(challenge),
+		RP:        RelyingParty{ID: c.RPID, Name: c.RPName},
+		User:      UserEntity{ID: Encode(userHandle), Name: name, DisplayName: displayName},
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:     c.timeout(),
+		Attestation: "none",
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: c.userVerification(),
+		},
+		ExcludeCredentials: descriptors(exclude),
+	}
+}
+
+// RequestOptions starts an authentication. An empty allow list lets the
+// authenticator offer any discoverable credential for the relying party.
+func (c Config) RequestOptions(challenge []byte, allow [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        Encode(challenge),
+		Timeout:          c.timeout(),
+		RPID:             c.RPID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: c.userVerification(),
+	}
+}
+
+// VerifyRegistration checks a registration response against the challenge
+// it was started with (WebAuthn section 7.1) and returns the new credential.
+func (c Config) VerifyRegistration(response RegistrationResponse, challenge []byte) (*Credential, error) {
+	rawClientData, err := c.verifyClientData(response.Response.ClientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	attestationObject, err := Decode(response.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: attestation object is not base64url", ErrInvalidResponse)
+	}
+	decoded, rest, err := decodeCBOR(attestationObject)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("%w: attestation object: %v", ErrInvalidResponse, err)
+	}
+	object, _ := decoded.(map[any]any)
+	format, _ := object["fmt"].(string)
+	statement, _ := object["attStmt"].(map[any]any)
+	rawAuthData, _ := object["authData"].([]byte)
+
+	authData, err := c.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.flags&FlagAttestedData == 0 {
+		return nil, fmt.Errorf("%w: no attested credential data", ErrInvalidResponse)
+	}
+	key, err := ParsePublicKey(authData.publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return nil, fmt.Errorf("%w: none attestation with a statement", ErrInvalidResponse)
+		}
+	case "packed":
+		// Self attestation: signed by the credential's own key
+		if _, ok := statement["x5c"]; ok {
+			return nil, fmt.Errorf("%w: only self attestation is supported", ErrInvalidResponse)
+		}
+		alg, _ := statement["alg"].(int64)
+		sig, _ := statement["sig"].([]byte)
+		clientDataHash := sha256.Sum256(rawClientData)
+		if alg != key.Alg || !key.Verify(append(append([]byte(nil), rawAuthData...), clientDataHash[:]...), sig) {
+			return nil, fmt.Errorf("%w: bad self attestation signature", ErrInvalidResponse)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported attestation format %q", ErrInvalidResponse, format)
+	}
+
+	if id, err := Decode(response.RawID); err != nil || !bytes.Equal(id, authData.credentialID) {
+		return nil, fmt.Errorf("%w: credential id mismatch", ErrInvalidResponse)
+	}
+	return &Credential{
+		ID:          authData.credentialID,
+		PublicKey:   authData.publicKey,
+		SignCount:   authData.signCount,
+		AAGUID:      authData.aaguid,
+		Attestation: format,
+		Transports:  response.Response.Transports,
+	}, nil
+}
+
+// VerifyAssertion checks an authentication response for the stored
+// credential (WebAuthn section 7.2) and returns the new signature counter.
+// A counter that did not increase suggests a cloned authenticator and is
+// rejected; authenticators that always report zero are accepted.
+func (c Config) VerifyAssertion(response AssertionResponse, challenge []byte, publicKey []byte, signCount uint32) (uint32, error) {
+	rawClientData, err := c.verifyClientData(response.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+	rawAuthData, err := Decode(response.Response.AuthenticatorData)
+	if err != nil {
+		return 0, fmt.Errorf("%w: authenticator data is not base64url", ErrInvalidResponse)
+	}
+	authData, err := c.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+	sig, err := Decode(response.Response.Signature)
+	if err != nil {
+		return 0, fmt.Errorf("%w: signature is not base64url", ErrInvalidResponse)
+	}
+
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(rawClientData)
+	if !key.Verify(append(rawAuthData, clientDataHash[:]...), sig) {
+		return 0, fmt.Errorf("%w: bad signature", ErrInvalidResponse)
+	}
+
+	// Authenticators without a counter always report 0, so the counter
+	// cannot stop a replay; callers must let each challenge be used once
+	if (authData.signCount != 0 || signCount != 0) && authData.signCount <= signCount {
+		return 0, fmt.Errorf("%w: signature counter did not increase", ErrInvalidResponse)
+	}
+	return authData.signCount, nil
+}
+
+func (c Config) verifyClientData(encoded string, typ string, challenge []byte) ([]byte, error) {
+	raw, err := Decode(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: client data is not base64url", ErrInvalidResponse)
+	}
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("%w: client data: %v", ErrInvalidResponse, err)
+	}
+	if data.Type != typ {
+		return nil, fmt.Errorf("%w: client data type %q", ErrInvalidResponse, data.Type)
+	}
+	got, err := Decode(data.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return nil, fmt.Errorf("%w: challenge mismatch", ErrInvalidResponse)
+	}
+	for _, origin := range c.Origins {
+		if data.Origin == origin {
+			return raw, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: origin %q not allowed", ErrInvalidResponse, data.Origin)
+}
+
+func (c Config) verifyAuthenticatorData(b []byte) (*authenticatorData, error) {
+	if len(b) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrInvalidResponse)
+	}
+	data := &authenticatorData{rpIDHash: b[:32], flags: b[32], signCount: binary.BigEndian.Uint32(b[33:37])}
+
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(data.rpIDHash, rpIDHash[:]) {
+		return nil, fmt.Errorf("%w: credential is for another relying party", ErrInvalidResponse)
+	}
+	if data.flags&FlagUserPresent == 0 {
+		return nil, fmt.Errorf("%w: user not present", ErrInvalidResponse)
+	}
+	if c.UserVerification == "required" && data.flags&FlagUserVerified == 0 {
+		return nil, fmt.Errorf("%w: user not verified", ErrInvalidResponse)
+	}
+
+	if data.flags&FlagAttestedData != 0 {
+		rest := b[37:]
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested credential data too short", ErrInvalidResponse)
+		}
+		data.aaguid = rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+			return nil, fmt.Errorf("%w: bad credential id", ErrInvalidResponse)
+		}
+		data.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+		// The key is followed by extensions, if any, so its length is
+		// only known after decoding it
+		_, after, err := parsePublicKey(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+		}
+		data.publicKey = rest[:len(rest)-len(after)]
+		if len(after) != 0 && data.flags&FlagExtensions == 0 {
+			return nil, fmt.Errorf("%w: trailing authenticator data", ErrInvalidResponse)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,128 @@
+package webauthn_test
+
+import (
+	"testing"
+
+	"server-go/webauthn"
+	"server-go/webauthn/webauthntest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var rp = webauthn.Config{RPID: "example.com", RPName: "Example", Origins: []string{"https://app.example.com"}}
+
+func register(t *testing.T, authenticator *webauthntest.Authenticator) (*webauthn.Credential, error) {
+	challenge, err := webauthn.NewChallenge()
+	require.NoError(t, err)
+	options := rp.CreationOptions(challenge, []byte{1, 2, 3}, "jane@example.com", "Jane Doe", nil)
+	response, err := authenticator.Register(options)
+	require.NoError(t, err)
+	return rp.VerifyRegistration(response, challenge)
+}
+
+func TestRegistration(t *testing.T) {
+	t.Run("none attestation", func(t *testing.T) {
+		authenticator := webauthntest.New("https://app.example.com")
+		credential, err := register(t, authenticator)
+		require.NoError(t, err)
+		assert.Equal(t, authenticator.CredentialID, credential.ID)
+		assert.Equal(t, "none", credential.Attestation)
+		assert.Equal(t, []byte{1, 2, 3}, authenticator.UserHandle)
+
+		key, err := webauthn.ParsePublicKey(credential.PublicKey)
+		require.NoError(t, err)
+		assert.EqualValues(t, webauthn.AlgES256, key.Alg)
+	})
+
+	t.Run("self attestation", func(t *testing.T) {
+		authenticator := webauthntest.New("https://app.example.com")
+		authenticator.SelfAttestation = true
+		credential, err := register(t, authenticator)
+		require.NoError(t, err)
+		assert.Equal(t, "packed", credential.Attestation)
+	})
+
+	t.Run("other origin", func(t *testing.T) {
+		_, err := register(t, webauthntest.New("https://evil.example"))
+		assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	})
+
+	t.Run("other challenge", func(t *testing.T) {
+		challenge, _ := webauthn.NewChallenge()
+		response, err := webauthntest.New("https://app.example.com").Register(rp.CreationOptions(challenge, []byte{1}, "a", "a", nil))
+		require.NoError(t, err)
+		other, _ := webauthn.NewChallenge()
+		_, err = rp.VerifyRegistration(response, other)
+		assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	})
+
+	t.Run("user verification required", func(t *testing.T) {
+		strict := rp
+		strict.UserVerification = "required"
+		authenticator := webauthntest.New("https://app.example.com")
+		authenticator.Verified = false
+		challenge, _ := webauthn.NewChallenge()
+		response, err := authenticator.Register(strict.CreationOptions(challenge, []byte{1}, "a", "a", nil))
+		require.NoError(t, err)
+		_, err = strict.VerifyRegistration(response, challenge)
+		assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	})
+}
+
+func TestAssertion(t *testing.T) {
+	authenticator := webauthntest.New("https://app.example.com")
+	credential, err := register(t, authenticator)
+	require.NoError(t, err)
+
+	login := func(allow [][]byte) (webauthn.AssertionResponse, []byte) {
+		challenge, err := webauthn.NewChallenge()
+		require.NoError(t, err)
+		response, err := authenticator.Login(rp.RequestOptions(challenge, allow))
+		require.NoError(t, err)
+		return response, challenge
+	}
+
+	response, challenge := login([][]byte{credential.ID})
+	count, err := rp.VerifyAssertion(response, challenge, credential.PublicKey, credential.SignCount)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+
+	t.Run("replayed response", func(t *testing.T) {
+		_, err := rp.VerifyAssertion(response, challenge, credential.PublicKey, count)
+		assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	})
+
+	t.Run("cloned authenticator", func(t *testing.T) {
+		authenticator.SignCount = 0
+		response, challenge := login(nil)
+		_, err := rp.VerifyAssertion(response, challenge, credential.PublicKey, 5)
+		assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	})
+
+	t.Run("tampered signature", func(t *testing.T) {
+		response, challenge := login(nil)
+		sig, _ := webauthn.Decode(response.Response.Signature)
+		sig[len(sig)-1] ^= 1
+		response.Response.Signature = webauthn.Encode(sig)
+		_, err := rp.VerifyAssertion(response, challenge, credential.PublicKey, 0)
+		assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	})
+
+	t.Run("registration response is not an assertion", func(t *testing.T) {
+		challenge, _ := webauthn.NewChallenge()
+		registration, err := webauthntest.New("https://app.example.com").Register(rp.CreationOptions(challenge, []byte{1}, "a", "a", nil))
+		require.NoError(t, err)
+		var forged webauthn.AssertionResponse
+		forged.Response.ClientDataJSON = registration.Response.ClientDataJSON
+		_, err = rp.VerifyAssertion(forged, challenge, credential.PublicKey, 0)
+		assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	})
+}
+
+func TestMalformedPublicKey(t *testing.T) {
+	for _, key := range [][]byte{nil, {0xa1}, {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, {0xa1, 0x01, 0x02}} {
+		_, err := webauthn.ParsePublicKey(key)
+		assert.Error(t, err, "%x", key)
+	}
+}
@@ -0,0 +1,230 @@
+// Package webauthntest is a software authenticator for tests: it creates
+// ES256 passkeys and answers registration and authentication ceremonies
+// the way a browser and platform authenticator would.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"server-go/webauthn"
+)
+
+// Authenticator holds one credential. Its fields may be changed between
+// ceremonies to simulate misbehaving authenticators.
+type Authenticator struct {
+	// Origin is reported in the client data, as a browser would.
+	Origin string
+	// SelfAttestation signs registrations with the credential key ("packed"
+	// format) instead of returning "none".
+	SelfAttestation bool
+	// Verified sets the user verified flag.
+	Verified  bool
+	SignCount uint32
+
+	CredentialID []byte
+	UserHandle   []byte
+	RPID         string
+	key          *ecdsa.PrivateKey
+}
+
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, Verified: true}
+}
+
+// Register answers navigator.credentials.create with a new credential.
+func (a *Authenticator) Register(options webauthn.CreationOptions) (webauthn.RegistrationResponse, error) {
+	var response webauthn.RegistrationResponse
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return response, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return response, err
+	}
+	for _, excluded := range options.ExcludeCredentials {
+		if excluded.ID == webauthn.Encode(a.CredentialID) {
+			return response, errors.New("InvalidStateError: credential already registered")
+		}
+	}
+	a.key, a.CredentialID, a.RPID, a.SignCount = key, id, options.RP.ID, 0
+	if a.UserHandle, err = webauthn.Decode(options.User.ID); err != nil {
+		return response, err
+	}
+
+	clientData, err := a.clientData("webauthn.create", options.Challenge)
+	if err != nil {
+		return response, err
+	}
+	authData := a.authenticatorData(webauthn.FlagAttestedData)
+	authData = append(authData, make([]byte, 16)...) // AAGUID, zero for software
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(id)))
+	authData = append(authData, id...)
+	authData = append(authData, encode(map[int64]any{
+		1:  int64(2), // kty: EC2
+		3:  int64(webauthn.AlgES256),
+		-1: int64(1), // crv: P-256
+		-2: pad32(key.X),
+		-3: pad32(key.Y),
+	})...)
+
+	format, statement := "none", map[string]any{}
+	if a.SelfAttestation {
+		sig, err := a.sign(authData, clientData)
+		if err != nil {
+			return response, err
+		}
+		format, statement = "packed", map[string]any{"alg": int64(webauthn.AlgES256), "sig": sig}
+	}
+
+	response.ID = webauthn.Encode(id)
+	response.RawID = response.ID
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = webauthn.Encode(clientData)
+	response.Response.AttestationObject = webauthn.Encode(encode(map[string]any{
+		"fmt":      format,
+		"attStmt":  statement,
+		"authData": authData,
+	}))
+	response.Response.Transports = []string{"internal"}
+	return response, nil
+}
+
+// Login answers navigator.credentials.get with the credential registered
+// last.
+func (a *Authenticator) Login(options webauthn.RequestOptions) (webauthn.AssertionResponse, error) {
+	var response webauthn.AssertionResponse
+	if a.key == nil {
+		return response, errors.New("NotAllowedError: no credential")
+	}
+	if len(options.AllowCredentials) > 0 {
+		allowed := false
+		for _, c := range options.AllowCredentials {
+			allowed = allowed || c.ID == webauthn.Encode(a.CredentialID)
+		}
+		if !allowed {
+			return response, errors.New("NotAllowedError: credential not allowed")
+		}
+	}
+
+	a.SignCount++
+	clientData, err := a.clientData("webauthn.get", options.Challenge)
+	if err != nil {
+		return response, err
+	}
+	authData := a.authenticatorData(0)
+	sig, err := a.sign(authData, clientData)
+	if err != nil {
+		return response, err
+	}
+
+	response.ID = webauthn.Encode(a.CredentialID)
+	response.RawID = response.ID
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = webauthn.Encode(clientData)
+	response.Response.AuthenticatorData = webauthn.Encode(authData)
+	response.Response.Signature = webauthn.Encode(sig)
+	response.Response.UserHandle = webauthn.Encode(a.UserHandle)
+	return response, nil
+}
+
+func (a *Authenticator) clientData(typ string, challenge string) ([]byte, error) {
+	return json.Marshal(map[string]any{"type": typ, "challenge": challenge, "origin": a.Origin, "crossOrigin": false})
+}
+
+func (a *Authenticator) authenticatorData(flags byte) []byte {
+	flags |= webauthn.FlagUserPresent
+	if a.Verified {
+		flags |= webauthn.FlagUserVerified
+	}
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.SignCount)
+}
+
+func (a *Authenticator) sign(authData []byte, clientData []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	return ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+}
+
+func pad32(n *big.Int) []byte {
+	b := make([]byte, 32)
+	return n.FillBytes(b)
+}
+
+// encode writes the CBOR subset authenticators use, with map keys in
+// canonical order.
+func encode(v any) []byte {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case map[int64]any:
+		keys := make([]int64, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		// Canonical CBOR sorts by encoded key: positive before negative
+		sort.Slice(keys, func(i, j int) bool {
+			if (keys[i] < 0) != (keys[j] < 0) {
+				return keys[i] >= 0
+			}
+			if keys[i] < 0 {
+				return keys[i] > keys[j]
+			}
+			return keys[i] < keys[j]
+		})
+		out := header(5, uint64(len(v)))
+		for _, k := range keys {
+			out = append(append(out, encode(k)...), encode(v[k])...)
+		}
+		return out
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		out := header(5, uint64(len(v)))
+		for _, k := range keys {
+			out = append(append(out, encode(k)...), encode(v[k])...)
+		}
+		return out
+	}
+	panic(fmt.Sprintf("webauthntest: cannot encode %T", v))
+}
+
+func header(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+}